@@ -0,0 +1,364 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/andrebq/auth"
+	"github.com/rs/zerolog/log"
+)
+
+// adminHandler serves /admin/*, every request requires a bearer token
+// of type auth.TokenTypeAdmin
+func adminHandler(st auth.Store) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/users", adminListUsers(st))
+	mux.HandleFunc("POST /admin/users", adminCreateUser(st))
+	mux.HandleFunc("GET /admin/users/{login}", adminShowUser(st))
+	mux.HandleFunc("DELETE /admin/users/{login}", adminUserAction(st, auth.DeleteUser))
+	mux.HandleFunc("POST /admin/users/{login}/disable", adminUserAction(st, auth.DisableUser))
+	mux.HandleFunc("POST /admin/users/{login}/enable", adminUserAction(st, auth.EnableUser))
+	mux.HandleFunc("PUT /admin/users/{login}/password", adminSetPassword(st))
+	mux.HandleFunc("POST /admin/users/{login}/rename", adminRenameUser(st))
+	mux.HandleFunc("PATCH /admin/users/{login}/attributes", adminSetAttributes(st))
+	mux.HandleFunc("POST /admin/users/{login}/reset-token", adminResetToken(st))
+	mux.HandleFunc("POST /admin/invites", adminInviteUser(st))
+	mux.HandleFunc("GET /admin/users/{login}/tokens", adminListTokens(st))
+	mux.HandleFunc("POST /admin/users/{login}/tokens", adminCreateToken(st))
+	mux.HandleFunc("DELETE /admin/tokens/{tokenID}", adminRevokeToken(st))
+	mux.HandleFunc("GET /admin/clients", adminListClients(st))
+	mux.HandleFunc("POST /admin/clients", adminRegisterClient(st))
+	mux.HandleFunc("DELETE /admin/clients/{clientID}", adminDeleteClient(st))
+	mux.HandleFunc("GET /admin/groups", adminListGroups(st))
+	mux.HandleFunc("POST /admin/groups", adminCreateGroup(st))
+	mux.HandleFunc("DELETE /admin/groups/{group}", adminGroupAction(st, auth.DeleteGroup))
+	mux.HandleFunc("GET /admin/groups/{group}/members", adminGroupMembers(st))
+	mux.HandleFunc("PUT /admin/groups/{group}/members/{login}", adminMemberAction(st, auth.AddToGroup))
+	mux.HandleFunc("DELETE /admin/groups/{group}/members/{login}", adminMemberAction(st, auth.RemoveFromGroup))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, ok := bearerUser(w, r, st)
+		if !ok {
+			return
+		}
+		if c.tokenType != auth.TokenTypeAdmin {
+			encode(w, 0, ForbiddenError("Admin token required"))
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func adminListUsers(st auth.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		users, err := auth.ListUsers(r.Context(), st)
+		if !adminResult(w, err) {
+			return
+		}
+		encode(w, http.StatusOK, users)
+	}
+}
+
+func adminCreateUser(st auth.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Login    string `json:"login"`
+			Password string `json:"password"`
+			// PasswordHash imports a hash from another system instead of
+			// hashing Password, see auth.ImportUser
+			PasswordHash string `json:"passwordHash"`
+		}
+		if !decode(&req, w, r) {
+			return
+		}
+		if req.Login == "" {
+			encode(w, 0, BadRequestError("login is required"))
+			return
+		}
+		var err error
+		if req.PasswordHash != "" {
+			_, err = auth.ImportUser(r.Context(), st, req.Login, req.PasswordHash)
+		} else {
+			_, err = auth.RegisterUser(r.Context(), st, req.Login, []byte(req.Password))
+		}
+		if !adminResult(w, err) {
+			return
+		}
+		user, err := auth.LookupUser(r.Context(), st, req.Login)
+		if !adminResult(w, err) {
+			return
+		}
+		encode(w, http.StatusCreated, user)
+	}
+}
+
+func adminShowUser(st auth.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := auth.LookupUser(r.Context(), st, r.PathValue("login"))
+		if !adminResult(w, err) {
+			return
+		}
+		encode(w, http.StatusOK, user)
+	}
+}
+
+func adminUserAction(st auth.Store, action func(ctx context.Context, st auth.Store, login string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !adminResult(w, action(r.Context(), st, r.PathValue("login"))) {
+			return
+		}
+		encode(w, http.StatusOK, struct{}{})
+	}
+}
+
+func adminSetPassword(st auth.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Password string `json:"password"`
+		}
+		if !decode(&req, w, r) {
+			return
+		}
+		if !adminResult(w, auth.ReplacePassword(r.Context(), st, r.PathValue("login"), []byte(req.Password))) {
+			return
+		}
+		encode(w, http.StatusOK, struct{}{})
+	}
+}
+
+func adminRenameUser(st auth.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Login string `json:"login"`
+		}
+		if !decode(&req, w, r) {
+			return
+		}
+		if req.Login == "" {
+			encode(w, 0, BadRequestError("login is required"))
+			return
+		}
+		if !adminResult(w, auth.RenameUser(r.Context(), st, r.PathValue("login"), req.Login)) {
+			return
+		}
+		encode(w, http.StatusOK, struct{}{})
+	}
+}
+
+func adminSetAttributes(st auth.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Attributes map[string]string `json:"attributes"`
+		}
+		if !decode(&req, w, r) {
+			return
+		}
+		if !adminResult(w, auth.SetUserAttributes(r.Context(), st, r.PathValue("login"), req.Attributes)) {
+			return
+		}
+		encode(w, http.StatusOK, struct{}{})
+	}
+}
+
+func adminResetToken(st auth.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			TTL apiDuration `json:"ttl"`
+		}
+		if !decode(&req, w, r) {
+			return
+		}
+		token, err := auth.CreateResetToken(r.Context(), st, r.PathValue("login"), time.Duration(req.TTL))
+		if !adminResult(w, err) {
+			return
+		}
+		encode(w, http.StatusCreated, struct {
+			Token string `json:"token"`
+		}{Token: token})
+	}
+}
+
+func adminInviteUser(st auth.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Login      string            `json:"login"`
+			Attributes map[string]string `json:"attributes"`
+			TTL        apiDuration       `json:"ttl"`
+		}
+		if !decode(&req, w, r) {
+			return
+		}
+		if req.Login == "" {
+			encode(w, 0, BadRequestError("login is required"))
+			return
+		}
+		token, err := auth.InviteUser(r.Context(), st, req.Login, req.Attributes, time.Duration(req.TTL))
+		if !adminResult(w, err) {
+			return
+		}
+		encode(w, http.StatusCreated, struct {
+			Token string `json:"token"`
+		}{Token: token})
+	}
+}
+
+func adminListTokens(st auth.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tokens, err := auth.ListTokens(r.Context(), st, r.PathValue("login"))
+		if !adminResult(w, err) {
+			return
+		}
+		encode(w, http.StatusOK, tokens)
+	}
+}
+
+func adminCreateToken(st auth.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			TokenType string      `json:"tokenType"`
+			TTL       apiDuration `json:"ttl"`
+		}
+		if !decode(&req, w, r) {
+			return
+		}
+		if req.TokenType == "" || auth.SingleUse(req.TokenType) {
+			encode(w, 0, BadRequestError("tokenType is required and cannot be a single use type"))
+			return
+		}
+		if req.TTL <= 0 {
+			encode(w, 0, BadRequestError("ttl must be positive"))
+			return
+		}
+		token, err := auth.CreateToken(r.Context(), st, r.PathValue("login"), req.TokenType, time.Now().Add(time.Duration(req.TTL)))
+		if !adminResult(w, err) {
+			return
+		}
+		encode(w, http.StatusCreated, struct {
+			Token string `json:"token"`
+		}{Token: token})
+	}
+}
+
+func adminRevokeToken(st auth.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !adminResult(w, st.ExpireToken(r.Context(), r.PathValue("tokenID"))) {
+			return
+		}
+		encode(w, http.StatusOK, struct{}{})
+	}
+}
+
+func adminListClients(st auth.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clients, err := auth.ListClients(r.Context(), st)
+		if !adminResult(w, err) {
+			return
+		}
+		encode(w, http.StatusOK, clients)
+	}
+}
+
+func adminRegisterClient(st auth.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req auth.NewClient
+		if !decode(&req, w, r) {
+			return
+		}
+		client, secret, err := auth.RegisterClient(r.Context(), st, req)
+		if !adminResult(w, err) {
+			return
+		}
+		encode(w, http.StatusCreated, struct {
+			Client auth.Client `json:"client"`
+			Secret string      `json:"secret,omitempty"`
+		}{Client: client, Secret: secret})
+	}
+}
+
+func adminDeleteClient(st auth.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !adminResult(w, auth.DeleteClient(r.Context(), st, r.PathValue("clientID"))) {
+			return
+		}
+		encode(w, http.StatusOK, struct{}{})
+	}
+}
+
+func adminListGroups(st auth.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		groups, err := auth.ListGroups(r.Context(), st)
+		if !adminResult(w, err) {
+			return
+		}
+		if groups == nil {
+			groups = []string{}
+		}
+		encode(w, http.StatusOK, groups)
+	}
+}
+
+func adminCreateGroup(st auth.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Name string `json:"name"`
+		}
+		if !decode(&req, w, r) {
+			return
+		}
+		if !adminResult(w, auth.CreateGroup(r.Context(), st, req.Name)) {
+			return
+		}
+		encode(w, http.StatusCreated, struct{}{})
+	}
+}
+
+func adminGroupAction(st auth.Store, action func(ctx context.Context, st auth.Store, group string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !adminResult(w, action(r.Context(), st, r.PathValue("group"))) {
+			return
+		}
+		encode(w, http.StatusOK, struct{}{})
+	}
+}
+
+func adminGroupMembers(st auth.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		members, err := auth.GroupMembers(r.Context(), st, r.PathValue("group"))
+		if !adminResult(w, err) {
+			return
+		}
+		encode(w, http.StatusOK, members)
+	}
+}
+
+func adminMemberAction(st auth.Store, action func(ctx context.Context, st auth.Store, group, login string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !adminResult(w, action(r.Context(), st, r.PathValue("group"), r.PathValue("login"))) {
+			return
+		}
+		encode(w, http.StatusOK, struct{}{})
+	}
+}
+
+// adminResult writes the error response for err and returns false,
+// if err is nil it returns true without writing anything
+func adminResult(w http.ResponseWriter, err error) bool {
+	var perr *auth.PolicyError
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, auth.ErrNotFound):
+		encode(w, 0, NotFoundError())
+	case errors.Is(err, auth.ErrConflict):
+		encode(w, 0, ConflictError())
+	case errors.Is(err, auth.ErrInvalidInput):
+		encode(w, 0, BadRequestError(err.Error()))
+	case errors.As(err, &perr):
+		encode(w, 0, PasswordRejectedError(perr))
+	default:
+		log.Error().Err(err).Msg("Admin request failed")
+		encode(w, 0, InternalError())
+	}
+	return false
+}
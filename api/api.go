@@ -1,10 +1,11 @@
 package api
 
 import (
-	"database/sql"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/andrebq/auth"
@@ -12,15 +13,53 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-func Handler(db *sql.DB) http.Handler {
+type (
+	// Option customizes the behaviour of Handler
+	Option func(*config)
+
+	config struct {
+		editableAttributes map[string]bool
+		resetNotifier      ResetNotifier
+		resetTTL           time.Duration
+		clientTokenTTL     time.Duration
+		signingKeys        *auth.SigningKeys
+	}
+)
+
+// WithEditableAttributes lists which attributes users are allowed to change
+// about themselves via /user/profile, by default none are editable
+func WithEditableAttributes(names ...string) Option {
+	return func(c *config) {
+		for _, n := range names {
+			c.editableAttributes[n] = true
+		}
+	}
+}
+
+func Handler(st auth.Store, opts ...Option) http.Handler {
+	cfg := config{editableAttributes: make(map[string]bool), resetTTL: auth.DefaultResetTTL, clientTokenTTL: auth.DefaultClientTokenTTL}
+	for _, o := range opts {
+		o(&cfg)
+	}
 	mux := http.NewServeMux()
-	mux.Handle("/auth/token", tokenAuth(db))
-	mux.Handle("/auth/login", loginAuth(db))
-	mux.Handle("/session", newSessionHandler(db))
+	mux.Handle("/auth/token", tokenAuth(st))
+	mux.Handle("/auth/login", loginAuth(st))
+	mux.Handle("/session", newSessionHandler(st, cfg))
+	mux.Handle("GET /auth/jwks", jwksHandler(st))
+	mux.Handle("GET /auth/revoked", revokedHandler(st))
+	mux.Handle("/user/profile", profileHandler(st, cfg))
+	mux.Handle("/user/password", changePasswordHandler(st))
+	mux.Handle("/password/reset", resetPasswordHandler(st))
+	mux.Handle("/password/reset/request", requestResetHandler(st, cfg))
+	mux.Handle("/invite/accept", acceptInviteHandler(st))
+	mux.Handle("POST /oauth/token", oauthTokenHandler(st, cfg))
+	mux.Handle("POST /oauth/introspect", oauthIntrospectHandler(st))
+	mux.Handle("POST /oauth/revoke", oauthRevokeHandler(st))
+	mux.Handle("/admin/", adminHandler(st))
 	return mux
 }
 
-func tokenAuth(db *sql.DB) http.Handler {
+func tokenAuth(st auth.Store) http.Handler {
 	sampler := zerolog.Sometimes
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var token struct {
@@ -29,27 +68,44 @@ func tokenAuth(db *sql.DB) http.Handler {
 		if !decode(&token, w, r) {
 			return
 		}
-		uid, tokenType, err := auth.TokenLogin(r.Context(), db, token.Token)
+		t, err := auth.InspectToken(r.Context(), st, token.Token)
+		if err == nil && !auth.Credential(t.TokenType) {
+			err = fmt.Errorf("token type %v cannot be used as a credential", t.TokenType)
+		}
 		if err != nil {
 			log := log.Logger.Sample(sampler)
 			log.Error().Err(err).Msg("Authentication failed")
 			encode(w, 0, UnauthorizedError("Invalid credentials"))
 			return
 		}
-		tokenID, _ := auth.ExtractTokenID(token.Token)
+		user, err := auth.LookupUserByUID(r.Context(), st, t.UID)
+		if err != nil {
+			log := log.Logger.Sample(sampler)
+			log.Error().Err(err).Msg("Unable to load token owner")
+			encode(w, 0, UnauthorizedError("Invalid credentials"))
+			return
+		}
 		encode(w, http.StatusOK, struct {
-			UID       string `json:"uid"`
-			TokenID   string `json:"tokenID"`
-			TokenType string `json:"tokenType"`
+			UID        string            `json:"uid"`
+			TokenID    string            `json:"tokenID"`
+			TokenType  string            `json:"tokenType"`
+			Login      string            `json:"login"`
+			Attributes map[string]string `json:"attributes"`
+			Groups     []string          `json:"groups"`
+			ExpiresAt  time.Time         `json:"expiresAt"`
 		}{
-			UID:       uid,
-			TokenType: tokenType,
-			TokenID:   tokenID,
+			UID:        t.UID,
+			TokenType:  t.TokenType,
+			TokenID:    t.TokenID,
+			Login:      user.Login,
+			Attributes: user.Attributes,
+			Groups:     user.Groups,
+			ExpiresAt:  t.ExpiresAt,
 		})
 	})
 }
 
-func loginAuth(db *sql.DB) http.Handler {
+func loginAuth(st auth.Store) http.Handler {
 	sampler := zerolog.Sometimes
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var user struct {
@@ -61,7 +117,7 @@ func loginAuth(db *sql.DB) http.Handler {
 		}
 		var uid string
 		var err error
-		if uid, err = auth.Login(r.Context(), db, user.Login, []byte(user.Password)); err != nil {
+		if uid, err = auth.Login(r.Context(), st, user.Login, []byte(user.Password)); err != nil {
 			log := log.Logger.Sample(sampler)
 			log.Error().Err(err).Msg("Authentication failed")
 			encode(w, 0, UnauthorizedError("Invalid credentials"))
@@ -73,7 +129,7 @@ func loginAuth(db *sql.DB) http.Handler {
 	})
 }
 
-func newSessionHandler(db *sql.DB) http.Handler {
+func newSessionHandler(st auth.Store, cfg config) http.Handler {
 	sampler := zerolog.Sometimes
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var user struct {
@@ -90,17 +146,23 @@ func newSessionHandler(db *sql.DB) http.Handler {
 		if time.Duration(user.TTL) < time.Second {
 			user.TTL = apiDuration(time.Second)
 		}
-		_, err := auth.Login(r.Context(), db, user.Login, []byte(user.Password))
+		_, err := auth.Login(r.Context(), st, user.Login, []byte(user.Password))
 		if err != nil {
 			log := log.Logger.Sample(sampler)
 			log.Error().Err(err).Msg("Authentication failed")
 			encode(w, 0, UnauthorizedError("Invalid credentials"))
 			return
 		}
-		token, err := auth.CreateToken(r.Context(), db, user.Login, "session", time.Now().Add(time.Duration(user.TTL)))
+		var token string
+		if cfg.signingKeys != nil {
+			token, err = auth.CreateSignedToken(r.Context(), st, cfg.signingKeys, user.Login, auth.TokenTypeSession, time.Now().Add(time.Duration(user.TTL)))
+		} else {
+			token, err = auth.CreateToken(r.Context(), st, user.Login, auth.TokenTypeSession, time.Now().Add(time.Duration(user.TTL)))
+		}
 		if err != nil {
 			log.Error().Err(err).Msg("Unable to create token for user")
 			encode(w, 0, InternalError())
+			return
 		}
 		encode(w, http.StatusOK, struct {
 			Token string `json:"token"`
@@ -110,6 +172,82 @@ func newSessionHandler(db *sql.DB) http.Handler {
 	})
 }
 
+func profileHandler(st auth.Store, cfg config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, ok := bearerUser(w, r, st)
+		if !ok {
+			return
+		}
+		user := c.user
+		switch r.Method {
+		case "GET":
+			encode(w, http.StatusOK, user)
+		case "PATCH":
+			var update struct {
+				Attributes map[string]string `json:"attributes"`
+			}
+			if !decode(&update, w, r) {
+				return
+			}
+			for name := range update.Attributes {
+				if !cfg.editableAttributes[name] {
+					encode(w, 0, ForbiddenError(fmt.Sprintf("Attribute %v cannot be changed", name)))
+					return
+				}
+			}
+			if err := auth.SetUserAttributes(r.Context(), st, user.Login, update.Attributes); err != nil {
+				encode(w, 0, BadRequestError(err.Error()))
+				return
+			}
+			user, err := auth.LookupUserByUID(r.Context(), st, user.UID)
+			if err != nil {
+				log.Error().Err(err).Msg("Unable to load user profile")
+				encode(w, 0, InternalError())
+				return
+			}
+			encode(w, http.StatusOK, user)
+		default:
+			encode(w, 0, MethodNotAllowedError())
+		}
+	})
+}
+
+type (
+	// caller is the owner of the bearer token used in a request
+	caller struct {
+		user      auth.User
+		tokenID   string
+		tokenType string
+	}
+)
+
+// bearerUser authenticates the request using the token from the Authorization
+// header, it writes the error response when it returns false
+func bearerUser(w http.ResponseWriter, r *http.Request, st auth.Store) (caller, bool) {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found {
+		encode(w, 0, UnauthorizedError("Missing bearer token"))
+		return caller{}, false
+	}
+	uid, tokenType, err := auth.TokenLogin(r.Context(), st, token)
+	if err == nil && !auth.Credential(tokenType) {
+		err = fmt.Errorf("token type %v cannot be used as a credential", tokenType)
+	}
+	if err != nil {
+		log := log.Logger.Sample(zerolog.Sometimes)
+		log.Error().Err(err).Msg("Authentication failed")
+		encode(w, 0, UnauthorizedError("Invalid credentials"))
+		return caller{}, false
+	}
+	user, err := auth.LookupUserByUID(r.Context(), st, uid)
+	if err != nil {
+		encode(w, 0, UnauthorizedError("Invalid credentials"))
+		return caller{}, false
+	}
+	tokenID, _ := auth.ExtractTokenID(token)
+	return caller{user: user, tokenID: tokenID, tokenType: tokenType}, true
+}
+
 func decode(out interface{}, w http.ResponseWriter, req *http.Request) bool {
 	err := json.NewDecoder(req.Body).Decode(out)
 	if err != nil {
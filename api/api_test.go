@@ -9,6 +9,7 @@ import (
 
 	"github.com/andrebq/auth"
 	"github.com/andrebq/auth/api"
+	"github.com/andrebq/auth/jwt"
 	"github.com/steinfletcher/apitest"
 	jsonpath "github.com/steinfletcher/apitest-jsonpath"
 )
@@ -55,6 +56,24 @@ func TestTokenAuth(t *testing.T) {
 		Status(http.StatusOK).
 		Assert(jsonpath.Chain().Equal("tokenID", tokenID).Equal("uid", uid).Equal("tokenType", "session").End()).
 		End()
+
+	// access tokens issued to oidc clients are not user credentials
+	access, err := auth.CreateClientToken(ctx, db, "bob", auth.TokenTypeAccess, "client-1", "openid", time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apitest.Handler(api.Handler(db)).
+		Post("/auth/token").
+		Bodyf(`{"token":%q}`, access).
+		Expect(t).
+		Status(http.StatusUnauthorized).
+		End()
+	apitest.Handler(api.Handler(db)).
+		Get("/user/profile").
+		Header("Authorization", "Bearer "+access).
+		Expect(t).
+		Status(http.StatusUnauthorized).
+		End()
 }
 
 func TestSession(t *testing.T) {
@@ -93,3 +112,479 @@ func TestSession(t *testing.T) {
 		t.Fatalf("Token should have a ttl of just 100ms, but it is still valid after 100ms")
 	}
 }
+
+func TestSignedSession(t *testing.T) {
+	ctx := context.Background()
+	db, err := auth.OpenMemory(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err = auth.RegisterUser(ctx, db, "bob", []byte("1234")); err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := auth.GenerateKEK()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kek, err := auth.ParseKEK(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := auth.NewSigningKeys(db, auth.NewKeyring(db, kek), auth.KeyPurposeToken, jwt.EdDSA, time.Hour)
+	handler := api.Handler(db, api.WithSignedTokens(keys))
+	var session struct {
+		Token string `json:"token"`
+	}
+	apitest.Handler(handler).
+		Post("/session").
+		Body(`{"login":"bob", "password": "1234", "ttl": "1m"}`).
+		Expect(t).
+		Status(http.StatusOK).
+		End().JSON(&session)
+	if !auth.IsSignedToken(session.Token) {
+		t.Fatalf("Session token should be signed got %q", session.Token)
+	}
+	tokenID, _ := auth.ExtractTokenID(session.Token)
+	apitest.Handler(handler).
+		Post("/auth/token").
+		Bodyf(`{"token":%q}`, session.Token).
+		Expect(t).
+		Status(http.StatusOK).
+		Assert(jsonpath.Chain().Equal("tokenID", tokenID).Equal("login", "bob").End()).
+		End()
+	apitest.Handler(handler).
+		Get("/auth/jwks").
+		Expect(t).
+		Status(http.StatusOK).
+		Assert(jsonpath.Len("keys", 1)).
+		Assert(jsonpath.Equal("keys[0].alg", jwt.EdDSA)).
+		End()
+
+	if err := auth.RevokeToken(ctx, db, tokenID); err != nil {
+		t.Fatal(err)
+	}
+	apitest.Handler(handler).
+		Get("/auth/revoked").
+		Expect(t).
+		Status(http.StatusOK).
+		Assert(jsonpath.Contains("revoked", tokenID)).
+		End()
+}
+
+func TestProfile(t *testing.T) {
+	ctx := context.Background()
+	db := auth.NewMemoryStore()
+	if _, err := auth.RegisterUser(ctx, db, "bob", []byte("1234")); err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.SetUserAttributes(ctx, db, "bob", map[string]string{auth.AttrEmail: "bob@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	token, err := auth.CreateToken(ctx, db, "bob", "session", time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := api.Handler(db, api.WithEditableAttributes(auth.AttrName))
+	apitest.Handler(handler).
+		Patch("/user/profile").
+		Header("Authorization", "Bearer "+token).
+		Body(`{"attributes": {"name": "Bob"}}`).
+		Expect(t).
+		Status(http.StatusOK).
+		Assert(jsonpath.Chain().Equal("login", "bob").Equal("attributes.name", "Bob").End()).
+		End()
+	apitest.Handler(handler).
+		Patch("/user/profile").
+		Header("Authorization", "Bearer "+token).
+		Body(`{"attributes": {"email": "eve@example.com"}}`).
+		Expect(t).
+		Status(http.StatusForbidden).
+		End()
+	apitest.Handler(handler).
+		Get("/user/profile").
+		Expect(t).
+		Status(http.StatusUnauthorized).
+		End()
+	apitest.Handler(handler).
+		Post("/auth/token").
+		Bodyf(`{"token":%q}`, token).
+		Expect(t).
+		Status(http.StatusOK).
+		Assert(jsonpath.Chain().Equal("login", "bob").Equal("attributes.email", "bob@example.com").Equal("attributes.name", "Bob").End()).
+		End()
+}
+
+type resetNotifierFunc func(ctx context.Context, user auth.User, token string) error
+
+func (fn resetNotifierFunc) NotifyReset(ctx context.Context, user auth.User, token string) error {
+	return fn(ctx, user, token)
+}
+
+func TestPasswordReset(t *testing.T) {
+	ctx := context.Background()
+	db := auth.NewMemoryStore()
+	if _, err := auth.RegisterUser(ctx, db, "bob", []byte("1234")); err != nil {
+		t.Fatal(err)
+	}
+	var sent string
+	handler := api.Handler(db, api.WithResetNotifier(resetNotifierFunc(func(_ context.Context, user auth.User, token string) error {
+		if user.Login != "bob" {
+			t.Errorf("Reset sent to the wrong user %v", user.Login)
+		}
+		sent = token
+		return nil
+	})))
+	for _, login := range []string{"bob", "alice"} {
+		apitest.Handler(handler).
+			Post("/password/reset/request").
+			Bodyf(`{"login":%q}`, login).
+			Expect(t).
+			Status(http.StatusAccepted).
+			End()
+	}
+	if sent == "" {
+		t.Fatal("Reset token was not delivered")
+	}
+	apitest.Handler(handler).
+		Post("/auth/token").
+		Bodyf(`{"token":%q}`, sent).
+		Expect(t).
+		Status(http.StatusUnauthorized).
+		End()
+	apitest.Handler(handler).
+		Post("/password/reset").
+		Bodyf(`{"token":%q, "newPassword": ""}`, sent).
+		Expect(t).
+		Status(http.StatusUnprocessableEntity).
+		End()
+	apitest.Handler(handler).
+		Post("/password/reset").
+		Bodyf(`{"token":%q, "newPassword": "5678"}`, sent).
+		Expect(t).
+		Status(http.StatusOK).
+		End()
+	if _, err := auth.Login(ctx, db, "bob", []byte("5678")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	ctx := context.Background()
+	db := auth.NewMemoryStore()
+	if _, err := auth.RegisterUser(ctx, db, "bob", []byte("1234")); err != nil {
+		t.Fatal(err)
+	}
+	token, err := auth.CreateToken(ctx, db, "bob", auth.TokenTypeSession, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apitest.Handler(api.Handler(db)).
+		Post("/user/password").
+		Header("Authorization", "Bearer "+token).
+		Body(`{"oldPassword": "wrong", "newPassword": "5678"}`).
+		Expect(t).
+		Status(http.StatusUnauthorized).
+		End()
+	apitest.Handler(api.Handler(db)).
+		Post("/user/password").
+		Header("Authorization", "Bearer "+token).
+		Body(`{"oldPassword": "1234", "newPassword": "5678"}`).
+		Expect(t).
+		Status(http.StatusOK).
+		End()
+	if _, err := auth.Login(ctx, db, "bob", []byte("5678")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAcceptInvite(t *testing.T) {
+	ctx := context.Background()
+	db := auth.NewMemoryStore()
+	token, err := auth.InviteUser(ctx, db, "bob", nil, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apitest.Handler(api.Handler(db)).
+		Post("/auth/token").
+		Bodyf(`{"token":%q}`, token).
+		Expect(t).
+		Status(http.StatusUnauthorized).
+		End()
+	apitest.Handler(api.Handler(db)).
+		Post("/invite/accept").
+		Bodyf(`{"token":%q, "password": "1234"}`, token).
+		Expect(t).
+		Status(http.StatusOK).
+		Assert(jsonpath.Present("userID")).
+		End()
+	apitest.Handler(api.Handler(db)).
+		Post("/invite/accept").
+		Bodyf(`{"token":%q, "password": "1234"}`, token).
+		Expect(t).
+		Status(http.StatusUnauthorized).
+		End()
+}
+
+func TestAdmin(t *testing.T) {
+	ctx := context.Background()
+	db := auth.NewMemoryStore()
+	for _, login := range []string{"root", "bob"} {
+		if _, err := auth.RegisterUser(ctx, db, login, []byte("1234")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	admin, err := auth.CreateToken(ctx, db, "root", auth.TokenTypeAdmin, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := auth.CreateToken(ctx, db, "bob", "session", time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := api.Handler(db)
+	apitest.Handler(handler).
+		Get("/admin/users").
+		Expect(t).
+		Status(http.StatusUnauthorized).
+		End()
+	apitest.Handler(handler).
+		Get("/admin/users").
+		Header("Authorization", "Bearer "+session).
+		Expect(t).
+		Status(http.StatusForbidden).
+		End()
+	apitest.Handler(handler).
+		Post("/admin/users").
+		Header("Authorization", "Bearer "+admin).
+		Body(`{"login": "alice", "password": "1234"}`).
+		Expect(t).
+		Status(http.StatusCreated).
+		Assert(jsonpath.Chain().Equal("login", "alice").Equal("active", true).End()).
+		End()
+	apitest.Handler(handler).
+		Post("/admin/users").
+		Header("Authorization", "Bearer "+admin).
+		Body(`{"login": "alice", "password": "1234"}`).
+		Expect(t).
+		Status(http.StatusConflict).
+		End()
+	apitest.Handler(handler).
+		Post("/admin/groups").
+		Header("Authorization", "Bearer "+admin).
+		Body(`{"name": "ops"}`).
+		Expect(t).
+		Status(http.StatusCreated).
+		End()
+	apitest.Handler(handler).
+		Put("/admin/groups/ops/members/alice").
+		Header("Authorization", "Bearer "+admin).
+		Expect(t).
+		Status(http.StatusOK).
+		End()
+	apitest.Handler(handler).
+		Get("/admin/users/alice").
+		Header("Authorization", "Bearer "+admin).
+		Expect(t).
+		Status(http.StatusOK).
+		Assert(jsonpath.Chain().Equal("groups[0]", "ops").End()).
+		End()
+	apitest.Handler(handler).
+		Post("/admin/users/bob/disable").
+		Header("Authorization", "Bearer "+admin).
+		Expect(t).
+		Status(http.StatusOK).
+		End()
+	apitest.Handler(handler).
+		Post("/auth/token").
+		Bodyf(`{"token":%q}`, session).
+		Expect(t).
+		Status(http.StatusUnauthorized).
+		End()
+	apitest.Handler(handler).
+		Get("/admin/users/nobody").
+		Header("Authorization", "Bearer "+admin).
+		Expect(t).
+		Status(http.StatusNotFound).
+		End()
+}
+
+func TestOAuthClientCredentials(t *testing.T) {
+	ctx := context.Background()
+	db, err := auth.OpenMemory(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := auth.RegisterUser(ctx, db, "backup-bot", []byte("1234")); err != nil {
+		t.Fatal(err)
+	}
+	service, secret, err := auth.RegisterClient(ctx, db, auth.NewClient{Name: "backup", Login: "backup-bot"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	webapp, webSecret, err := auth.RegisterClient(ctx, db, auth.NewClient{Name: "wiki", RedirectURIs: []string{"https://wiki.example.com/cb"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := api.Handler(db, api.WithClientTokenTTL(time.Minute))
+	var token string
+	apitest.Handler(handler).
+		Post("/oauth/token").
+		BasicAuth(service.ClientID, secret).
+		FormData("grant_type", "client_credentials").
+		Expect(t).
+		Status(http.StatusOK).
+		Header("Cache-Control", "no-store").
+		Assert(jsonpath.Chain().Equal("token_type", "Bearer").Equal("expires_in", float64(60)).End()).
+		Assert(jsonpath.Present("access_token")).
+		End().
+		JSON(&struct {
+			Token *string `json:"access_token"`
+		}{Token: &token})
+	apitest.Handler(handler).
+		Post("/auth/token").
+		Bodyf(`{"token":%q}`, token).
+		Expect(t).
+		Status(http.StatusOK).
+		Assert(jsonpath.Chain().Equal("login", "backup-bot").Equal("tokenType", auth.TokenTypeMachine).End()).
+		End()
+	apitest.Handler(handler).
+		Post("/oauth/token").
+		FormData("grant_type", "client_credentials").
+		FormData("client_id", service.ClientID).
+		FormData("client_secret", "wrong").
+		Expect(t).
+		Status(http.StatusUnauthorized).
+		Assert(jsonpath.Equal("error", "invalid_client")).
+		End()
+	apitest.Handler(handler).
+		Post("/oauth/token").
+		BasicAuth(webapp.ClientID, webSecret).
+		FormData("grant_type", "client_credentials").
+		Expect(t).
+		Status(http.StatusBadRequest).
+		Assert(jsonpath.Equal("error", "unauthorized_client")).
+		End()
+	apitest.Handler(handler).
+		Post("/oauth/token").
+		BasicAuth(service.ClientID, secret).
+		FormData("grant_type", "password").
+		Expect(t).
+		Status(http.StatusBadRequest).
+		Assert(jsonpath.Equal("error", "unsupported_grant_type")).
+		End()
+	if err := auth.DisableUser(ctx, db, "backup-bot"); err != nil {
+		t.Fatal(err)
+	}
+	apitest.Handler(handler).
+		Post("/oauth/token").
+		BasicAuth(service.ClientID, secret).
+		FormData("grant_type", "client_credentials").
+		Expect(t).
+		Status(http.StatusBadRequest).
+		Assert(jsonpath.Equal("error", "unauthorized_client")).
+		End()
+}
+
+func TestOAuthIntrospectRevoke(t *testing.T) {
+	ctx := context.Background()
+	db, err := auth.OpenMemory(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var uid string
+	if uid, err = auth.RegisterUser(ctx, db, "bob", []byte("1234")); err != nil {
+		t.Fatal(err)
+	}
+	gateway, secret, err := auth.RegisterClient(ctx, db, auth.NewClient{Name: "gateway", Login: "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, otherSecret, err := auth.RegisterClient(ctx, db, auth.NewClient{Name: "other", Login: "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spa, _, err := auth.RegisterClient(ctx, db, auth.NewClient{Name: "spa", RedirectURIs: []string{"https://spa.example.com/cb"}, Public: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := auth.CreateToken(ctx, db, "bob", auth.TokenTypeSession, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	machine, _, err := auth.IssueClientToken(ctx, db, gateway, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := api.Handler(db)
+
+	apitest.Handler(handler).
+		Post("/oauth/introspect").
+		BasicAuth(gateway.ClientID, secret).
+		FormData("token", session).
+		Expect(t).
+		Status(http.StatusOK).
+		Assert(jsonpath.Chain().
+			Equal("active", true).
+			Equal("sub", uid).
+			Equal("username", "bob").
+			Equal("token_type", "Bearer").
+			Equal("auth_token_type", auth.TokenTypeSession).
+			End()).
+		Assert(jsonpath.Present("exp")).
+		End()
+	apitest.Handler(handler).
+		Post("/oauth/introspect").
+		BasicAuth(gateway.ClientID, secret).
+		FormData("token", machine).
+		Expect(t).
+		Status(http.StatusOK).
+		Assert(jsonpath.Chain().Equal("active", true).Equal("client_id", gateway.ClientID).End()).
+		End()
+	apitest.Handler(handler).
+		Post("/oauth/introspect").
+		BasicAuth(gateway.ClientID, secret).
+		FormData("token", "not-a-token").
+		Expect(t).
+		Status(http.StatusOK).
+		Body(`{"active": false}`).
+		End()
+	apitest.Handler(handler).
+		Post("/oauth/introspect").
+		FormData("client_id", spa.ClientID).
+		FormData("token", session).
+		Expect(t).
+		Status(http.StatusUnauthorized).
+		Assert(jsonpath.Equal("error", "invalid_client")).
+		End()
+
+	apitest.Handler(handler).
+		Post("/oauth/revoke").
+		BasicAuth(other.ClientID, otherSecret).
+		FormData("token", machine).
+		Expect(t).
+		Status(http.StatusBadRequest).
+		Assert(jsonpath.Equal("error", "unauthorized_client")).
+		End()
+	for _, token := range []string{machine, session, "not-a-token"} {
+		apitest.Handler(handler).
+			Post("/oauth/revoke").
+			BasicAuth(gateway.ClientID, secret).
+			FormData("token", token).
+			Expect(t).
+			Status(http.StatusOK).
+			End()
+	}
+	for _, token := range []string{machine, session} {
+		apitest.Handler(handler).
+			Post("/oauth/introspect").
+			BasicAuth(gateway.ClientID, secret).
+			FormData("token", token).
+			Expect(t).
+			Status(http.StatusOK).
+			Body(`{"active": false}`).
+			End()
+	}
+}
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/andrebq/auth"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+type (
+	// oauthError is returned by the /oauth endpoints as defined by RFC 6749 section 5.2
+	oauthError struct {
+		status      int
+		Code        string `json:"error"`
+		Description string `json:"error_description,omitempty"`
+	}
+)
+
+// WithClientTokenTTL changes how long tokens issued by the client_credentials grant are valid
+func WithClientTokenTTL(ttl time.Duration) Option {
+	return func(c *config) {
+		c.clientTokenTTL = ttl
+	}
+}
+
+func (e oauthError) HTTPStatus() int {
+	if e.status == 0 {
+		return http.StatusBadRequest
+	}
+	return e.status
+}
+
+func oauthTokenHandler(st auth.Store, cfg config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		client, ok := oauthClient(w, r, st)
+		if !ok {
+			return
+		}
+		switch {
+		case r.PostFormValue("grant_type") != auth.GrantClientCredentials:
+			encode(w, 0, oauthError{Code: "unsupported_grant_type"})
+			return
+		case !slices.Contains(client.GrantTypes(), auth.GrantClientCredentials):
+			encode(w, 0, oauthError{Code: "unauthorized_client", Description: "Client is not bound to a user"})
+			return
+		}
+		token, expiresAt, err := auth.IssueClientToken(r.Context(), st, client, cfg.clientTokenTTL)
+		if errors.Is(err, auth.ErrNotFound) {
+			encode(w, 0, oauthError{Code: "unauthorized_client", Description: "The user bound to the client is disabled or was removed"})
+			return
+		} else if err != nil {
+			log.Error().Err(err).Str("clientID", client.ClientID).Msg("Unable to issue client token")
+			encode(w, 0, oauthError{status: http.StatusInternalServerError, Code: "server_error"})
+			return
+		}
+		encode(w, http.StatusOK, struct {
+			AccessToken string `json:"access_token"`
+			TokenType   string `json:"token_type"`
+			ExpiresIn   int64  `json:"expires_in"`
+		}{
+			AccessToken: token,
+			TokenType:   "Bearer",
+			ExpiresIn:   int64(time.Until(expiresAt).Round(time.Second) / time.Second),
+		})
+	})
+}
+
+// oauthIntrospectHandler implements RFC 7662, invalid tokens are reported as
+// inactive instead of returning an error
+func oauthIntrospectHandler(st auth.Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		if _, ok := oauthConfidentialClient(w, r, st); !ok {
+			return
+		}
+		inactive := struct {
+			Active bool `json:"active"`
+		}{}
+		t, err := auth.InspectToken(r.Context(), st, r.PostFormValue("token"))
+		if err != nil || auth.SingleUse(t.TokenType) {
+			encode(w, http.StatusOK, inactive)
+			return
+		}
+		user, err := auth.LookupUserByUID(r.Context(), st, t.UID)
+		if err != nil || !user.Active {
+			encode(w, http.StatusOK, inactive)
+			return
+		}
+		encode(w, http.StatusOK, struct {
+			Active    bool     `json:"active"`
+			Subject   string   `json:"sub"`
+			Username  string   `json:"username"`
+			ExpiresAt int64    `json:"exp"`
+			IssuedAt  int64    `json:"iat"`
+			TokenType string   `json:"token_type"`
+			ClientID  string   `json:"client_id,omitempty"`
+			Scope     string   `json:"scope,omitempty"`
+			Groups    []string `json:"groups,omitempty"`
+			// AuthTokenType is the type used by auth (eg.: session or machine),
+			// token_type is always Bearer as defined by RFC 6749
+			AuthTokenType string `json:"auth_token_type"`
+		}{
+			Active:        true,
+			Subject:       t.UID,
+			Username:      user.Login,
+			ExpiresAt:     t.ExpiresAt.Unix(),
+			IssuedAt:      t.CreatedAt.Unix(),
+			TokenType:     "Bearer",
+			ClientID:      t.ClientID,
+			Scope:         t.Scope,
+			Groups:        user.Groups,
+			AuthTokenType: t.TokenType,
+		})
+	})
+}
+
+// oauthRevokeHandler implements RFC 7009, tokens issued to a client can only
+// be revoked by that client. Other tokens (eg.: sessions) can be revoked by
+// any client which holds them, since holding a token already allows using it.
+func oauthRevokeHandler(st auth.Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, ok := oauthConfidentialClient(w, r, st)
+		if !ok {
+			return
+		}
+		t, err := auth.InspectToken(r.Context(), st, r.PostFormValue("token"))
+		if err != nil {
+			// invalid tokens do not need to be revoked, see RFC 7009 section 2.2
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if t.ClientID != "" && t.ClientID != client.ClientID {
+			encode(w, 0, oauthError{Code: "unauthorized_client", Description: "Token was issued to another client"})
+			return
+		}
+		if err := auth.RevokeToken(r.Context(), st, t.TokenID); err != nil {
+			log.Error().Err(err).Str("clientID", client.ClientID).Msg("Unable to revoke token")
+			encode(w, 0, oauthError{status: http.StatusServiceUnavailable, Code: "server_error"})
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// oauthConfidentialClient is like oauthClient but refuses public clients,
+// which cannot prove their identity
+func oauthConfidentialClient(w http.ResponseWriter, r *http.Request, st auth.Store) (auth.Client, bool) {
+	client, ok := oauthClient(w, r, st)
+	if ok && client.Public {
+		encode(w, 0, oauthError{status: http.StatusUnauthorized, Code: "invalid_client", Description: "Public clients are not allowed"})
+		return auth.Client{}, false
+	}
+	return client, ok
+}
+
+// oauthClient authenticates the client with HTTP Basic or with the
+// client_id and client_secret form parameters
+func oauthClient(w http.ResponseWriter, r *http.Request, st auth.Store) (auth.Client, bool) {
+	clientID, secret, basic := r.BasicAuth()
+	if basic {
+		// RFC 6749 section 2.3.1 requires form encoding of the credentials
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	client, err := auth.AuthenticateClient(r.Context(), st, clientID, secret)
+	if err != nil {
+		log := log.Logger.Sample(zerolog.Sometimes)
+		log.Error().Err(err).Str("clientID", clientID).Msg("Client authentication failed")
+		if basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="auth"`)
+		}
+		encode(w, 0, oauthError{status: http.StatusUnauthorized, Code: "invalid_client"})
+		return auth.Client{}, false
+	}
+	return client, true
+}
@@ -0,0 +1,176 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/andrebq/auth"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+type (
+	// ResetNotifier delivers password reset tokens to users (eg.: by email),
+	// without one /password/reset/request is disabled and reset tokens
+	// can only be created by operators
+	ResetNotifier interface {
+		NotifyReset(ctx context.Context, user auth.User, token string) error
+	}
+)
+
+// WithResetNotifier enables self-service password reset requests
+func WithResetNotifier(n ResetNotifier) Option {
+	return func(c *config) {
+		c.resetNotifier = n
+	}
+}
+
+// WithResetTTL changes how long reset tokens sent by the ResetNotifier are valid
+func WithResetTTL(ttl time.Duration) Option {
+	return func(c *config) {
+		c.resetTTL = ttl
+	}
+}
+
+func changePasswordHandler(st auth.Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			encode(w, 0, MethodNotAllowedError())
+			return
+		}
+		c, ok := bearerUser(w, r, st)
+		if !ok {
+			return
+		}
+		var change struct {
+			OldPassword string `json:"oldPassword"`
+			NewPassword string `json:"newPassword"`
+		}
+		if !decode(&change, w, r) {
+			return
+		}
+		err := auth.ChangePassword(r.Context(), st, c.user.UID, []byte(change.OldPassword), []byte(change.NewPassword), c.tokenID)
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			encode(w, 0, UnauthorizedError("Invalid credentials"))
+			return
+		} else if !passwordUpdated(w, err) {
+			return
+		}
+		encode(w, http.StatusOK, struct{}{})
+	})
+}
+
+func resetPasswordHandler(st auth.Store) http.Handler {
+	sampler := zerolog.Sometimes
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			encode(w, 0, MethodNotAllowedError())
+			return
+		}
+		var reset struct {
+			Token       string `json:"token"`
+			NewPassword string `json:"newPassword"`
+		}
+		if !decode(&reset, w, r) {
+			return
+		}
+		err := auth.ResetPassword(r.Context(), st, reset.Token, []byte(reset.NewPassword))
+		var perr *auth.PolicyError
+		if err != nil && !errors.As(err, &perr) {
+			log := log.Logger.Sample(sampler)
+			log.Error().Err(err).Msg("Password reset failed")
+			encode(w, 0, UnauthorizedError("Invalid or expired reset token"))
+			return
+		} else if !passwordUpdated(w, err) {
+			return
+		}
+		encode(w, http.StatusOK, struct{}{})
+	})
+}
+
+func acceptInviteHandler(st auth.Store) http.Handler {
+	sampler := zerolog.Sometimes
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			encode(w, 0, MethodNotAllowedError())
+			return
+		}
+		var invite struct {
+			Token    string `json:"token"`
+			Password string `json:"password"`
+		}
+		if !decode(&invite, w, r) {
+			return
+		}
+		uid, err := auth.AcceptInvite(r.Context(), st, invite.Token, []byte(invite.Password))
+		var perr *auth.PolicyError
+		if err != nil && !errors.As(err, &perr) {
+			log := log.Logger.Sample(sampler)
+			log.Error().Err(err).Msg("Unable to accept invitation")
+			encode(w, 0, UnauthorizedError("Invalid or expired invitation"))
+			return
+		} else if !passwordUpdated(w, err) {
+			return
+		}
+		encode(w, http.StatusOK, struct {
+			UID string `json:"userID"`
+		}{UID: uid})
+	})
+}
+
+func requestResetHandler(st auth.Store, cfg config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			encode(w, 0, MethodNotAllowedError())
+			return
+		}
+		if cfg.resetNotifier == nil {
+			encode(w, 0, NotImplementedError())
+			return
+		}
+		var req struct {
+			Login string `json:"login"`
+		}
+		if !decode(&req, w, r) {
+			return
+		}
+		// the response is the same whether the user exists or not,
+		// otherwise this endpoint could be used to enumerate users
+		if err := sendResetToken(r.Context(), st, cfg, req.Login); err != nil {
+			log.Error().Err(err).Msg("Unable to send reset token")
+		}
+		encode(w, http.StatusAccepted, struct{}{})
+	})
+}
+
+func sendResetToken(ctx context.Context, st auth.Store, cfg config, login string) error {
+	user, err := auth.LookupUser(ctx, st, login)
+	if errors.Is(err, auth.ErrNotFound) || (err == nil && !user.Active) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	token, err := auth.CreateResetToken(ctx, st, login, cfg.resetTTL)
+	if err != nil {
+		return err
+	}
+	return cfg.resetNotifier.NotifyReset(ctx, user, token)
+}
+
+// passwordUpdated writes the error response for err and returns false,
+// if err is nil it returns true without writing anything
+func passwordUpdated(w http.ResponseWriter, err error) bool {
+	var perr *auth.PolicyError
+	switch {
+	case err == nil:
+		return true
+	case errors.As(err, &perr):
+		encode(w, 0, PasswordRejectedError(perr))
+	default:
+		log.Error().Err(err).Msg("Unable to update password")
+		encode(w, 0, InternalError())
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/andrebq/auth"
+	"github.com/rs/zerolog/log"
+)
+
+// WithSignedTokens makes /session issue self-contained tokens signed by keys,
+// see auth.CreateSignedToken
+func WithSignedTokens(keys *auth.SigningKeys) Option {
+	return func(c *config) {
+		c.signingKeys = keys
+	}
+}
+
+// jwksHandler publishes the keys which verify signed tokens, it only
+// needs the public keys so it works even if this server cannot sign
+func jwksHandler(st auth.Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys, err := auth.PublishedKeys(r.Context(), st, auth.KeyPurposeToken)
+		if err != nil {
+			log.Error().Err(err).Msg("Unable to list signing keys")
+			encode(w, 0, InternalError())
+			return
+		}
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		encode(w, http.StatusOK, keys)
+	})
+}
+
+// revokedHandler lists the tokens revoked before their expiration, services
+// verifying or caching tokens locally poll it. The optional since parameter
+// (unix seconds) is usually the until value of the previous response.
+func revokedHandler(st auth.Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var since time.Time
+		if v := r.URL.Query().Get("since"); v != "" {
+			unix, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				encode(w, 0, BadRequestError("since must be a unix timestamp"))
+				return
+			}
+			since = time.Unix(unix, 0)
+		}
+		until := time.Now()
+		ids, err := auth.RevokedTokens(r.Context(), st, since)
+		if err != nil {
+			log.Error().Err(err).Msg("Unable to list revoked tokens")
+			encode(w, 0, InternalError())
+			return
+		}
+		if ids == nil {
+			ids = []string{}
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		encode(w, http.StatusOK, struct {
+			Revoked []string `json:"revoked"`
+			Until   int64    `json:"until"`
+		}{Revoked: ids, Until: until.Unix()})
+	})
+}
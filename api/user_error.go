@@ -2,7 +2,9 @@ package api
 
 import (
 	"net/http"
+	"strings"
 
+	"github.com/andrebq/auth"
 	"github.com/andrebq/auth/internal/usererror"
 )
 
@@ -27,6 +29,43 @@ func BadRequestError(msg string) error {
 	}
 }
 
+func ForbiddenError(msg string) error {
+	return usererror.E{
+		Status:  http.StatusForbidden,
+		Message: msg,
+	}
+}
+
+func NotFoundError() error {
+	return usererror.E{
+		Status:  http.StatusNotFound,
+		Message: "Not found",
+	}
+}
+
+func ConflictError() error {
+	return usererror.E{
+		Status:  http.StatusConflict,
+		Message: "Already exists",
+	}
+}
+
+func MethodNotAllowedError() error {
+	return usererror.E{
+		Status:  http.StatusMethodNotAllowed,
+		Message: "Method not allowed",
+	}
+}
+
+// PasswordRejectedError explains to the user why a new password
+// does not satisfy the password policy
+func PasswordRejectedError(err *auth.PolicyError) error {
+	return usererror.E{
+		Status:  http.StatusUnprocessableEntity,
+		Message: "Password " + strings.Join(err.Violations, ", "),
+	}
+}
+
 func NotImplementedError() error {
 	return usererror.E{
 		Status:  http.StatusInternalServerError,
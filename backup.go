@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/uptrace/bun/driver/sqliteshim"
+	"golang.org/x/crypto/argon2"
+)
+
+type (
+	// BackupOptions controls how Backup writes the snapshot
+	BackupOptions struct {
+		// Passphrase, when not empty, is used to encrypt the backup
+		Passphrase []byte
+		// ExcludeExpiredTokens removes expired (and revoked) tokens
+		// from the snapshot
+		ExcludeExpiredTokens bool
+	}
+
+	// RestoreOptions controls how Restore reads a snapshot
+	RestoreOptions struct {
+		// Passphrase used to decrypt the backup, required if the backup is encrypted
+		Passphrase []byte
+	}
+)
+
+var (
+	// ErrBackupUnsupported is returned when trying to backup or restore a database
+	// which is not managed by SQLite, use the tooling of the database instead (eg.: pg_dump)
+	ErrBackupUnsupported = errors.New("auth: backup and restore are only supported for sqlite databases")
+
+	backupMagic  = []byte("AUTHBAK1")
+	sqliteMagic  = []byte("SQLite format 3\x00")
+	backupKeyLen = uint32(32)
+)
+
+// Backup writes a consistent snapshot of db to out, it is safe to call while
+// other processes are using the database.
+//
+// The snapshot is kept in memory while being encrypted, so this is not
+// suited for very large databases.
+func Backup(ctx context.Context, db *sql.DB, out io.Writer, opts BackupOptions) error {
+	if dialectOf(db) != dialectSQLite {
+		return ErrBackupUnsupported
+	}
+	tmpdir, err := os.MkdirTemp("", "auth-backup")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpdir)
+	snapshot := filepath.Join(tmpdir, "users.db")
+	if err := onlineBackup(ctx, db, snapshot); err != nil {
+		return err
+	}
+	if opts.ExcludeExpiredTokens {
+		err = withSQLiteFile(ctx, snapshot, func(db *sql.DB) error {
+			// revoking a token also expires it, but match revocations explicitly
+			_, err := db.ExecContext(ctx, `delete from db_tokens where expires_at_unix <= ? or revoked_at_unix > 0`, time.Now().Unix())
+			if err != nil {
+				return err
+			}
+			return execCmds(ctx, db, []string{`vacuum`})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	buf, err := os.ReadFile(snapshot)
+	if err != nil {
+		return err
+	}
+	if len(opts.Passphrase) > 0 {
+		buf, err = sealBackup(buf, opts.Passphrase)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = out.Write(buf)
+	return err
+}
+
+// Restore replaces the contents of db with the snapshot read from in,
+// after checking that the snapshot schema is supported by this binary.
+// Pending migrations are applied after the snapshot is restored.
+func Restore(ctx context.Context, db *sql.DB, in io.Reader, opts RestoreOptions) error {
+	if dialectOf(db) != dialectSQLite {
+		return ErrBackupUnsupported
+	}
+	buf, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	switch {
+	case bytes.HasPrefix(buf, backupMagic):
+		if len(opts.Passphrase) == 0 {
+			return errors.New("auth: backup is encrypted, a passphrase is required")
+		}
+		buf, err = openBackup(buf, opts.Passphrase)
+		if err != nil {
+			return err
+		}
+	case !bytes.HasPrefix(buf, sqliteMagic):
+		return errors.New("auth: input is not a valid backup")
+	}
+	tmpdir, err := os.MkdirTemp("", "auth-restore")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpdir)
+	snapshot := filepath.Join(tmpdir, "users.db")
+	if err := os.WriteFile(snapshot, buf, 0600); err != nil {
+		return err
+	}
+	err = withSQLiteFile(ctx, snapshot, func(snapdb *sql.DB) error {
+		status, err := MigrationStatus(ctx, snapdb)
+		if err != nil {
+			return err
+		}
+		if status.Current > status.Latest {
+			return fmt.Errorf("%w: backup is at version %v", ErrSchemaTooNew, status.Current)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := onlineRestore(ctx, db, snapshot); err != nil {
+		return err
+	}
+	_, err = Migrate(ctx, db)
+	return err
+}
+
+func withSQLiteFile(ctx context.Context, file string, fn func(*sql.DB) error) error {
+	db, err := sql.Open(sqliteshim.ShimName, fmt.Sprintf("file:%v", file))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return fn(db)
+}
+
+func backupKey(passphrase, salt []byte) []byte {
+	return argon2.IDKey(passphrase, salt, 3, 64*1024, 4, backupKeyLen)
+}
+
+func sealBackup(plain, passphrase []byte) ([]byte, error) {
+	salt, err := randomSalt(16)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(backupKey(passphrase, salt))
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomSalt(aead.NonceSize())
+	if err != nil {
+		return nil, err
+	}
+	header := append(append(append([]byte{}, backupMagic...), salt...), nonce...)
+	return aead.Seal(header, nonce, plain, backupMagic), nil
+}
+
+func openBackup(sealed, passphrase []byte) ([]byte, error) {
+	sealed = sealed[len(backupMagic):]
+	if len(sealed) < 16 {
+		return nil, errors.New("auth: backup is truncated")
+	}
+	salt, sealed := sealed[:16], sealed[16:]
+	aead, err := newGCM(backupKey(passphrase, salt))
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("auth: backup is truncated")
+	}
+	nonce, sealed := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, sealed, backupMagic)
+	if err != nil {
+		return nil, errors.New("auth: invalid passphrase or corrupted backup")
+	}
+	return plain, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
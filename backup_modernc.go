@@ -0,0 +1,60 @@
+//go:build !cgosqlite && ((darwin && amd64) || (darwin && arm64) || (linux && 386) || (linux && amd64) || (linux && arm) || (linux && arm64) || (windows && amd64))
+
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"modernc.org/sqlite"
+)
+
+type (
+	backuper interface {
+		NewBackup(dstUri string) (*sqlite.Backup, error)
+		NewRestore(srcUri string) (*sqlite.Backup, error)
+	}
+)
+
+// onlineBackup uses the SQLite online backup API to copy db into file
+func onlineBackup(ctx context.Context, db *sql.DB, file string) error {
+	return withBackuper(ctx, db, func(b backuper) (*sqlite.Backup, error) {
+		return b.NewBackup(fmt.Sprintf("file:%v", file))
+	})
+}
+
+// onlineRestore uses the SQLite online backup API to replace the contents of db
+// with the contents of file
+func onlineRestore(ctx context.Context, db *sql.DB, file string) error {
+	return withBackuper(ctx, db, func(b backuper) (*sqlite.Backup, error) {
+		return b.NewRestore(fmt.Sprintf("file:%v", file))
+	})
+}
+
+func withBackuper(ctx context.Context, db *sql.DB, start func(backuper) (*sqlite.Backup, error)) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Raw(func(driverConn any) error {
+		b, ok := driverConn.(backuper)
+		if !ok {
+			return errors.New("auth: sqlite driver does not support online backups")
+		}
+		bkp, err := start(b)
+		if err != nil {
+			return err
+		}
+		for more := true; more; {
+			more, err = bkp.Step(-1)
+			if err != nil {
+				bkp.Finish()
+				return err
+			}
+		}
+		return bkp.Finish()
+	})
+}
@@ -0,0 +1,20 @@
+//go:build !(!cgosqlite && ((darwin && amd64) || (darwin && arm64) || (linux && 386) || (linux && amd64) || (linux && arm) || (linux && arm64) || (windows && amd64)))
+
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// onlineBackup relies on VACUUM INTO which also produces a consistent
+// snapshot when the online backup API is not exposed by the driver
+func onlineBackup(ctx context.Context, db *sql.DB, file string) error {
+	_, err := db.ExecContext(ctx, `vacuum into ?`, file)
+	return err
+}
+
+func onlineRestore(ctx context.Context, db *sql.DB, file string) error {
+	return errors.New("auth: restore requires the modernc sqlite driver, stop the server and copy the snapshot to users.db instead")
+}
@@ -0,0 +1,65 @@
+package auth_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/andrebq/auth"
+)
+
+func TestBackupRestore(t *testing.T) {
+	ctx := context.Background()
+	src, err := auth.OpenDir(ctx, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	if _, err := auth.RegisterUser(ctx, src, "bob", []byte("super-secure")); err != nil {
+		t.Fatal(err)
+	}
+	valid, err := auth.CreateToken(ctx, src, "bob", "session", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, err := auth.CreateToken(ctx, src, "bob", "session", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	revokedID, _ := auth.ExtractTokenID(revoked)
+	if err := auth.RevokeToken(ctx, src, revokedID); err != nil {
+		t.Fatal(err)
+	}
+
+	backup := bytes.Buffer{}
+	err = auth.Backup(ctx, src.DB(), &backup, auth.BackupOptions{Passphrase: []byte("backup-secret"), ExcludeExpiredTokens: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(backup.Bytes(), []byte("bob")) {
+		t.Fatal("Encrypted backup should not contain the user login")
+	}
+
+	dst, err := auth.OpenDir(ctx, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	if err := auth.Restore(ctx, dst.DB(), bytes.NewReader(backup.Bytes()), auth.RestoreOptions{Passphrase: []byte("wrong")}); err == nil {
+		t.Fatal("Restore should fail with the wrong passphrase")
+	}
+	if err := auth.Restore(ctx, dst.DB(), bytes.NewReader(backup.Bytes()), auth.RestoreOptions{Passphrase: []byte("backup-secret")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.Login(ctx, dst, "bob", []byte("super-secure")); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := auth.TokenLogin(ctx, dst, valid); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dst.FindToken(ctx, revokedID); !errors.Is(err, auth.ErrNotFound) {
+		t.Fatalf("Revoked token should not be part of the backup, got %v", err)
+	}
+}
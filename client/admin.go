@@ -0,0 +1,272 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/dghubble/sling"
+)
+
+type (
+	// Admin calls the /admin API, every request is authenticated
+	// with a token of type admin
+	Admin struct {
+		base *sling.Sling
+	}
+
+	// User is the administrative view of a user
+	User struct {
+		UID        string            `json:"uid"`
+		Login      string            `json:"login"`
+		Active     bool              `json:"active"`
+		Invited    bool              `json:"invited,omitempty"`
+		Attributes map[string]string `json:"attributes,omitempty"`
+		Groups     []string          `json:"groups,omitempty"`
+	}
+
+	// Client is an application registered with the oidc provider
+	Client struct {
+		ClientID     string    `json:"clientID"`
+		Name         string    `json:"name"`
+		RedirectURIs []string  `json:"redirectURIs"`
+		Public       bool      `json:"public"`
+		UID          string    `json:"uid,omitempty"`
+		CreatedAt    time.Time `json:"createdAt"`
+	}
+
+	// NewClient describes a client to be registered, see RegisterClient
+	NewClient struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirectURIs"`
+		Public       bool     `json:"public"`
+		Login        string   `json:"login,omitempty"`
+	}
+
+	// Token describes a token without exposing its secret
+	Token struct {
+		TokenID   string    `json:"tokenID"`
+		TokenType string    `json:"tokenType"`
+		UID       string    `json:"uid"`
+		ClientID  string    `json:"clientID,omitempty"`
+		Scope     string    `json:"scope,omitempty"`
+		CreatedAt time.Time `json:"createdAt"`
+		ExpiresAt time.Time `json:"expiresAt"`
+	}
+)
+
+// Admin returns a client for the /admin API authenticated with adminToken
+func (c *C) Admin(adminToken string) *Admin {
+	return &Admin{base: c.base.New().Set("Authorization", "Bearer "+adminToken)}
+}
+
+// ListUsers returns all users ordered by login
+func (a *Admin) ListUsers(ctx context.Context) ([]User, error) {
+	var out []User
+	err := receive(a.base.New().Get("/admin/users"), &out, http.StatusOK)
+	return out, err
+}
+
+// CreateUser registers a new active user
+func (a *Admin) CreateUser(ctx context.Context, login, password string) (User, error) {
+	var out User
+	err := receive(a.base.New().BodyJSON(struct {
+		Login    string `json:"login"`
+		Password string `json:"password"`
+	}{
+		Login:    login,
+		Password: password,
+	}).Post("/admin/users"), &out, http.StatusCreated)
+	return out, err
+}
+
+// ImportUser creates an active user with a password hash exported
+// from another system
+func (a *Admin) ImportUser(ctx context.Context, login, passwordHash string) (User, error) {
+	var out User
+	err := receive(a.base.New().BodyJSON(struct {
+		Login        string `json:"login"`
+		PasswordHash string `json:"passwordHash"`
+	}{
+		Login:        login,
+		PasswordHash: passwordHash,
+	}).Post("/admin/users"), &out, http.StatusCreated)
+	return out, err
+}
+
+// InviteUser creates an inactive user and returns its invitation token
+func (a *Admin) InviteUser(ctx context.Context, login string, attrs map[string]string, ttl time.Duration) (string, error) {
+	var out struct {
+		Token string `json:"token"`
+	}
+	err := receive(a.base.New().BodyJSON(struct {
+		Login      string            `json:"login"`
+		Attributes map[string]string `json:"attributes"`
+		TTL        string            `json:"ttl"`
+	}{
+		Login:      login,
+		Attributes: attrs,
+		TTL:        ttl.String(),
+	}).Post("/admin/invites"), &out, http.StatusCreated)
+	return out.Token, err
+}
+
+// LookupUser returns login including its attributes and groups
+func (a *Admin) LookupUser(ctx context.Context, login string) (User, error) {
+	var out User
+	err := receive(a.base.New().Get(userPath(login)), &out, http.StatusOK)
+	return out, err
+}
+
+// DeleteUser removes login and all of its tokens
+func (a *Admin) DeleteUser(ctx context.Context, login string) error {
+	return receive(a.base.New().Delete(userPath(login)), nil, http.StatusOK)
+}
+
+// DisableUser prevents login from authenticating and revokes its tokens
+func (a *Admin) DisableUser(ctx context.Context, login string) error {
+	return receive(a.base.New().Post(userPath(login)+"/disable"), nil, http.StatusOK)
+}
+
+// EnableUser allows a disabled user to authenticate again
+func (a *Admin) EnableUser(ctx context.Context, login string) error {
+	return receive(a.base.New().Post(userPath(login)+"/enable"), nil, http.StatusOK)
+}
+
+// SetPassword replaces the password of login
+func (a *Admin) SetPassword(ctx context.Context, login, password string) error {
+	return receive(a.base.New().BodyJSON(struct {
+		Password string `json:"password"`
+	}{
+		Password: password,
+	}).Put(userPath(login)+"/password"), nil, http.StatusOK)
+}
+
+// RenameUser changes the login of a user
+func (a *Admin) RenameUser(ctx context.Context, login, newLogin string) error {
+	return receive(a.base.New().BodyJSON(struct {
+		Login string `json:"login"`
+	}{
+		Login: newLogin,
+	}).Post(userPath(login)+"/rename"), nil, http.StatusOK)
+}
+
+// SetAttributes inserts or replaces attributes of login,
+// attributes with an empty value are removed
+func (a *Admin) SetAttributes(ctx context.Context, login string, attrs map[string]string) error {
+	return receive(a.base.New().BodyJSON(struct {
+		Attributes map[string]string `json:"attributes"`
+	}{
+		Attributes: attrs,
+	}).Patch(userPath(login)+"/attributes"), nil, http.StatusOK)
+}
+
+// CreateResetToken returns a single use token which lets login choose a new password
+func (a *Admin) CreateResetToken(ctx context.Context, login string, ttl time.Duration) (string, error) {
+	var out struct {
+		Token string `json:"token"`
+	}
+	err := receive(a.base.New().BodyJSON(struct {
+		TTL string `json:"ttl"`
+	}{
+		TTL: ttl.String(),
+	}).Post(userPath(login)+"/reset-token"), &out, http.StatusCreated)
+	return out.Token, err
+}
+
+// ListTokens returns every token of login, newest first
+func (a *Admin) ListTokens(ctx context.Context, login string) ([]Token, error) {
+	var out []Token
+	err := receive(a.base.New().Get(userPath(login)+"/tokens"), &out, http.StatusOK)
+	return out, err
+}
+
+// CreateToken mints a token of tokenType for login
+func (a *Admin) CreateToken(ctx context.Context, login, tokenType string, ttl time.Duration) (string, error) {
+	var out struct {
+		Token string `json:"token"`
+	}
+	err := receive(a.base.New().BodyJSON(struct {
+		TokenType string `json:"tokenType"`
+		TTL       string `json:"ttl"`
+	}{
+		TokenType: tokenType,
+		TTL:       ttl.String(),
+	}).Post(userPath(login)+"/tokens"), &out, http.StatusCreated)
+	return out.Token, err
+}
+
+// RevokeToken expires the token with the given id
+func (a *Admin) RevokeToken(ctx context.Context, tokenID string) error {
+	return receive(a.base.New().Delete("/admin/tokens/"+url.PathEscape(tokenID)), nil, http.StatusOK)
+}
+
+// ListClients returns all clients ordered by name
+func (a *Admin) ListClients(ctx context.Context) ([]Client, error) {
+	var out []Client
+	err := receive(a.base.New().Get("/admin/clients"), &out, http.StatusOK)
+	return out, err
+}
+
+// RegisterClient creates a client, the secret is empty for public clients.
+// Clients with a login can use the client_credentials grant.
+func (a *Admin) RegisterClient(ctx context.Context, nc NewClient) (Client, string, error) {
+	var out struct {
+		Client Client `json:"client"`
+		Secret string `json:"secret"`
+	}
+	err := receive(a.base.New().BodyJSON(nc).Post("/admin/clients"), &out, http.StatusCreated)
+	return out.Client, out.Secret, err
+}
+
+// DeleteClient removes a client
+func (a *Admin) DeleteClient(ctx context.Context, clientID string) error {
+	return receive(a.base.New().Delete("/admin/clients/"+url.PathEscape(clientID)), nil, http.StatusOK)
+}
+
+// ListGroups returns the name of every group
+func (a *Admin) ListGroups(ctx context.Context) ([]string, error) {
+	var out []string
+	err := receive(a.base.New().Get("/admin/groups"), &out, http.StatusOK)
+	return out, err
+}
+
+// CreateGroup adds an empty group
+func (a *Admin) CreateGroup(ctx context.Context, name string) error {
+	return receive(a.base.New().BodyJSON(struct {
+		Name string `json:"name"`
+	}{
+		Name: name,
+	}).Post("/admin/groups"), nil, http.StatusCreated)
+}
+
+// DeleteGroup removes a group and all of its memberships
+func (a *Admin) DeleteGroup(ctx context.Context, name string) error {
+	return receive(a.base.New().Delete(groupPath(name)), nil, http.StatusOK)
+}
+
+// GroupMembers returns the members of a group ordered by login
+func (a *Admin) GroupMembers(ctx context.Context, name string) ([]User, error) {
+	var out []User
+	err := receive(a.base.New().Get(groupPath(name)+"/members"), &out, http.StatusOK)
+	return out, err
+}
+
+// AddToGroup makes login a member of group
+func (a *Admin) AddToGroup(ctx context.Context, group, login string) error {
+	return receive(a.base.New().Put(groupPath(group)+"/members/"+url.PathEscape(login)), nil, http.StatusOK)
+}
+
+// RemoveFromGroup removes login from group
+func (a *Admin) RemoveFromGroup(ctx context.Context, group, login string) error {
+	return receive(a.base.New().Delete(groupPath(group)+"/members/"+url.PathEscape(login)), nil, http.StatusOK)
+}
+
+func userPath(login string) string {
+	return "/admin/users/" + url.PathEscape(login)
+}
+
+func groupPath(name string) string {
+	return "/admin/groups/" + url.PathEscape(name)
+}
@@ -0,0 +1,164 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/andrebq/auth/internal/usererror"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"golang.org/x/sync/singleflight"
+)
+
+type (
+	// Option customizes a client created by New
+	Option func(*C)
+
+	// ValidationCache configures how results of ValidateTokenInfo are cached
+	ValidationCache struct {
+		// Size is the maximum number of tokens kept in memory
+		Size int
+		// TTL is how long a valid token is trusted without asking the API,
+		// it is capped by the expiration of the token
+		TTL time.Duration
+		// NegativeTTL is how long a rejected token is remembered, zero
+		// disables negative caching
+		NegativeTTL time.Duration
+		// PollInterval is how often revoked tokens are fetched from the API
+		// to evict them from the cache
+		PollInterval time.Duration
+	}
+
+	validationCache struct {
+		cfg     ValidationCache
+		entries *lru.Cache[[sha256.Size]byte, cachedValidation]
+		refresh singleflight.Group
+
+		// lock guards the fields below, it is not held while polling
+		lock     sync.Mutex
+		since    time.Time
+		polledAt time.Time
+		// generation changes whenever a poll evicts tokens, revoked holds
+		// the ids evicted by the latest of those polls
+		generation uint64
+		revoked    map[string]bool
+	}
+
+	cachedValidation struct {
+		info      TokenInfo
+		err       error
+		expiresAt time.Time
+	}
+)
+
+// DefaultValidationCache is a reasonable configuration for proxies
+var DefaultValidationCache = ValidationCache{
+	Size:         10000,
+	TTL:          time.Minute,
+	NegativeTTL:  5 * time.Second,
+	PollInterval: 5 * time.Second,
+}
+
+// WithValidationCache keeps the result of ValidateTokenInfo in a bounded
+// LRU cache keyed by the hash of the token. Revoked tokens are evicted
+// within PollInterval, if the API cannot be polled for three intervals the
+// cache is bypassed.
+func WithValidationCache(cfg ValidationCache) Option {
+	return func(c *C) {
+		if cfg.Size <= 0 {
+			cfg.Size = DefaultValidationCache.Size
+		}
+		if cfg.PollInterval <= 0 {
+			cfg.PollInterval = DefaultValidationCache.PollInterval
+		}
+		entries, _ := lru.New[[sha256.Size]byte, cachedValidation](cfg.Size)
+		c.cache = &validationCache{cfg: cfg, entries: entries}
+	}
+}
+
+func (v *validationCache) validate(ctx context.Context, c *C, token string) (TokenInfo, error) {
+	if !v.poll(ctx, c) {
+		return c.validateTokenInfo(ctx, token)
+	}
+	key := sha256.Sum256([]byte(token))
+	now := time.Now()
+	if entry, found := v.entries.Get(key); found {
+		if now.Before(entry.expiresAt) {
+			return entry.info, entry.err
+		}
+		v.entries.Remove(key)
+	}
+	v.lock.Lock()
+	generation := v.generation
+	v.lock.Unlock()
+	info, err := c.validateTokenInfo(ctx, token)
+	switch {
+	case err == nil:
+		expiresAt := now.Add(v.cfg.TTL)
+		if !info.ExpiresAt.IsZero() && info.ExpiresAt.Before(expiresAt) {
+			expiresAt = info.ExpiresAt
+		}
+		// a poll which finished while the api was answering might have
+		// missed this entry, caching it would undo the eviction
+		v.lock.Lock()
+		if v.generation == generation && !v.revoked[info.TokenID] {
+			v.entries.Add(key, cachedValidation{info: info, expiresAt: expiresAt})
+		}
+		v.lock.Unlock()
+	case v.cfg.NegativeTTL > 0 && rejected(err):
+		v.entries.Add(key, cachedValidation{err: err, expiresAt: now.Add(v.cfg.NegativeTTL)})
+	}
+	return info, err
+}
+
+// poll evicts tokens revoked since the previous poll, it returns false
+// when the cache cannot be trusted because the API is unreachable
+func (v *validationCache) poll(ctx context.Context, c *C) bool {
+	v.lock.Lock()
+	polledAt := v.polledAt
+	v.lock.Unlock()
+	if time.Since(polledAt) < v.cfg.PollInterval {
+		return true
+	}
+	_, err, _ := v.refresh.Do("revoked", func() (any, error) {
+		v.lock.Lock()
+		since := v.since
+		v.lock.Unlock()
+		ids, until, err := c.RevokedTokens(ctx, since)
+		if err != nil {
+			return nil, err
+		}
+		v.lock.Lock()
+		defer v.lock.Unlock()
+		if len(ids) > 0 {
+			revoked := make(map[string]bool, len(ids))
+			for _, id := range ids {
+				revoked[id] = true
+			}
+			for _, key := range v.entries.Keys() {
+				if entry, found := v.entries.Peek(key); found && revoked[entry.info.TokenID] {
+					v.entries.Remove(key)
+				}
+			}
+			v.generation++
+			v.revoked = revoked
+		}
+		v.since, v.polledAt = until, time.Now()
+		return nil, nil
+	})
+	if err != nil && time.Since(polledAt) >= 3*v.cfg.PollInterval {
+		v.entries.Purge()
+		return false
+	}
+	return true
+}
+
+// rejected reports whether err means the API refused the token, as opposed
+// to errors which might go away by retrying (eg.: network failures)
+func rejected(err error) bool {
+	var ue usererror.E
+	return errors.As(err, &ue) && ue.HTTPStatus() == http.StatusUnauthorized
+}
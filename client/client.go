@@ -13,20 +13,44 @@ import (
 
 type (
 	C struct {
-		base *sling.Sling
+		base  *sling.Sling
+		cache *validationCache
+	}
+
+	// TokenInfo describes a valid token and the user who owns it
+	TokenInfo struct {
+		UID        string            `json:"uid"`
+		TokenID    string            `json:"tokenID"`
+		TokenType  string            `json:"tokenType"`
+		Login      string            `json:"login"`
+		Attributes map[string]string `json:"attributes"`
+		Groups     []string          `json:"groups"`
+		// ExpiresAt is zero when the server does not report it
+		ExpiresAt time.Time `json:"expiresAt"`
+	}
+
+	// Profile is the information a user can see about themselves
+	Profile struct {
+		UID        string            `json:"uid"`
+		Login      string            `json:"login"`
+		Attributes map[string]string `json:"attributes"`
 	}
 )
 
-func New(base string) *C {
+func New(base string, opts ...Option) *C {
 	if strings.HasSuffix(base, "//") {
 		base = fmt.Sprintf("%v/", strings.ReplaceAll(base, "//", ""))
 	}
 	if !strings.HasSuffix(base, "/") {
 		base = fmt.Sprintf("%v/", base)
 	}
-	return &C{
+	c := &C{
 		base: sling.New().Base(base),
 	}
+	for _, o := range opts {
+		o(c)
+	}
+	return c
 }
 
 func (c *C) Login(ctx context.Context, login, password string) error {
@@ -50,25 +74,74 @@ func (c *C) Login(ctx context.Context, login, password string) error {
 }
 
 func (c *C) ValidateToken(ctx context.Context, token string) (string, string, error) {
-	var ue usererror.E
-	var out struct {
-		UDI       string `json:"uid"`
-		TokenID   string `json:"tokenID"`
-		TokenType string `json:"tokenType"`
+	info, err := c.ValidateTokenInfo(ctx, token)
+	if err != nil {
+		return "", "", err
 	}
-	res, err := c.base.BodyJSON(struct {
+	return info.UID, info.TokenType, nil
+}
+
+// ValidateTokenInfo is like ValidateToken but also returns the login
+// and attributes of the token owner
+func (c *C) ValidateTokenInfo(ctx context.Context, token string) (TokenInfo, error) {
+	if c.cache != nil {
+		return c.cache.validate(ctx, c, token)
+	}
+	return c.validateTokenInfo(ctx, token)
+}
+
+func (c *C) validateTokenInfo(ctx context.Context, token string) (TokenInfo, error) {
+	var ue usererror.E
+	var out TokenInfo
+	res, err := c.base.New().BodyJSON(struct {
 		Token string `json:"token"`
 	}{
 		Token: token,
 	}).Post("/auth/token").Receive(&out, &ue)
 	if err != nil {
-		return "", "", err
+		return TokenInfo{}, err
+	} else if ue.Failure() {
+		return TokenInfo{}, ue
+	} else if res.StatusCode != http.StatusOK {
+		return TokenInfo{}, fmt.Errorf("client: unexpected status code %v", res.StatusCode)
+	}
+	return out, nil
+}
+
+// Profile returns the profile of the user who owns token
+func (c *C) Profile(ctx context.Context, token string) (Profile, error) {
+	var ue usererror.E
+	var out Profile
+	res, err := c.base.New().Set("Authorization", "Bearer "+token).Get("/user/profile").Receive(&out, &ue)
+	if err != nil {
+		return Profile{}, err
+	} else if ue.Failure() {
+		return Profile{}, ue
+	} else if res.StatusCode != http.StatusOK {
+		return Profile{}, fmt.Errorf("client: unexpected status code %v", res.StatusCode)
+	}
+	return out, nil
+}
+
+// UpdateProfile changes attributes of the user who owns token, the server
+// only accepts attributes which are configured as editable.
+// Attributes with an empty value are removed.
+func (c *C) UpdateProfile(ctx context.Context, token string, attrs map[string]string) (Profile, error) {
+	var ue usererror.E
+	var out Profile
+	res, err := c.base.New().Set("Authorization", "Bearer "+token).BodyJSON(struct {
+		Attributes map[string]string `json:"attributes"`
+	}{
+		Attributes: attrs,
+	}).Patch("/user/profile").Receive(&out, &ue)
+	if err != nil {
+		return Profile{}, err
 	} else if ue.Failure() {
-		return "", "", ue
+		return Profile{}, ue
 	} else if res.StatusCode != http.StatusOK {
-		return "", "", fmt.Errorf("client: unexpected status code %v", res.StatusCode)
+		return Profile{}, fmt.Errorf("client: unexpected status code %v", res.StatusCode)
 	}
-	return out.UDI, out.TokenType, err
+	return out, nil
 }
 
 func (c *C) StartSession(ctx context.Context, login, password string, ttl time.Duration) (string, error) {
@@ -94,3 +167,70 @@ func (c *C) StartSession(ctx context.Context, login, password string, ttl time.D
 	}
 	return out.Token, nil
 }
+
+// ChangePassword replaces the password of the user who owns token,
+// every other token of the user is revoked
+func (c *C) ChangePassword(ctx context.Context, token, oldPassword, newPassword string) error {
+	req := c.base.New().Set("Authorization", "Bearer "+token).BodyJSON(struct {
+		OldPassword string `json:"oldPassword"`
+		NewPassword string `json:"newPassword"`
+	}{
+		OldPassword: oldPassword,
+		NewPassword: newPassword,
+	}).Post("/user/password")
+	return c.expectSuccess(req, http.StatusOK)
+}
+
+// RequestPasswordReset asks the server to deliver a reset token to login,
+// it succeeds even if login does not exist
+func (c *C) RequestPasswordReset(ctx context.Context, login string) error {
+	req := c.base.New().BodyJSON(struct {
+		Login string `json:"login"`
+	}{
+		Login: login,
+	}).Post("/password/reset/request")
+	return c.expectSuccess(req, http.StatusAccepted)
+}
+
+// ResetPassword redeems a reset token and sets a new password
+func (c *C) ResetPassword(ctx context.Context, resetToken, newPassword string) error {
+	req := c.base.New().BodyJSON(struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"newPassword"`
+	}{
+		Token:       resetToken,
+		NewPassword: newPassword,
+	}).Post("/password/reset")
+	return c.expectSuccess(req, http.StatusOK)
+}
+
+// AcceptInvite redeems an invitation token, activating the
+// invited user with the given password
+func (c *C) AcceptInvite(ctx context.Context, inviteToken, password string) error {
+	req := c.base.New().BodyJSON(struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}{
+		Token:    inviteToken,
+		Password: password,
+	}).Post("/invite/accept")
+	return c.expectSuccess(req, http.StatusOK)
+}
+
+func (c *C) expectSuccess(req *sling.Sling, status int) error {
+	return receive(req, nil, status)
+}
+
+// receive decodes a successful response into out, which might be nil
+func receive(req *sling.Sling, out interface{}, status int) error {
+	var ue usererror.E
+	res, err := req.Receive(out, &ue)
+	if err != nil {
+		return err
+	} else if ue.Failure() {
+		return ue
+	} else if res.StatusCode != status {
+		return fmt.Errorf("client: unexpected status code %v", res.StatusCode)
+	}
+	return nil
+}
@@ -2,14 +2,18 @@ package client_test
 
 import (
 	"context"
+	"errors"
+	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/andrebq/auth"
 	"github.com/andrebq/auth/api"
 	"github.com/andrebq/auth/client"
+	"github.com/andrebq/auth/jwt"
 )
 
 func TestClientAPI(t *testing.T) {
@@ -32,7 +36,7 @@ func TestClientAPI(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	token, err := cli.StartSession(ctx, "bob", "bob", time.Second)
+	token, err := cli.StartSession(ctx, "bob", "bob", time.Minute)
 	if err != nil {
 		t.Fatal(err)
 	} else if _, err = auth.ExtractTokenID(token); err != nil {
@@ -48,3 +52,417 @@ func TestClientAPI(t *testing.T) {
 		t.Fatalf("Invalid token type should be session got %v", actualTokenType)
 	}
 }
+
+func TestClientProfile(t *testing.T) {
+	ctx := context.Background()
+	db := auth.NewMemoryStore()
+	server := httptest.NewServer(api.Handler(db, api.WithEditableAttributes(auth.AttrName)))
+	defer server.Close()
+	if _, err := auth.RegisterUser(ctx, db, "bob", []byte("bob")); err != nil {
+		t.Fatal(err)
+	}
+
+	cli := client.New(server.URL)
+	token, err := cli.StartSession(ctx, "bob", "bob", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cli.UpdateProfile(ctx, token, map[string]string{auth.AttrName: "Bob"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cli.UpdateProfile(ctx, token, map[string]string{"team": "infra"}); err == nil {
+		t.Fatal("Only editable attributes should be accepted")
+	}
+	info, err := cli.ValidateTokenInfo(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	} else if info.Login != "bob" || !reflect.DeepEqual(info.Attributes, map[string]string{auth.AttrName: "Bob"}) {
+		t.Fatalf("Unexpected token info %#v", info)
+	}
+}
+
+func TestClientChangePassword(t *testing.T) {
+	ctx := context.Background()
+	db := auth.NewMemoryStore()
+	server := httptest.NewServer(api.Handler(db))
+	defer server.Close()
+	if _, err := auth.RegisterUser(ctx, db, "bob", []byte("bob")); err != nil {
+		t.Fatal(err)
+	}
+
+	cli := client.New(server.URL)
+	token, err := cli.StartSession(ctx, "bob", "bob", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.ChangePassword(ctx, token, "wrong", "robert"); err == nil {
+		t.Fatal("Wrong old password should be rejected")
+	}
+	if err := cli.ChangePassword(ctx, token, "bob", "robert"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.Login(ctx, "bob", "robert"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.RequestPasswordReset(ctx, "bob"); err == nil {
+		t.Fatal("Reset requests should fail when the server has no notifier")
+	}
+}
+
+func TestClientAdmin(t *testing.T) {
+	ctx := context.Background()
+	db := auth.NewMemoryStore()
+	server := httptest.NewServer(api.Handler(db))
+	defer server.Close()
+	if _, err := auth.RegisterUser(ctx, db, "root", []byte("root")); err != nil {
+		t.Fatal(err)
+	}
+	token, err := auth.CreateToken(ctx, db, "root", auth.TokenTypeAdmin, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cli := client.New(server.URL)
+	admin := cli.Admin(token)
+	if _, err := admin.CreateUser(ctx, "bob", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.CreateGroup(ctx, "ops"); err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.AddToGroup(ctx, "ops", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	bobToken, err := admin.CreateToken(ctx, "bob", "session", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := cli.ValidateTokenInfo(ctx, bobToken)
+	if err != nil {
+		t.Fatal(err)
+	} else if info.Login != "bob" || !reflect.DeepEqual(info.Groups, []string{"ops"}) {
+		t.Fatalf("Unexpected token info %#v", info)
+	}
+	tokens, err := admin.ListTokens(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(tokens) != 1 || tokens[0].TokenID != info.TokenID {
+		t.Fatalf("Unexpected tokens %#v", tokens)
+	}
+	if err := admin.RevokeToken(ctx, info.TokenID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cli.ValidateTokenInfo(ctx, bobToken); err == nil {
+		t.Fatal("Revoked token should be rejected")
+	}
+	members, err := admin.GroupMembers(ctx, "ops")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(members) != 1 || members[0].Login != "bob" {
+		t.Fatalf("Unexpected members %#v", members)
+	}
+	if _, err := cli.Admin(bobToken).ListUsers(ctx); err == nil {
+		t.Fatal("Non admin tokens should be rejected")
+	}
+	users, err := admin.ListUsers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(users) != 2 {
+		t.Fatalf("Unexpected users %#v", users)
+	}
+	app, secret, err := admin.RegisterClient(ctx, client.NewClient{Name: "app", RedirectURIs: []string{"https://app.example.com/cb"}})
+	if err != nil {
+		t.Fatal(err)
+	} else if app.ClientID == "" || secret == "" {
+		t.Fatalf("Unexpected client %#v", app)
+	}
+	if _, _, err := admin.RegisterClient(ctx, client.NewClient{Name: "bad", RedirectURIs: []string{"/cb"}}); err == nil {
+		t.Fatal("Relative redirect uri should be rejected")
+	}
+	if clients, err := admin.ListClients(ctx); err != nil || len(clients) != 1 || clients[0].Name != "app" {
+		t.Fatalf("Unexpected clients %#v, %v", clients, err)
+	}
+	if err := admin.DeleteClient(ctx, app.ClientID); err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.DeleteClient(ctx, app.ClientID); err == nil {
+		t.Fatal("Deleting a missing client should fail")
+	}
+}
+
+func TestClientTokenSource(t *testing.T) {
+	ctx := context.Background()
+	db, err := auth.OpenMemory(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	server := httptest.NewServer(api.Handler(db, api.WithClientTokenTTL(time.Second)))
+	defer server.Close()
+	if _, err := auth.RegisterUser(ctx, db, "backup-bot", []byte("1234")); err != nil {
+		t.Fatal(err)
+	}
+	service, secret, err := auth.RegisterClient(ctx, db, auth.NewClient{Name: "backup", Login: "backup-bot"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cli := client.New(server.URL)
+	var oe client.OAuthError
+	if _, err := cli.TokenSource(service.ClientID, "wrong").Token(ctx); !errors.As(err, &oe) || oe.Code != "invalid_client" {
+		t.Fatalf("Wrong secret should return invalid_client got %v", err)
+	}
+	tokens := cli.TokenSource(service.ClientID, secret)
+	first, err := tokens.Token(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := tokens.Token(ctx); err != nil || again != first {
+		t.Fatalf("Token should be cached got %v, %v", again, err)
+	}
+	if info, err := cli.ValidateTokenInfo(ctx, first); err != nil || info.Login != "backup-bot" || info.TokenType != auth.TokenTypeMachine {
+		t.Fatalf("Unexpected token info %#v, %v", info, err)
+	}
+	time.Sleep(900 * time.Millisecond)
+	if renewed, err := tokens.Token(ctx); err != nil || renewed == first {
+		t.Fatalf("Token should be renewed before it expires got %v, %v", renewed, err)
+	}
+}
+
+func TestClientIntrospectRevoke(t *testing.T) {
+	ctx := context.Background()
+	db, err := auth.OpenMemory(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	server := httptest.NewServer(api.Handler(db))
+	defer server.Close()
+	if _, err := auth.RegisterUser(ctx, db, "bob", []byte("1234")); err != nil {
+		t.Fatal(err)
+	}
+	gateway, secret, err := auth.RegisterClient(ctx, db, auth.NewClient{Name: "gateway", Login: "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli := client.New(server.URL)
+	token, err := cli.StartSession(ctx, "bob", "1234", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := cli.Introspect(ctx, gateway.ClientID, secret, token)
+	if err != nil {
+		t.Fatal(err)
+	} else if !info.Active || info.Username != "bob" || info.AuthTokenType != auth.TokenTypeSession {
+		t.Fatalf("Unexpected introspection %#v", info)
+	}
+	if err := cli.Revoke(ctx, gateway.ClientID, secret, token); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := cli.Introspect(ctx, gateway.ClientID, secret, token); err != nil || info.Active {
+		t.Fatalf("Revoked token should be inactive got %#v, %v", info, err)
+	}
+	var oe client.OAuthError
+	if _, err := cli.Introspect(ctx, gateway.ClientID, "wrong", token); !errors.As(err, &oe) || oe.Code != "invalid_client" {
+		t.Fatalf("Wrong secret should return invalid_client got %v", err)
+	}
+}
+
+func TestClientVerifier(t *testing.T) {
+	ctx := context.Background()
+	db, err := auth.OpenMemory(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	encoded, err := auth.GenerateKEK()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kek, err := auth.ParseKEK(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := auth.NewSigningKeys(db, auth.NewKeyring(db, kek), auth.KeyPurposeToken, jwt.EdDSA, time.Hour)
+	server := httptest.NewServer(api.Handler(db, api.WithSignedTokens(keys)))
+	defer server.Close()
+	uid, err := auth.RegisterUser(ctx, db, "bob", []byte("1234"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli := client.New(server.URL)
+	token, err := cli.StartSession(ctx, "bob", "1234", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := cli.Verifier(200 * time.Millisecond)
+	info, err := verifier.ValidateTokenInfo(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	} else if info.UID != uid || info.Login != "bob" || info.TokenType != auth.TokenTypeSession {
+		t.Fatalf("Unexpected token info %#v", info)
+	}
+
+	// the revocation is only noticed after the list is polled again
+	if err := auth.RevokeToken(ctx, db, info.TokenID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := verifier.ValidateToken(ctx, token); err != nil {
+		t.Fatalf("Token should be verified locally until the next poll got %v", err)
+	}
+	time.Sleep(250 * time.Millisecond)
+	if _, _, err := verifier.ValidateToken(ctx, token); !errors.Is(err, client.ErrInvalidToken) {
+		t.Fatalf("Revoked token should be rejected got %v", err)
+	}
+
+	// deleting a user revokes its tokens
+	if _, err := auth.RegisterUser(ctx, db, "alice", []byte("1234")); err != nil {
+		t.Fatal(err)
+	}
+	aliceToken, err := cli.StartSession(ctx, "alice", "1234", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := verifier.ValidateToken(ctx, aliceToken); err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.DeleteUser(ctx, db, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(250 * time.Millisecond)
+	if _, _, err := verifier.ValidateToken(ctx, aliceToken); !errors.Is(err, client.ErrInvalidToken) {
+		t.Fatalf("Tokens of deleted users should be rejected got %v", err)
+	}
+
+	// tokens which are not signed are validated by the api
+	opaque, err := auth.CreateToken(ctx, db, "bob", auth.TokenTypeMachine, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actualUID, _, err := verifier.ValidateToken(ctx, opaque); err != nil || actualUID != uid {
+		t.Fatalf("Unexpected uid %v, %v", actualUID, err)
+	}
+}
+
+func TestClientValidationCache(t *testing.T) {
+	ctx := context.Background()
+	db, err := auth.OpenMemory(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var validations atomic.Int32
+	handler := api.Handler(db)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/auth/token" {
+			validations.Add(1)
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	if _, err := auth.RegisterUser(ctx, db, "bob", []byte("1234")); err != nil {
+		t.Fatal(err)
+	}
+	cli := client.New(server.URL, client.WithValidationCache(client.ValidationCache{
+		Size:         10,
+		TTL:          time.Minute,
+		NegativeTTL:  time.Minute,
+		PollInterval: 200 * time.Millisecond,
+	}))
+	token, err := cli.StartSession(ctx, "bob", "1234", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if info, err := cli.ValidateTokenInfo(ctx, token); err != nil || info.Login != "bob" {
+			t.Fatalf("Unexpected token info %#v, %v", info, err)
+		}
+		if _, _, err := cli.ValidateToken(ctx, "bogus:dG9rZW4="); err == nil {
+			t.Fatal("Invalid token should be rejected")
+		}
+	}
+	if n := validations.Load(); n != 2 {
+		t.Fatalf("Each token should be validated once by the api got %v calls", n)
+	}
+
+	tokenID, _ := auth.ExtractTokenID(token)
+	if err := auth.RevokeToken(ctx, db, tokenID); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(250 * time.Millisecond)
+	if _, _, err := cli.ValidateToken(ctx, token); err == nil {
+		t.Fatal("Revoked token should be evicted from the cache")
+	}
+}
+
+func TestClientValidationCacheRevokedWhileValidating(t *testing.T) {
+	ctx := context.Background()
+	db, err := auth.OpenMemory(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var hold atomic.Bool
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := api.Handler(db)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/auth/token" || !hold.CompareAndSwap(true, false) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		// the api accepts the token but the answer is delayed until
+		// the token is revoked and the revocation is polled
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		close(started)
+		<-release
+		for k, v := range rec.Header() {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(rec.Code)
+		w.Write(rec.Body.Bytes())
+	}))
+	defer server.Close()
+	if _, err := auth.RegisterUser(ctx, db, "bob", []byte("1234")); err != nil {
+		t.Fatal(err)
+	}
+	cli := client.New(server.URL, client.WithValidationCache(client.ValidationCache{
+		Size:         10,
+		TTL:          time.Minute,
+		PollInterval: 50 * time.Millisecond,
+	}))
+	token, err := cli.StartSession(ctx, "bob", "1234", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := cli.StartSession(ctx, "bob", "1234", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cli.ValidateTokenInfo(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+
+	hold.Store(true)
+	done := make(chan error)
+	go func() {
+		_, err := cli.ValidateTokenInfo(ctx, token)
+		done <- err
+	}()
+	<-started
+	tokenID, _ := auth.ExtractTokenID(token)
+	if err := auth.RevokeToken(ctx, db, tokenID); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := cli.ValidateTokenInfo(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := cli.ValidateToken(ctx, token); err == nil {
+		t.Fatal("Token revoked while it was validated should not be cached")
+	}
+}
@@ -0,0 +1,145 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+type (
+	// ClientToken is issued by /oauth/token
+	ClientToken struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+
+	// Introspection describes a token as returned by /oauth/introspect,
+	// only Active is set for invalid tokens
+	Introspection struct {
+		Active        bool     `json:"active"`
+		Subject       string   `json:"sub"`
+		Username      string   `json:"username"`
+		ExpiresAt     int64    `json:"exp"`
+		IssuedAt      int64    `json:"iat"`
+		TokenType     string   `json:"token_type"`
+		ClientID      string   `json:"client_id"`
+		Scope         string   `json:"scope"`
+		Groups        []string `json:"groups"`
+		AuthTokenType string   `json:"auth_token_type"`
+	}
+
+	// OAuthError is returned by the /oauth endpoints
+	OAuthError struct {
+		Status      int    `json:"-"`
+		Code        string `json:"error"`
+		Description string `json:"error_description"`
+	}
+
+	// TokenSource obtains tokens with the client_credentials grant,
+	// tokens are cached and renewed before they expire
+	TokenSource struct {
+		c        *C
+		clientID string
+		secret   string
+		refresh  singleflight.Group
+
+		lock    sync.Mutex
+		token   string
+		renewAt time.Time
+	}
+)
+
+func (e OAuthError) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("[%v]: %v: %v", e.Status, e.Code, e.Description)
+	}
+	return fmt.Sprintf("[%v]: %v", e.Status, e.Code)
+}
+
+// ClientCredentials requests a new token using the client_credentials grant
+func (c *C) ClientCredentials(ctx context.Context, clientID, secret string) (ClientToken, error) {
+	var out ClientToken
+	err := c.postForm(ctx, "/oauth/token", clientID, secret, url.Values{"grant_type": {"client_credentials"}}, &out)
+	return out, err
+}
+
+// Introspect returns what the server knows about token, invalid tokens
+// are not an error but return an inactive Introspection
+func (c *C) Introspect(ctx context.Context, clientID, secret, token string) (Introspection, error) {
+	var out Introspection
+	err := c.postForm(ctx, "/oauth/introspect", clientID, secret, url.Values{"token": {token}}, &out)
+	return out, err
+}
+
+// Revoke invalidates token, revoking an invalid token is not an error
+func (c *C) Revoke(ctx context.Context, clientID, secret, token string) error {
+	return c.postForm(ctx, "/oauth/revoke", clientID, secret, url.Values{"token": {token}}, nil)
+}
+
+// postForm sends form authenticated as the client, errors are returned as OAuthError
+func (c *C) postForm(ctx context.Context, path, clientID, secret string, form url.Values, out interface{}) error {
+	var oe OAuthError
+	req, err := c.base.New().Post(path).
+		Set("Authorization", "Basic "+basicAuth(clientID, secret)).
+		Set("Content-Type", "application/x-www-form-urlencoded").
+		Body(strings.NewReader(form.Encode())).
+		Request()
+	if err != nil {
+		return err
+	}
+	res, err := c.base.New().Do(req.WithContext(ctx), out, &oe)
+	if err != nil {
+		return err
+	} else if res.StatusCode != http.StatusOK {
+		oe.Status = res.StatusCode
+		return oe
+	}
+	return nil
+}
+
+// TokenSource returns a token source for the given client, it is safe for concurrent use
+func (c *C) TokenSource(clientID, secret string) *TokenSource {
+	return &TokenSource{c: c, clientID: clientID, secret: secret}
+}
+
+// Token returns the cached token, a new one is requested once 80% of
+// the lifetime of the current token has passed
+func (s *TokenSource) Token(ctx context.Context) (string, error) {
+	s.lock.Lock()
+	token, renewAt := s.token, s.renewAt
+	s.lock.Unlock()
+	if token != "" && time.Now().Before(renewAt) {
+		return token, nil
+	}
+	// the request is made without holding the lock, concurrent callers
+	// share a single round trip
+	v, err, _ := s.refresh.Do("token", func() (any, error) {
+		now := time.Now()
+		t, err := s.c.ClientCredentials(ctx, s.clientID, s.secret)
+		if err != nil {
+			return "", err
+		}
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.token = t.AccessToken
+		s.renewAt = now.Add(time.Duration(t.ExpiresIn) * time.Second * 4 / 5)
+		return s.token, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return v.(string), nil
+}
+
+// basicAuth encodes the credentials as required by RFC 6749 section 2.3.1
+func basicAuth(clientID, secret string) string {
+	return base64.StdEncoding.EncodeToString([]byte(url.QueryEscape(clientID) + ":" + url.QueryEscape(secret)))
+}
@@ -0,0 +1,203 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andrebq/auth/internal/usererror"
+	"github.com/andrebq/auth/jwt"
+	"golang.org/x/sync/singleflight"
+)
+
+type (
+	// Verifier validates signed tokens locally using the keys published by
+	// the auth API, revocations are learned by polling the API every interval.
+	// Tokens which are not signed are validated by the API.
+	//
+	// When the revocation list cannot be refreshed for three intervals the
+	// Verifier falls back to the API, so a revoked token is never accepted
+	// for much longer than interval.
+	Verifier struct {
+		c        *C
+		interval time.Duration
+		// refresh ensures concurrent requests trigger a single fetch
+		refresh singleflight.Group
+
+		// lock only guards the fields below, it is never held during network calls
+		lock      sync.Mutex
+		keys      jwt.JWKS
+		keysAt    time.Time
+		revoked   map[string]bool
+		revokedAt time.Time
+	}
+
+	signedClaims struct {
+		jwt.Claims
+		TokenType  string            `json:"token_type"`
+		Login      string            `json:"login"`
+		Attributes map[string]string `json:"attrs"`
+		Groups     []string          `json:"groups"`
+	}
+)
+
+// DefaultRevocationInterval is how often a Verifier polls revoked tokens
+const DefaultRevocationInterval = 30 * time.Second
+
+// ErrInvalidToken is returned by Verifier for tokens which fail local validation
+var ErrInvalidToken = errors.New("client: invalid token")
+
+// Verifier returns a Verifier which refreshes the revocation list every interval
+func (c *C) Verifier(interval time.Duration) *Verifier {
+	if interval <= 0 {
+		interval = DefaultRevocationInterval
+	}
+	return &Verifier{c: c, interval: interval}
+}
+
+// ValidateToken is like C.ValidateToken
+func (v *Verifier) ValidateToken(ctx context.Context, token string) (string, string, error) {
+	info, err := v.ValidateTokenInfo(ctx, token)
+	if err != nil {
+		return "", "", err
+	}
+	return info.UID, info.TokenType, nil
+}
+
+// ValidateTokenInfo is like C.ValidateTokenInfo
+func (v *Verifier) ValidateTokenInfo(ctx context.Context, token string) (TokenInfo, error) {
+	if strings.Count(token, ".") != 2 || strings.Contains(token, ":") {
+		return v.c.ValidateTokenInfo(ctx, token)
+	}
+	kid, err := jwt.KeyID(token)
+	if err != nil {
+		return TokenInfo{}, ErrInvalidToken
+	}
+
+	keys, err := v.currentKeys(ctx, kid)
+	if err != nil {
+		return TokenInfo{}, err
+	}
+	var claims signedClaims
+	if err := jwt.VerifyType(token, keys, jwt.TypeAccessToken, &claims); err != nil {
+		return TokenInfo{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if err := claims.Validate(time.Now(), "", ""); err != nil || claims.ID == "" || claims.Subject == "" {
+		return TokenInfo{}, ErrInvalidToken
+	}
+	revoked, fresh := v.revokedTokens(ctx)
+	if !fresh {
+		return v.c.ValidateTokenInfo(ctx, token)
+	}
+	if revoked[claims.ID] {
+		return TokenInfo{}, ErrInvalidToken
+	}
+	return TokenInfo{
+		UID:        claims.Subject,
+		TokenID:    claims.ID,
+		TokenType:  claims.TokenType,
+		Login:      claims.Login,
+		Attributes: claims.Attributes,
+		Groups:     claims.Groups,
+		ExpiresAt:  time.Unix(claims.ExpiresAt, 0),
+	}, nil
+}
+
+// currentKeys returns the key set, it is fetched again when kid is unknown
+// but at most once per interval
+func (v *Verifier) currentKeys(ctx context.Context, kid string) (jwt.JWKS, error) {
+	v.lock.Lock()
+	keys, keysAt := v.keys, v.keysAt
+	v.lock.Unlock()
+	if _, found := keys.Find(kid); found || time.Since(keysAt) < v.interval {
+		return keys, nil
+	}
+	fetched, err, _ := v.refresh.Do("keys", func() (any, error) {
+		keys, err := v.c.PublishedKeys(ctx)
+		if err != nil {
+			return nil, err
+		}
+		v.lock.Lock()
+		v.keys, v.keysAt = keys, time.Now()
+		v.lock.Unlock()
+		return keys, nil
+	})
+	if err != nil {
+		return jwt.JWKS{}, err
+	}
+	return fetched.(jwt.JWKS), nil
+}
+
+// revokedTokens returns the revocation list, polling it once per interval.
+// fresh is false when the list is too old to be trusted.
+func (v *Verifier) revokedTokens(ctx context.Context) (revoked map[string]bool, fresh bool) {
+	v.lock.Lock()
+	revoked, revokedAt := v.revoked, v.revokedAt
+	v.lock.Unlock()
+	if time.Since(revokedAt) < v.interval {
+		return revoked, true
+	}
+	fetched, err, _ := v.refresh.Do("revoked", func() (any, error) {
+		ids, _, err := v.c.RevokedTokens(ctx, time.Time{})
+		if err != nil {
+			return nil, err
+		}
+		revoked := make(map[string]bool, len(ids))
+		for _, id := range ids {
+			revoked[id] = true
+		}
+		v.lock.Lock()
+		v.revoked, v.revokedAt = revoked, time.Now()
+		v.lock.Unlock()
+		return revoked, nil
+	})
+	if err != nil {
+		return revoked, time.Since(revokedAt) < 3*v.interval
+	}
+	return fetched.(map[string]bool), true
+}
+
+// PublishedKeys returns the keys which verify signed tokens
+func (c *C) PublishedKeys(ctx context.Context) (jwt.JWKS, error) {
+	var ue usererror.E
+	var out jwt.JWKS
+	res, err := c.base.New().Get("/auth/jwks").Receive(&out, &ue)
+	if err != nil {
+		return jwt.JWKS{}, err
+	} else if ue.Failure() {
+		return jwt.JWKS{}, ue
+	} else if res.StatusCode != http.StatusOK {
+		return jwt.JWKS{}, fmt.Errorf("client: unexpected status code %v", res.StatusCode)
+	}
+	return out, nil
+}
+
+// RevokedTokens returns the ids of tokens revoked before their expiration,
+// only revocations after since are returned unless since is zero.
+// until should be used as since of the next call.
+func (c *C) RevokedTokens(ctx context.Context, since time.Time) (ids []string, until time.Time, err error) {
+	var ue usererror.E
+	var out struct {
+		Revoked []string `json:"revoked"`
+		Until   int64    `json:"until"`
+	}
+	req := c.base.New().Get("/auth/revoked")
+	if !since.IsZero() {
+		req = req.QueryStruct(struct {
+			Since int64 `url:"since"`
+		}{Since: since.Unix()})
+	}
+	res, err := req.Receive(&out, &ue)
+	if err != nil {
+		return nil, time.Time{}, err
+	} else if ue.Failure() {
+		return nil, time.Time{}, ue
+	} else if res.StatusCode != http.StatusOK {
+		return nil, time.Time{}, fmt.Errorf("client: unexpected status code %v", res.StatusCode)
+	}
+	return out.Revoked, time.Unix(out.Until, 0), nil
+}
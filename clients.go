@@ -0,0 +1,269 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type (
+	// Client is the public view of a client, it never exposes the secret hash
+	Client struct {
+		ClientID     string    `json:"clientID"`
+		Name         string    `json:"name"`
+		RedirectURIs []string  `json:"redirectURIs"`
+		Public       bool      `json:"public"`
+		UID          string    `json:"uid,omitempty"`
+		CreatedAt    time.Time `json:"createdAt"`
+	}
+
+	// NewClient describes a client to be registered, clients with redirect
+	// uris can use the authorization code grant and clients with a login
+	// can use the client_credentials grant to obtain tokens owned by that user
+	NewClient struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirectURIs"`
+		Public       bool     `json:"public"`
+		Login        string   `json:"login,omitempty"`
+	}
+
+	// AuthCode holds what is needed to issue tokens once the
+	// authorization code is exchanged
+	AuthCode struct {
+		ClientID    string
+		UID         string
+		RedirectURI string
+		Scope       string
+		Nonce       string
+		// CodeChallenge is the PKCE S256 challenge, empty when PKCE is not used
+		CodeChallenge string
+		AuthTime      time.Time
+	}
+)
+
+const (
+	// DefaultAuthCodeTTL is how long an authorization code can wait to be exchanged
+	DefaultAuthCodeTTL = time.Minute
+
+	// GrantAuthorizationCode and GrantClientCredentials are the OAuth 2.0 grants supported by clients
+	GrantAuthorizationCode = "authorization_code"
+	GrantClientCredentials = "client_credentials"
+)
+
+func clientFromRecord(c ClientRecord) Client {
+	return Client{
+		ClientID:     c.ClientID,
+		Name:         c.Name,
+		RedirectURIs: c.RedirectURIs,
+		Public:       c.SecretHash == "",
+		UID:          c.UID,
+		CreatedAt:    c.CreatedAt,
+	}
+}
+
+// RegisterClient creates a client allowed to redirect users to any of
+// its redirect uris, the returned secret is empty for public clients
+func RegisterClient(ctx context.Context, st Store, nc NewClient) (Client, string, error) {
+	switch {
+	case nc.Name == "":
+		return Client{}, "", fmt.Errorf("%w: client name is required", ErrInvalidInput)
+	case len(nc.RedirectURIs) == 0 && nc.Login == "":
+		return Client{}, "", fmt.Errorf("%w: client needs a redirect uri or a login", ErrInvalidInput)
+	case nc.Public && nc.Login != "":
+		return Client{}, "", fmt.Errorf("%w: public clients cannot use the client_credentials grant", ErrInvalidInput)
+	}
+	for _, ru := range nc.RedirectURIs {
+		u, err := url.Parse(ru)
+		if err != nil || !u.IsAbs() || u.Fragment != "" {
+			return Client{}, "", fmt.Errorf("%w: redirect uri %q must be an absolute url without fragment", ErrInvalidInput, ru)
+		}
+	}
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return Client{}, "", err
+	}
+	rec := ClientRecord{
+		ClientID:     id.String(),
+		Name:         nc.Name,
+		RedirectURIs: nc.RedirectURIs,
+		CreatedAt:    time.Now(),
+	}
+	if nc.Login != "" {
+		u, err := st.FindUserByLogin(ctx, nc.Login)
+		if err != nil {
+			return Client{}, "", err
+		}
+		rec.UID = u.UID
+	}
+	var secret string
+	if !nc.Public {
+		raw, err := randomSalt(32)
+		if err != nil {
+			return Client{}, "", err
+		}
+		secret = base64.RawURLEncoding.EncodeToString(raw)
+		rec.SecretHash = hashToken([]byte(secret))
+	}
+	if err := st.InsertClient(ctx, rec); err != nil {
+		return Client{}, "", err
+	}
+	return clientFromRecord(rec), secret, nil
+}
+
+// LookupClient returns ErrNotFound if clientID does not exist
+func LookupClient(ctx context.Context, st Store, clientID string) (Client, error) {
+	c, err := st.FindClient(ctx, clientID)
+	if err != nil {
+		return Client{}, err
+	}
+	return clientFromRecord(c), nil
+}
+
+// ListClients returns all clients ordered by name
+func ListClients(ctx context.Context, st Store) ([]Client, error) {
+	recs, err := st.ListClients(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Client, 0, len(recs))
+	for _, c := range recs {
+		out = append(out, clientFromRecord(c))
+	}
+	return out, nil
+}
+
+// DeleteClient removes a client, tokens already issued to it remain valid
+func DeleteClient(ctx context.Context, st Store, clientID string) error {
+	return st.DeleteClient(ctx, clientID)
+}
+
+// AuthenticateClient checks the secret of a confidential client,
+// public clients are only accepted with an empty secret
+func AuthenticateClient(ctx context.Context, st Store, clientID, secret string) (Client, error) {
+	c, err := st.FindClient(ctx, clientID)
+	if err != nil {
+		return Client{}, ErrInvalidCredentials
+	}
+	if c.SecretHash == "" {
+		if secret != "" {
+			return Client{}, ErrInvalidCredentials
+		}
+		return clientFromRecord(c), nil
+	}
+	if ok, err := verifyTokenHash(c.SecretHash, []byte(secret)); err != nil || !ok {
+		return Client{}, ErrInvalidCredentials
+	}
+	return clientFromRecord(c), nil
+}
+
+// GrantTypes returns the OAuth 2.0 grants which c is allowed to use
+func (c Client) GrantTypes() []string {
+	var out []string
+	if len(c.RedirectURIs) > 0 {
+		out = append(out, GrantAuthorizationCode)
+	}
+	if c.UID != "" {
+		out = append(out, GrantClientCredentials)
+	}
+	return out
+}
+
+// IssueClientToken returns a machine token owned by the user bound to c,
+// as requested by the client_credentials grant, and when it expires.
+// DefaultClientTokenTTL is used when ttl is not positive.
+func IssueClientToken(ctx context.Context, st Store, c Client, ttl time.Duration) (string, time.Time, error) {
+	if c.UID == "" {
+		return "", time.Time{}, fmt.Errorf("%w: client %v cannot use the client_credentials grant", ErrInvalidInput, c.ClientID)
+	}
+	if ttl <= 0 {
+		ttl = DefaultClientTokenTTL
+	}
+	u, err := st.FindUserByUID(ctx, c.UID)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expiresAt := time.Now().Add(ttl)
+	// CreateClientToken refuses disabled users
+	token, err := CreateClientToken(ctx, st, u.Login, TokenTypeMachine, c.ClientID, "", expiresAt)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// AllowsRedirect reports whether redirectURI is registered for c, the
+// comparison is exact as required by OAuth 2.0 Security Best Current Practice
+func (c Client) AllowsRedirect(redirectURI string) bool {
+	return slices.Contains(c.RedirectURIs, redirectURI)
+}
+
+// CreateAuthCode returns a single use authorization code which expires after ttl
+func CreateAuthCode(ctx context.Context, st Store, code AuthCode, ttl time.Duration) (string, error) {
+	if ttl <= 0 {
+		ttl = DefaultAuthCodeTTL
+	}
+	raw, err := randomSalt(32)
+	if err != nil {
+		return "", err
+	}
+	plain := base64.RawURLEncoding.EncodeToString(raw)
+	err = st.InsertAuthCode(ctx, AuthCodeRecord{
+		CodeHash:      hashAuthCode(plain),
+		ClientID:      code.ClientID,
+		UID:           code.UID,
+		RedirectURI:   code.RedirectURI,
+		Scope:         code.Scope,
+		Nonce:         code.Nonce,
+		CodeChallenge: code.CodeChallenge,
+		AuthTime:      code.AuthTime,
+		ExpiresAt:     time.Now().Add(ttl),
+	})
+	return plain, err
+}
+
+// RedeemAuthCode returns the data associated with code, a code can only be
+// redeemed once. Unknown and expired codes return ErrInvalidCredentials.
+func RedeemAuthCode(ctx context.Context, st Store, code string) (AuthCode, error) {
+	rec, err := st.TakeAuthCode(ctx, hashAuthCode(code))
+	if err != nil {
+		return AuthCode{}, ErrInvalidCredentials
+	}
+	if time.Now().After(rec.ExpiresAt) {
+		return AuthCode{}, ErrInvalidCredentials
+	}
+	return AuthCode{
+		ClientID:      rec.ClientID,
+		UID:           rec.UID,
+		RedirectURI:   rec.RedirectURI,
+		Scope:         rec.Scope,
+		Nonce:         rec.Nonce,
+		CodeChallenge: rec.CodeChallenge,
+		AuthTime:      rec.AuthTime,
+	}, nil
+}
+
+// VerifyCodeVerifier checks a PKCE code_verifier against the S256 challenge
+// stored with the code
+func (c AuthCode) VerifyCodeVerifier(verifier string) bool {
+	if c.CodeChallenge == "" {
+		return verifier == ""
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(c.CodeChallenge)) == 1
+}
+
+// codes have 256 bits of entropy and live for a minute,
+// so a plain sha256 is enough to protect them at rest
+func hashAuthCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,178 @@
+package auth_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andrebq/auth"
+	"golang.org/x/crypto/argon2"
+)
+
+func TestClients(t *testing.T) {
+	eachStore(t, func(t *testing.T, st auth.Store) {
+		ctx := context.Background()
+		redirects := []string{"https://app.example.com/callback", "http://localhost:8080/cb"}
+		if _, _, err := auth.RegisterClient(ctx, st, auth.NewClient{Name: "app", RedirectURIs: []string{"/callback"}}); !errors.Is(err, auth.ErrInvalidInput) {
+			t.Fatalf("Relative redirect uri should be rejected got %v", err)
+		}
+		app, secret, err := auth.RegisterClient(ctx, st, auth.NewClient{Name: "app", RedirectURIs: redirects})
+		if err != nil {
+			t.Fatal(err)
+		} else if secret == "" || app.Public {
+			t.Fatalf("Confidential client should have a secret got %#v", app)
+		}
+		spa, spaSecret, err := auth.RegisterClient(ctx, st, auth.NewClient{Name: "spa", RedirectURIs: redirects[:1], Public: true})
+		if err != nil {
+			t.Fatal(err)
+		} else if spaSecret != "" || !spa.Public {
+			t.Fatalf("Public client should not have a secret got %#v", spa)
+		}
+
+		if found, err := auth.LookupClient(ctx, st, app.ClientID); err != nil || !reflect.DeepEqual(found.RedirectURIs, redirects) {
+			t.Fatalf("Unexpected client %#v, %v", found, err)
+		}
+		if clients, err := auth.ListClients(ctx, st); err != nil || len(clients) != 2 || clients[0].Name != "app" {
+			t.Fatalf("Unexpected clients %#v, %v", clients, err)
+		}
+		if err := st.InsertClient(ctx, auth.ClientRecord{ClientID: app.ClientID, Name: "copy", CreatedAt: time.Now()}); !errors.Is(err, auth.ErrConflict) {
+			t.Fatalf("Client id should be unique, got %v", err)
+		}
+		if !app.AllowsRedirect(redirects[1]) || app.AllowsRedirect(redirects[1]+"/other") {
+			t.Fatal("Redirect uris must match exactly")
+		}
+
+		if _, err := auth.AuthenticateClient(ctx, st, app.ClientID, secret); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := auth.AuthenticateClient(ctx, st, app.ClientID, "wrong"); !errors.Is(err, auth.ErrInvalidCredentials) {
+			t.Fatalf("Wrong secret should be rejected got %v", err)
+		}
+		if rec, err := st.FindClient(ctx, app.ClientID); err != nil || !strings.HasPrefix(rec.SecretHash, "$hmac-sha256$") {
+			t.Fatalf("Client secrets should use the token hash, got %v, %v", rec.SecretHash, err)
+		}
+		if _, err := auth.AuthenticateClient(ctx, st, spa.ClientID, ""); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := auth.AuthenticateClient(ctx, st, spa.ClientID, "anything"); !errors.Is(err, auth.ErrInvalidCredentials) {
+			t.Fatalf("Public client should not accept a secret got %v", err)
+		}
+
+		if !reflect.DeepEqual(app.GrantTypes(), []string{auth.GrantAuthorizationCode}) {
+			t.Fatalf("Unexpected grants %v", app.GrantTypes())
+		}
+		if _, _, err := auth.IssueClientToken(ctx, st, app, time.Minute); !errors.Is(err, auth.ErrInvalidInput) {
+			t.Fatalf("Client without login should not issue tokens got %v", err)
+		}
+
+		if _, err := auth.RegisterUser(ctx, st, "backup-bot", []byte("1234")); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := auth.RegisterClient(ctx, st, auth.NewClient{Name: "backup", Login: "backup-bot", Public: true}); !errors.Is(err, auth.ErrInvalidInput) {
+			t.Fatalf("Public client cannot use client_credentials got %v", err)
+		}
+		if _, _, err := auth.RegisterClient(ctx, st, auth.NewClient{Name: "backup", Login: "nobody"}); !errors.Is(err, auth.ErrNotFound) {
+			t.Fatalf("Missing login should return ErrNotFound got %v", err)
+		}
+		service, _, err := auth.RegisterClient(ctx, st, auth.NewClient{Name: "backup", Login: "backup-bot"})
+		if err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(service.GrantTypes(), []string{auth.GrantClientCredentials}) {
+			t.Fatalf("Unexpected grants %v", service.GrantTypes())
+		}
+		token, expiresAt, err := auth.IssueClientToken(ctx, st, service, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		} else if ttl := time.Until(expiresAt); ttl <= 0 || ttl > time.Minute {
+			t.Fatalf("Unexpected expiration %v", expiresAt)
+		}
+		if _, expiresAt, err := auth.IssueClientToken(ctx, st, service, 0); err != nil {
+			t.Fatal(err)
+		} else if ttl := time.Until(expiresAt); ttl <= time.Minute || ttl > auth.DefaultClientTokenTTL {
+			t.Fatalf("Default ttl should be reported got %v", expiresAt)
+		}
+		if uid, tokenType, err := auth.TokenLogin(ctx, st, token); err != nil || uid != service.UID || tokenType != auth.TokenTypeMachine {
+			t.Fatalf("Unexpected token owner %v %v, %v", uid, tokenType, err)
+		}
+
+		if err := auth.DeleteClient(ctx, st, spa.ClientID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := auth.LookupClient(ctx, st, spa.ClientID); !errors.Is(err, auth.ErrNotFound) {
+			t.Fatalf("Deleted client should return ErrNotFound got %v", err)
+		}
+	})
+}
+
+func TestArgon2ClientSecret(t *testing.T) {
+	eachStore(t, func(t *testing.T, st auth.Store) {
+		ctx := context.Background()
+		salt := []byte("16-bytes-of-salt")
+		key := argon2.IDKey([]byte("old-secret"), salt, 1, 64, 1, 32)
+		err := st.InsertClient(ctx, auth.ClientRecord{
+			ClientID: "old-client",
+			Name:     "old",
+			SecretHash: fmt.Sprintf("$argon2id$v=%d$m=64,t=1,p=1$%v$%v", argon2.Version,
+				base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)),
+			RedirectURIs: []string{"https://old.example.com/cb"},
+			CreatedAt:    time.Now(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := auth.AuthenticateClient(ctx, st, "old-client", "old-secret"); err != nil {
+			t.Fatalf("Clients registered with argon2id should still authenticate, got %v", err)
+		}
+		if _, err := auth.AuthenticateClient(ctx, st, "old-client", "wrong"); !errors.Is(err, auth.ErrInvalidCredentials) {
+			t.Fatalf("Wrong secret should be rejected got %v", err)
+		}
+	})
+}
+
+func TestAuthCodes(t *testing.T) {
+	eachStore(t, func(t *testing.T, st auth.Store) {
+		ctx := context.Background()
+		verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+		sum := sha256.Sum256([]byte(verifier))
+		issued := auth.AuthCode{
+			ClientID:      "client-1",
+			UID:           "uid-1",
+			RedirectURI:   "https://app.example.com/callback",
+			Scope:         "openid email",
+			Nonce:         "n-1",
+			CodeChallenge: base64.RawURLEncoding.EncodeToString(sum[:]),
+			AuthTime:      time.Unix(time.Now().Unix(), 0),
+		}
+		code, err := auth.CreateAuthCode(ctx, st, issued, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		redeemed, err := auth.RedeemAuthCode(ctx, st, code)
+		if err != nil {
+			t.Fatal(err)
+		} else if redeemed.UID != issued.UID || redeemed.Nonce != issued.Nonce || !redeemed.AuthTime.Equal(issued.AuthTime) {
+			t.Fatalf("Unexpected code data %#v", redeemed)
+		}
+		if !redeemed.VerifyCodeVerifier(verifier) || redeemed.VerifyCodeVerifier("other") {
+			t.Fatal("Code verifier does not match the S256 challenge")
+		}
+		if _, err := auth.RedeemAuthCode(ctx, st, code); !errors.Is(err, auth.ErrInvalidCredentials) {
+			t.Fatalf("Codes must be single use got %v", err)
+		}
+
+		expired, err := auth.CreateAuthCode(ctx, st, issued, time.Nanosecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+		if _, err := auth.RedeemAuthCode(ctx, st, expired); !errors.Is(err, auth.ErrInvalidCredentials) {
+			t.Fatalf("Expired code should be rejected got %v", err)
+		}
+	})
+}
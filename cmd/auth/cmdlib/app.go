@@ -1,10 +1,12 @@
 package cmdlib
 
 import (
+	"errors"
 	"io"
 	"path"
 	"path/filepath"
 
+	"github.com/andrebq/auth"
 	"github.com/andrebq/auth/cmd/auth/cmdlib/ctl"
 	"github.com/andrebq/auth/cmd/auth/cmdlib/hub"
 	"github.com/andrebq/auth/cmd/auth/cmdlib/install"
@@ -14,7 +16,11 @@ import (
 )
 
 func NewApp(output io.Writer, input io.Reader) *cli.App {
-	var dir string
+	var dbcfg auth.DBConfig
+	hashParams := auth.DefaultHashParams
+	policy := auth.DefaultPasswordPolicy
+	var tokenPepper, breachedList, breachedFormat string
+	var previousPeppers cli.StringSlice
 	return &cli.App{
 		Name:  "auth",
 		Usage: "Runs/controls the auth server",
@@ -23,13 +29,147 @@ func NewApp(output io.Writer, input io.Reader) *cli.App {
 				Name:        "data-dir",
 				Aliases:     []string{"d"},
 				EnvVars:     []string{"AUTH_DATA_DIR"},
-				Destination: &dir,
+				Destination: &dbcfg.Dir,
 				Value:       filepath.FromSlash(path.Join("/", "var", "authdb", "data-dir")),
 			},
+			&cli.StringFlag{
+				Name:        "db",
+				Usage:       "DSN of a PostgreSQL database (postgres://...), when empty the SQLite database under data-dir is used",
+				EnvVars:     []string{"AUTH_DB"},
+				Destination: &dbcfg.DSN,
+			},
+			&cli.IntFlag{
+				Name:        "db-max-open-conns",
+				Usage:       "Maximum number of open connections to the database, zero means unlimited",
+				EnvVars:     []string{"AUTH_DB_MAX_OPEN_CONNS"},
+				Destination: &dbcfg.MaxOpenConns,
+			},
+			&cli.IntFlag{
+				Name:        "db-max-idle-conns",
+				Usage:       "Maximum number of idle connections kept in the pool",
+				EnvVars:     []string{"AUTH_DB_MAX_IDLE_CONNS"},
+				Destination: &dbcfg.MaxIdleConns,
+			},
+			&cli.DurationFlag{
+				Name:        "db-conn-max-lifetime",
+				Usage:       "Maximum amount of time a connection may be reused",
+				EnvVars:     []string{"AUTH_DB_CONN_MAX_LIFETIME"},
+				Destination: &dbcfg.ConnMaxLifetime,
+			},
+			&cli.DurationFlag{
+				Name:        "db-conn-max-idle-time",
+				Usage:       "Maximum amount of time a connection may be idle before being closed",
+				EnvVars:     []string{"AUTH_DB_CONN_MAX_IDLE_TIME"},
+				Destination: &dbcfg.ConnMaxIdleTime,
+			},
+			&cli.UintFlag{
+				Name:    "argon2-time",
+				Usage:   "Number of argon2id iterations used to hash new passwords",
+				EnvVars: []string{"AUTH_ARGON2_TIME"},
+				Value:   uint(hashParams.Time),
+				Action: func(_ *cli.Context, v uint) error {
+					hashParams.Time = uint32(v)
+					return nil
+				},
+			},
+			&cli.UintFlag{
+				Name:    "argon2-memory",
+				Usage:   "Memory in KiB used by argon2id to hash new passwords",
+				EnvVars: []string{"AUTH_ARGON2_MEMORY"},
+				Value:   uint(hashParams.MemoryKiB),
+				Action: func(_ *cli.Context, v uint) error {
+					hashParams.MemoryKiB = uint32(v)
+					return nil
+				},
+			},
+			&cli.UintFlag{
+				Name:    "argon2-threads",
+				Usage:   "Parallelism used by argon2id to hash new passwords",
+				EnvVars: []string{"AUTH_ARGON2_THREADS"},
+				Value:   uint(hashParams.Threads),
+				Action: func(_ *cli.Context, v uint) error {
+					if v > 255 {
+						return errors.New("argon2-threads must be at most 255")
+					}
+					hashParams.Threads = uint8(v)
+					return nil
+				},
+			},
+			&cli.StringFlag{
+				Name: "token-pepper",
+				Usage: "Server secret mixed into the hash of tokens, every server sharing the database must use the same value. " +
+					"Required with --db, SQLite databases generate one in data-dir/token-pepper when empty. " +
+					"Changing it revokes every token unless the old value is passed to --token-pepper-previous",
+				EnvVars:     []string{"AUTH_TOKEN_PEPPER"},
+				Destination: &tokenPepper,
+			},
+			&cli.StringSliceFlag{
+				Name:        "token-pepper-previous",
+				Usage:       "Previous values of --token-pepper still accepted to verify tokens, keep them until those tokens expire",
+				EnvVars:     []string{"AUTH_TOKEN_PEPPER_PREVIOUS"},
+				Destination: &previousPeppers,
+			},
+			&cli.IntFlag{
+				Name:        "password-min-length",
+				Usage:       "Minimum number of characters of new passwords",
+				EnvVars:     []string{"AUTH_PASSWORD_MIN_LENGTH"},
+				Destination: &policy.MinLength,
+				Value:       policy.MinLength,
+			},
+			&cli.IntFlag{
+				Name:        "password-max-length",
+				Usage:       "Maximum number of characters of new passwords, bounds the cost of hashing",
+				EnvVars:     []string{"AUTH_PASSWORD_MAX_LENGTH"},
+				Destination: &policy.MaxLength,
+				Value:       policy.MaxLength,
+			},
+			&cli.IntFlag{
+				Name:        "password-min-classes",
+				Usage:       "Number of character classes (lowercase, uppercase, digits, symbols) new passwords must use",
+				EnvVars:     []string{"AUTH_PASSWORD_MIN_CLASSES"},
+				Destination: &policy.MinClasses,
+			},
+			&cli.IntFlag{
+				Name:        "password-history",
+				Usage:       "Reject new passwords equal to any of the last N passwords of the user",
+				EnvVars:     []string{"AUTH_PASSWORD_HISTORY"},
+				Destination: &policy.History,
+			},
+			&cli.StringFlag{
+				Name:        "password-breached-list",
+				Usage:       "File with breached passwords or a directory with k-anonymity range files",
+				EnvVars:     []string{"AUTH_PASSWORD_BREACHED_LIST"},
+				Destination: &breachedList,
+			},
+			&cli.StringFlag{
+				Name:        "password-breached-format",
+				Usage:       "Format of the entries in the --password-breached-list file: sha1 (hex, optionally followed by :count) or plain",
+				EnvVars:     []string{"AUTH_PASSWORD_BREACHED_FORMAT"},
+				Value:       string(auth.BreachedSHA1),
+				Destination: &breachedFormat,
+			},
+		},
+		Before: func(ctx *cli.Context) error {
+			if err := auth.SetHashParams(hashParams); err != nil {
+				return err
+			}
+			var previous [][]byte
+			for _, p := range previousPeppers.Value() {
+				previous = append(previous, []byte(p))
+			}
+			auth.SetTokenPepper([]byte(tokenPepper), previous...)
+			if breachedList != "" {
+				var err error
+				policy.Breached, err = auth.LoadBreachedList(breachedList, auth.BreachedFormat(breachedFormat))
+				if err != nil {
+					return err
+				}
+			}
+			return auth.SetPasswordPolicy(policy)
 		},
 		Commands: []*cli.Command{
-			ctl.Cmd(&dir, output, input),
-			serve.Cmd(&dir),
+			ctl.Cmd(&dbcfg, output, input),
+			serve.Cmd(&dbcfg),
 			proxy.Cmd(),
 			hub.Cmd(),
 			install.Cmd(output),
@@ -0,0 +1,332 @@
+package ctl
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/andrebq/auth"
+	"github.com/andrebq/auth/client"
+	"github.com/urfave/cli/v2"
+)
+
+type (
+	// backend is implemented by the local database and by the admin API,
+	// subcommands use it so their output is the same in both modes
+	backend interface {
+		io.Closer
+		RegisterUser(ctx context.Context, login, password string) error
+		ImportUser(ctx context.Context, login, passwordHash string) error
+		InviteUser(ctx context.Context, login string, attrs map[string]string, ttl time.Duration) (string, error)
+		ListUsers(ctx context.Context) ([]auth.User, error)
+		LookupUser(ctx context.Context, login string) (auth.User, error)
+		ReplacePassword(ctx context.Context, login, password string) error
+		DisableUser(ctx context.Context, login string) error
+		EnableUser(ctx context.Context, login string) error
+		DeleteUser(ctx context.Context, login string) error
+		RenameUser(ctx context.Context, login, newLogin string) error
+		SetUserAttributes(ctx context.Context, login string, attrs map[string]string) error
+		CreateResetToken(ctx context.Context, login string, ttl time.Duration) (string, error)
+		CreateToken(ctx context.Context, login, tokenType string, ttl time.Duration) (string, error)
+		RevokeToken(ctx context.Context, tokenID string) error
+		ListTokens(ctx context.Context, login string) ([]auth.Token, error)
+		CreateGroup(ctx context.Context, name string) error
+		DeleteGroup(ctx context.Context, name string) error
+		ListGroups(ctx context.Context) ([]string, error)
+		AddToGroup(ctx context.Context, group, login string) error
+		RemoveFromGroup(ctx context.Context, group, login string) error
+		GroupMembers(ctx context.Context, group string) ([]auth.User, error)
+		RegisterClient(ctx context.Context, nc auth.NewClient) (auth.Client, string, error)
+		ListClients(ctx context.Context) ([]auth.Client, error)
+		DeleteClient(ctx context.Context, clientID string) error
+	}
+
+	// remoteConfig holds the --endpoint and --token flags of auth ctl,
+	// when endpoint is empty commands operate on the local database
+	remoteConfig struct {
+		endpoint string
+		token    string
+	}
+
+	localBackend struct {
+		st auth.Store
+	}
+
+	remoteBackend struct {
+		admin *client.Admin
+	}
+)
+
+var errLocalOnly = errors.New("this command is only available in local mode, remove --endpoint")
+
+func (r *remoteConfig) flags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:        "endpoint",
+			Usage:       "URL of the auth API, when set commands use the admin API instead of the local database",
+			EnvVars:     []string{"AUTH_CTL_ENDPOINT"},
+			Destination: &r.endpoint,
+		},
+		&cli.StringFlag{
+			Name:        "token",
+			Usage:       "Admin token used to authenticate with --endpoint",
+			EnvVars:     []string{"AUTH_CTL_TOKEN"},
+			Destination: &r.token,
+		},
+	}
+}
+
+func (r *remoteConfig) remote() bool {
+	return r.endpoint != ""
+}
+
+// open returns the remote backend if an endpoint was given,
+// otherwise it opens the database described by dbcfg
+func (r *remoteConfig) open(ctx context.Context, dbcfg *auth.DBConfig) (backend, error) {
+	if r.remote() {
+		if r.token == "" {
+			return nil, errors.New("--token is required with --endpoint")
+		}
+		return remoteBackend{admin: client.New(r.endpoint).Admin(r.token)}, nil
+	}
+	st, err := auth.Open(ctx, *dbcfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := auth.InitTokenPepper(*dbcfg); err != nil {
+		st.Close()
+		return nil, err
+	}
+	return localBackend{st: st}, nil
+}
+
+// localOnly is used as the Before of commands which need direct access to the database
+func (r *remoteConfig) localOnly(ctx *cli.Context) error {
+	if r.remote() {
+		return errLocalOnly
+	}
+	return nil
+}
+
+func (l localBackend) Close() error { return l.st.Close() }
+
+func (l localBackend) RegisterUser(ctx context.Context, login, password string) error {
+	_, err := auth.RegisterUser(ctx, l.st, login, []byte(password))
+	return err
+}
+
+func (l localBackend) ImportUser(ctx context.Context, login, passwordHash string) error {
+	_, err := auth.ImportUser(ctx, l.st, login, passwordHash)
+	return err
+}
+
+func (l localBackend) InviteUser(ctx context.Context, login string, attrs map[string]string, ttl time.Duration) (string, error) {
+	return auth.InviteUser(ctx, l.st, login, attrs, ttl)
+}
+
+func (l localBackend) ListUsers(ctx context.Context) ([]auth.User, error) {
+	return auth.ListUsers(ctx, l.st)
+}
+
+func (l localBackend) LookupUser(ctx context.Context, login string) (auth.User, error) {
+	return auth.LookupUser(ctx, l.st, login)
+}
+
+func (l localBackend) ReplacePassword(ctx context.Context, login, password string) error {
+	return auth.ReplacePassword(ctx, l.st, login, []byte(password))
+}
+
+func (l localBackend) DisableUser(ctx context.Context, login string) error {
+	return auth.DisableUser(ctx, l.st, login)
+}
+
+func (l localBackend) EnableUser(ctx context.Context, login string) error {
+	return auth.EnableUser(ctx, l.st, login)
+}
+
+func (l localBackend) DeleteUser(ctx context.Context, login string) error {
+	return auth.DeleteUser(ctx, l.st, login)
+}
+
+func (l localBackend) RenameUser(ctx context.Context, login, newLogin string) error {
+	return auth.RenameUser(ctx, l.st, login, newLogin)
+}
+
+func (l localBackend) SetUserAttributes(ctx context.Context, login string, attrs map[string]string) error {
+	return auth.SetUserAttributes(ctx, l.st, login, attrs)
+}
+
+func (l localBackend) CreateResetToken(ctx context.Context, login string, ttl time.Duration) (string, error) {
+	return auth.CreateResetToken(ctx, l.st, login, ttl)
+}
+
+func (l localBackend) CreateToken(ctx context.Context, login, tokenType string, ttl time.Duration) (string, error) {
+	return auth.CreateToken(ctx, l.st, login, tokenType, time.Now().Add(ttl))
+}
+
+func (l localBackend) RevokeToken(ctx context.Context, tokenID string) error {
+	return auth.RevokeToken(ctx, l.st, tokenID)
+}
+
+func (l localBackend) ListTokens(ctx context.Context, login string) ([]auth.Token, error) {
+	return auth.ListTokens(ctx, l.st, login)
+}
+
+func (l localBackend) CreateGroup(ctx context.Context, name string) error {
+	return auth.CreateGroup(ctx, l.st, name)
+}
+
+func (l localBackend) DeleteGroup(ctx context.Context, name string) error {
+	return auth.DeleteGroup(ctx, l.st, name)
+}
+
+func (l localBackend) ListGroups(ctx context.Context) ([]string, error) {
+	return auth.ListGroups(ctx, l.st)
+}
+
+func (l localBackend) AddToGroup(ctx context.Context, group, login string) error {
+	return auth.AddToGroup(ctx, l.st, group, login)
+}
+
+func (l localBackend) RemoveFromGroup(ctx context.Context, group, login string) error {
+	return auth.RemoveFromGroup(ctx, l.st, group, login)
+}
+
+func (l localBackend) GroupMembers(ctx context.Context, group string) ([]auth.User, error) {
+	return auth.GroupMembers(ctx, l.st, group)
+}
+
+func (l localBackend) RegisterClient(ctx context.Context, nc auth.NewClient) (auth.Client, string, error) {
+	return auth.RegisterClient(ctx, l.st, nc)
+}
+
+func (l localBackend) ListClients(ctx context.Context) ([]auth.Client, error) {
+	return auth.ListClients(ctx, l.st)
+}
+
+func (l localBackend) DeleteClient(ctx context.Context, clientID string) error {
+	return auth.DeleteClient(ctx, l.st, clientID)
+}
+
+func (r remoteBackend) Close() error { return nil }
+
+func (r remoteBackend) RegisterUser(ctx context.Context, login, password string) error {
+	_, err := r.admin.CreateUser(ctx, login, password)
+	return err
+}
+
+func (r remoteBackend) ImportUser(ctx context.Context, login, passwordHash string) error {
+	_, err := r.admin.ImportUser(ctx, login, passwordHash)
+	return err
+}
+
+func (r remoteBackend) InviteUser(ctx context.Context, login string, attrs map[string]string, ttl time.Duration) (string, error) {
+	return r.admin.InviteUser(ctx, login, attrs, ttl)
+}
+
+func (r remoteBackend) ListUsers(ctx context.Context) ([]auth.User, error) {
+	users, err := r.admin.ListUsers(ctx)
+	return fromClientUsers(users), err
+}
+
+func (r remoteBackend) LookupUser(ctx context.Context, login string) (auth.User, error) {
+	u, err := r.admin.LookupUser(ctx, login)
+	return auth.User(u), err
+}
+
+func (r remoteBackend) ReplacePassword(ctx context.Context, login, password string) error {
+	return r.admin.SetPassword(ctx, login, password)
+}
+
+func (r remoteBackend) DisableUser(ctx context.Context, login string) error {
+	return r.admin.DisableUser(ctx, login)
+}
+
+func (r remoteBackend) EnableUser(ctx context.Context, login string) error {
+	return r.admin.EnableUser(ctx, login)
+}
+
+func (r remoteBackend) DeleteUser(ctx context.Context, login string) error {
+	return r.admin.DeleteUser(ctx, login)
+}
+
+func (r remoteBackend) RenameUser(ctx context.Context, login, newLogin string) error {
+	return r.admin.RenameUser(ctx, login, newLogin)
+}
+
+func (r remoteBackend) SetUserAttributes(ctx context.Context, login string, attrs map[string]string) error {
+	return r.admin.SetAttributes(ctx, login, attrs)
+}
+
+func (r remoteBackend) CreateResetToken(ctx context.Context, login string, ttl time.Duration) (string, error) {
+	return r.admin.CreateResetToken(ctx, login, ttl)
+}
+
+func (r remoteBackend) CreateToken(ctx context.Context, login, tokenType string, ttl time.Duration) (string, error) {
+	return r.admin.CreateToken(ctx, login, tokenType, ttl)
+}
+
+func (r remoteBackend) RevokeToken(ctx context.Context, tokenID string) error {
+	return r.admin.RevokeToken(ctx, tokenID)
+}
+
+func (r remoteBackend) ListTokens(ctx context.Context, login string) ([]auth.Token, error) {
+	tokens, err := r.admin.ListTokens(ctx, login)
+	out := make([]auth.Token, 0, len(tokens))
+	for _, t := range tokens {
+		out = append(out, auth.Token(t))
+	}
+	return out, err
+}
+
+func (r remoteBackend) CreateGroup(ctx context.Context, name string) error {
+	return r.admin.CreateGroup(ctx, name)
+}
+
+func (r remoteBackend) DeleteGroup(ctx context.Context, name string) error {
+	return r.admin.DeleteGroup(ctx, name)
+}
+
+func (r remoteBackend) ListGroups(ctx context.Context) ([]string, error) {
+	return r.admin.ListGroups(ctx)
+}
+
+func (r remoteBackend) AddToGroup(ctx context.Context, group, login string) error {
+	return r.admin.AddToGroup(ctx, group, login)
+}
+
+func (r remoteBackend) RemoveFromGroup(ctx context.Context, group, login string) error {
+	return r.admin.RemoveFromGroup(ctx, group, login)
+}
+
+func (r remoteBackend) GroupMembers(ctx context.Context, group string) ([]auth.User, error) {
+	users, err := r.admin.GroupMembers(ctx, group)
+	return fromClientUsers(users), err
+}
+
+func (r remoteBackend) RegisterClient(ctx context.Context, nc auth.NewClient) (auth.Client, string, error) {
+	c, secret, err := r.admin.RegisterClient(ctx, client.NewClient(nc))
+	return auth.Client(c), secret, err
+}
+
+func (r remoteBackend) ListClients(ctx context.Context) ([]auth.Client, error) {
+	clients, err := r.admin.ListClients(ctx)
+	out := make([]auth.Client, 0, len(clients))
+	for _, c := range clients {
+		out = append(out, auth.Client(c))
+	}
+	return out, err
+}
+
+func (r remoteBackend) DeleteClient(ctx context.Context, clientID string) error {
+	return r.admin.DeleteClient(ctx, clientID)
+}
+
+func fromClientUsers(users []client.User) []auth.User {
+	out := make([]auth.User, 0, len(users))
+	for _, u := range users {
+		out = append(out, auth.User(u))
+	}
+	return out
+}
@@ -8,19 +8,32 @@ import (
 	"github.com/urfave/cli/v2"
 )
 
-func Cmd(dir *string, output io.Writer, input io.Reader) *cli.Command {
+func Cmd(dbcfg *auth.DBConfig, output io.Writer, input io.Reader) *cli.Command {
+	var remote remoteConfig
 	return &cli.Command{
 		Name:  "ctl",
 		Usage: "Controls the auth database",
+		Description: `By default commands operate directly on the database selected by --data-dir or --db.
+
+With --endpoint and --token commands are sent to the admin API of a running
+"auth serve api", the token must be of type admin
+(eg.: auth ctl token register --token-type admin ...).
+The db and keys commands are only available in local mode.`,
+		Flags: remote.flags(),
 		Subcommands: []*cli.Command{
-			registerUserCmd(dir, input),
-			updateUserCmd(dir, input),
-			tokenCtlCmd(dir, output),
+			registerUserCmd(dbcfg, &remote, input),
+			updateUserCmd(dbcfg, &remote, input),
+			tokenCtlCmd(dbcfg, &remote, output),
+			userCtlCmd(dbcfg, &remote, output, input),
+			groupCtlCmd(dbcfg, &remote, output),
+			oidcCtlCmd(dbcfg, &remote, output),
+			dbCtlCmd(dbcfg, &remote, output, input),
+			keysCtlCmd(dbcfg, &remote, output),
 		},
 	}
 }
 
-func updateUserCmd(dir *string, input io.Reader) *cli.Command {
+func updateUserCmd(dbcfg *auth.DBConfig, remote *remoteConfig, input io.Reader) *cli.Command {
 	var login, password string
 	return &cli.Command{
 		Name:  "passwd",
@@ -52,16 +65,17 @@ func updateUserCmd(dir *string, input io.Reader) *cli.Command {
 				aux = bytes.TrimSpace(aux)
 				password = string(aux)
 			}
-			db, err := auth.OpenDir(ctx.Context, *dir)
+			be, err := remote.open(ctx.Context, dbcfg)
 			if err != nil {
 				return err
 			}
-			return auth.ReplacePassword(ctx.Context, db, login, []byte(password))
+			defer be.Close()
+			return be.ReplacePassword(ctx.Context, login, password)
 		},
 	}
 }
 
-func registerUserCmd(dir *string, input io.Reader) *cli.Command {
+func registerUserCmd(dbcfg *auth.DBConfig, remote *remoteConfig, input io.Reader) *cli.Command {
 	var login, password string
 	return &cli.Command{
 		Name:  "register",
@@ -93,12 +107,12 @@ func registerUserCmd(dir *string, input io.Reader) *cli.Command {
 				aux = bytes.TrimSpace(aux)
 				password = string(aux)
 			}
-			db, err := auth.OpenDir(ctx.Context, *dir)
+			be, err := remote.open(ctx.Context, dbcfg)
 			if err != nil {
 				return err
 			}
-			_, err = auth.RegisterUser(ctx.Context, db, login, []byte(password))
-			return err
+			defer be.Close()
+			return be.RegisterUser(ctx.Context, login, password)
 		},
 	}
 }
@@ -0,0 +1,188 @@
+package ctl
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/andrebq/auth"
+	"github.com/urfave/cli/v2"
+)
+
+func dbCtlCmd(dbcfg *auth.DBConfig, remote *remoteConfig, output io.Writer, input io.Reader) *cli.Command {
+	var db *sql.DB
+	return &cli.Command{
+		Name:  "db",
+		Usage: "Maintenance of the auth database",
+		Subcommands: []*cli.Command{
+			migrateCmd(&db, output),
+			statusCmd(&db, output),
+			backupCmd(&db, output),
+			restoreCmd(&db, input),
+		},
+		Before: func(ctx *cli.Context) error {
+			if err := remote.localOnly(ctx); err != nil {
+				return err
+			}
+			var err error
+			db, err = auth.OpenNoMigrate(ctx.Context, *dbcfg)
+			return err
+		},
+		After: func(ctx *cli.Context) error {
+			if db != nil {
+				return db.Close()
+			}
+			return nil
+		},
+	}
+}
+
+func migrateCmd(db **sql.DB, output io.Writer) *cli.Command {
+	return &cli.Command{
+		Name:  "migrate",
+		Usage: "Apply all pending migrations and print them to stdout",
+		Action: func(ctx *cli.Context) error {
+			applied, err := auth.Migrate(ctx.Context, *db)
+			for _, m := range applied {
+				fmt.Fprintf(output, "applied %04d %v\n", m.Version, m.Name)
+			}
+			if err != nil {
+				return err
+			}
+			if len(applied) == 0 {
+				fmt.Fprintln(output, "schema is up to date")
+			}
+			return nil
+		},
+	}
+}
+
+func statusCmd(db **sql.DB, output io.Writer) *cli.Command {
+	return &cli.Command{
+		Name:  "status",
+		Usage: "Print the current schema version and pending migrations",
+		Action: func(ctx *cli.Context) error {
+			status, err := auth.MigrationStatus(ctx.Context, *db)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(output, "current: %v\nlatest: %v\n", status.Current, status.Latest)
+			for _, m := range status.Pending {
+				fmt.Fprintf(output, "pending %04d %v\n", m.Version, m.Name)
+			}
+			if status.Current > status.Latest {
+				return auth.ErrSchemaTooNew
+			}
+			return nil
+		},
+	}
+}
+
+func backupCmd(db **sql.DB, output io.Writer) *cli.Command {
+	var out, passphrase, passphraseFile string
+	var opts auth.BackupOptions
+	return &cli.Command{
+		Name:  "backup",
+		Usage: "Write a consistent snapshot of the database, safe to run while the server is running",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "out",
+				Usage:       "File where the backup is written, use - for stdout",
+				Destination: &out,
+				Required:    true,
+			},
+			&cli.BoolFlag{
+				Name:        "exclude-expired-tokens",
+				Usage:       "Do not include expired or revoked tokens in the backup",
+				Destination: &opts.ExcludeExpiredTokens,
+			},
+			passphraseFileFlag(&passphraseFile),
+			passphraseFlag(&passphrase),
+		},
+		Action: func(ctx *cli.Context) error {
+			var err error
+			if opts.Passphrase, err = readPassphrase(passphrase, passphraseFile); err != nil {
+				return err
+			}
+			if out == "-" {
+				return auth.Backup(ctx.Context, *db, output, opts)
+			}
+			fd, err := os.OpenFile(out, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+			if err != nil {
+				return err
+			}
+			err = auth.Backup(ctx.Context, *db, fd, opts)
+			if err != nil {
+				fd.Close()
+				os.Remove(out)
+				return err
+			}
+			return fd.Close()
+		},
+	}
+}
+
+func restoreCmd(db **sql.DB, input io.Reader) *cli.Command {
+	var in, passphrase, passphraseFile string
+	var opts auth.RestoreOptions
+	return &cli.Command{
+		Name:  "restore",
+		Usage: "Replace the contents of the database with a backup",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "in",
+				Usage:       "File where the backup is read from, use - for stdin",
+				Destination: &in,
+				Required:    true,
+			},
+			passphraseFileFlag(&passphraseFile),
+			passphraseFlag(&passphrase),
+		},
+		Action: func(ctx *cli.Context) error {
+			var err error
+			if opts.Passphrase, err = readPassphrase(passphrase, passphraseFile); err != nil {
+				return err
+			}
+			if in == "-" {
+				return auth.Restore(ctx.Context, *db, input, opts)
+			}
+			fd, err := os.Open(in)
+			if err != nil {
+				return err
+			}
+			defer fd.Close()
+			return auth.Restore(ctx.Context, *db, fd, opts)
+		},
+	}
+}
+
+func passphraseFileFlag(out *string) cli.Flag {
+	return &cli.StringFlag{
+		Name:        "passphrase-file",
+		Usage:       "File containing the passphrase used to encrypt/decrypt the backup",
+		Destination: out,
+	}
+}
+
+func passphraseFlag(out *string) cli.Flag {
+	return &cli.StringFlag{
+		Name:        "passphrase",
+		Usage:       "Passphrase used to encrypt/decrypt the backup",
+		EnvVars:     []string{"AUTH_BACKUP_PASSPHRASE"},
+		Destination: out,
+		Hidden:      true,
+	}
+}
+
+func readPassphrase(passphrase, file string) ([]byte, error) {
+	if file == "" {
+		return []byte(passphrase), nil
+	}
+	aux, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSpace(aux), nil
+}
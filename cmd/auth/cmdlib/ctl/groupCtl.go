@@ -0,0 +1,144 @@
+package ctl
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/andrebq/auth"
+	"github.com/urfave/cli/v2"
+)
+
+func groupCtlCmd(dbcfg *auth.DBConfig, remote *remoteConfig, output io.Writer) *cli.Command {
+	var be backend
+	return &cli.Command{
+		Name:  "group",
+		Usage: "Manage groups and their members",
+		Subcommands: []*cli.Command{
+			createGroupCmd(&be),
+			deleteGroupCmd(&be),
+			listGroupsCmd(&be, output),
+			addGroupMemberCmd(&be),
+			removeGroupMemberCmd(&be),
+			groupMembersCmd(&be, output),
+		},
+		Before: func(ctx *cli.Context) error {
+			var err error
+			be, err = remote.open(ctx.Context, dbcfg)
+			return err
+		},
+		After: func(ctx *cli.Context) error {
+			if be != nil {
+				return be.Close()
+			}
+			return nil
+		},
+	}
+}
+
+func createGroupCmd(be *backend) *cli.Command {
+	var name string
+	return &cli.Command{
+		Name:  "create",
+		Usage: "Create an empty group",
+		Flags: []cli.Flag{groupFlag(&name)},
+		Action: func(ctx *cli.Context) error {
+			return (*be).CreateGroup(ctx.Context, name)
+		},
+	}
+}
+
+func deleteGroupCmd(be *backend) *cli.Command {
+	var name string
+	return &cli.Command{
+		Name:  "delete",
+		Usage: "Remove a group, its members are kept",
+		Flags: []cli.Flag{groupFlag(&name)},
+		Action: func(ctx *cli.Context) error {
+			return (*be).DeleteGroup(ctx.Context, name)
+		},
+	}
+}
+
+func listGroupsCmd(be *backend, output io.Writer) *cli.Command {
+	var asJSON bool
+	return &cli.Command{
+		Name:  "list",
+		Usage: "List all groups",
+		Flags: []cli.Flag{jsonFlag(&asJSON)},
+		Action: func(ctx *cli.Context) error {
+			groups, err := (*be).ListGroups(ctx.Context)
+			if err != nil {
+				return err
+			}
+			if asJSON {
+				if groups == nil {
+					groups = []string{}
+				}
+				return writeJSON(output, groups)
+			}
+			for _, g := range groups {
+				fmt.Fprintln(output, g)
+			}
+			return nil
+		},
+	}
+}
+
+func addGroupMemberCmd(be *backend) *cli.Command {
+	var name, login string
+	return &cli.Command{
+		Name:  "add",
+		Usage: "Add a user to a group",
+		Flags: []cli.Flag{groupFlag(&name), loginFlag(&login)},
+		Action: func(ctx *cli.Context) error {
+			return (*be).AddToGroup(ctx.Context, name, login)
+		},
+	}
+}
+
+func removeGroupMemberCmd(be *backend) *cli.Command {
+	var name, login string
+	return &cli.Command{
+		Name:  "remove",
+		Usage: "Remove a user from a group",
+		Flags: []cli.Flag{groupFlag(&name), loginFlag(&login)},
+		Action: func(ctx *cli.Context) error {
+			return (*be).RemoveFromGroup(ctx.Context, name, login)
+		},
+	}
+}
+
+func groupMembersCmd(be *backend, output io.Writer) *cli.Command {
+	var name string
+	var asJSON bool
+	return &cli.Command{
+		Name:  "members",
+		Usage: "List the members of a group",
+		Flags: []cli.Flag{groupFlag(&name), jsonFlag(&asJSON)},
+		Action: func(ctx *cli.Context) error {
+			members, err := (*be).GroupMembers(ctx.Context, name)
+			if err != nil {
+				return err
+			}
+			if asJSON {
+				return writeJSON(output, members)
+			}
+			tw := tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "LOGIN\tUID\tSTATUS")
+			for _, u := range members {
+				fmt.Fprintf(tw, "%v\t%v\t%v\n", u.Login, u.UID, userStatus(u))
+			}
+			return tw.Flush()
+		},
+	}
+}
+
+func groupFlag(name *string) cli.Flag {
+	return &cli.StringFlag{
+		Name:        "group",
+		Usage:       "Group name",
+		Destination: name,
+		Required:    true,
+	}
+}
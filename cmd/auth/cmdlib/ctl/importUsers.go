@@ -0,0 +1,82 @@
+package ctl
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type (
+	importedUser struct {
+		Login        string `json:"login"`
+		PasswordHash string `json:"passwordHash"`
+	}
+)
+
+func parseImport(format string, in io.Reader) ([]importedUser, error) {
+	var users []importedUser
+	var err error
+	switch format {
+	case "htpasswd":
+		users, err = parseHtpasswd(in)
+	case "csv":
+		users, err = parseCSV(in)
+	case "json":
+		err = json.NewDecoder(in).Decode(&users)
+	default:
+		return nil, fmt.Errorf("unsupported import format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i, u := range users {
+		if u.Login == "" || u.PasswordHash == "" {
+			return nil, fmt.Errorf("entry %v is missing the login or the password hash", i+1)
+		}
+	}
+	return users, nil
+}
+
+func parseHtpasswd(in io.Reader) ([]importedUser, error) {
+	var users []importedUser
+	sc := bufio.NewScanner(in)
+	line := 0
+	for sc.Scan() {
+		line++
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		login, hash, found := strings.Cut(text, ":")
+		if !found {
+			return nil, fmt.Errorf("line %v: expecting <login>:<hash>", line)
+		}
+		users = append(users, importedUser{Login: login, PasswordHash: hash})
+	}
+	return users, sc.Err()
+}
+
+func parseCSV(in io.Reader) ([]importedUser, error) {
+	r := csv.NewReader(in)
+	r.FieldsPerRecord = 2
+	r.TrimLeadingSpace = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) > 0 && strings.EqualFold(records[0][0], "login") {
+		records = records[1:]
+	}
+	if len(records) == 0 {
+		return nil, errors.New("csv file does not contain any user")
+	}
+	users := make([]importedUser, 0, len(records))
+	for _, rec := range records {
+		users = append(users, importedUser{Login: rec[0], PasswordHash: rec[1]})
+	}
+	return users, nil
+}
@@ -0,0 +1,154 @@
+package ctl
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/andrebq/auth"
+	"github.com/urfave/cli/v2"
+)
+
+func keysCtlCmd(dbcfg *auth.DBConfig, remote *remoteConfig, output io.Writer) *cli.Command {
+	var db auth.Store
+	return &cli.Command{
+		Name:  "keys",
+		Usage: "Manage the keys used to encrypt sensitive data at rest",
+		Subcommands: []*cli.Command{
+			generateKEKCmd(output),
+			listKeysCmd(&db, output),
+			rotateKeysCmd(&db, output),
+		},
+		Before: func(ctx *cli.Context) error {
+			if ctx.Args().First() == "generate" {
+				return nil
+			}
+			if err := remote.localOnly(ctx); err != nil {
+				return err
+			}
+			var err error
+			db, err = auth.Open(ctx.Context, *dbcfg)
+			return err
+		},
+		After: func(ctx *cli.Context) error {
+			if db != nil {
+				return db.Close()
+			}
+			return nil
+		},
+	}
+}
+
+func generateKEKCmd(output io.Writer) *cli.Command {
+	return &cli.Command{
+		Name:  "generate",
+		Usage: "Print a new random key-encryption-key, store it outside of the data-dir",
+		Action: func(ctx *cli.Context) error {
+			kek, err := auth.GenerateKEK()
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(output, kek)
+			return nil
+		},
+	}
+}
+
+func listKeysCmd(db *auth.Store, output io.Writer) *cli.Command {
+	return &cli.Command{
+		Name:  "list",
+		Usage: "List data keys and the key-encryption-key that wraps them",
+		Action: func(ctx *cli.Context) error {
+			keys, err := (*db).ListDataKeys(ctx.Context)
+			if err != nil {
+				return err
+			}
+			for _, k := range keys {
+				state := "inactive"
+				if k.Active {
+					state = "active"
+				}
+				fmt.Fprintf(output, "%v\tkek:%v\t%v\t%v\n", k.KeyID, k.KEKID, k.CreatedAt.UTC().Format(time.RFC3339), state)
+			}
+			return nil
+		},
+	}
+}
+
+func rotateKeysCmd(db *auth.Store, output io.Writer) *cli.Command {
+	var kekFile, kekValue string
+	var oldKEKFiles cli.StringSlice
+	var newDataKey bool
+	return &cli.Command{
+		Name:  "rotate",
+		Usage: "Re-wrap all data keys with a new key-encryption-key",
+		Description: `Data keys are decrypted with any of the old keks and encrypted again with the new kek,
+encrypted values are not touched so servers can keep running during the rotation.
+
+Once the rotation finishes, restart servers with the new kek.`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "kek-file",
+				Usage:       "File with the new key-encryption-key",
+				EnvVars:     []string{"AUTH_KEK_FILE"},
+				Destination: &kekFile,
+			},
+			&cli.StringFlag{
+				Name:        "kek",
+				Usage:       "The new key-encryption-key encoded as base64, used when kek-file is empty",
+				EnvVars:     []string{"AUTH_KEK"},
+				Destination: &kekValue,
+				Hidden:      true,
+			},
+			&cli.StringSliceFlag{
+				Name:        "old-kek-file",
+				Usage:       "File with a key-encryption-key currently in use, can be repeated",
+				Destination: &oldKEKFiles,
+			},
+			&cli.BoolFlag{
+				Name:        "new-data-key",
+				Usage:       "Also create a new data key, used to encrypt new values from now on",
+				Destination: &newDataKey,
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			primary, err := loadKEK(kekFile, kekValue)
+			if err != nil {
+				return err
+			}
+			var others []auth.KEK
+			for _, f := range oldKEKFiles.Value() {
+				kek, err := auth.LoadKEKFile(f)
+				if err != nil {
+					return err
+				}
+				others = append(others, kek)
+			}
+			kr := auth.NewKeyring(*db, primary, others...)
+			count, err := kr.Rotate(ctx.Context)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(output, "re-wrapped %v data keys with kek %v\n", count, primary.ID)
+			if newDataKey {
+				keyID, err := kr.NewDataKey(ctx.Context)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(output, "new data key %v\n", keyID)
+			}
+			return nil
+		},
+	}
+}
+
+func loadKEK(file, value string) (auth.KEK, error) {
+	switch {
+	case file != "":
+		return auth.LoadKEKFile(file)
+	case value != "":
+		return auth.ParseKEK(value)
+	}
+	return auth.KEK{}, errors.New("missing key-encryption-key, use --kek-file or AUTH_KEK")
+}
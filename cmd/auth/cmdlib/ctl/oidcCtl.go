@@ -0,0 +1,152 @@
+package ctl
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/andrebq/auth"
+	"github.com/urfave/cli/v2"
+)
+
+func oidcCtlCmd(dbcfg *auth.DBConfig, remote *remoteConfig, output io.Writer) *cli.Command {
+	var be backend
+	return &cli.Command{
+		Name:  "oidc",
+		Usage: "Manage applications which use auth as their OpenID Connect provider or obtain OAuth 2.0 tokens from it",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "client",
+				Usage: "Manage oidc clients",
+				Subcommands: []*cli.Command{
+					addClientCmd(&be, output),
+					listClientsCmd(&be, output),
+					deleteClientCmd(&be),
+				},
+			},
+		},
+		Before: func(ctx *cli.Context) error {
+			var err error
+			be, err = remote.open(ctx.Context, dbcfg)
+			return err
+		},
+		After: func(ctx *cli.Context) error {
+			if be != nil {
+				return be.Close()
+			}
+			return nil
+		},
+	}
+}
+
+func addClientCmd(be *backend, output io.Writer) *cli.Command {
+	var name, login string
+	var redirectURIs cli.StringSlice
+	var public, asJSON bool
+	return &cli.Command{
+		Name:  "add",
+		Usage: "Register a client and print its id and secret",
+		Description: `The secret is only printed once, store it in the configuration of the application.
+
+Public clients (eg.: single page or mobile apps) have no secret and must use PKCE.
+
+Clients registered with --login are able to obtain machine tokens owned by that
+user from the /oauth/token endpoint of the api using the client_credentials grant.`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "name",
+				Usage:       "Name of the application",
+				Destination: &name,
+				Required:    true,
+			},
+			&cli.StringSliceFlag{
+				Name:        "redirect-uri",
+				Usage:       "URL the user is sent back to after login, can be repeated",
+				Destination: &redirectURIs,
+			},
+			&cli.StringFlag{
+				Name:        "login",
+				Usage:       "User who owns the tokens issued with the client_credentials grant",
+				Destination: &login,
+			},
+			&cli.BoolFlag{
+				Name:        "public",
+				Usage:       "Register a public client, which has no secret",
+				Destination: &public,
+			},
+			jsonFlag(&asJSON),
+		},
+		Action: func(ctx *cli.Context) error {
+			client, secret, err := (*be).RegisterClient(ctx.Context, auth.NewClient{
+				Name:         name,
+				RedirectURIs: redirectURIs.Value(),
+				Public:       public,
+				Login:        login,
+			})
+			if err != nil {
+				return err
+			}
+			if asJSON {
+				return writeJSON(output, struct {
+					auth.Client
+					Secret string `json:"secret,omitempty"`
+				}{Client: client, Secret: secret})
+			}
+			fmt.Fprintf(output, "client_id: %v\n", client.ClientID)
+			if secret != "" {
+				fmt.Fprintf(output, "client_secret: %v\n", secret)
+			}
+			return nil
+		},
+	}
+}
+
+func listClientsCmd(be *backend, output io.Writer) *cli.Command {
+	var asJSON bool
+	return &cli.Command{
+		Name:  "list",
+		Usage: "List all clients",
+		Flags: []cli.Flag{jsonFlag(&asJSON)},
+		Action: func(ctx *cli.Context) error {
+			clients, err := (*be).ListClients(ctx.Context)
+			if err != nil {
+				return err
+			}
+			if asJSON {
+				return writeJSON(output, clients)
+			}
+			tw := tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "CLIENT ID\tNAME\tTYPE\tGRANTS\tCREATED\tREDIRECT URIS")
+			for _, c := range clients {
+				kind := "confidential"
+				if c.Public {
+					kind = "public"
+				}
+				fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\n", c.ClientID, c.Name, kind, strings.Join(c.GrantTypes(), ","),
+					c.CreatedAt.UTC().Format(time.RFC3339), strings.Join(c.RedirectURIs, ","))
+			}
+			return tw.Flush()
+		},
+	}
+}
+
+func deleteClientCmd(be *backend) *cli.Command {
+	var clientID string
+	return &cli.Command{
+		Name:  "delete",
+		Usage: "Remove a client, tokens already issued to it remain valid until they expire",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "client-id",
+				Usage:       "ID of the client",
+				Destination: &clientID,
+				Required:    true,
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			return (*be).DeleteClient(ctx.Context, clientID)
+		},
+	}
+}
@@ -1,39 +1,43 @@
 package ctl
 
 import (
-	"database/sql"
 	"fmt"
 	"io"
+	"text/tabwriter"
 	"time"
 
 	"github.com/andrebq/auth"
 	"github.com/urfave/cli/v2"
 )
 
-func tokenCtlCmd(dir *string, output io.Writer) *cli.Command {
-	var db *sql.DB
+func tokenCtlCmd(dbcfg *auth.DBConfig, remote *remoteConfig, output io.Writer) *cli.Command {
+	var be backend
 	return &cli.Command{
 		Name:  "token",
 		Usage: "Controls tokens for users",
 		Subcommands: []*cli.Command{
-			registerTokenCmd(&db, output),
-			revokeTokenCmd(&db),
+			registerTokenCmd(&be, output),
+			revokeTokenCmd(&be),
+			listTokensCmd(&be, output),
 		},
 		Before: func(ctx *cli.Context) error {
 			var err error
-			db, err = auth.OpenDir(ctx.Context, *dir)
+			be, err = remote.open(ctx.Context, dbcfg)
 			if err != nil {
 				return err
 			}
 			return nil
 		},
 		After: func(ctx *cli.Context) error {
-			return db.Close()
+			if be != nil {
+				return be.Close()
+			}
+			return nil
 		},
 	}
 }
 
-func registerTokenCmd(db **sql.DB, output io.Writer) *cli.Command {
+func registerTokenCmd(be *backend, output io.Writer) *cli.Command {
 	var login, tokenType string
 	var ttl time.Duration
 	var skipnl bool
@@ -67,7 +71,7 @@ func registerTokenCmd(db **sql.DB, output io.Writer) *cli.Command {
 			},
 		},
 		Action: func(ctx *cli.Context) error {
-			token, err := auth.CreateToken(ctx.Context, *db, login, tokenType, time.Now().Add(ttl))
+			token, err := (*be).CreateToken(ctx.Context, login, tokenType, ttl)
 			if err != nil {
 				return err
 			}
@@ -80,7 +84,7 @@ func registerTokenCmd(db **sql.DB, output io.Writer) *cli.Command {
 	}
 }
 
-func revokeTokenCmd(db **sql.DB) *cli.Command {
+func revokeTokenCmd(be *backend) *cli.Command {
 	var tokenID string
 	return &cli.Command{
 		Name:  "revoke",
@@ -98,7 +102,38 @@ func revokeTokenCmd(db **sql.DB) *cli.Command {
 			if err != nil {
 				return err
 			}
-			return auth.RevokeToken(ctx.Context, *db, actualID)
+			return (*be).RevokeToken(ctx.Context, actualID)
+		},
+	}
+}
+
+func listTokensCmd(be *backend, output io.Writer) *cli.Command {
+	var login string
+	var asJSON bool
+	return &cli.Command{
+		Name:  "list",
+		Usage: "List the tokens of a user, newest first",
+		Flags: []cli.Flag{loginFlag(&login), jsonFlag(&asJSON)},
+		Action: func(ctx *cli.Context) error {
+			tokens, err := (*be).ListTokens(ctx.Context, login)
+			if err != nil {
+				return err
+			}
+			if asJSON {
+				return writeJSON(output, tokens)
+			}
+			now := time.Now()
+			tw := tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "TOKEN ID\tTYPE\tCREATED\tEXPIRES\tSTATUS")
+			for _, t := range tokens {
+				status := "valid"
+				if t.Expired(now) {
+					status = "expired"
+				}
+				fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\n", t.TokenID, t.TokenType,
+					t.CreatedAt.UTC().Format(time.RFC3339), t.ExpiresAt.UTC().Format(time.RFC3339), status)
+			}
+			return tw.Flush()
 		},
 	}
 }
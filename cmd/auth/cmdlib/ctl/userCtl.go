@@ -0,0 +1,401 @@
+package ctl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/andrebq/auth"
+	"github.com/andrebq/auth/notify"
+	"github.com/urfave/cli/v2"
+)
+
+func userCtlCmd(dbcfg *auth.DBConfig, remote *remoteConfig, output io.Writer, input io.Reader) *cli.Command {
+	var be backend
+	return &cli.Command{
+		Name:  "user",
+		Usage: "Manage users",
+		Subcommands: []*cli.Command{
+			importUsersCmd(&be, output, input),
+			listUsersCmd(&be, output),
+			showUserCmd(&be, output),
+			disableUserCmd(&be),
+			enableUserCmd(&be),
+			deleteUserCmd(&be),
+			renameUserCmd(&be),
+			setAttrCmd(&be),
+			resetTokenCmd(&be, output),
+			inviteUserCmd(&be, output),
+		},
+		Before: func(ctx *cli.Context) error {
+			var err error
+			be, err = remote.open(ctx.Context, dbcfg)
+			return err
+		},
+		After: func(ctx *cli.Context) error {
+			if be != nil {
+				return be.Close()
+			}
+			return nil
+		},
+	}
+}
+
+func importUsersCmd(be *backend, output io.Writer, input io.Reader) *cli.Command {
+	var format, file string
+	var skipExisting bool
+	return &cli.Command{
+		Name:  "import",
+		Usage: "Import users and their password hashes from other systems",
+		Description: `Supported hashes are bcrypt ($2a$, $2b$, $2y$), md5-crypt ($apr1$, $1$),
+sha1 ({SHA}) and argon2id. Hashes are upgraded to argon2id on the next successful login.
+
+Formats:
+  htpasswd: one <login>:<hash> per line
+  csv:      <login>,<hash> records, an optional header starting with "login" is ignored
+  json:     [{"login": "...", "passwordHash": "..."}]`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "format",
+				Usage:       "Format of the input, one of htpasswd, csv or json",
+				Destination: &format,
+				Value:       "htpasswd",
+			},
+			&cli.StringFlag{
+				Name:        "file",
+				Usage:       "File to import, use - for stdin",
+				Destination: &file,
+				Required:    true,
+			},
+			&cli.BoolFlag{
+				Name:        "skip-existing",
+				Usage:       "Ignore users which already exist instead of failing",
+				Destination: &skipExisting,
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			in := input
+			if file != "-" {
+				fd, err := os.Open(file)
+				if err != nil {
+					return err
+				}
+				defer fd.Close()
+				in = fd
+			}
+			users, err := parseImport(format, in)
+			if err != nil {
+				return err
+			}
+			imported, skipped := 0, 0
+			for _, u := range users {
+				if skipExisting {
+					if _, err := (*be).LookupUser(ctx.Context, u.Login); err == nil {
+						skipped++
+						continue
+					}
+				}
+				if err := (*be).ImportUser(ctx.Context, u.Login, u.PasswordHash); err != nil {
+					return fmt.Errorf("unable to import %v: %w", u.Login, err)
+				}
+				imported++
+			}
+			fmt.Fprintf(output, "imported %v users, skipped %v\n", imported, skipped)
+			return nil
+		},
+	}
+}
+
+func listUsersCmd(be *backend, output io.Writer) *cli.Command {
+	var asJSON bool
+	return &cli.Command{
+		Name:  "list",
+		Usage: "List all users, including disabled ones",
+		Flags: []cli.Flag{jsonFlag(&asJSON)},
+		Action: func(ctx *cli.Context) error {
+			users, err := (*be).ListUsers(ctx.Context)
+			if err != nil {
+				return err
+			}
+			if asJSON {
+				return writeJSON(output, users)
+			}
+			tw := tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "LOGIN\tUID\tSTATUS")
+			for _, u := range users {
+				fmt.Fprintf(tw, "%v\t%v\t%v\n", u.Login, u.UID, userStatus(u))
+			}
+			return tw.Flush()
+		},
+	}
+}
+
+func showUserCmd(be *backend, output io.Writer) *cli.Command {
+	var login string
+	var asJSON bool
+	return &cli.Command{
+		Name:  "show",
+		Usage: "Show the details of a single user",
+		Flags: []cli.Flag{loginFlag(&login), jsonFlag(&asJSON)},
+		Action: func(ctx *cli.Context) error {
+			u, err := (*be).LookupUser(ctx.Context, login)
+			if err != nil {
+				return err
+			}
+			if asJSON {
+				return writeJSON(output, u)
+			}
+			fmt.Fprintf(output, "login: %v\nuid: %v\nstatus: %v\n", u.Login, u.UID, userStatus(u))
+			if len(u.Groups) > 0 {
+				fmt.Fprintf(output, "groups: %v\n", strings.Join(u.Groups, ","))
+			}
+			names := make([]string, 0, len(u.Attributes))
+			for name := range u.Attributes {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				fmt.Fprintf(output, "attr.%v: %v\n", name, u.Attributes[name])
+			}
+			return nil
+		},
+	}
+}
+
+func disableUserCmd(be *backend) *cli.Command {
+	var login string
+	return &cli.Command{
+		Name:  "disable",
+		Usage: "Prevent a user from authenticating and revoke all of its tokens",
+		Flags: []cli.Flag{loginFlag(&login)},
+		Action: func(ctx *cli.Context) error {
+			return (*be).DisableUser(ctx.Context, login)
+		},
+	}
+}
+
+func enableUserCmd(be *backend) *cli.Command {
+	var login string
+	return &cli.Command{
+		Name:  "enable",
+		Usage: "Allow a disabled user to authenticate again",
+		Flags: []cli.Flag{loginFlag(&login)},
+		Action: func(ctx *cli.Context) error {
+			return (*be).EnableUser(ctx.Context, login)
+		},
+	}
+}
+
+func deleteUserCmd(be *backend) *cli.Command {
+	var login string
+	return &cli.Command{
+		Name:  "delete",
+		Usage: "Remove a user and all of its tokens",
+		Flags: []cli.Flag{loginFlag(&login)},
+		Action: func(ctx *cli.Context) error {
+			return (*be).DeleteUser(ctx.Context, login)
+		},
+	}
+}
+
+func renameUserCmd(be *backend) *cli.Command {
+	var login, newLogin string
+	return &cli.Command{
+		Name:  "rename",
+		Usage: "Change the login of a user, existing tokens remain valid",
+		Flags: []cli.Flag{
+			loginFlag(&login),
+			&cli.StringFlag{
+				Name:        "new-login",
+				Usage:       "New login for the user",
+				Destination: &newLogin,
+				Required:    true,
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			return (*be).RenameUser(ctx.Context, login, newLogin)
+		},
+	}
+}
+
+func setAttrCmd(be *backend) *cli.Command {
+	var login string
+	return &cli.Command{
+		Name:      "set-attr",
+		Usage:     "Set or remove attributes of a user",
+		ArgsUsage: "<name>=<value>...",
+		Description: `Each argument sets one attribute, an empty value removes it.
+
+Well-known attributes are "name" (display name) and "email".
+
+Example:
+  auth ctl user set-attr --login bob name="Bob Smith" team=infra cost-center=`,
+		Flags: []cli.Flag{loginFlag(&login)},
+		Action: func(ctx *cli.Context) error {
+			if ctx.NArg() == 0 {
+				return errors.New("at least one <name>=<value> is required")
+			}
+			attrs := make(map[string]string, ctx.NArg())
+			for _, arg := range ctx.Args().Slice() {
+				name, value, found := strings.Cut(arg, "=")
+				if !found {
+					return fmt.Errorf("invalid attribute %q, expecting <name>=<value>", arg)
+				}
+				attrs[name] = value
+			}
+			return (*be).SetUserAttributes(ctx.Context, login, attrs)
+		},
+	}
+}
+
+func resetTokenCmd(be *backend, output io.Writer) *cli.Command {
+	var login, baseURL string
+	ttl := auth.DefaultResetTTL
+	return &cli.Command{
+		Name:  "reset-token",
+		Usage: "Create a single use token which allows the user to choose a new password",
+		Flags: []cli.Flag{
+			loginFlag(&login),
+			&cli.DurationFlag{
+				Name:        "ttl",
+				Usage:       "How long the token is valid",
+				Destination: &ttl,
+				Value:       ttl,
+			},
+			&cli.StringFlag{
+				Name:        "base-url",
+				Usage:       "Public URL of the auth proxy, when set a link to the reset page is printed instead of the token",
+				EnvVars:     []string{"AUTH_PUBLIC_URL"},
+				Destination: &baseURL,
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			token, err := (*be).CreateResetToken(ctx.Context, login, ttl)
+			if err != nil {
+				return err
+			}
+			printLink(output, token, notify.Links{PublicURL: baseURL}.ResetLink(token))
+			return nil
+		},
+	}
+}
+
+func inviteUserCmd(be *backend, output io.Writer) *cli.Command {
+	var login, email, name, baseURL, notifier, templatesDir string
+	ttl := auth.DefaultInviteTTL
+	return &cli.Command{
+		Name:  "invite",
+		Usage: "Create an inactive user and an invitation which lets the user choose a password",
+		Description: `The invitation link points to /.auth/accept-invite on the auth proxy,
+when --notifier is set the invitation is also delivered to the user.`,
+		Flags: []cli.Flag{
+			loginFlag(&login),
+			&cli.StringFlag{
+				Name:        "email",
+				Usage:       "Email of the user, required to deliver the invitation by smtp",
+				Destination: &email,
+			},
+			&cli.StringFlag{
+				Name:        "name",
+				Usage:       "Display name of the user",
+				Destination: &name,
+			},
+			&cli.DurationFlag{
+				Name:        "ttl",
+				Usage:       "How long the invitation is valid",
+				Destination: &ttl,
+				Value:       ttl,
+			},
+			&cli.StringFlag{
+				Name:        "base-url",
+				Usage:       "Public URL of the auth proxy, when set a link is printed instead of the token",
+				EnvVars:     []string{"AUTH_PUBLIC_URL"},
+				Destination: &baseURL,
+			},
+			&cli.StringFlag{
+				Name:        "notifier",
+				Usage:       "Also deliver the invitation using smtp://..., http(s)://..., file://... or stdout",
+				EnvVars:     []string{"AUTH_NOTIFIER"},
+				Destination: &notifier,
+			},
+			&cli.StringFlag{
+				Name:        "notify-templates",
+				Usage:       "Directory with <event>.tmpl files overriding the default notification templates",
+				EnvVars:     []string{"AUTH_NOTIFY_TEMPLATES"},
+				Destination: &templatesDir,
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			attrs := map[string]string{auth.AttrEmail: email, auth.AttrName: name}
+			token, err := (*be).InviteUser(ctx.Context, login, attrs, ttl)
+			if err != nil {
+				return err
+			}
+			links := notify.Links{PublicURL: baseURL}
+			if notifier != "" {
+				if links.Notifier, err = notify.Open(notifier, templatesDir); err != nil {
+					return err
+				}
+				if c, ok := links.Notifier.(io.Closer); ok {
+					defer c.Close()
+				}
+				user, err := (*be).LookupUser(ctx.Context, login)
+				if err != nil {
+					return err
+				}
+				if err := links.NotifyInvite(ctx.Context, user, token); err != nil {
+					return fmt.Errorf("invitation created but could not be delivered: %w", err)
+				}
+			}
+			printLink(output, token, links.InviteLink(token))
+			return nil
+		},
+	}
+}
+
+// printLink prints link if available, otherwise the raw token
+func printLink(output io.Writer, token, link string) {
+	if link == "" {
+		fmt.Fprintln(output, token)
+		return
+	}
+	fmt.Fprintln(output, link)
+}
+
+func loginFlag(login *string) cli.Flag {
+	return &cli.StringFlag{
+		Name:        "login",
+		Usage:       "User login",
+		Destination: login,
+		Required:    true,
+	}
+}
+
+func jsonFlag(asJSON *bool) cli.Flag {
+	return &cli.BoolFlag{
+		Name:        "json",
+		Usage:       "Print the output as JSON",
+		Destination: asJSON,
+	}
+}
+
+func writeJSON(output io.Writer, v any) error {
+	enc := json.NewEncoder(output)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func userStatus(u auth.User) string {
+	switch {
+	case u.Active:
+		return "active"
+	case u.Invited:
+		return "invited"
+	}
+	return "disabled"
+}
@@ -0,0 +1,67 @@
+package hub
+
+import (
+	"errors"
+
+	"github.com/andrebq/auth/client"
+	"github.com/andrebq/auth/tunnel/hub"
+	"github.com/urfave/cli/v2"
+)
+
+type (
+	// credentials used by expose and dial to authenticate with the hub,
+	// either a static token or an oauth client which obtains tokens from
+	// the auth api and renews them before they expire
+	credentials struct {
+		token        string
+		authEndpoint string
+		clientID     string
+		clientSecret string
+	}
+)
+
+func (c *credentials) flags() []cli.Flag {
+	c.authEndpoint = "http://localhost:18001/"
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:        "token",
+			Usage:       "Token to authenticate",
+			EnvVars:     []string{"AUTH_HUB_TOKEN"},
+			Hidden:      true,
+			Destination: &c.token,
+		},
+		&cli.StringFlag{
+			Name:        "client-id",
+			Usage:       "ID of the client used to obtain tokens from the auth api, instead of a static token",
+			EnvVars:     []string{"AUTH_HUB_CLIENT_ID"},
+			Destination: &c.clientID,
+		},
+		&cli.StringFlag{
+			Name:        "client-secret",
+			Usage:       "Secret of the client used to obtain tokens",
+			EnvVars:     []string{"AUTH_HUB_CLIENT_SECRET"},
+			Hidden:      true,
+			Destination: &c.clientSecret,
+		},
+		&cli.StringFlag{
+			Name:        "auth-endpoint",
+			Aliases:     []string{"ae"},
+			Usage:       "Endpoint where the auth server is running, used with client-id",
+			Destination: &c.authEndpoint,
+			Value:       c.authEndpoint,
+		},
+	}
+}
+
+func (c *credentials) source() (hub.TokenSource, error) {
+	switch {
+	case c.clientID != "":
+		if c.clientSecret == "" {
+			return nil, errors.New("--client-secret is required with --client-id")
+		}
+		return client.New(c.authEndpoint).TokenSource(c.clientID, c.clientSecret), nil
+	case c.token != "":
+		return hub.StaticToken(c.token), nil
+	}
+	return nil, errors.New("either AUTH_HUB_TOKEN or --client-id is required")
+}
@@ -29,6 +29,8 @@ func serveCmd() *cli.Command {
 	var addr string = "127.0.0.1"
 	var port uint = 18003
 	var internetFacing bool
+	var localVerification bool
+	revocationInterval := client.DefaultRevocationInterval
 	return &cli.Command{
 		Name:  "serve",
 		Usage: "Runs the hub server that creates tunnels",
@@ -58,10 +60,25 @@ func serveCmd() *cli.Command {
 				Destination: &port,
 				Value:       port,
 			},
+			&cli.BoolFlag{
+				Name:        "local-verification",
+				Usage:       "Verify signed tokens with the keys published by the auth server instead of calling it for every connection",
+				Destination: &localVerification,
+			},
+			&cli.DurationFlag{
+				Name:        "revocation-interval",
+				Usage:       "How often revoked tokens are fetched when local-verification is enabled",
+				Destination: &revocationInterval,
+				Value:       revocationInterval,
+			},
 		},
 		Action: func(ctx *cli.Context) error {
 			authcli := client.New(authEndpoint)
-			h, err := hub.NewHub(authcli)
+			var tokens hub.ValidateToken = authcli
+			if localVerification {
+				tokens = authcli.Verifier(revocationInterval)
+			}
+			h, err := hub.NewHub(tokens)
 			if err != nil {
 				return err
 			}
@@ -72,8 +89,8 @@ func serveCmd() *cli.Command {
 
 func exposeLocalCmd() *cli.Command {
 	var localAddr string
-	var token string
 	var tunnelID string
+	var creds credentials
 	hub := "ws://localhost:18003/"
 	return &cli.Command{
 		Name:  "expose",
@@ -82,21 +99,13 @@ func exposeLocalCmd() *cli.Command {
 and Accept clients.
 
 Each new tunnel client will then be proxied to the local server`,
-		Flags: []cli.Flag{
+		Flags: append(creds.flags(),
 			&cli.StringFlag{
 				Name:        "local-addr",
 				Usage:       "Local Address where to dial",
 				Destination: &localAddr,
 				Required:    true,
 			},
-			&cli.StringFlag{
-				Name:        "token",
-				Usage:       "Token to authenticate",
-				EnvVars:     []string{"AUTH_HUB_TOKEN"},
-				Hidden:      true,
-				Required:    true,
-				Destination: &token,
-			},
 			&cli.StringFlag{
 				Name:        "tunnel-id",
 				Usage:       "ID of the tunnel",
@@ -110,18 +119,23 @@ Each new tunnel client will then be proxied to the local server`,
 				Value:       hub,
 				Destination: &hub,
 			},
-		},
+		),
 		Action: func(ctx *cli.Context) error {
+			tokens, err := creds.source()
+			if err != nil {
+				return err
+			}
 			dialer := func(_ context.Context) (net.Conn, error) {
 				return net.Dial("tcp", localAddr)
 			}
-			return proxy.RemoteToLocal(ctx.Context, hub, token, tunnelID, dialer)
+			return proxy.RemoteToLocal(ctx.Context, hub, tokens, tunnelID, dialer)
 		},
 	}
 }
 
 func dialRemoteCmd() *cli.Command {
-	var token, tunnelID string
+	var tunnelID string
+	var creds credentials
 	hub := "ws://localhost:18003/"
 	var addr string
 	var port uint
@@ -130,7 +144,7 @@ func dialRemoteCmd() *cli.Command {
 		Usage: "Accept connections on a given local listener and dials to a given tunnel",
 		Description: `When started, this process will start a local-listener, for each new
 connection made, it will then dial to a tunnel on the given hub.`,
-		Flags: []cli.Flag{
+		Flags: append(creds.flags(),
 			&cli.StringFlag{
 				Name:        "bind",
 				Usage:       "Local address to listen for incoming connections",
@@ -143,14 +157,6 @@ connection made, it will then dial to a tunnel on the given hub.`,
 				Destination: &port,
 				Required:    true,
 			},
-			&cli.StringFlag{
-				Name:        "token",
-				Usage:       "Token to authenticate",
-				EnvVars:     []string{"AUTH_HUB_TOKEN"},
-				Hidden:      true,
-				Required:    true,
-				Destination: &token,
-			},
 			&cli.StringFlag{
 				Name:        "tunnel-id",
 				Usage:       "ID of the tunnel",
@@ -164,13 +170,17 @@ connection made, it will then dial to a tunnel on the given hub.`,
 				Value:       hub,
 				Destination: &hub,
 			},
-		},
+		),
 		Action: func(ctx *cli.Context) error {
+			tokens, err := creds.source()
+			if err != nil {
+				return err
+			}
 			lst, err := net.Listen("tcp", fmt.Sprintf("%v:%v", addr, port))
 			if err != nil {
 				return err
 			}
-			return proxy.LocalToRemote(ctx.Context, lst, hub, token, tunnelID)
+			return proxy.LocalToRemote(ctx.Context, lst, hub, tokens, tunnelID)
 		},
 	}
 }
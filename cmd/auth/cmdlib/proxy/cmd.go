@@ -1,6 +1,10 @@
 package proxy
 
 import (
+	"errors"
+	"net/http"
+
+	"github.com/andrebq/auth/client"
 	"github.com/andrebq/auth/internal/httpserver"
 	"github.com/andrebq/auth/proxy"
 	"github.com/urfave/cli/v2"
@@ -12,9 +16,43 @@ func Cmd() *cli.Command {
 	var bind string = "localhost"
 	var port uint = 18002
 	var internetFacing bool
+	var localVerification bool
+	revocationInterval := client.DefaultRevocationInterval
+	cache := client.DefaultValidationCache
+	var cacheEnabled bool
+	var forwardAuth bool
+	identity := proxy.DefaultIdentityHeaders
+	var identityJWT bool
+	var identityKeyFile string
+	var rulesFile, routesFile, cookieDomain string
+	jwtCfg := proxy.IdentityJWT{Header: proxy.DefaultIdentityHeader, TTL: proxy.DefaultIdentityTTL}
 	return &cli.Command{
 		Name:  "proxy",
 		Usage: "Proxy requets to enforce authentication via cookies",
+		Description: `With --forward-auth no request is proxied, instead /forward-auth answers the
+authentication subrequests of another reverse proxy, eg.: for nginx
+
+	location /.auth/ { proxy_pass http://127.0.0.1:18002; }
+	location = /_auth {
+		internal;
+		proxy_pass http://127.0.0.1:18002/forward-auth?status=401;
+		proxy_pass_request_body off;
+		proxy_set_header Content-Length "";
+		proxy_set_header X-Forwarded-Host $host;
+		proxy_set_header X-Forwarded-Proto $scheme;
+		proxy_set_header X-Original-URI $request_uri;
+	}
+	location / {
+		auth_request /_auth;
+		auth_request_set $auth_user $upstream_http_x_auth_user;
+		auth_request_set $auth_login $upstream_http_location;
+		proxy_set_header X-Auth-User $auth_user;
+		error_page 401 =302 $auth_login;
+		proxy_pass http://upstream;
+	}
+
+Traefik (forwardAuth) and Caddy (forward_auth) send the X-Forwarded-* headers
+on their own and follow the redirect to the login page.`,
 		Flags: []cli.Flag{
 			&cli.BoolFlag{
 				Name:        "internet-facing",
@@ -38,7 +76,86 @@ func Cmd() *cli.Command {
 				Name:        "upstream",
 				Usage:       "URL base where requests will be proxied to",
 				Destination: &upstream,
-				Required:    true,
+			},
+			&cli.StringFlag{
+				Name:        "routes",
+				Usage:       "YAML or JSON file routing requests to many upstreams by host and path prefix, replaces --upstream",
+				Destination: &routesFile,
+				EnvVars:     []string{"AUTH_PROXY_ROUTES"},
+			},
+			&cli.StringFlag{
+				Name:        "cookie-domain",
+				Usage:       "Parent domain (eg.: example.com) which shares the session cookie, so one login is valid for every subdomain",
+				Destination: &cookieDomain,
+				EnvVars:     []string{"AUTH_PROXY_COOKIE_DOMAIN"},
+			},
+			&cli.BoolFlag{
+				Name:        "forward-auth",
+				Usage:       "Serve /forward-auth for nginx, Traefik or Caddy instead of proxying requests to upstream",
+				Destination: &forwardAuth,
+			},
+			&cli.StringFlag{
+				Name:        "rules",
+				Usage:       "YAML or JSON file with per path rules: public paths, required groups and paths accepting bearer tokens",
+				Destination: &rulesFile,
+				EnvVars:     []string{"AUTH_PROXY_RULES"},
+			},
+			&cli.StringFlag{
+				Name:        "user-header",
+				Usage:       "Header which tells upstream the login of the user, empty to disable",
+				Destination: &identity.User,
+				Value:       identity.User,
+			},
+			&cli.StringFlag{
+				Name:        "uid-header",
+				Usage:       "Header which tells upstream the uid of the user, empty to disable",
+				Destination: &identity.UID,
+				Value:       identity.UID,
+			},
+			&cli.StringFlag{
+				Name:        "token-type-header",
+				Usage:       "Header which tells upstream the type of the token used by the user, empty to disable",
+				Destination: &identity.TokenType,
+				Value:       identity.TokenType,
+			},
+			&cli.StringFlag{
+				Name:        "groups-header",
+				Usage:       "Header which tells upstream the groups of the user separated by commas, empty to disable",
+				Destination: &identity.Groups,
+				Value:       identity.Groups,
+			},
+			&cli.BoolFlag{
+				Name:        "identity-jwt",
+				Usage:       "Also send the identity as a short-lived signed JWT, upstream can verify it with the keys at /.auth/jwks",
+				Destination: &identityJWT,
+			},
+			&cli.StringFlag{
+				Name:        "identity-jwt-header",
+				Usage:       "Header which carries the identity JWT",
+				Destination: &jwtCfg.Header,
+				Value:       jwtCfg.Header,
+			},
+			&cli.StringFlag{
+				Name:        "identity-jwt-key",
+				Usage:       "PEM file with the PKCS #8 key (Ed25519 or RSA) which signs identity tokens, a new key is generated on every start when empty",
+				Destination: &identityKeyFile,
+				EnvVars:     []string{"AUTH_PROXY_IDENTITY_KEY"},
+			},
+			&cli.DurationFlag{
+				Name:        "identity-jwt-ttl",
+				Usage:       "How long identity tokens are valid",
+				Destination: &jwtCfg.TTL,
+				Value:       jwtCfg.TTL,
+			},
+			&cli.StringFlag{
+				Name:        "identity-jwt-issuer",
+				Usage:       "Value of the iss claim of identity tokens",
+				Destination: &jwtCfg.Issuer,
+			},
+			&cli.StringFlag{
+				Name:        "identity-jwt-audience",
+				Usage:       "Value of the aud claim of identity tokens",
+				Destination: &jwtCfg.Audience,
 			},
 			&cli.StringFlag{
 				Name:        "auth-endpoint",
@@ -46,9 +163,98 @@ func Cmd() *cli.Command {
 				Destination: &authEndpoint,
 				Value:       authEndpoint,
 			},
+			&cli.BoolFlag{
+				Name:        "local-verification",
+				Usage:       "Verify signed session tokens with the keys published by the auth api instead of calling it for every request",
+				Destination: &localVerification,
+			},
+			&cli.DurationFlag{
+				Name:        "revocation-interval",
+				Usage:       "How often revoked tokens are fetched when local-verification is enabled",
+				Destination: &revocationInterval,
+				Value:       revocationInterval,
+			},
+			&cli.BoolFlag{
+				Name:        "validation-cache",
+				Usage:       "Cache token validations so every request does not call the auth api",
+				Destination: &cacheEnabled,
+				EnvVars:     []string{"AUTH_PROXY_VALIDATION_CACHE"},
+			},
+			&cli.IntFlag{
+				Name:        "validation-cache-size",
+				Usage:       "Maximum number of tokens kept by the validation cache",
+				Destination: &cache.Size,
+				Value:       cache.Size,
+			},
+			&cli.DurationFlag{
+				Name:        "validation-cache-ttl",
+				Usage:       "How long a valid token is cached, capped by the token expiration",
+				Destination: &cache.TTL,
+				Value:       cache.TTL,
+			},
+			&cli.DurationFlag{
+				Name:        "validation-cache-negative-ttl",
+				Usage:       "How long a rejected token is cached, 0 disables negative caching",
+				Destination: &cache.NegativeTTL,
+				Value:       cache.NegativeTTL,
+			},
+			&cli.DurationFlag{
+				Name:        "validation-cache-poll",
+				Usage:       "How often revoked tokens are evicted from the validation cache",
+				Destination: &cache.PollInterval,
+				Value:       cache.PollInterval,
+			},
 		},
 		Action: func(ctx *cli.Context) error {
-			handler, err := proxy.Handler(upstream, authEndpoint)
+			var clientOpts []client.Option
+			if cacheEnabled {
+				clientOpts = append(clientOpts, client.WithValidationCache(cache))
+			}
+			authcli := client.New(authEndpoint, clientOpts...)
+			opts := []proxy.Option{proxy.WithValidator(authcli)}
+			if localVerification {
+				opts = []proxy.Option{proxy.WithValidator(authcli.Verifier(revocationInterval))}
+			}
+			opts = append(opts, proxy.WithIdentityHeaders(identity))
+			if rulesFile != "" {
+				rules, err := proxy.LoadRules(rulesFile)
+				if err != nil {
+					return err
+				}
+				opts = append(opts, proxy.WithRules(rules))
+			}
+			if cookieDomain != "" {
+				opts = append(opts, proxy.WithCookieDomain(cookieDomain))
+			}
+			if identityJWT {
+				var err error
+				if jwtCfg.Key, err = proxy.LoadIdentityKey(identityKeyFile); err != nil {
+					return err
+				}
+				opts = append(opts, proxy.WithIdentityJWT(jwtCfg))
+			}
+			var handler http.Handler
+			var err error
+			switch {
+			case forwardAuth:
+				handler, err = proxy.ForwardAuthHandler(authEndpoint, opts...)
+			case routesFile != "":
+				if upstream != "" {
+					return errors.New("--upstream and --routes cannot be used together")
+				}
+				routes, err := proxy.LoadRoutes(routesFile)
+				if err != nil {
+					return err
+				}
+				handler, err = proxy.RoutesHandler(routes.Routes, authEndpoint, opts...)
+				if err != nil {
+					return err
+				}
+			case upstream == "":
+				return errors.New("--upstream or --routes is required unless --forward-auth is used")
+			default:
+				handler, err = proxy.Handler(upstream, authEndpoint, opts...)
+			}
 			if err != nil {
 				return err
 			}
@@ -0,0 +1,131 @@
+package serve
+
+import (
+	"errors"
+
+	"github.com/andrebq/auth"
+	"github.com/andrebq/auth/internal/httpserver"
+	"github.com/andrebq/auth/jwt"
+	"github.com/andrebq/auth/oidc"
+	"github.com/urfave/cli/v2"
+)
+
+func serveOIDCCmd(st *auth.Store) *cli.Command {
+	port := uint(18003)
+	addr := "127.0.0.1"
+	var internetFacing bool
+	var issuer, kekFile, kekValue string
+	alg := jwt.RS256
+	rotation := auth.DefaultKeyRotation
+	accessTTL := oidc.DefaultAccessTokenTTL
+	idTTL := oidc.DefaultIDTokenTTL
+	sessionTTL := oidc.DefaultSessionTTL
+	return &cli.Command{
+		Name:  "oidc",
+		Usage: "Serve an OpenID Connect provider, so applications can use auth users through the authorization code flow",
+		Description: `Clients are managed with 'auth ctl oidc client'.
+
+Signing keys are stored in the database sealed by the key-encryption-key,
+every server sharing the database must use the same kek.`,
+		Flags: []cli.Flag{
+			&cli.UintFlag{
+				Name:        "port",
+				Usage:       "Port to bind and listen for incoming connections",
+				Destination: &port,
+				EnvVars:     []string{"AUTH_SERVE_OIDC_PORT"},
+				Value:       port,
+			},
+			&cli.StringFlag{
+				Name:        "bind",
+				Usage:       "Address to bind and listen for incoming connections",
+				Destination: &addr,
+				EnvVars:     []string{"AUTH_SERVE_OIDC_ADDR"},
+				Value:       addr,
+			},
+			&cli.BoolFlag{
+				Name:        "internet-facing",
+				Usage:       "Indicates if this server is directly facing the public internet and should be protected as such",
+				Destination: &internetFacing,
+				Value:       internetFacing,
+			},
+			&cli.StringFlag{
+				Name:        "issuer",
+				Usage:       "Public URL of this server, used as the iss claim and as prefix of every endpoint",
+				Destination: &issuer,
+				EnvVars:     []string{"AUTH_OIDC_ISSUER"},
+				Required:    true,
+			},
+			&cli.StringFlag{
+				Name:        "kek-file",
+				Usage:       "File with the key-encryption-key which seals the signing keys",
+				EnvVars:     []string{"AUTH_KEK_FILE"},
+				Destination: &kekFile,
+			},
+			&cli.StringFlag{
+				Name:        "kek",
+				Usage:       "The key-encryption-key encoded as base64, used when kek-file is empty",
+				EnvVars:     []string{"AUTH_KEK"},
+				Destination: &kekValue,
+			},
+			&cli.StringFlag{
+				Name:        "signing-alg",
+				Usage:       "Algorithm used to sign id tokens: RS256 or EdDSA",
+				Destination: &alg,
+				EnvVars:     []string{"AUTH_OIDC_SIGNING_ALG"},
+				Value:       alg,
+			},
+			&cli.DurationFlag{
+				Name:        "key-rotation",
+				Usage:       "How long a signing key is used before a new one is created",
+				Destination: &rotation,
+				Value:       rotation,
+			},
+			&cli.DurationFlag{
+				Name:        "access-token-ttl",
+				Usage:       "How long access tokens are valid",
+				Destination: &accessTTL,
+				Value:       accessTTL,
+			},
+			&cli.DurationFlag{
+				Name:        "id-token-ttl",
+				Usage:       "How long id tokens are valid, must be shorter than key-rotation",
+				Destination: &idTTL,
+				Value:       idTTL,
+			},
+			&cli.DurationFlag{
+				Name:        "session-ttl",
+				Usage:       "How long users stay logged in to the provider",
+				Destination: &sessionTTL,
+				Value:       sessionTTL,
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			if idTTL >= rotation {
+				return errors.New("id-token-ttl must be shorter than key-rotation")
+			}
+			kek, err := loadKEK(kekFile, kekValue)
+			if err != nil {
+				return err
+			}
+			keys := auth.NewSigningKeys(*st, auth.NewKeyring(*st, kek), auth.KeyPurposeOIDC, alg, rotation)
+			handler, err := oidc.Handler(*st, keys, issuer,
+				oidc.WithAccessTokenTTL(accessTTL),
+				oidc.WithIDTokenTTL(idTTL),
+				oidc.WithSessionTTL(sessionTTL))
+			if err != nil {
+				return err
+			}
+			return httpserver.RunProxy(ctx.Context, internetFacing, addr, port, handler)
+		},
+	}
+}
+
+func loadKEK(file, value string) (auth.KEK, error) {
+	switch {
+	case file != "":
+		return auth.LoadKEKFile(file)
+	case value != "":
+		return auth.ParseKEK(value)
+	}
+	return auth.KEK{}, errors.New("missing key-encryption-key, use --kek-file or AUTH_KEK")
+}
@@ -3,33 +3,41 @@ package auth
 import (
 	"context"
 	"crypto/rand"
-	"crypto/subtle"
 	"database/sql"
 	"errors"
 	"fmt"
 	"io"
 	"path/filepath"
-	"runtime"
 
 	"github.com/google/uuid"
-	"golang.org/x/crypto/argon2"
 
 	"github.com/uptrace/bun/driver/sqliteshim"
 )
 
-func OpenDir(ctx context.Context, dir string) (*sql.DB, error) {
-	db, err := sql.Open(sqliteshim.ShimName, fmt.Sprintf("file:%v?_pragma=foreign_keys(1)", filepath.Join(dir, "users.db")))
+// ErrInvalidCredentials is returned when a password or token does not match
+var ErrInvalidCredentials = errors.New("auth: credentials not found or invalid")
+
+func OpenDir(ctx context.Context, dir string) (*SQLStore, error) {
+	db, err := OpenDirNoMigrate(ctx, dir)
 	if err != nil {
 		return nil, err
 	}
 	err = InitDB(ctx, db)
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
-	return db, nil
+	return NewSQLStore(db), nil
+}
+
+// OpenDirNoMigrate opens the database stored at dir without applying
+// any pending migration, callers should use MigrationStatus
+// to check if the schema is usable
+func OpenDirNoMigrate(ctx context.Context, dir string) (*sql.DB, error) {
+	return sql.Open(sqliteshim.ShimName, fmt.Sprintf("file:%v?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)", filepath.Join(dir, "users.db")))
 }
 
-func OpenMemory(ctx context.Context) (*sql.DB, error) {
+func OpenMemory(ctx context.Context) (*SQLStore, error) {
 	db, err := sql.Open(sqliteshim.ShimName, "file::memory:?cache=shared")
 	if err != nil {
 		return nil, err
@@ -38,81 +46,158 @@ func OpenMemory(ctx context.Context) (*sql.DB, error) {
 	if err != nil {
 		return nil, err
 	}
-	return db, nil
+	return NewSQLStore(db), nil
 }
 
-// InitDB creates the required tables on the given db object
+// InitDB applies all pending migrations on the given db object
 func InitDB(ctx context.Context, db *sql.DB) error {
-	return execCmds(ctx, db, []string{
-		`create table if not exists db_users(
-			uid text not null,
-			login text not null,
-			salt blob not null,
-			passwd blob not null,
-			active integer not null,
-			primary key(uid),
-			unique(login))`,
-		`create table if not exists db_tokens(token_id text not null,
-			token_type text not null,
-			uid text not null,
-			salt blob not null,
-			token blob not null,
-			created_at_unix integer not null,
-			expires_at_unix integer not null,
-			primary key(token_id))`,
-	})
+	_, err := Migrate(ctx, db)
+	return err
 }
 
 // RegisterUser with the given login and password
-func RegisterUser(ctx context.Context, db *sql.DB, login string, passwd []byte) (string, error) {
+func RegisterUser(ctx context.Context, st Store, login string, passwd []byte) (string, error) {
+	if err := CurrentPasswordPolicy().Check(passwd); err != nil {
+		return "", err
+	}
 	uid, err := uuid.NewRandom()
 	if err != nil {
 		return "", err
 	}
-	salt, salted, err := saltPassword(passwd)
+	hash, err := hashPassword(passwd)
+	if err != nil {
+		return "", err
+	}
+	err = st.InsertUser(ctx, UserRecord{
+		UID:        uid.String(),
+		Login:      login,
+		PasswdHash: hash,
+		Active:     true,
+	})
+	if err != nil {
+		return "", err
+	}
+	return uid.String(), nil
+}
+
+// ImportUser registers login using a password hash created by another system,
+// see IsSupportedHash for the accepted formats. The hash is upgraded to argon2id
+// on the first successful login.
+func ImportUser(ctx context.Context, st Store, login string, hash string) (string, error) {
+	if !IsSupportedHash(hash) {
+		return "", fmt.Errorf("%w: unsupported password hash format for user %v", ErrInvalidInput, login)
+	}
+	uid, err := uuid.NewRandom()
 	if err != nil {
 		return "", err
 	}
-	_, err = db.ExecContext(ctx, `insert into db_users(uid, login, salt, passwd, active) values (?, ?, ?, ?, ?)`,
-		uid.String(), login, salt, salted, 1)
+	err = st.InsertUser(ctx, UserRecord{
+		UID:        uid.String(),
+		Login:      login,
+		PasswdHash: hash,
+		Active:     true,
+	})
 	if err != nil {
 		return "", err
 	}
 	return uid.String(), nil
 }
 
-// ReplacePassword of given user
-func ReplacePassword(ctx context.Context, db *sql.DB, login string, newpass []byte) error {
-	var uid string
-	err := db.QueryRowContext(ctx, `select uid from db_users where login = ?`, login).Scan(&uid)
+// ReplacePassword of given user, newpass must satisfy the current PasswordPolicy
+func ReplacePassword(ctx context.Context, st Store, login string, newpass []byte) error {
+	u, err := st.FindUserByLogin(ctx, login)
 	if err != nil {
 		return err
 	}
-	salt, salted, err := saltPassword(newpass)
+	return setPassword(ctx, st, u, newpass)
+}
+
+// ChangePassword replaces the password of uid after checking oldpass,
+// every token of the user except keepTokenID is revoked
+func ChangePassword(ctx context.Context, st Store, uid string, oldpass, newpass []byte, keepTokenID string) error {
+	u, err := st.FindUserByUID(ctx, uid)
 	if err != nil {
 		return err
 	}
-	_, err = db.ExecContext(ctx, `update db_users set passwd = ?, salt = ? where uid = ?`, salted, salt, uid)
-	return err
+	if !u.Active {
+		return ErrNotFound
+	}
+	ok, _, err := verifyUserPassword(u, oldpass)
+	if err != nil {
+		return err
+	} else if !ok {
+		return ErrInvalidCredentials
+	}
+	if err := setPassword(ctx, st, u, newpass); err != nil {
+		return err
+	}
+	return st.ExpireUserTokens(ctx, uid, keepTokenID)
+}
+
+func setPassword(ctx context.Context, st Store, u UserRecord, newpass []byte) error {
+	if err := checkNewPassword(ctx, st, u, newpass); err != nil {
+		return err
+	}
+	return savePassword(ctx, st, u, newpass)
+}
+
+// checkNewPassword applies the password policy, including the history of u
+func checkNewPassword(ctx context.Context, st Store, u UserRecord, newpass []byte) error {
+	policy := CurrentPasswordPolicy()
+	if err := policy.Check(newpass); err != nil {
+		return err
+	}
+	return policy.checkHistory(ctx, st, u, newpass)
+}
+
+// savePassword stores newpass without checking the password policy
+func savePassword(ctx context.Context, st Store, u UserRecord, newpass []byte) error {
+	hash, err := hashPassword(newpass)
+	if err != nil {
+		return err
+	}
+	return st.UpdatePassword(ctx, u.UID, hash)
 }
 
 // Login user
-func Login(ctx context.Context, db *sql.DB, login string, plainPass []byte) (string, error) {
-	var uid string
-	var salted []byte
-	var salt []byte
-	err := db.QueryRowContext(ctx, `select uid, salt, passwd from db_users where login = ? and active = 1`, login).Scan(&uid, &salt, &salted)
+func Login(ctx context.Context, st Store, login string, plainPass []byte) (string, error) {
+	u, err := lookupActiveLogin(ctx, st, login)
+	if err != nil {
+		return "", err
+	}
+	ok, rehash, err := verifyUserPassword(u, plainPass)
 	if err != nil {
 		return "", err
 	}
-	if !validatePasswd(salt, salted, plainPass) {
-		return "", errors.New("auth: credentials not found or invalid")
+	if !ok {
+		return "", ErrInvalidCredentials
 	}
-	return uid, nil
+	if rehash {
+		// upgrading the hash is best effort, the user provided valid credentials
+		// and should be able to login even if the upgrade fails
+		if hash, err := hashPassword(plainPass); err == nil {
+			st.UpdatePassword(ctx, u.UID, hash)
+		}
+	}
+	return u.UID, nil
 }
 
-func lookupActiveLogin(ctx context.Context, uid *string, db *sql.DB, login string) error {
-	return db.QueryRowContext(ctx, `select uid from db_users where login = ? and active = 1`, login).Scan(uid)
+func verifyUserPassword(u UserRecord, plain []byte) (bool, bool, error) {
+	if u.PasswdHash == "" {
+		return validateLegacyPasswd(u.Salt, u.Passwd, plain), true, nil
+	}
+	return verifyPassword(u.PasswdHash, plain)
+}
+
+func lookupActiveLogin(ctx context.Context, st Store, login string) (UserRecord, error) {
+	u, err := st.FindUserByLogin(ctx, login)
+	if err != nil {
+		return UserRecord{}, err
+	}
+	if !u.Active {
+		return UserRecord{}, ErrNotFound
+	}
+	return u, nil
 }
 
 func randomSalt(sz int) ([]byte, error) {
@@ -124,19 +209,6 @@ func randomSalt(sz int) ([]byte, error) {
 	return buf[:], nil
 }
 
-func saltPassword(plain []byte) ([]byte, []byte, error) {
-	salt, err := randomSalt(8)
-	if err != nil {
-		return nil, nil, err
-	}
-	return salt, argon2.IDKey(plain, salt, 2, 32*1024, uint8(runtime.NumCPU()), 16), nil
-}
-
-func validatePasswd(salt, salted, plain []byte) bool {
-	key := argon2.IDKey(plain, salt, 2, 32*1024, uint8(runtime.NumCPU()), 16)
-	return subtle.ConstantTimeCompare(key, salted) == 1
-}
-
 func execCmds(ctx context.Context, db *sql.DB, cmds []string) error {
 	for _, c := range cmds {
 		_, err := db.ExecContext(ctx, c)
@@ -2,45 +2,87 @@ package auth_test
 
 import (
 	"context"
+	"errors"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/andrebq/auth"
 )
 
 func TestLogin(t *testing.T) {
+	eachStore(t, func(t *testing.T, db auth.Store) {
+		ctx := context.Background()
+		login := "bob"
+		passwd := []byte("super-secure")
+
+		uid, err := auth.RegisterUser(ctx, db, login, passwd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		authUID, err := auth.Login(ctx, db, login, passwd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(uid, authUID) {
+			t.Fatalf("udi and authUID do not match: %v != %v", uid, authUID)
+		}
+		passwd = []byte("extra-secure")
+		if err := auth.ReplacePassword(ctx, db, login, passwd); err != nil {
+			t.Fatal(err)
+		}
+		authUID, err = auth.Login(ctx, db, login, passwd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(uid, authUID) {
+			t.Fatalf("udi and authUID do not match: %v != %v", uid, authUID)
+		}
+	})
+}
+
+func TestInitDB(t *testing.T) {
 	ctx := context.Background()
 	db, err := auth.OpenMemory(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer db.Close()
-	if err = auth.InitDB(ctx, db); err != nil {
+	if err = auth.InitDB(ctx, db.DB()); err != nil {
 		t.Fatal(err)
 	}
-	login := "bob"
-	passwd := []byte("super-secure")
+}
 
-	uid, err := auth.RegisterUser(ctx, db, login, passwd)
-	if err != nil {
-		t.Fatal(err)
-	}
-	authUID, err := auth.Login(ctx, db, login, passwd)
-	if err != nil {
-		t.Fatal(err)
-	}
-	if !reflect.DeepEqual(uid, authUID) {
-		t.Fatalf("udi and authUID do not match: %v != %v", uid, authUID)
-	}
-	passwd = []byte("extra-secure")
-	if err := auth.ReplacePassword(ctx, db, login, passwd); err != nil {
-		t.Fatal(err)
-	}
-	authUID, err = auth.Login(ctx, db, login, passwd)
-	if err != nil {
-		t.Fatal(err)
-	}
-	if !reflect.DeepEqual(uid, authUID) {
-		t.Fatalf("udi and authUID do not match: %v != %v", uid, authUID)
-	}
+func TestChangePassword(t *testing.T) {
+	eachStore(t, func(t *testing.T, db auth.Store) {
+		ctx := context.Background()
+		uid, err := auth.RegisterUser(ctx, db, "bob", []byte("old-password"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var tokens []string
+		for i := 0; i < 2; i++ {
+			token, err := auth.CreateToken(ctx, db, "bob", auth.TokenTypeSession, time.Now().Add(time.Minute))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tokens = append(tokens, token)
+		}
+		current, _ := auth.ExtractTokenID(tokens[0])
+		if err := auth.ChangePassword(ctx, db, uid, []byte("wrong"), []byte("new-password"), current); !errors.Is(err, auth.ErrInvalidCredentials) {
+			t.Fatalf("Wrong old password should return ErrInvalidCredentials got %v", err)
+		}
+		if err := auth.ChangePassword(ctx, db, uid, []byte("old-password"), []byte("new-password"), current); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := auth.Login(ctx, db, "bob", []byte("new-password")); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := auth.TokenLogin(ctx, db, tokens[0]); err != nil {
+			t.Fatalf("Current session should be kept got %v", err)
+		}
+		if _, _, err := auth.TokenLogin(ctx, db, tokens[1]); err == nil {
+			t.Fatal("Other sessions should be revoked")
+		}
+	})
 }
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type (
+	// DBConfig selects and tunes the database used by auth
+	DBConfig struct {
+		// Dir is the directory where the SQLite database is stored,
+		// it is ignored when DSN is set
+		Dir string
+		// DSN selects a PostgreSQL database (postgres://...)
+		DSN string
+
+		MaxOpenConns    int
+		MaxIdleConns    int
+		ConnMaxLifetime time.Duration
+		ConnMaxIdleTime time.Duration
+	}
+
+	dialect int
+)
+
+const (
+	dialectSQLite dialect = iota
+	dialectPostgres
+)
+
+// Open the database selected by cfg and apply all pending migrations
+func Open(ctx context.Context, cfg DBConfig) (*SQLStore, error) {
+	db, err := OpenNoMigrate(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	err = InitDB(ctx, db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return NewSQLStore(db), nil
+}
+
+// OpenNoMigrate opens the database selected by cfg without applying
+// any pending migration, see OpenDirNoMigrate
+func OpenNoMigrate(ctx context.Context, cfg DBConfig) (*sql.DB, error) {
+	var db *sql.DB
+	var err error
+	switch {
+	case cfg.DSN == "":
+		db, err = OpenDirNoMigrate(ctx, cfg.Dir)
+	case strings.HasPrefix(cfg.DSN, "postgres://"), strings.HasPrefix(cfg.DSN, "postgresql://"):
+		db, err = sql.Open("postgres", cfg.DSN)
+	default:
+		return nil, errors.New("auth: unsupported database dsn, only postgres:// is supported")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cfg.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+	if cfg.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	if cfg.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	}
+	if cfg.ConnMaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+	}
+	return db, nil
+}
+
+// String returns a description of the database which is safe to log
+func (c DBConfig) String() string {
+	if c.DSN == "" {
+		return fmt.Sprintf("sqlite:%v", filepath.Join(c.Dir, "users.db"))
+	}
+	return "postgres"
+}
+
+func dialectOf(db *sql.DB) dialect {
+	if _, ok := db.Driver().(*pq.Driver); ok {
+		return dialectPostgres
+	}
+	return dialectSQLite
+}
+
+func (d dialect) migrationsDir() string {
+	if d == dialectPostgres {
+		return "migrations/postgres"
+	}
+	return "migrations/sqlite"
+}
+
+// rebind replaces the '?' placeholders used across auth with the
+// positional placeholders used by the dialect
+func (d dialect) rebind(query string) string {
+	if d != dialectPostgres {
+		return query
+	}
+	var sb strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			sb.WriteByte('$')
+			sb.WriteString(strconv.Itoa(n))
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// conflict replaces unique constraint violations with ErrConflict,
+// other errors are returned unchanged
+func (d dialect) conflict(err error) error {
+	if err == nil {
+		return nil
+	}
+	if d == dialectPostgres {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return ErrConflict
+		}
+		return err
+	}
+	// matched by message as the SQLite driver depends on the build
+	if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+		return ErrConflict
+	}
+	return err
+}
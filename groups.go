@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// group names are forwarded to upstream applications as a comma
+// separated header, so they are restricted to a safe subset of ascii
+var groupNameRE = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{0,63}$`)
+
+// CreateGroup returns ErrConflict if the group already exists
+func CreateGroup(ctx context.Context, st Store, name string) error {
+	if !groupNameRE.MatchString(name) {
+		return fmt.Errorf("%w: group name %q must use letters, digits, ., - and _", ErrInvalidInput, name)
+	}
+	return st.CreateGroup(ctx, name)
+}
+
+// DeleteGroup removes the group, its members are not affected
+func DeleteGroup(ctx context.Context, st Store, name string) error {
+	return st.DeleteGroup(ctx, name)
+}
+
+// ListGroups returns all group names in order
+func ListGroups(ctx context.Context, st Store) ([]string, error) {
+	return st.ListGroups(ctx)
+}
+
+// AddToGroup makes login a member of group
+func AddToGroup(ctx context.Context, st Store, group, login string) error {
+	u, err := st.FindUserByLogin(ctx, login)
+	if err != nil {
+		return err
+	}
+	return st.AddGroupMember(ctx, group, u.UID)
+}
+
+// RemoveFromGroup removes login from group
+func RemoveFromGroup(ctx context.Context, st Store, group, login string) error {
+	u, err := st.FindUserByLogin(ctx, login)
+	if err != nil {
+		return err
+	}
+	return st.RemoveGroupMember(ctx, group, u.UID)
+}
+
+// GroupMembers returns the members of group ordered by login
+func GroupMembers(ctx context.Context, st Store, group string) ([]User, error) {
+	uids, err := st.GroupMembers(ctx, group)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]User, 0, len(uids))
+	for _, uid := range uids {
+		u, err := st.FindUserByUID(ctx, uid)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, userFromRecord(u))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Login < out[j].Login })
+	return out, nil
+}
@@ -0,0 +1,262 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+)
+
+type (
+	// HashParams configures argon2id when hashing new passwords and tokens,
+	// the parameters are stored alongside each hash so changing them does not
+	// invalidate existing hashes.
+	HashParams struct {
+		Time      uint32
+		MemoryKiB uint32
+		Threads   uint8
+		SaltLen   uint32
+		KeyLen    uint32
+	}
+)
+
+var (
+	// DefaultHashParams are used unless SetHashParams is called
+	DefaultHashParams = HashParams{
+		Time:      2,
+		MemoryKiB: 32 * 1024,
+		Threads:   4,
+		SaltLen:   16,
+		KeyLen:    32,
+	}
+
+	hashParamsLock sync.RWMutex
+	hashParams     = DefaultHashParams
+	// tokenPeppers has the current pepper first followed by the previous ones
+	tokenPeppers = [][]byte{nil}
+
+	errInvalidHash = errors.New("auth: invalid password hash")
+)
+
+// SetHashParams changes the parameters used to hash new passwords, existing hashes
+// using different parameters are upgraded on the next successful login
+func SetHashParams(p HashParams) error {
+	if p.Time == 0 || p.Threads == 0 || p.MemoryKiB < 8*uint32(p.Threads) {
+		return errors.New("auth: invalid argon2 parameters, time and threads must be positive and memory at least 8KiB per thread")
+	}
+	if p.SaltLen < 8 || p.KeyLen < 16 {
+		return errors.New("auth: invalid argon2 parameters, salt must have at least 8 bytes and key at least 16 bytes")
+	}
+	hashParamsLock.Lock()
+	hashParams = p
+	hashParamsLock.Unlock()
+	return nil
+}
+
+// CurrentHashParams returns the parameters used to hash new passwords
+func CurrentHashParams() HashParams {
+	hashParamsLock.RLock()
+	defer hashParamsLock.RUnlock()
+	return hashParams
+}
+
+// SetTokenPepper changes the server secret mixed into the hash of new tokens.
+//
+// Tokens hashed with one of the previous peppers are still accepted, which
+// allows rotating the pepper: keep the old one as previous until the tokens
+// hashed with it expire. Changing the pepper without passing the old one as
+// previous revokes every existing token.
+func SetTokenPepper(pepper []byte, previous ...[]byte) {
+	peppers := [][]byte{bytes.Clone(pepper)}
+	for _, p := range previous {
+		peppers = append(peppers, bytes.Clone(p))
+	}
+	hashParamsLock.Lock()
+	tokenPeppers = peppers
+	hashParamsLock.Unlock()
+}
+
+// tokenPepperFile holds the pepper generated for SQLite databases
+const tokenPepperFile = "token-pepper"
+
+// InitTokenPepper ensures new tokens are hashed with a pepper.
+//
+// When SetTokenPepper was not given a pepper, SQLite databases use the pepper
+// stored in the token-pepper file next to the database, the file is created
+// with a random pepper on first use. PostgreSQL databases are shared by many
+// servers which must agree on the pepper, so an error is returned instead.
+func InitTokenPepper(cfg DBConfig) error {
+	hashParamsLock.RLock()
+	configured := len(tokenPeppers[0]) > 0
+	hashParamsLock.RUnlock()
+	if configured {
+		return nil
+	}
+	if cfg.DSN != "" {
+		return errors.New("auth: a token pepper is required with PostgreSQL, every server sharing the database must use the same value")
+	}
+	pepper, err := loadTokenPepper(filepath.Join(cfg.Dir, tokenPepperFile))
+	if err != nil {
+		return err
+	}
+	hashParamsLock.Lock()
+	tokenPeppers = append([][]byte{pepper}, tokenPeppers[1:]...)
+	hashParamsLock.Unlock()
+	return nil
+}
+
+func loadTokenPepper(file string) ([]byte, error) {
+	encoded, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		var pepper []byte
+		if pepper, err = randomSalt(32); err != nil {
+			return nil, err
+		}
+		encoded = []byte(base64.RawStdEncoding.EncodeToString(pepper))
+		// O_EXCL makes concurrent processes agree on the first pepper written
+		err = writeNewFile(file, encoded)
+		if errors.Is(err, os.ErrExist) {
+			encoded, err = os.ReadFile(file)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	encoded = bytes.TrimSpace(encoded)
+	if len(encoded) == 0 {
+		return nil, fmt.Errorf("auth: token pepper file %v is empty", file)
+	}
+	return encoded, nil
+}
+
+func writeNewFile(file string, content []byte) error {
+	fd, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := fd.Write(content); err != nil {
+		fd.Close()
+		os.Remove(file)
+		return err
+	}
+	return fd.Close()
+}
+
+// tokenHashPrefix identifies hashes created by hashToken
+const tokenHashPrefix = "$hmac-sha256$"
+
+// hashToken returns the HMAC-SHA256 of plain keyed by the token pepper.
+// Tokens are random secrets with enough entropy to make a slow KDF useless,
+// so they are cheap to verify on every request unlike passwords.
+func hashToken(plain []byte) string {
+	hashParamsLock.RLock()
+	pepper := tokenPeppers[0]
+	hashParamsLock.RUnlock()
+	return tokenHashPrefix + base64.RawStdEncoding.EncodeToString(tokenMAC(pepper, plain))
+}
+
+// verifyTokenHash checks plain against a hash created by hashToken,
+// tokens created before hashToken existed use argon2id, any other
+// format is rejected
+func verifyTokenHash(encoded string, plain []byte) (bool, error) {
+	mac, found := strings.CutPrefix(encoded, tokenHashPrefix)
+	if !found {
+		if !strings.HasPrefix(encoded, "$argon2id$") {
+			return false, errInvalidHash
+		}
+		ok, _, err := verifyPassword(encoded, plain)
+		return ok, err
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(mac)
+	if err != nil {
+		return false, errInvalidHash
+	}
+	hashParamsLock.RLock()
+	peppers := tokenPeppers
+	hashParamsLock.RUnlock()
+	for _, pepper := range peppers {
+		if hmac.Equal(tokenMAC(pepper, plain), expected) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func tokenMAC(pepper, plain []byte) []byte {
+	h := hmac.New(sha256.New, pepper)
+	h.Write(plain)
+	return h.Sum(nil)
+}
+
+// hashPassword returns plain hashed with argon2id encoded in the PHC string format
+// $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<key>
+func hashPassword(plain []byte) (string, error) {
+	p := CurrentHashParams()
+	salt, err := randomSalt(int(p.SaltLen))
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey(plain, salt, p.Time, p.MemoryKiB, p.Threads, p.KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%v$%v", argon2.Version, p.MemoryKiB, p.Time, p.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// verifyPassword checks plain against the encoded hash, rehash indicates that the
+// hash was created with parameters different from CurrentHashParams or by
+// an algorithm other than argon2id
+func verifyPassword(encoded string, plain []byte) (ok bool, rehash bool, err error) {
+	if !strings.HasPrefix(encoded, "$argon2id$") {
+		return verifyLegacy(encoded, plain)
+	}
+	p, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, false, err
+	}
+	actual := argon2.IDKey(plain, salt, p.Time, p.MemoryKiB, p.Threads, p.KeyLen)
+	if subtle.ConstantTimeCompare(actual, key) != 1 {
+		return false, false, nil
+	}
+	return true, p != CurrentHashParams(), nil
+}
+
+func decodeArgon2id(encoded string) (HashParams, []byte, []byte, error) {
+	var p HashParams
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return p, nil, nil, errInvalidHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, errInvalidHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.MemoryKiB, &p.Time, &p.Threads); err != nil {
+		return p, nil, nil, errInvalidHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, errInvalidHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, errInvalidHash
+	}
+	p.SaltLen, p.KeyLen = uint32(len(salt)), uint32(len(key))
+	return p, salt, key, nil
+}
+
+// validateLegacyPasswd checks hashes created before parameters were stored with
+// the hash, those depend on the number of CPUs of the machine which created them
+func validateLegacyPasswd(salt, salted, plain []byte) bool {
+	key := argon2.IDKey(plain, salt, 2, 32*1024, uint8(runtime.NumCPU()), 16)
+	return subtle.ConstantTimeCompare(key, salted) == 1
+}
@@ -0,0 +1,79 @@
+package auth_test
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/andrebq/auth"
+	"golang.org/x/crypto/argon2"
+)
+
+func TestRehashOnLogin(t *testing.T) {
+	eachStore(t, func(t *testing.T, st auth.Store) {
+		ctx := context.Background()
+		t.Cleanup(func() { auth.SetHashParams(auth.DefaultHashParams) })
+
+		if _, err := auth.RegisterUser(ctx, st, "bob", []byte("super-secure")); err != nil {
+			t.Fatal(err)
+		}
+		u, err := st.FindUserByLogin(ctx, "bob")
+		if err != nil {
+			t.Fatal(err)
+		} else if !strings.HasPrefix(u.PasswdHash, "$argon2id$v=19$m=32768,t=2,p=4$") {
+			t.Fatalf("Unexpected hash format %v", u.PasswdHash)
+		}
+
+		upgraded := auth.DefaultHashParams
+		upgraded.Time = 3
+		if err := auth.SetHashParams(upgraded); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := auth.Login(ctx, st, "bob", []byte("super-secure")); err != nil {
+			t.Fatal(err)
+		}
+		if u, err = st.FindUserByLogin(ctx, "bob"); err != nil {
+			t.Fatal(err)
+		} else if !strings.HasPrefix(u.PasswdHash, "$argon2id$v=19$m=32768,t=3,p=4$") {
+			t.Fatalf("Hash should be upgraded on login, got %v", u.PasswdHash)
+		}
+		if _, err := auth.Login(ctx, st, "bob", []byte("super-secure")); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestLegacyHashUpgrade(t *testing.T) {
+	eachStore(t, func(t *testing.T, st auth.Store) {
+		ctx := context.Background()
+		salt := []byte("8-bytes!")
+		err := st.InsertUser(ctx, auth.UserRecord{
+			UID:    "legacy-uid",
+			Login:  "bob",
+			Salt:   salt,
+			Passwd: argon2.IDKey([]byte("old-password"), salt, 2, 32*1024, uint8(runtime.NumCPU()), 16),
+			Active: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := auth.Login(ctx, st, "bob", []byte("wrong-password")); err == nil {
+			t.Fatal("Login should fail with the wrong password")
+		}
+		if uid, err := auth.Login(ctx, st, "bob", []byte("old-password")); err != nil {
+			t.Fatal(err)
+		} else if uid != "legacy-uid" {
+			t.Fatalf("Unexpected uid %v", uid)
+		}
+		u, err := st.FindUserByLogin(ctx, "bob")
+		if err != nil {
+			t.Fatal(err)
+		} else if !strings.HasPrefix(u.PasswdHash, "$argon2id$") || len(u.Passwd) != 0 {
+			t.Fatalf("Legacy hash should be replaced on login, got %#v", u)
+		}
+		if _, err := auth.Login(ctx, st, "bob", []byte("old-password")); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,86 @@
+package e2etests
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andrebq/auth"
+	"github.com/andrebq/auth/cmd/auth/cmdlib"
+)
+
+func TestDBMigrate(t *testing.T) {
+	ctx := context.Background()
+	tmpdir, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err := auth.Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := all[len(all)-1].Version
+
+	output := &bytes.Buffer{}
+	app := cmdlib.NewApp(output, bytes.NewBuffer(nil))
+	if err = app.RunContext(ctx, []string{"auth", "-d", tmpdir, "ctl", "db", "status"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output.String(), "current: 0\n") {
+		t.Fatalf("Fresh database should be at version 0, got %v", output.String())
+	}
+
+	output.Reset()
+	if err = app.RunContext(ctx, []string{"auth", "-d", tmpdir, "ctl", "db", "migrate"}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(output.String(), "applied ") != len(all) {
+		t.Fatalf("All migrations should be applied, got %v", output.String())
+	}
+
+	output.Reset()
+	if err = app.RunContext(ctx, []string{"auth", "-d", tmpdir, "ctl", "db", "status"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output.String(), fmt.Sprintf("current: %v\n", latest)) || strings.Contains(output.String(), "pending") {
+		t.Fatalf("Database should be up to date, got %v", output.String())
+	}
+}
+
+func TestDBBackupRestore(t *testing.T) {
+	ctx := context.Background()
+	srcdir, dstdir := t.TempDir(), t.TempDir()
+	db, err := auth.OpenDir(ctx, srcdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = auth.RegisterUser(ctx, db, "bob", []byte("secure-password")); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	backup := filepath.Join(t.TempDir(), "users.bkp")
+	app := cmdlib.NewApp(io.Discard, bytes.NewBuffer(nil))
+	args := []string{"auth", "-d", srcdir, "ctl", "db", "backup", "--out", backup, "--passphrase", "backup-secret", "--exclude-expired-tokens"}
+	if err = app.RunContext(ctx, args); err != nil {
+		t.Fatal(err)
+	}
+	args = []string{"auth", "-d", dstdir, "ctl", "db", "restore", "--in", backup, "--passphrase", "backup-secret"}
+	if err = app.RunContext(ctx, args); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = auth.OpenDir(ctx, dstdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err = auth.Login(ctx, db, "bob", []byte("secure-password")); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,44 @@
+package e2etests
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/andrebq/auth/cmd/auth/cmdlib"
+)
+
+func TestGroups(t *testing.T) {
+	ctx := context.Background()
+	tmpdir := t.TempDir()
+	run := func(args ...string) string {
+		output := &bytes.Buffer{}
+		app := cmdlib.NewApp(output, strings.NewReader(""))
+		if err := app.RunContext(ctx, append([]string{"auth", "-d", tmpdir, "ctl"}, args...)); err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		return output.String()
+	}
+	run("register", "--login", "bob", "--password", "secret")
+	run("group", "create", "--group", "ops")
+	run("group", "create", "--group", "dev")
+	run("group", "add", "--group", "ops", "--login", "bob")
+	if out := run("group", "list"); out != "dev\nops\n" {
+		t.Fatalf("Unexpected output %q", out)
+	}
+	if out := run("group", "members", "--group", "ops"); !strings.Contains(out, "bob") {
+		t.Fatalf("Unexpected output %q", out)
+	}
+	if out := run("user", "show", "--login", "bob"); !strings.Contains(out, "groups: ops\n") {
+		t.Fatalf("Unexpected output %q", out)
+	}
+	run("group", "remove", "--group", "ops", "--login", "bob")
+	run("group", "delete", "--group", "dev")
+	if out := run("group", "members", "--group", "ops"); strings.Contains(out, "bob") {
+		t.Fatalf("Unexpected output %q", out)
+	}
+	if out := run("group", "list"); out != "ops\n" {
+		t.Fatalf("Unexpected output %q", out)
+	}
+}
@@ -0,0 +1,67 @@
+package e2etests
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andrebq/auth"
+	"github.com/andrebq/auth/cmd/auth/cmdlib"
+)
+
+func TestKeysRotate(t *testing.T) {
+	ctx := context.Background()
+	tmpdir := t.TempDir()
+	oldKEK, newKEK := filepath.Join(tmpdir, "old.kek"), filepath.Join(tmpdir, "new.kek")
+	for _, f := range []string{oldKEK, newKEK} {
+		output := &bytes.Buffer{}
+		app := cmdlib.NewApp(output, bytes.NewBuffer(nil))
+		if err := app.RunContext(ctx, []string{"auth", "-d", tmpdir, "ctl", "keys", "generate"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(f, output.Bytes(), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db, err := auth.OpenDir(ctx, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kek, err := auth.LoadKEKFile(oldKEK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyID, sealed, err := auth.NewKeyring(db, kek).Seal(ctx, []byte("secret"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	output := &bytes.Buffer{}
+	app := cmdlib.NewApp(output, bytes.NewBuffer(nil))
+	args := []string{"auth", "-d", tmpdir, "ctl", "keys", "rotate", "--kek-file", newKEK, "--old-kek-file", oldKEK}
+	if err := app.RunContext(ctx, args); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(output.String(), "re-wrapped 1 data keys") {
+		t.Fatalf("Unexpected output %v", output.String())
+	}
+
+	db, err = auth.OpenDir(ctx, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if kek, err = auth.LoadKEKFile(newKEK); err != nil {
+		t.Fatal(err)
+	}
+	if plain, err := auth.NewKeyring(db, kek).Open(ctx, keyID, sealed, nil); err != nil {
+		t.Fatal(err)
+	} else if string(plain) != "secret" {
+		t.Fatalf("Unexpected value %q", plain)
+	}
+}
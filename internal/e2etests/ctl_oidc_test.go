@@ -0,0 +1,50 @@
+package e2etests
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/andrebq/auth/cmd/auth/cmdlib"
+)
+
+func TestOIDCClients(t *testing.T) {
+	ctx := context.Background()
+	tmpdir := t.TempDir()
+	run := func(args ...string) string {
+		output := &bytes.Buffer{}
+		app := cmdlib.NewApp(output, strings.NewReader(""))
+		if err := app.RunContext(ctx, append([]string{"auth", "-d", tmpdir, "ctl", "oidc", "client"}, args...)); err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		return output.String()
+	}
+	out := run("add", "--name", "wiki", "--redirect-uri", "https://wiki.example.com/cb", "--redirect-uri", "http://localhost/cb")
+	match := regexp.MustCompile(`client_id: (\S+)\nclient_secret: \S+\n`).FindStringSubmatch(out)
+	if match == nil {
+		t.Fatalf("Unexpected output %q", out)
+	}
+	if out := run("add", "--name", "spa", "--redirect-uri", "https://spa.example.com/cb", "--public"); strings.Contains(out, "client_secret") {
+		t.Fatalf("Public clients should not have a secret %q", out)
+	}
+	output := &bytes.Buffer{}
+	if err := cmdlib.NewApp(output, strings.NewReader("")).RunContext(ctx, []string{"auth", "-d", tmpdir, "ctl", "register", "--login", "backup-bot", "--password", "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	if out := run("add", "--name", "backup", "--login", "backup-bot"); !strings.Contains(out, "client_secret") {
+		t.Fatalf("Unexpected output %q", out)
+	}
+	out = run("list")
+	if !strings.Contains(out, match[1]) || !strings.Contains(out, "https://wiki.example.com/cb,http://localhost/cb") || !strings.Contains(out, "public") {
+		t.Fatalf("Unexpected output %q", out)
+	}
+	if !strings.Contains(out, "client_credentials") {
+		t.Fatalf("Service client should list the client_credentials grant %q", out)
+	}
+	run("delete", "--client-id", match[1])
+	if out := run("list"); strings.Contains(out, "wiki") {
+		t.Fatalf("Unexpected output %q", out)
+	}
+}
@@ -2,6 +2,7 @@ package e2etests
 
 import (
 	"context"
+	"errors"
 	"io"
 	"os"
 	"strings"
@@ -35,3 +36,16 @@ func TestRegister(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestRegisterPasswordPolicy(t *testing.T) {
+	tmpdir := t.TempDir()
+	ctx := context.Background()
+	for _, input := range []string{"", "short\n"} {
+		app := cmdlib.NewApp(io.Discard, strings.NewReader(input))
+		args := []string{"auth", "-d", tmpdir, "--password-min-length", "8", "ctl", "register", "--login", "bob"}
+		var perr *auth.PolicyError
+		if err := app.RunContext(ctx, args); !errors.As(err, &perr) {
+			t.Fatalf("Password %q should be rejected by the policy got %v", input, err)
+		}
+	}
+}
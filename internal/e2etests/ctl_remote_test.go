@@ -0,0 +1,105 @@
+package e2etests
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andrebq/auth"
+	"github.com/andrebq/auth/api"
+	"github.com/andrebq/auth/cmd/auth/cmdlib"
+)
+
+func TestRemoteCtl(t *testing.T) {
+	ctx := context.Background()
+	// a previous test might have left a stricter policy behind
+	if err := auth.SetPasswordPolicy(auth.DefaultPasswordPolicy); err != nil {
+		t.Fatal(err)
+	}
+	// the server and the local runs share the process, and so the pepper
+	t.Setenv("AUTH_TOKEN_PEPPER", "remote-ctl-pepper")
+	auth.SetTokenPepper([]byte("remote-ctl-pepper"))
+	serverDir := t.TempDir()
+	db, err := auth.OpenDir(ctx, serverDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := auth.RegisterUser(ctx, db, "root", []byte("root")); err != nil {
+		t.Fatal(err)
+	}
+	adminToken, err := auth.CreateToken(ctx, db, "root", auth.TokenTypeAdmin, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(api.Handler(db))
+	defer server.Close()
+
+	// the remote runs use an empty data-dir to make sure nothing is written locally
+	remoteDir := t.TempDir()
+	run := func(remote bool, input string, args ...string) (string, error) {
+		output := &bytes.Buffer{}
+		app := cmdlib.NewApp(output, strings.NewReader(input))
+		prefix := []string{"auth", "-d", serverDir, "ctl"}
+		if remote {
+			prefix = []string{"auth", "-d", remoteDir, "ctl", "--endpoint", server.URL, "--token", adminToken}
+		}
+		err := app.RunContext(ctx, append(prefix, args...))
+		return output.String(), err
+	}
+	mustRun := func(remote bool, args ...string) string {
+		out, err := run(remote, "", args...)
+		if err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		return out
+	}
+
+	if _, err := run(true, "secret\n", "register", "--login", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	mustRun(true, "user", "set-attr", "--login", "bob", "email=bob@example.com")
+	mustRun(true, "group", "create", "--group", "ops")
+	mustRun(true, "group", "add", "--group", "ops", "--login", "bob")
+	mustRun(true, "user", "rename", "--login", "bob", "--new-login", "robert")
+	token := strings.TrimSpace(mustRun(true, "token", "register", "--login", "robert", "--token-type", "session", "--ttl", "1m"))
+	if _, _, err := auth.TokenLogin(ctx, db, token); err != nil {
+		t.Fatalf("Token created remotely should be valid: %v", err)
+	}
+	tokenID, err := auth.ExtractTokenID(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustRun(true, "token", "revoke", "--token-id", tokenID)
+	if _, _, err := auth.TokenLogin(ctx, db, token); err == nil {
+		t.Fatal("Token revoked remotely should be rejected")
+	}
+
+	for _, args := range [][]string{
+		{"user", "list"},
+		{"user", "list", "--json"},
+		{"user", "show", "--login", "robert"},
+		{"user", "show", "--login", "robert", "--json"},
+		{"token", "list", "--login", "robert"},
+		{"group", "list"},
+		{"group", "members", "--group", "ops"},
+	} {
+		local, remote := mustRun(false, args...), mustRun(true, args...)
+		if local != remote {
+			t.Errorf("%v: remote output differs from local\nlocal:\n%v\nremote:\n%v", args, local, remote)
+		}
+	}
+
+	if _, err := run(true, "", "db", "status"); err == nil {
+		t.Fatal("db commands should not be available remotely")
+	}
+	if _, err := run(true, "", "--token", "", "user", "list"); err == nil || !strings.Contains(err.Error(), "--token is required") {
+		t.Fatal("Remote mode should require a token")
+	}
+	if _, err := auth.Login(ctx, db, "robert", []byte("secret")); err != nil {
+		t.Fatalf("Password set remotely should be valid: %v", err)
+	}
+}
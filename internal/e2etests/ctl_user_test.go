@@ -0,0 +1,144 @@
+package e2etests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andrebq/auth"
+	"github.com/andrebq/auth/cmd/auth/cmdlib"
+)
+
+func TestUserImport(t *testing.T) {
+	ctx := context.Background()
+	tmpdir := t.TempDir()
+	input := strings.NewReader("# exported from nginx\nbob:$apr1$saltsalt$LrttParrLPdxvgutaSXWJ0\nalice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n")
+	output := &bytes.Buffer{}
+	app := cmdlib.NewApp(output, input)
+	args := []string{"auth", "-d", tmpdir, "ctl", "user", "import", "--format", "htpasswd", "--file", "-"}
+	if err := app.RunContext(ctx, args); err != nil {
+		t.Fatal(err)
+	}
+	if output.String() != "imported 2 users, skipped 0\n" {
+		t.Fatalf("Unexpected output %q", output.String())
+	}
+
+	db, err := auth.OpenDir(ctx, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, login := range []string{"bob", "alice"} {
+		if _, err := auth.Login(ctx, db, login, []byte("secret")); err != nil {
+			t.Fatalf("%v: %v", login, err)
+		}
+	}
+}
+
+func TestUserLifecycle(t *testing.T) {
+	ctx := context.Background()
+	tmpdir := t.TempDir()
+	run := func(args ...string) string {
+		output := &bytes.Buffer{}
+		app := cmdlib.NewApp(output, strings.NewReader(""))
+		if err := app.RunContext(ctx, append([]string{"auth", "-d", tmpdir, "ctl"}, args...)); err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		return output.String()
+	}
+	run("register", "--login", "bob", "--password", "secret")
+	run("user", "rename", "--login", "bob", "--new-login", "robert")
+	run("user", "set-attr", "--login", "robert", "email=robert@example.com", "team=infra")
+	run("user", "set-attr", "--login", "robert", "team=")
+	run("user", "disable", "--login", "robert")
+
+	var users []auth.User
+	if err := json.Unmarshal([]byte(run("user", "list", "--json")), &users); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Login != "robert" || users[0].Active {
+		t.Fatalf("Unexpected users %#v", users)
+	}
+	if out := run("user", "show", "--login", "robert"); out != "login: robert\nuid: "+users[0].UID+"\nstatus: disabled\nattr.email: robert@example.com\n" {
+		t.Fatalf("Unexpected output %q", out)
+	}
+
+	run("user", "enable", "--login", "robert")
+	run("user", "delete", "--login", "robert")
+	if out := run("user", "list"); strings.Contains(out, "robert") {
+		t.Fatalf("User should be deleted %q", out)
+	}
+}
+
+func TestUserResetToken(t *testing.T) {
+	ctx := context.Background()
+	tmpdir := t.TempDir()
+	app := cmdlib.NewApp(io.Discard, strings.NewReader(""))
+	if err := app.RunContext(ctx, []string{"auth", "-d", tmpdir, "ctl", "register", "--login", "bob", "--password", "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	output := &bytes.Buffer{}
+	app = cmdlib.NewApp(output, strings.NewReader(""))
+	args := []string{"auth", "-d", tmpdir, "ctl", "user", "reset-token", "--login", "bob", "--base-url", "https://example.com/"}
+	if err := app.RunContext(ctx, args); err != nil {
+		t.Fatal(err)
+	}
+	link, err := url.Parse(strings.TrimSpace(output.String()))
+	if err != nil {
+		t.Fatal(err)
+	} else if link.Path != "/.auth/reset" {
+		t.Fatalf("Unexpected link %v", link)
+	}
+
+	db, err := auth.OpenDir(ctx, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := auth.ResetPassword(ctx, db, link.Query().Get("token"), []byte("new-secret")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUserInvite(t *testing.T) {
+	ctx := context.Background()
+	tmpdir := t.TempDir()
+	outbox := filepath.Join(tmpdir, "outbox.txt")
+	output := &bytes.Buffer{}
+	app := cmdlib.NewApp(output, strings.NewReader(""))
+	args := []string{"auth", "-d", tmpdir, "ctl", "user", "invite", "--login", "bob", "--email", "bob@example.com",
+		"--base-url", "https://example.com", "--notifier", "file://" + filepath.ToSlash(outbox)}
+	if err := app.RunContext(ctx, args); err != nil {
+		t.Fatal(err)
+	}
+	link, err := url.Parse(strings.TrimSpace(output.String()))
+	if err != nil {
+		t.Fatal(err)
+	} else if link.Path != "/.auth/accept-invite" {
+		t.Fatalf("Unexpected link %v", link)
+	}
+	sent, err := os.ReadFile(outbox)
+	if err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(string(sent), link.String()) {
+		t.Fatalf("Invitation should contain the link %v got %q", link, sent)
+	}
+
+	db, err := auth.OpenDir(ctx, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := auth.AcceptInvite(ctx, db, link.Query().Get("token"), []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.Login(ctx, db, "bob", []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+}
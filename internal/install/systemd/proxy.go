@@ -17,6 +17,8 @@ type (
 		Bind         string
 		AuthEndpoint string
 		Upstream     string
+		Routes       string
+		CookieDomain string
 	}
 )
 
@@ -27,7 +29,7 @@ var (
 Description={{.Description}}
 
 [Service]
-ExecStart={{.Binary}} proxy --upstream {{.Upstream}} --port {{.Port}} --addr {{.Bind}} --auth-endpoint {{.AuthEndpoint}}
+ExecStart={{.Binary}} proxy {{ if .Routes }}--routes {{.Routes}}{{ else }}--upstream {{.Upstream}}{{ end }} --port {{.Port}} --addr {{.Bind}} --auth-endpoint {{.AuthEndpoint}}{{ if .CookieDomain }} --cookie-domain {{.CookieDomain}}{{ end }}
 Restart=always
 
 [Install]
@@ -48,6 +50,8 @@ func (p *ProxyConfig) Flags() []cli.Flag {
 		stringFlag(&p.AuthEndpoint, "auth-endpoint", "Endpoint where auth API is running"),
 		stringFlag(&p.Binary, "binary", "Path to auth binary"),
 		stringFlag(&p.Upstream, "upstream", "Upstream server to proxy requests to"),
+		stringFlag(&p.Routes, "routes", "Routes file used instead of upstream to proxy many applications"),
+		stringFlag(&p.CookieDomain, "cookie-domain", "Parent domain sharing the session cookie between routed applications"),
 		stringFlag(&p.Bind, "bind", "Address where the proxy will listen for requests"),
 		uintFlag(&p.Port, "port", "Port where the proxy will listen for requests"),
 	}
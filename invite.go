@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// InviteUser registers login as an inactive user without a password and returns
+// a single use token which the user redeems with AcceptInvite to choose a password
+func InviteUser(ctx context.Context, st Store, login string, attrs map[string]string, ttl time.Duration) (string, error) {
+	if err := ValidateAttributes(attrs); err != nil {
+		return "", err
+	}
+	if ttl <= 0 {
+		ttl = DefaultInviteTTL
+	}
+	uid, err := uuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+	u := UserRecord{UID: uid.String(), Login: login}
+	if err := st.InsertUser(ctx, u); err != nil {
+		return "", err
+	}
+	if len(attrs) > 0 {
+		if err := st.SetUserAttributes(ctx, u.UID, attrs); err != nil {
+			return "", err
+		}
+	}
+	return createToken(ctx, st, u, TokenRecord{TokenType: TokenTypeInvite, ExpiresAt: time.Now().Add(ttl)})
+}
+
+// AcceptInvite redeems a token created by InviteUser, the user becomes
+// active with passwd as its password
+func AcceptInvite(ctx context.Context, st Store, token string, passwd []byte) (string, error) {
+	uid, tokenType, err := TokenLogin(ctx, st, token)
+	if err != nil {
+		return "", err
+	}
+	if tokenType != TokenTypeInvite {
+		return "", ErrInvalidCredentials
+	}
+	u, err := st.FindUserByUID(ctx, uid)
+	if err != nil {
+		return "", err
+	}
+	// like ResetPassword, consume the token first so it is redeemed at most once
+	if err := checkNewPassword(ctx, st, u, passwd); err != nil {
+		return "", err
+	}
+	if err := consumeToken(ctx, st, token); err != nil {
+		return "", err
+	}
+	if err := savePassword(ctx, st, u, passwd); err != nil {
+		return "", err
+	}
+	if err := st.SetUserActive(ctx, uid, true); err != nil {
+		return "", err
+	}
+	return uid, st.ExpireUserTokens(ctx, uid)
+}
@@ -0,0 +1,56 @@
+package auth_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/andrebq/auth"
+)
+
+func TestInviteUser(t *testing.T) {
+	eachStore(t, func(t *testing.T, st auth.Store) {
+		ctx := context.Background()
+		token, err := auth.InviteUser(ctx, st, "bob", map[string]string{auth.AttrEmail: "bob@example.com"}, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u, err := auth.LookupUser(ctx, st, "bob"); err != nil {
+			t.Fatal(err)
+		} else if u.Active || !u.Invited || u.Attributes[auth.AttrEmail] != "bob@example.com" {
+			t.Fatalf("Unexpected invited user %#v", u)
+		}
+		if _, _, err := auth.TokenLogin(ctx, st, token); err != nil {
+			t.Fatal(err)
+		}
+		if err := auth.ResetPassword(ctx, st, token, []byte("secret")); err == nil {
+			t.Fatal("Invitations should not reset passwords")
+		}
+		uid, err := auth.AcceptInvite(ctx, st, token, []byte("secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual, err := auth.Login(ctx, st, "bob", []byte("secret")); err != nil || actual != uid {
+			t.Fatalf("Login after accepting should return %v got %v, %v", uid, actual, err)
+		}
+		if _, err := auth.AcceptInvite(ctx, st, token, []byte("other")); err == nil {
+			t.Fatal("Invitations should be single use")
+		}
+		if _, err := auth.InviteUser(ctx, st, "bob", nil, time.Minute); err == nil {
+			t.Fatal("Existing users cannot be invited")
+		}
+
+		token, err = auth.InviteUser(ctx, st, "alice", nil, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		accepted := concurrently(4, func(i int) error {
+			_, err := auth.AcceptInvite(ctx, st, token, []byte(fmt.Sprintf("concurrent-%v", i)))
+			return err
+		})
+		if accepted != 1 {
+			t.Fatalf("Invitation should be accepted once got %v", accepted)
+		}
+	})
+}
@@ -0,0 +1,329 @@
+// Package jwt implements the subset of JSON Web Tokens (RFC 7519) and
+// JSON Web Keys (RFC 7517) used by auth: compact tokens signed with
+// RS256 or EdDSA (Ed25519)
+package jwt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+type (
+	// Key is a private key used to sign tokens, ID is published
+	// as the kid header so verifiers can pick the right public key
+	Key struct {
+		ID     string
+		Signer crypto.Signer
+	}
+
+	// JWK is the public part of a Key
+	JWK struct {
+		KeyType   string `json:"kty"`
+		KeyID     string `json:"kid"`
+		Use       string `json:"use,omitempty"`
+		Algorithm string `json:"alg"`
+		// RSA
+		N string `json:"n,omitempty"`
+		E string `json:"e,omitempty"`
+		// OKP (Ed25519)
+		Curve string `json:"crv,omitempty"`
+		X     string `json:"x,omitempty"`
+	}
+
+	// JWKS is a set of public keys, as served by a jwks_uri
+	JWKS struct {
+		Keys []JWK `json:"keys"`
+	}
+
+	// Claims are the registered claims, embed it in a struct to add more claims
+	Claims struct {
+		Issuer    string   `json:"iss,omitempty"`
+		Subject   string   `json:"sub,omitempty"`
+		Audience  Audience `json:"aud,omitempty"`
+		ExpiresAt int64    `json:"exp,omitempty"`
+		NotBefore int64    `json:"nbf,omitempty"`
+		IssuedAt  int64    `json:"iat,omitempty"`
+		ID        string   `json:"jti,omitempty"`
+	}
+
+	// Audience is encoded as a single string when it has only one value
+	Audience []string
+
+	header struct {
+		Algorithm string `json:"alg"`
+		Type      string `json:"typ,omitempty"`
+		KeyID     string `json:"kid,omitempty"`
+	}
+)
+
+const (
+	RS256 = "RS256"
+	EdDSA = "EdDSA"
+
+	// TypeJWT is the typ header of tokens created by Sign
+	TypeJWT = "JWT"
+	// TypeAccessToken is the typ header of access tokens (RFC 9068), it
+	// prevents other tokens signed by the same keys from being used as access tokens
+	TypeAccessToken = "at+jwt"
+)
+
+var (
+	// ErrInvalidToken is returned for malformed tokens and invalid signatures
+	ErrInvalidToken = errors.New("jwt: invalid token")
+	// ErrExpired is returned by Claims.Validate when the token is expired
+	// or not valid yet
+	ErrExpired = errors.New("jwt: token expired")
+
+	enc = base64.RawURLEncoding
+)
+
+// GenerateKey returns a new private key for alg, which is either RS256 or EdDSA
+func GenerateKey(alg string) (crypto.Signer, error) {
+	switch alg {
+	case RS256:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case EdDSA:
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		return priv, err
+	}
+	return nil, fmt.Errorf("jwt: unsupported algorithm %q", alg)
+}
+
+// Algorithm returns the jws algorithm used by k
+func (k Key) Algorithm() (string, error) {
+	switch k.Signer.Public().(type) {
+	case *rsa.PublicKey:
+		return RS256, nil
+	case ed25519.PublicKey:
+		return EdDSA, nil
+	}
+	return "", fmt.Errorf("jwt: unsupported key type %T", k.Signer)
+}
+
+// Public returns the JWK which verifies tokens signed by k
+func (k Key) Public() (JWK, error) {
+	alg, err := k.Algorithm()
+	if err != nil {
+		return JWK{}, err
+	}
+	jwk := JWK{KeyID: k.ID, Use: "sig", Algorithm: alg}
+	switch pub := k.Signer.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = enc.EncodeToString(pub.N.Bytes())
+		jwk.E = enc.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.KeyType, jwk.Curve = "OKP", "Ed25519"
+		jwk.X = enc.EncodeToString(pub)
+	}
+	return jwk, nil
+}
+
+// PublicKey decodes the key material of j
+func (j JWK) PublicKey() (crypto.PublicKey, error) {
+	switch j.KeyType {
+	case "RSA":
+		n, err := enc.DecodeString(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := enc.DecodeString(j.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "OKP":
+		x, err := enc.DecodeString(j.X)
+		if err != nil {
+			return nil, err
+		}
+		if j.Curve != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("jwt: unsupported curve %q", j.Curve)
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("jwt: unsupported key type %q", j.KeyType)
+}
+
+// Find returns the key with the given id
+func (s JWKS) Find(kid string) (JWK, bool) {
+	for _, k := range s.Keys {
+		if k.KeyID == kid {
+			return k, true
+		}
+	}
+	return JWK{}, false
+}
+
+// Sign encodes claims as json and signs it with key
+func Sign(key Key, claims any) (string, error) {
+	return SignType(key, TypeJWT, claims)
+}
+
+// SignType is like Sign but uses typ as the typ header
+func SignType(key Key, typ string, claims any) (string, error) {
+	alg, err := key.Algorithm()
+	if err != nil {
+		return "", err
+	}
+	h, err := json.Marshal(header{Algorithm: alg, Type: typ, KeyID: key.ID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := enc.EncodeToString(h) + "." + enc.EncodeToString(payload)
+	var sig []byte
+	switch alg {
+	case RS256:
+		sum := sha256.Sum256([]byte(signed))
+		sig, err = key.Signer.Sign(rand.Reader, sum[:], crypto.SHA256)
+	case EdDSA:
+		sig, err = key.Signer.Sign(rand.Reader, []byte(signed), crypto.Hash(0))
+	}
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + enc.EncodeToString(sig), nil
+}
+
+// Verify checks the signature of token using the key from keys with the
+// same kid and decodes the payload into claims.
+// Registered claims are not validated, see Claims.Validate.
+func Verify(token string, keys JWKS, claims any) error {
+	return verify(token, keys, "", claims)
+}
+
+// VerifyType is like Verify but also requires the typ header to be typ
+func VerifyType(token string, keys JWKS, typ string, claims any) error {
+	return verify(token, keys, typ, claims)
+}
+
+// KeyID returns the kid header of token without verifying it, verifiers
+// use it to decide whether their key set must be refreshed
+func KeyID(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", ErrInvalidToken
+	}
+	var h header
+	if err := decodePart(parts[0], &h); err != nil {
+		return "", err
+	}
+	return h.KeyID, nil
+}
+
+func verify(token string, keys JWKS, typ string, claims any) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ErrInvalidToken
+	}
+	var h header
+	if err := decodePart(parts[0], &h); err != nil {
+		return err
+	}
+	if typ != "" && !strings.EqualFold(h.Type, typ) {
+		return fmt.Errorf("%w: unexpected type %q", ErrInvalidToken, h.Type)
+	}
+	jwk, found := keys.Find(h.KeyID)
+	if !found || jwk.Algorithm != h.Algorithm {
+		return fmt.Errorf("%w: unknown key %q", ErrInvalidToken, h.KeyID)
+	}
+	pub, err := jwk.PublicKey()
+	if err != nil {
+		return err
+	}
+	sig, err := enc.DecodeString(parts[2])
+	if err != nil {
+		return ErrInvalidToken
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		if h.Algorithm != RS256 {
+			return ErrInvalidToken
+		}
+		sum := sha256.Sum256(signed)
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig) != nil {
+			return ErrInvalidToken
+		}
+	case ed25519.PublicKey:
+		if h.Algorithm != EdDSA || !ed25519.Verify(pub, signed, sig) {
+			return ErrInvalidToken
+		}
+	}
+	return decodePart(parts[1], claims)
+}
+
+// Validate checks the time based claims, and issuer and audience when
+// they are not empty. A small leeway is allowed to compensate clock skew.
+func (c Claims) Validate(now time.Time, issuer, audience string) error {
+	const leeway = 30
+	unix := now.Unix()
+	if c.ExpiresAt != 0 && unix > c.ExpiresAt+leeway {
+		return ErrExpired
+	}
+	if c.NotBefore != 0 && unix+leeway < c.NotBefore {
+		return ErrExpired
+	}
+	if issuer != "" && c.Issuer != issuer {
+		return fmt.Errorf("%w: unexpected issuer %q", ErrInvalidToken, c.Issuer)
+	}
+	if audience != "" && !c.Audience.Contains(audience) {
+		return fmt.Errorf("%w: unexpected audience %v", ErrInvalidToken, c.Audience)
+	}
+	return nil
+}
+
+// Contains reports whether aud is one of the values of a
+func (a Audience) Contains(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+	return false
+}
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a *Audience) UnmarshalJSON(buf []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(buf), []byte(`"`)) {
+		var single string
+		if err := json.Unmarshal(buf, &single); err != nil {
+			return err
+		}
+		*a = Audience{single}
+		return nil
+	}
+	return json.Unmarshal(buf, (*[]string)(a))
+}
+
+func decodePart(part string, out any) error {
+	buf, err := enc.DecodeString(part)
+	if err != nil {
+		return ErrInvalidToken
+	}
+	if err := json.Unmarshal(buf, out); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return nil
+}
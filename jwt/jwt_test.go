@@ -0,0 +1,98 @@
+package jwt_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andrebq/auth/jwt"
+)
+
+func TestSignVerify(t *testing.T) {
+	type claims struct {
+		jwt.Claims
+		Email string `json:"email"`
+	}
+	for _, alg := range []string{jwt.RS256, jwt.EdDSA} {
+		t.Run(alg, func(t *testing.T) {
+			signer, err := jwt.GenerateKey(alg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			key := jwt.Key{ID: "k1", Signer: signer}
+			pub, err := key.Public()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// keys must survive a round trip through the jwks document
+			buf, err := json.Marshal(jwt.JWKS{Keys: []jwt.JWK{pub}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var keys jwt.JWKS
+			if err := json.Unmarshal(buf, &keys); err != nil {
+				t.Fatal(err)
+			}
+
+			now := time.Now()
+			token, err := jwt.Sign(key, claims{
+				Claims: jwt.Claims{Issuer: "https://auth.example.com", Subject: "uid-1", Audience: jwt.Audience{"app"}, ExpiresAt: now.Add(time.Minute).Unix()},
+				Email:  "bob@example.com",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out claims
+			if err := jwt.Verify(token, keys, &out); err != nil {
+				t.Fatal(err)
+			} else if out.Subject != "uid-1" || out.Email != "bob@example.com" {
+				t.Fatalf("Unexpected claims %#v", out)
+			}
+			if err := out.Validate(now, "https://auth.example.com", "app"); err != nil {
+				t.Fatal(err)
+			}
+			if err := out.Validate(now.Add(time.Hour), "", ""); !errors.Is(err, jwt.ErrExpired) {
+				t.Fatalf("Token should be expired got %v", err)
+			}
+			if err := out.Validate(now, "", "other-app"); !errors.Is(err, jwt.ErrInvalidToken) {
+				t.Fatalf("Audience should be checked got %v", err)
+			}
+
+			parts := strings.Split(token, ".")
+			tampered, _ := json.Marshal(claims{Claims: jwt.Claims{Subject: "uid-2"}})
+			forged := parts[0] + "." + base64.RawURLEncoding.EncodeToString(tampered) + "." + parts[2]
+			if err := jwt.Verify(forged, keys, &out); !errors.Is(err, jwt.ErrInvalidToken) {
+				t.Fatalf("Tampered token should be rejected got %v", err)
+			}
+
+			other, err := jwt.GenerateKey(alg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			otherToken, err := jwt.Sign(jwt.Key{ID: "k1", Signer: other}, claims{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := jwt.Verify(otherToken, keys, &out); !errors.Is(err, jwt.ErrInvalidToken) {
+				t.Fatalf("Token signed by another key should be rejected got %v", err)
+			}
+
+			if err := jwt.VerifyType(token, keys, jwt.TypeAccessToken, &out); !errors.Is(err, jwt.ErrInvalidToken) {
+				t.Fatalf("Token type should be checked got %v", err)
+			}
+			accessToken, err := jwt.SignType(key, jwt.TypeAccessToken, claims{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := jwt.VerifyType(accessToken, keys, jwt.TypeAccessToken, &out); err != nil {
+				t.Fatal(err)
+			}
+			if kid, err := jwt.KeyID(accessToken); err != nil || kid != "k1" {
+				t.Fatalf("Unexpected kid %q, %v", kid, err)
+			}
+		})
+	}
+}
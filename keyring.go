@@ -0,0 +1,264 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type (
+	// KEK is a key-encryption-key, it is only used to wrap data keys
+	// and must never be stored in the database
+	KEK struct {
+		ID  string
+		key []byte
+	}
+
+	// Keyring implements envelope encryption: values are encrypted with
+	// data keys stored in the database, which in turn are encrypted (wrapped)
+	// with a KEK loaded from outside the database.
+	//
+	// Callers must persist the key ID returned by Seal next to the
+	// encrypted value.
+	Keyring struct {
+		st      Store
+		primary KEK
+		keks    map[string]KEK
+
+		lock      sync.Mutex
+		unwrapped map[string][]byte
+	}
+)
+
+const kekSize = 32
+
+var (
+	// ErrUnknownKEK is returned when a data key was wrapped by a KEK
+	// which was not given to the Keyring
+	ErrUnknownKEK = errors.New("auth: data key is wrapped by an unknown kek")
+)
+
+// GenerateKEK returns a new random KEK encoded as base64
+func GenerateKEK() (string, error) {
+	key, err := randomSalt(kekSize)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// ParseKEK decodes a base64 encoded KEK
+func ParseKEK(encoded string) (KEK, error) {
+	key, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace([]byte(encoded))))
+	if err != nil {
+		return KEK{}, fmt.Errorf("auth: kek is not valid base64: %w", err)
+	}
+	if len(key) != kekSize {
+		return KEK{}, fmt.Errorf("auth: kek must have %v bytes got %v", kekSize, len(key))
+	}
+	sum := sha256.Sum256(key)
+	return KEK{ID: hex.EncodeToString(sum[:8]), key: key}, nil
+}
+
+// LoadKEKFile reads a base64 encoded KEK from file
+func LoadKEKFile(file string) (KEK, error) {
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return KEK{}, err
+	}
+	return ParseKEK(string(buf))
+}
+
+// NewKeyring returns a keyring which wraps new data keys with primary,
+// the other keks are only used to unwrap existing keys (eg.: during rotation)
+func NewKeyring(st Store, primary KEK, others ...KEK) *Keyring {
+	kr := &Keyring{
+		st:        st,
+		primary:   primary,
+		keks:      map[string]KEK{primary.ID: primary},
+		unwrapped: make(map[string][]byte),
+	}
+	for _, k := range others {
+		kr.keks[k.ID] = k
+	}
+	return kr
+}
+
+// Seal encrypts plain with the active data key, aad is authenticated but
+// not encrypted (eg.: the uid which owns the value)
+func (k *Keyring) Seal(ctx context.Context, plain, aad []byte) (string, []byte, error) {
+	keyID, dek, err := k.activeKey(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	sealed, err := sealWith(dek, plain, aad)
+	return keyID, sealed, err
+}
+
+// Open decrypts a value sealed with the data key keyID
+func (k *Keyring) Open(ctx context.Context, keyID string, sealed, aad []byte) ([]byte, error) {
+	dek, err := k.dataKey(ctx, keyID)
+	if err != nil {
+		return nil, err
+	}
+	return openWith(dek, sealed, aad)
+}
+
+// NewDataKey creates a new data key which will be used by Seal from now on,
+// previous keys are kept to decrypt existing values
+func (k *Keyring) NewDataKey(ctx context.Context) (string, error) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	return k.newDataKey(ctx)
+}
+
+// Rotate re-wraps every data key with the primary KEK, values encrypted with
+// the data keys are not touched so this can be done while servers are running.
+// It returns the number of keys which were re-wrapped.
+func (k *Keyring) Rotate(ctx context.Context) (int, error) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	keys, err := k.st.ListDataKeys(ctx)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, rec := range keys {
+		if rec.KEKID == k.primary.ID {
+			continue
+		}
+		dek, err := k.unwrap(rec)
+		if err != nil {
+			return count, err
+		}
+		wrapped, err := sealWith(k.primary.key, dek, []byte(rec.KeyID))
+		if err != nil {
+			return count, err
+		}
+		if err := k.st.UpdateDataKeyWrap(ctx, rec.KeyID, k.primary.ID, wrapped); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+func (k *Keyring) activeKey(ctx context.Context) (string, []byte, error) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	keys, err := k.st.ListDataKeys(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, rec := range keys {
+		if !rec.Active {
+			continue
+		}
+		dek, err := k.unwrap(rec)
+		return rec.KeyID, dek, err
+	}
+	keyID, err := k.newDataKey(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	return keyID, k.unwrapped[keyID], nil
+}
+
+func (k *Keyring) dataKey(ctx context.Context, keyID string) ([]byte, error) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if dek, ok := k.unwrapped[keyID]; ok {
+		return dek, nil
+	}
+	keys, err := k.st.ListDataKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, rec := range keys {
+		if rec.KeyID == keyID {
+			return k.unwrap(rec)
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// newDataKey must be called with k.lock held
+func (k *Keyring) newDataKey(ctx context.Context) (string, error) {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+	dek, err := randomSalt(32)
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := sealWith(k.primary.key, dek, []byte(id.String()))
+	if err != nil {
+		return "", err
+	}
+	err = k.st.RotateDataKey(ctx, DataKeyRecord{
+		KeyID:     id.String(),
+		KEKID:     k.primary.ID,
+		Wrapped:   wrapped,
+		CreatedAt: time.Now(),
+		Active:    true,
+	})
+	if err != nil {
+		return "", err
+	}
+	k.unwrapped[id.String()] = dek
+	return id.String(), nil
+}
+
+// unwrap must be called with k.lock held
+func (k *Keyring) unwrap(rec DataKeyRecord) ([]byte, error) {
+	if dek, ok := k.unwrapped[rec.KeyID]; ok {
+		return dek, nil
+	}
+	kek, ok := k.keks[rec.KEKID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrUnknownKEK, rec.KEKID)
+	}
+	dek, err := openWith(kek.key, rec.Wrapped, []byte(rec.KeyID))
+	if err != nil {
+		return nil, err
+	}
+	k.unwrapped[rec.KeyID] = dek
+	return dek, nil
+}
+
+func sealWith(key, plain, aad []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomSalt(aead.NonceSize())
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plain, aad), nil
+}
+
+func openWith(key, sealed, aad []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("auth: sealed value is truncated")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], aad)
+	if err != nil {
+		return nil, errors.New("auth: unable to decrypt value")
+	}
+	return plain, nil
+}
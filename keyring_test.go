@@ -0,0 +1,85 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/andrebq/auth"
+)
+
+func TestKeyring(t *testing.T) {
+	eachStore(t, func(t *testing.T, st auth.Store) {
+		ctx := context.Background()
+		oldKEK, newKEK := mustKEK(t), mustKEK(t)
+
+		kr := auth.NewKeyring(st, oldKEK)
+		keyID, sealed, err := kr.Seal(ctx, []byte("totp-secret"), []byte("uid-1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := kr.Open(ctx, keyID, sealed, []byte("uid-2")); err == nil {
+			t.Fatal("Open should fail if aad does not match")
+		}
+
+		count, err := auth.NewKeyring(st, newKEK, oldKEK).Rotate(ctx)
+		if err != nil {
+			t.Fatal(err)
+		} else if count != 1 {
+			t.Fatalf("Should re-wrap 1 key got %v", count)
+		}
+
+		plain, err := auth.NewKeyring(st, newKEK).Open(ctx, keyID, sealed, []byte("uid-1"))
+		if err != nil {
+			t.Fatal(err)
+		} else if string(plain) != "totp-secret" {
+			t.Fatalf("Unexpected plain value %q", plain)
+		}
+		if _, err := auth.NewKeyring(st, oldKEK).Open(ctx, keyID, sealed, []byte("uid-1")); !errors.Is(err, auth.ErrUnknownKEK) {
+			t.Fatalf("Old kek should not be able to unwrap keys after rotation, got %v", err)
+		}
+	})
+}
+
+func TestRotateDataKey(t *testing.T) {
+	eachStore(t, func(t *testing.T, st auth.Store) {
+		ctx := context.Background()
+		rotate := func(id string) error {
+			return st.RotateDataKey(ctx, auth.DataKeyRecord{KeyID: id, KEKID: "kek", Wrapped: []byte(id), CreatedAt: time.Now(), Active: true})
+		}
+		for _, id := range []string{"key-1", "key-2"} {
+			if err := rotate(id); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := rotate("key-2"); err == nil {
+			t.Fatal("Rotating to an existing key id should fail")
+		}
+		keys, err := st.ListDataKeys(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var active []string
+		for _, k := range keys {
+			if k.Active {
+				active = append(active, k.KeyID)
+			}
+		}
+		if len(active) != 1 || active[0] != "key-2" {
+			t.Fatalf("A failed rotation should keep the previous key active, got %v", active)
+		}
+	})
+}
+
+func mustKEK(t *testing.T) auth.KEK {
+	encoded, err := auth.GenerateKEK()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kek, err := auth.ParseKEK(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kek
+}
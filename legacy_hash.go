@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Legacy formats are accepted so users can be imported from other systems
+// (eg.: htpasswd files), they are replaced by argon2id on the next successful login.
+
+const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// IsSupportedHash returns true if encoded uses a format which can be verified by Login
+func IsSupportedHash(encoded string) bool {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		_, _, _, err := decodeArgon2id(encoded)
+		return err == nil
+	case isBcrypt(encoded):
+		_, err := bcrypt.Cost([]byte(encoded))
+		return err == nil
+	case strings.HasPrefix(encoded, "$apr1$"), strings.HasPrefix(encoded, "$1$"):
+		_, _, err := splitMD5Crypt(encoded)
+		return err == nil
+	case strings.HasPrefix(encoded, "{SHA}"):
+		sum, err := base64.StdEncoding.DecodeString(encoded[len("{SHA}"):])
+		return err == nil && len(sum) == sha1.Size
+	}
+	return false
+}
+
+// verifyLegacy checks plain against hashes created by other systems,
+// a successful verification always requires a rehash
+func verifyLegacy(encoded string, plain []byte) (bool, bool, error) {
+	var ok bool
+	switch {
+	case isBcrypt(encoded):
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), plain)
+		if err != nil && !errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, false, errInvalidHash
+		}
+		ok = err == nil
+	case strings.HasPrefix(encoded, "$apr1$"), strings.HasPrefix(encoded, "$1$"):
+		magic, salt, err := splitMD5Crypt(encoded)
+		if err != nil {
+			return false, false, err
+		}
+		ok = subtle.ConstantTimeCompare([]byte(md5Crypt(plain, []byte(salt), []byte(magic))), []byte(encoded)) == 1
+	case strings.HasPrefix(encoded, "{SHA}"):
+		sum := sha1.Sum(plain)
+		ok = subtle.ConstantTimeCompare([]byte(base64.StdEncoding.EncodeToString(sum[:])), []byte(encoded[len("{SHA}"):])) == 1
+	default:
+		return false, false, errInvalidHash
+	}
+	return ok, ok, nil
+}
+
+func isBcrypt(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") ||
+		strings.HasPrefix(encoded, "$2b$") ||
+		strings.HasPrefix(encoded, "$2y$")
+}
+
+func splitMD5Crypt(encoded string) (string, string, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != "" || len(parts[2]) > 8 || len(parts[3]) != 22 {
+		return "", "", errInvalidHash
+	}
+	return "$" + parts[1] + "$", parts[2], nil
+}
+
+// md5Crypt implements the MD5 based crypt(3) used by htpasswd (apr1) and
+// glibc ($1$), both differ only by the magic prefix
+func md5Crypt(password, salt, magic []byte) string {
+	alt := md5.New()
+	alt.Write(password)
+	alt.Write(salt)
+	alt.Write(password)
+	altSum := alt.Sum(nil)
+
+	h := md5.New()
+	h.Write(password)
+	h.Write(magic)
+	h.Write(salt)
+	for i := len(password); i > 0; i -= 16 {
+		h.Write(altSum[:min(i, 16)])
+	}
+	for i := len(password); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			h.Write([]byte{0})
+		} else {
+			h.Write(password[:1])
+		}
+	}
+	final := h.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		r := md5.New()
+		if i&1 != 0 {
+			r.Write(password)
+		} else {
+			r.Write(final)
+		}
+		if i%3 != 0 {
+			r.Write(salt)
+		}
+		if i%7 != 0 {
+			r.Write(password)
+		}
+		if i&1 != 0 {
+			r.Write(final)
+		} else {
+			r.Write(password)
+		}
+		final = r.Sum(nil)
+	}
+
+	out := strings.Builder{}
+	out.Write(magic)
+	out.Write(salt)
+	out.WriteByte('$')
+	to64 := func(v uint32, n int) {
+		for ; n > 0; n-- {
+			out.WriteByte(itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+	for _, g := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		to64(uint32(final[g[0]])<<16|uint32(final[g[1]])<<8|uint32(final[g[2]]), 4)
+	}
+	to64(uint32(final[11]), 2)
+	return out.String()
+}
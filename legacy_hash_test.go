@@ -0,0 +1,54 @@
+package auth_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/andrebq/auth"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestImportLegacyHashes(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes := map[string]string{
+		"bcrypt": string(bcryptHash),
+		"apr1":   "$apr1$saltsalt$LrttParrLPdxvgutaSXWJ0",
+		"md5":    "$1$abc$iCQ2D3nhptRYi27fDYv2s1",
+		"sha1":   "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=",
+	}
+	for name, hash := range hashes {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			st := auth.NewMemoryStore()
+			if !auth.IsSupportedHash(hash) {
+				t.Fatalf("Hash %v should be supported", hash)
+			}
+			uid, err := auth.ImportUser(ctx, st, "bob", hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := auth.Login(ctx, st, "bob", []byte("not-the-secret")); err == nil {
+				t.Fatal("Login should fail with the wrong password")
+			}
+			if actual, err := auth.Login(ctx, st, "bob", []byte("secret")); err != nil {
+				t.Fatal(err)
+			} else if actual != uid {
+				t.Fatalf("Unexpected uid %v", actual)
+			}
+			u, err := st.FindUserByLogin(ctx, "bob")
+			if err != nil {
+				t.Fatal(err)
+			} else if !strings.HasPrefix(u.PasswdHash, "$argon2id$") {
+				t.Fatalf("Hash should be upgraded to argon2id, got %v", u.PasswdHash)
+			}
+		})
+	}
+
+	if _, err := auth.ImportUser(context.Background(), auth.NewMemoryStore(), "bob", "plain-text"); err == nil {
+		t.Fatal("Unsupported hashes should be rejected")
+	}
+}
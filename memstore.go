@@ -0,0 +1,570 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// MemoryStore implements Store using plain maps, data is lost when
+	// the process exits. It is meant to be used by tests.
+	MemoryStore struct {
+		lock   sync.RWMutex
+		users  map[string]UserRecord
+		logins map[string]string
+		attrs  map[string]map[string]string
+		// history holds previous password hashes, oldest first
+		history map[string][]string
+		// groups maps group names to the uids of its members
+		groups map[string]map[string]struct{}
+		tokens map[string]TokenRecord
+		// clients, codes and signingKeys are used by the oidc provider
+		clients     map[string]ClientRecord
+		codes       map[string]AuthCodeRecord
+		signingKeys map[string]SigningKeyRecord
+		keys        map[string]DataKeyRecord
+	}
+)
+
+var _ Store = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:   make(map[string]UserRecord),
+		logins:  make(map[string]string),
+		attrs:   make(map[string]map[string]string),
+		history: make(map[string][]string),
+		groups:  make(map[string]map[string]struct{}),
+		tokens:  make(map[string]TokenRecord),
+
+		clients:     make(map[string]ClientRecord),
+		codes:       make(map[string]AuthCodeRecord),
+		signingKeys: make(map[string]SigningKeyRecord),
+		keys:        make(map[string]DataKeyRecord),
+	}
+}
+
+func (m *MemoryStore) Close() error { return nil }
+
+func (m *MemoryStore) InsertUser(_ context.Context, u UserRecord) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, taken := m.users[u.UID]; taken {
+		return ErrConflict
+	}
+	if _, taken := m.logins[u.Login]; taken {
+		return ErrConflict
+	}
+	m.users[u.UID] = cloneUser(u)
+	m.logins[u.Login] = u.UID
+	return nil
+}
+
+func (m *MemoryStore) FindUserByLogin(_ context.Context, login string) (UserRecord, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	uid, ok := m.logins[login]
+	if !ok {
+		return UserRecord{}, ErrNotFound
+	}
+	return cloneUser(m.users[uid]), nil
+}
+
+func (m *MemoryStore) FindUserByUID(_ context.Context, uid string) (UserRecord, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	u, ok := m.users[uid]
+	if !ok {
+		return UserRecord{}, ErrNotFound
+	}
+	return cloneUser(u), nil
+}
+
+func (m *MemoryStore) ListUsers(_ context.Context) ([]UserRecord, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	out := make([]UserRecord, 0, len(m.users))
+	for _, u := range m.users {
+		out = append(out, cloneUser(u))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Login < out[j].Login })
+	return out, nil
+}
+
+func (m *MemoryStore) SetUserActive(_ context.Context, uid string, active bool) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	u, ok := m.users[uid]
+	if !ok {
+		return ErrNotFound
+	}
+	u.Active = active
+	m.users[uid] = u
+	return nil
+}
+
+func (m *MemoryStore) RenameUser(_ context.Context, uid string, login string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	u, ok := m.users[uid]
+	if !ok {
+		return ErrNotFound
+	}
+	if other, taken := m.logins[login]; taken && other != uid {
+		return ErrConflict
+	}
+	delete(m.logins, u.Login)
+	u.Login = login
+	m.users[uid] = u
+	m.logins[login] = uid
+	return nil
+}
+
+func (m *MemoryStore) DeleteUser(_ context.Context, uid string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	u, ok := m.users[uid]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(m.users, uid)
+	delete(m.logins, u.Login)
+	delete(m.attrs, uid)
+	delete(m.history, uid)
+	for _, members := range m.groups {
+		delete(members, uid)
+	}
+	now := time.Now()
+	for id, t := range m.tokens {
+		switch {
+		case t.UID != uid:
+		case t.ExpiresAt.Unix() > now.Unix():
+			t.ExpiresAt, t.RevokedAt = t.CreatedAt, now
+			m.tokens[id] = t
+		case t.RevokedAt.Before(now.Add(-MaxSignedTokenTTL)):
+			delete(m.tokens, id)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) UpdatePassword(_ context.Context, uid string, hash string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	u, ok := m.users[uid]
+	if !ok {
+		return ErrNotFound
+	}
+	if u.PasswdHash != "" {
+		m.history[uid] = append(m.history[uid], u.PasswdHash)
+	}
+	u.PasswdHash, u.Salt, u.Passwd = hash, nil, nil
+	m.users[uid] = u
+	return nil
+}
+
+func (m *MemoryStore) PasswordHistory(_ context.Context, uid string, limit int) ([]string, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	var out []string
+	history := m.history[uid]
+	for i := len(history) - 1; i >= 0 && len(out) < limit; i-- {
+		out = append(out, history[i])
+	}
+	return out, nil
+}
+
+func (m *MemoryStore) UserAttributes(_ context.Context, uid string) (map[string]string, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	out := make(map[string]string, len(m.attrs[uid]))
+	maps.Copy(out, m.attrs[uid])
+	return out, nil
+}
+
+func (m *MemoryStore) SetUserAttributes(_ context.Context, uid string, attrs map[string]string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.users[uid]; !ok {
+		return ErrNotFound
+	}
+	current := m.attrs[uid]
+	if current == nil {
+		current = make(map[string]string)
+		m.attrs[uid] = current
+	}
+	for name, value := range attrs {
+		if value == "" {
+			delete(current, name)
+		} else {
+			current[name] = value
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) InsertToken(_ context.Context, t TokenRecord) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, taken := m.tokens[t.TokenID]; taken {
+		return ErrConflict
+	}
+	limit := time.Now().Add(-MaxSignedTokenTTL)
+	for id, old := range m.tokens {
+		if _, ok := m.users[old.UID]; !ok && !old.RevokedAt.IsZero() && old.RevokedAt.Before(limit) {
+			delete(m.tokens, id)
+		}
+	}
+	m.tokens[t.TokenID] = cloneToken(t)
+	return nil
+}
+
+func (m *MemoryStore) FindToken(_ context.Context, tokenID string) (TokenRecord, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	t, ok := m.tokens[tokenID]
+	if !ok {
+		return TokenRecord{}, ErrNotFound
+	}
+	return cloneToken(t), nil
+}
+
+func (m *MemoryStore) ExpireToken(_ context.Context, tokenID string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	t, ok := m.tokens[tokenID]
+	if !ok {
+		return ErrNotFound
+	}
+	t.ExpiresAt, t.RevokedAt = t.CreatedAt, time.Now()
+	m.tokens[tokenID] = t
+	return nil
+}
+
+func (m *MemoryStore) ConsumeToken(_ context.Context, tokenID string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	now := time.Now()
+	t, ok := m.tokens[tokenID]
+	if !ok || t.ExpiresAt.Unix() <= now.Unix() {
+		return ErrNotFound
+	}
+	t.ExpiresAt, t.RevokedAt = t.CreatedAt, now
+	m.tokens[tokenID] = t
+	return nil
+}
+
+func (m *MemoryStore) ExpireUserTokens(_ context.Context, uid string, keep ...string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	now := time.Now()
+	for id, t := range m.tokens {
+		if t.UID != uid || slices.Contains(keep, id) || t.ExpiresAt.Unix() <= now.Unix() {
+			continue
+		}
+		t.ExpiresAt, t.RevokedAt = t.CreatedAt, now
+		m.tokens[id] = t
+	}
+	return nil
+}
+
+func (m *MemoryStore) ListRevokedTokens(_ context.Context, since time.Time) ([]string, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	var out []string
+	for id, t := range m.tokens {
+		if !t.RevokedAt.IsZero() && t.RevokedAt.Unix() >= since.Unix() {
+			out = append(out, id)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func (m *MemoryStore) ListTokens(_ context.Context, uid string) ([]TokenRecord, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	var out []TokenRecord
+	for _, t := range m.tokens {
+		if t.UID == uid {
+			out = append(out, cloneToken(t))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].CreatedAt.Unix() != out[j].CreatedAt.Unix() {
+			return out[i].CreatedAt.Unix() > out[j].CreatedAt.Unix()
+		}
+		return out[i].TokenID < out[j].TokenID
+	})
+	return out, nil
+}
+
+func (m *MemoryStore) CreateGroup(_ context.Context, name string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, taken := m.groups[name]; taken {
+		return ErrConflict
+	}
+	m.groups[name] = make(map[string]struct{})
+	return nil
+}
+
+func (m *MemoryStore) DeleteGroup(_ context.Context, name string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.groups[name]; !ok {
+		return ErrNotFound
+	}
+	delete(m.groups, name)
+	return nil
+}
+
+func (m *MemoryStore) ListGroups(_ context.Context) ([]string, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return sortedKeys(m.groups), nil
+}
+
+func (m *MemoryStore) AddGroupMember(_ context.Context, group, uid string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	members, ok := m.groups[group]
+	if !ok {
+		return ErrNotFound
+	}
+	if _, ok := m.users[uid]; !ok {
+		return ErrNotFound
+	}
+	members[uid] = struct{}{}
+	return nil
+}
+
+func (m *MemoryStore) RemoveGroupMember(_ context.Context, group, uid string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.groups[group][uid]; !ok {
+		return ErrNotFound
+	}
+	delete(m.groups[group], uid)
+	return nil
+}
+
+func (m *MemoryStore) GroupMembers(_ context.Context, group string) ([]string, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	members, ok := m.groups[group]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return sortedKeys(members), nil
+}
+
+func (m *MemoryStore) UserGroups(_ context.Context, uid string) ([]string, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	var out []string
+	for name, members := range m.groups {
+		if _, ok := members[uid]; ok {
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func (m *MemoryStore) InsertClient(_ context.Context, c ClientRecord) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, taken := m.clients[c.ClientID]; taken {
+		return ErrConflict
+	}
+	c.RedirectURIs = slices.Clone(c.RedirectURIs)
+	m.clients[c.ClientID] = c
+	return nil
+}
+
+func (m *MemoryStore) FindClient(_ context.Context, clientID string) (ClientRecord, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	c, ok := m.clients[clientID]
+	if !ok {
+		return ClientRecord{}, ErrNotFound
+	}
+	c.RedirectURIs = slices.Clone(c.RedirectURIs)
+	return c, nil
+}
+
+func (m *MemoryStore) ListClients(_ context.Context) ([]ClientRecord, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	out := make([]ClientRecord, 0, len(m.clients))
+	for _, c := range m.clients {
+		c.RedirectURIs = slices.Clone(c.RedirectURIs)
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Name != out[j].Name {
+			return out[i].Name < out[j].Name
+		}
+		return out[i].ClientID < out[j].ClientID
+	})
+	return out, nil
+}
+
+func (m *MemoryStore) DeleteClient(_ context.Context, clientID string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.clients[clientID]; !ok {
+		return ErrNotFound
+	}
+	delete(m.clients, clientID)
+	return nil
+}
+
+func (m *MemoryStore) InsertAuthCode(_ context.Context, c AuthCodeRecord) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	now := time.Now()
+	for hash, code := range m.codes {
+		if code.ExpiresAt.Before(now) {
+			delete(m.codes, hash)
+		}
+	}
+	if _, taken := m.codes[c.CodeHash]; taken {
+		return ErrConflict
+	}
+	m.codes[c.CodeHash] = c
+	return nil
+}
+
+func (m *MemoryStore) TakeAuthCode(_ context.Context, codeHash string) (AuthCodeRecord, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	c, ok := m.codes[codeHash]
+	if !ok {
+		return AuthCodeRecord{}, ErrNotFound
+	}
+	delete(m.codes, codeHash)
+	return c, nil
+}
+
+func (m *MemoryStore) InsertSigningKey(_ context.Context, k SigningKeyRecord) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, taken := m.signingKeys[k.KeyID]; taken {
+		return ErrConflict
+	}
+	k.Sealed = bytes.Clone(k.Sealed)
+	m.signingKeys[k.KeyID] = k
+	return nil
+}
+
+func (m *MemoryStore) ListSigningKeys(_ context.Context, purpose string) ([]SigningKeyRecord, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	out := make([]SigningKeyRecord, 0, len(m.signingKeys))
+	for _, k := range m.signingKeys {
+		if k.Purpose != purpose {
+			continue
+		}
+		k.Sealed = bytes.Clone(k.Sealed)
+		out = append(out, k)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].CreatedAt.Unix() != out[j].CreatedAt.Unix() {
+			return out[i].CreatedAt.Unix() > out[j].CreatedAt.Unix()
+		}
+		return out[i].KeyID < out[j].KeyID
+	})
+	return out, nil
+}
+
+func (m *MemoryStore) DeleteSigningKeys(_ context.Context, purpose string, before time.Time) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for id, k := range m.signingKeys {
+		if k.Purpose == purpose && k.CreatedAt.Unix() < before.Unix() {
+			delete(m.signingKeys, id)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) InsertDataKey(_ context.Context, k DataKeyRecord) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, taken := m.keys[k.KeyID]; taken {
+		return ErrConflict
+	}
+	k.Wrapped = bytes.Clone(k.Wrapped)
+	m.keys[k.KeyID] = k
+	return nil
+}
+
+func (m *MemoryStore) ListDataKeys(_ context.Context) ([]DataKeyRecord, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	out := make([]DataKeyRecord, 0, len(m.keys))
+	for _, k := range m.keys {
+		k.Wrapped = bytes.Clone(k.Wrapped)
+		out = append(out, k)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].CreatedAt.Unix() != out[j].CreatedAt.Unix() {
+			return out[i].CreatedAt.Unix() > out[j].CreatedAt.Unix()
+		}
+		return out[i].KeyID < out[j].KeyID
+	})
+	return out, nil
+}
+
+func (m *MemoryStore) UpdateDataKeyWrap(_ context.Context, keyID, kekID string, wrapped []byte) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	k, ok := m.keys[keyID]
+	if !ok {
+		return ErrNotFound
+	}
+	k.KEKID, k.Wrapped = kekID, bytes.Clone(wrapped)
+	m.keys[keyID] = k
+	return nil
+}
+
+func (m *MemoryStore) RotateDataKey(_ context.Context, k DataKeyRecord) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, taken := m.keys[k.KeyID]; taken {
+		return ErrConflict
+	}
+	for id, old := range m.keys {
+		old.Active = false
+		m.keys[id] = old
+	}
+	k.Wrapped = bytes.Clone(k.Wrapped)
+	m.keys[k.KeyID] = k
+	return nil
+}
+
+func cloneUser(u UserRecord) UserRecord {
+	u.Salt, u.Passwd = bytes.Clone(u.Salt), bytes.Clone(u.Passwd)
+	return u
+}
+
+func cloneToken(t TokenRecord) TokenRecord {
+	t.Salt, t.Token = bytes.Clone(t.Salt), bytes.Clone(t.Token)
+	return t
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// Migration is a forward-only change to the database schema,
+	// identified by a monotonically increasing version
+	Migration struct {
+		Version int
+		Name    string
+		SQL     string
+	}
+
+	// SchemaStatus compares the schema version stored in a database
+	// with the migrations embedded in this binary
+	SchemaStatus struct {
+		Current int
+		Latest  int
+		Pending []Migration
+	}
+)
+
+var (
+	// ErrSchemaTooNew is returned when the database was migrated by a newer
+	// version of auth and this binary cannot safely operate on it
+	ErrSchemaTooNew = errors.New("auth: database schema is newer than this binary supports")
+
+	//go:embed migrations/sqlite/*.sql migrations/postgres/*.sql
+	migrationFiles embed.FS
+)
+
+// Migrations returns all migrations embedded in this binary ordered by version,
+// every dialect has the same set of versions
+func Migrations() ([]Migration, error) {
+	return dialectSQLite.migrations()
+}
+
+func (d dialect) migrations() ([]Migration, error) {
+	return loadMigrations(migrationFiles, d.migrationsDir())
+}
+
+// Migrate applies all pending migrations to db, each one in its own transaction,
+// and returns the list of migrations that were applied.
+//
+// Concurrent calls, even from different processes, are serialized by a lock
+// held while each migration is applied (an advisory lock on PostgreSQL and
+// the database write lock on SQLite), so replicas may start together.
+//
+// It returns ErrSchemaTooNew if db has a version which is unknown to this binary.
+func Migrate(ctx context.Context, db *sql.DB) ([]Migration, error) {
+	status, err := MigrationStatus(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	if status.Current > status.Latest {
+		return nil, ErrSchemaTooNew
+	}
+	var applied []Migration
+	for _, m := range status.Pending {
+		done, err := applyMigration(ctx, db, m)
+		if err != nil {
+			return applied, fmt.Errorf("auth: migration %v (%v) failed: %w", m.Version, m.Name, err)
+		}
+		if done {
+			applied = append(applied, m)
+		}
+	}
+	return applied, nil
+}
+
+// MigrationStatus reports the current schema version of db and which migrations
+// are still pending, without changing the database.
+func MigrationStatus(ctx context.Context, db *sql.DB) (SchemaStatus, error) {
+	var status SchemaStatus
+	all, err := dialectOf(db).migrations()
+	if err != nil {
+		return status, err
+	}
+	if len(all) > 0 {
+		status.Latest = all[len(all)-1].Version
+	}
+	if err := ensureMigrationsTable(ctx, db); err != nil {
+		return status, err
+	}
+	err = db.QueryRowContext(ctx, `select coalesce(max(version), 0) from schema_migrations`).Scan(&status.Current)
+	if err != nil {
+		return status, err
+	}
+	for _, m := range all {
+		if m.Version > status.Current {
+			status.Pending = append(status.Pending, m)
+		}
+	}
+	return status, nil
+}
+
+// migrationLockID is the PostgreSQL advisory lock held while migrating
+const migrationLockID = 0x61757468
+
+func ensureMigrationsTable(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// on SQLite create table is already serialized by the write lock
+	if dialectOf(db) == dialectPostgres {
+		if _, err := tx.ExecContext(ctx, `select pg_advisory_xact_lock($1)`, migrationLockID); err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, `create table if not exists schema_migrations(
+			version integer not null,
+			name text not null,
+			applied_at_unix bigint not null,
+			primary key(version))`)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// applyMigration applies m unless another process applied it first,
+// it returns false in that case
+func applyMigration(ctx context.Context, db *sql.DB, m Migration) (bool, error) {
+	d := dialectOf(db)
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	if err := lockMigrations(ctx, tx, d); err != nil {
+		return false, err
+	}
+	var count int
+	err = tx.QueryRowContext(ctx, d.rebind(`select count(*) from schema_migrations where version = ?`), m.Version).Scan(&count)
+	if err != nil {
+		return false, err
+	} else if count > 0 {
+		return false, nil
+	}
+	if _, err = tx.ExecContext(ctx, m.SQL); err != nil {
+		return false, err
+	}
+	_, err = tx.ExecContext(ctx, d.rebind(`insert into schema_migrations(version, name, applied_at_unix) values (?, ?, ?)`),
+		m.Version, m.Name, time.Now().Unix())
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// lockMigrations blocks other migrations until tx ends. SQLite has no
+// advisory locks, a write as the first statement takes the database
+// write lock before schema_migrations is read.
+func lockMigrations(ctx context.Context, tx *sql.Tx, d dialect) error {
+	if d == dialectPostgres {
+		_, err := tx.ExecContext(ctx, `select pg_advisory_xact_lock($1)`, migrationLockID)
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `update schema_migrations set version = version where 1 = 0`)
+	return err
+}
+
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []Migration
+	for _, e := range entries {
+		if e.IsDir() || path.Ext(e.Name()) != ".sql" {
+			continue
+		}
+		name := strings.TrimSuffix(e.Name(), ".sql")
+		prefix, label, found := strings.Cut(name, "_")
+		if !found {
+			return nil, fmt.Errorf("auth: invalid migration name %q, expecting <version>_<name>.sql", e.Name())
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("auth: invalid migration version in %q", e.Name())
+		}
+		body, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, Migration{Version: version, Name: label, SQL: string(body)})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	for i := 1; i < len(out); i++ {
+		if out[i].Version == out[i-1].Version {
+			return nil, fmt.Errorf("auth: duplicated migration version %v", out[i].Version)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,128 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/andrebq/auth"
+)
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	db, err := auth.OpenDirNoMigrate(ctx, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	all, err := auth.Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := auth.MigrationStatus(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Current != 0 || len(status.Pending) != len(all) {
+		t.Fatalf("Fresh database should have all migrations pending, got %#v", status)
+	}
+
+	applied, err := auth.Migrate(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(applied) != len(all) {
+		t.Fatalf("Should apply %v migrations got %v", len(all), len(applied))
+	}
+	if applied, err = auth.Migrate(ctx, db); err != nil {
+		t.Fatal(err)
+	} else if len(applied) != 0 {
+		t.Fatalf("Migrate should be idempotent but applied %v", applied)
+	}
+
+	status, err = auth.MigrationStatus(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Current != status.Latest || len(status.Pending) != 0 {
+		t.Fatalf("Database should be up to date, got %#v", status)
+	}
+
+	if _, err := db.ExecContext(ctx, `insert into schema_migrations(version, name, applied_at_unix) values (?, 'from-the-future', 0)`, status.Latest+1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.Migrate(ctx, db); !errors.Is(err, auth.ErrSchemaTooNew) {
+		t.Fatalf("Migrate should refuse a newer schema, got %v", err)
+	}
+}
+
+func TestMigrateLegacyDB(t *testing.T) {
+	ctx := context.Background()
+	db, err := auth.OpenDirNoMigrate(ctx, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// tables created by versions of auth which did not track migrations
+	_, err = db.ExecContext(ctx, `create table db_users(
+			uid text not null,
+			login text not null,
+			salt blob not null,
+			passwd blob not null,
+			active integer not null,
+			primary key(uid),
+			unique(login))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.ExecContext(ctx, `insert into db_users(uid, login, salt, passwd, active) values ('uid', 'bob', x'00', x'00', 1)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.InitDB(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	if err := db.QueryRowContext(ctx, `select count(*) from db_users`).Scan(&count); err != nil {
+		t.Fatal(err)
+	} else if count != 1 {
+		t.Fatalf("Existing users should be preserved, got %v rows", count)
+	}
+}
+
+func TestMigrateConcurrent(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	all, err := auth.Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// each replica has its own connection pool to the same database
+	const replicas = 4
+	results := make(chan []auth.Migration, replicas)
+	errs := make(chan error, replicas)
+	for i := 0; i < replicas; i++ {
+		db, err := auth.OpenDirNoMigrate(ctx, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		go func() {
+			applied, err := auth.Migrate(ctx, db)
+			results <- applied
+			errs <- err
+		}()
+	}
+	total := 0
+	for i := 0; i < replicas; i++ {
+		total += len(<-results)
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if total != len(all) {
+		t.Fatalf("Each migration should be applied once, got %v applications for %v migrations", total, len(all))
+	}
+}
@@ -0,0 +1,17 @@
+create table if not exists db_users(
+	uid text not null,
+	login text not null,
+	salt bytea not null,
+	passwd bytea not null,
+	active integer not null,
+	primary key(uid),
+	unique(login));
+
+create table if not exists db_tokens(token_id text not null,
+	token_type text not null,
+	uid text not null,
+	salt bytea not null,
+	token bytea not null,
+	created_at_unix bigint not null,
+	expires_at_unix bigint not null,
+	primary key(token_id));
@@ -0,0 +1,7 @@
+create table if not exists db_data_keys(
+	key_id text not null,
+	kek_id text not null,
+	wrapped bytea not null,
+	created_at_unix bigint not null,
+	active integer not null,
+	primary key(key_id));
@@ -0,0 +1,3 @@
+alter table db_users add column passwd_hash text not null default '';
+
+alter table db_tokens add column token_hash text not null default '';
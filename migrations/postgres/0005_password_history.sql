@@ -0,0 +1,6 @@
+create table if not exists db_password_history(
+	seq bigserial primary key,
+	uid text not null,
+	passwd_hash text not null,
+	replaced_at_unix bigint not null);
+create index if not exists db_password_history_uid on db_password_history(uid, seq);
@@ -0,0 +1,5 @@
+alter table db_signing_keys add column purpose text not null default 'oidc';
+
+alter table db_signing_keys add column public_key text not null default '';
+
+alter table db_tokens add column revoked_at_unix bigint not null default 0;
@@ -0,0 +1 @@
+create index if not exists db_tokens_revoked_at on db_tokens(revoked_at_unix);
@@ -0,0 +1,17 @@
+create table if not exists db_users(
+	uid text not null,
+	login text not null,
+	salt blob not null,
+	passwd blob not null,
+	active integer not null,
+	primary key(uid),
+	unique(login));
+
+create table if not exists db_tokens(token_id text not null,
+	token_type text not null,
+	uid text not null,
+	salt blob not null,
+	token blob not null,
+	created_at_unix integer not null,
+	expires_at_unix integer not null,
+	primary key(token_id));
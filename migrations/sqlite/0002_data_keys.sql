@@ -0,0 +1,7 @@
+create table if not exists db_data_keys(
+	key_id text not null,
+	kek_id text not null,
+	wrapped blob not null,
+	created_at_unix integer not null,
+	active integer not null,
+	primary key(key_id));
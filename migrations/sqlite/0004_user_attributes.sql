@@ -0,0 +1,5 @@
+create table if not exists db_user_attributes(
+	uid text not null,
+	name text not null,
+	value text not null,
+	primary key(uid, name));
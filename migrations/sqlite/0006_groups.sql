@@ -0,0 +1,10 @@
+create table if not exists db_groups(
+	name text not null,
+	primary key(name));
+
+create table if not exists db_group_members(
+	group_name text not null,
+	uid text not null,
+	primary key(group_name, uid));
+create index if not exists db_group_members_uid on db_group_members(uid);
+create index if not exists db_tokens_uid on db_tokens(uid);
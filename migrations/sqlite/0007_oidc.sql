@@ -0,0 +1,27 @@
+create table if not exists db_clients(
+	client_id text not null,
+	name text not null,
+	secret_hash text not null,
+	redirect_uris text not null,
+	created_at_unix integer not null,
+	primary key(client_id));
+
+create table if not exists db_auth_codes(
+	code_hash text not null,
+	client_id text not null,
+	uid text not null,
+	redirect_uri text not null,
+	scope text not null,
+	nonce text not null,
+	code_challenge text not null,
+	auth_time_unix integer not null,
+	expires_at_unix integer not null,
+	primary key(code_hash));
+
+create table if not exists db_signing_keys(
+	key_id text not null,
+	algorithm text not null,
+	data_key_id text not null,
+	sealed blob not null,
+	created_at_unix integer not null,
+	primary key(key_id));
@@ -0,0 +1 @@
+alter table db_clients add column uid text not null default '';
@@ -0,0 +1,3 @@
+alter table db_tokens add column client_id text not null default '';
+
+alter table db_tokens add column scope text not null default '';
@@ -0,0 +1,53 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+type (
+	// File writes notifications to w, it is meant for development
+	// and for setups where another process delivers the messages
+	File struct {
+		Templates *Templates
+
+		lock sync.Mutex
+		w    io.Writer
+	}
+)
+
+// NewFile returns a notifier which writes every message to w
+func NewFile(w io.Writer, tmpl *Templates) *File {
+	return &File{w: w, Templates: tmpl}
+}
+
+// OpenFile returns a notifier which appends every message to name
+func OpenFile(name string, tmpl *Templates) (*File, error) {
+	fd, err := os.OpenFile(name, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return NewFile(fd, tmpl), nil
+}
+
+func (f *File) Notify(_ context.Context, n Notification) error {
+	msg, err := f.Templates.Render(n)
+	if err != nil {
+		return err
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	_, err = fmt.Fprintf(f.w, "To: %v\nEvent: %v\nSubject: %v\n\n%v\n", recipient(n.User), n.Event, msg.Subject, msg.Body)
+	return err
+}
+
+// Close closes the underlying writer if it is an io.Closer
+func (f *File) Close() error {
+	if c, ok := f.w.(io.Closer); ok && f.w != os.Stdout {
+		return c.Close()
+	}
+	return nil
+}
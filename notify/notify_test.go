@@ -0,0 +1,173 @@
+package notify_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andrebq/auth"
+	"github.com/andrebq/auth/notify"
+)
+
+var bob = auth.User{UID: "uid-1", Login: "bob", Active: true, Attributes: map[string]string{
+	auth.AttrName:  "Bob",
+	auth.AttrEmail: "bob@example.com",
+}}
+
+// fakeSMTP accepts a single message and sends its DATA section to the returned channel
+func fakeSMTP(t *testing.T) (string, <-chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	received := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+		reply("220 localhost ESMTP fake")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.Fields(line)[0])
+			switch cmd {
+			case "EHLO", "HELO", "MAIL":
+				reply("250 ok")
+			case "RCPT":
+				if addr := strings.TrimSpace(line[len("RCPT TO:"):]); strings.Count(addr, "<") != 1 || strings.ContainsAny(addr, " \"") {
+					reply("553 invalid recipient " + addr)
+					continue
+				}
+				reply("250 ok")
+			case "DATA":
+				reply("354 go ahead")
+				var data strings.Builder
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				received <- data.String()
+				reply("250 queued")
+			case "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("502 not implemented")
+			}
+		}
+	}()
+	return l.Addr().String(), received
+}
+
+func TestSMTP(t *testing.T) {
+	addr, received := fakeSMTP(t)
+	n, err := notify.New("smtp://"+addr+"?from=auth@example.com", notify.DefaultTemplates())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = notify.Links{Notifier: n, PublicURL: "https://example.com"}.NotifyReset(context.Background(), bob, "tid:secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := <-received
+	for _, expected := range []string{"To: <bob@example.com>", "Subject: Reset your password", "Hello Bob", "https://example.com/.auth/reset?token=tid%3Asecret"} {
+		if !strings.Contains(msg, expected) {
+			t.Errorf("Message should contain %q got:\n%v", expected, msg)
+		}
+	}
+
+	named := bob
+	named.Attributes = map[string]string{auth.AttrEmail: "Bob Smith <bob@example.com>"}
+	addr, received = fakeSMTP(t)
+	n, err = notify.New("smtp://"+addr+"?from=auth@example.com", notify.DefaultTemplates())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(context.Background(), notify.Notification{Event: notify.EventPasswordReset, User: named}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := <-received; !strings.Contains(msg, "To: \"Bob Smith\" <bob@example.com>\r\n") {
+		t.Errorf("Message should be addressed to the parsed address got:\n%v", msg)
+	}
+
+	noEmail := auth.User{UID: "uid-2", Login: "alice"}
+	if err := n.Notify(context.Background(), notify.Notification{Event: notify.EventPasswordReset, User: noEmail}); err == nil {
+		t.Fatal("Users without email should not be notified")
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var payload struct {
+		Event   string    `json:"event"`
+		User    auth.User `json:"user"`
+		Subject string    `json:"subject"`
+		Token   string    `json:"token"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("X-Auth-Signature") != "sha256="+notify.Sign([]byte("s3cr3t"), body) {
+			http.Error(w, "invalid signature", http.StatusForbidden)
+			return
+		}
+		json.Unmarshal(body, &payload)
+	}))
+	defer server.Close()
+	n, err := notify.New(server.URL+"/hook?secret=s3cr3t", notify.DefaultTemplates())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(context.Background(), notify.Notification{Event: notify.EventPasswordReset, User: bob, Token: "tid:secret"}); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Event != string(notify.EventPasswordReset) || payload.User.Login != "bob" || payload.Token != "tid:secret" || payload.Subject == "" {
+		t.Fatalf("Unexpected payload %#v", payload)
+	}
+}
+
+func TestFileTemplates(t *testing.T) {
+	dir := t.TempDir()
+	custom := `{{ define "subject" }}Password reset for {{ .User.Login }}{{ end }}{{ define "body" }}Code: {{ .Token }}{{ end }}`
+	if err := os.WriteFile(filepath.Join(dir, "password-reset.tmpl"), []byte(custom), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := notify.LoadTemplates(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := notify.NewFile(&out, tmpl).Notify(context.Background(), notify.Notification{Event: notify.EventPasswordReset, User: bob, Token: "tid:secret"}); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "To: bob <bob@example.com>\nEvent: password-reset\nSubject: Password reset for bob\n\nCode: tid:secret\n\n"; out.String() != expected {
+		t.Fatalf("Unexpected output %q", out.String())
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "password-reset.tmpl"), []byte(`{{ define "body" }}{{ end }}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := notify.LoadTemplates(dir); err == nil {
+		t.Fatal("Templates without a subject should be rejected")
+	}
+}
@@ -0,0 +1,109 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/andrebq/auth"
+)
+
+type (
+	// SMTP delivers notifications by email to the address stored
+	// in the email attribute of the user
+	SMTP struct {
+		// Addr is host:port of the SMTP server, STARTTLS is used when
+		// the server supports it
+		Addr     string
+		From     string
+		Username string
+		Password string
+
+		Templates *Templates
+	}
+)
+
+func (s *SMTP) Notify(ctx context.Context, n Notification) error {
+	email := n.User.Attributes[auth.AttrEmail]
+	if email == "" {
+		return fmt.Errorf("%w: %v", ErrNoRecipient, n.User.Login)
+	}
+	to, err := mail.ParseAddress(email)
+	if err != nil {
+		return fmt.Errorf("notify: invalid email for %v: %w", n.User.Login, err)
+	}
+	msg, err := s.Templates.Render(n)
+	if err != nil {
+		return err
+	}
+	return s.send(ctx, to.Address, s.encode(to.String(), msg))
+}
+
+func (s *SMTP) send(ctx context.Context, to string, data []byte) error {
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return err
+	}
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(time.Minute)
+	}
+	conn.SetDeadline(deadline)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if s.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.Username, s.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(s.From); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func (s *SMTP) encode(to string, msg Message) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %v\r\n", s.From)
+	fmt.Fprintf(&buf, "To: %v\r\n", to)
+	fmt.Fprintf(&buf, "Subject: %v\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %v\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	buf.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return buf.Bytes()
+}
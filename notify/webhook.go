@@ -0,0 +1,88 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/andrebq/auth"
+)
+
+type (
+	// Webhook posts notifications as JSON to URL, leaving the delivery
+	// (chat, sms, ...) to another system
+	Webhook struct {
+		URL string
+		// Secret, when set, is used to sign the body with HMAC-SHA256,
+		// the signature is sent in the X-Auth-Signature header as sha256=<hex>
+		Secret string
+		// Client defaults to an http.Client with a 10 seconds timeout
+		Client *http.Client
+
+		Templates *Templates
+	}
+
+	webhookPayload struct {
+		Event   Event     `json:"event"`
+		User    auth.User `json:"user"`
+		Subject string    `json:"subject"`
+		Body    string    `json:"body"`
+		Token   string    `json:"token,omitempty"`
+		Link    string    `json:"link,omitempty"`
+	}
+)
+
+var defaultWebhookClient = &http.Client{Timeout: 10 * time.Second}
+
+func (wh *Webhook) Notify(ctx context.Context, n Notification) error {
+	msg, err := wh.Templates.Render(n)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(webhookPayload{
+		Event:   n.Event,
+		User:    n.User,
+		Subject: msg.Subject,
+		Body:    msg.Body,
+		Token:   n.Token,
+		Link:    n.Link,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", wh.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if wh.Secret != "" {
+		req.Header.Set("X-Auth-Signature", "sha256="+Sign([]byte(wh.Secret), body))
+	}
+	client := wh.Client
+	if client == nil {
+		client = defaultWebhookClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("notify: webhook returned status %v", res.StatusCode)
+	}
+	return nil
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of body, receivers use it
+// to verify the X-Auth-Signature header
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
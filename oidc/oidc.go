@@ -0,0 +1,470 @@
+// Package oidc implements an OpenID Connect provider on top of auth users:
+// authorization code flow with PKCE, discovery, JWKS and userinfo
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/andrebq/auth"
+	"github.com/andrebq/auth/jwt"
+	"github.com/andrebq/auth/proxy"
+	"github.com/rs/zerolog/log"
+)
+
+type (
+	// Option changes the default configuration of the provider
+	Option func(*provider)
+
+	provider struct {
+		st     auth.Store
+		keys   *auth.SigningKeys
+		issuer string
+		prefix string
+		secure bool
+
+		accessTokenTTL time.Duration
+		idTokenTTL     time.Duration
+		sessionTTL     time.Duration
+	}
+
+	// authorizeRequest holds the validated parameters of /authorize
+	authorizeRequest struct {
+		client        auth.Client
+		redirectURI   string
+		state         string
+		scope         string
+		nonce         string
+		codeChallenge string
+		prompt        string
+	}
+
+	// oauthError is returned as defined by RFC 6749 section 5.2
+	oauthError struct {
+		status      int
+		Code        string `json:"error"`
+		Description string `json:"error_description,omitempty"`
+	}
+
+	idTokenClaims struct {
+		jwt.Claims
+		Nonce    string `json:"nonce,omitempty"`
+		AuthTime int64  `json:"auth_time,omitempty"`
+		userClaims
+	}
+
+	userClaims struct {
+		PreferredUsername string   `json:"preferred_username,omitempty"`
+		Name              string   `json:"name,omitempty"`
+		Email             string   `json:"email,omitempty"`
+		Groups            []string `json:"groups,omitempty"`
+	}
+)
+
+const (
+	// sessionCookie is shared with the auth proxy, so users who logged in
+	// through the proxy on the same domain are not asked to login again
+	sessionCookie = "auth.session"
+
+	// default lifetime of tokens and provider sessions, see the With* options
+	DefaultAccessTokenTTL = time.Hour
+	DefaultIDTokenTTL     = time.Hour
+	DefaultSessionTTL     = 24 * time.Hour
+)
+
+var supportedScopes = []string{"openid", "profile", "email", "groups"}
+
+// WithAccessTokenTTL changes how long access tokens are valid
+func WithAccessTokenTTL(ttl time.Duration) Option {
+	return func(p *provider) { p.accessTokenTTL = ttl }
+}
+
+// WithIDTokenTTL changes how long id tokens are valid, it must be
+// shorter than the key rotation period
+func WithIDTokenTTL(ttl time.Duration) Option {
+	return func(p *provider) { p.idTokenTTL = ttl }
+}
+
+// WithSessionTTL changes how long users stay logged in to the provider
+func WithSessionTTL(ttl time.Duration) Option {
+	return func(p *provider) { p.sessionTTL = ttl }
+}
+
+// Handler serves the provider identified by issuer, which is the public
+// URL of the handler. Every endpoint is served under the path of the issuer.
+func Handler(st auth.Store, keys *auth.SigningKeys, issuer string, opts ...Option) (http.Handler, error) {
+	u, err := url.Parse(issuer)
+	if err != nil {
+		return nil, err
+	}
+	if !u.IsAbs() || u.RawQuery != "" || u.Fragment != "" {
+		return nil, errors.New("oidc: issuer must be an absolute url without query or fragment")
+	}
+	if alg := keys.Algorithm(); alg != jwt.RS256 && alg != jwt.EdDSA {
+		return nil, fmt.Errorf("oidc: unsupported signing algorithm %q", alg)
+	}
+	p := &provider{
+		st:             st,
+		keys:           keys,
+		issuer:         strings.TrimSuffix(issuer, "/"),
+		prefix:         strings.TrimSuffix(u.Path, "/"),
+		secure:         u.Scheme == "https",
+		accessTokenTTL: DefaultAccessTokenTTL,
+		idTokenTTL:     DefaultIDTokenTTL,
+		sessionTTL:     DefaultSessionTTL,
+	}
+	for _, o := range opts {
+		o(p)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+p.prefix+"/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("GET "+p.prefix+"/oidc/jwks", p.jwks)
+	mux.HandleFunc(p.prefix+"/oidc/authorize", p.authorize)
+	mux.HandleFunc("POST "+p.prefix+"/oidc/token", p.token)
+	mux.HandleFunc(p.prefix+"/oidc/userinfo", p.userinfo)
+	return mux, nil
+}
+
+func (p *provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                p.issuer,
+		"authorization_endpoint":                p.issuer + "/oidc/authorize",
+		"token_endpoint":                        p.issuer + "/oidc/token",
+		"userinfo_endpoint":                     p.issuer + "/oidc/userinfo",
+		"jwks_uri":                              p.issuer + "/oidc/jwks",
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{p.keys.Algorithm()},
+		"scopes_supported":                      supportedScopes,
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"claims_supported":                      []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "preferred_username", "name", "email", "groups"},
+	})
+}
+
+func (p *provider) jwks(w http.ResponseWriter, r *http.Request) {
+	keys, err := p.keys.PublicKeys(r.Context())
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to load signing keys")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, http.StatusOK, keys)
+}
+
+func (p *provider) authorize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// the login form posts back to the same url, so parameters are always in the query
+	q := r.URL.Query()
+	req, oerr := p.parseAuthorize(r.Context(), q)
+	if oerr != nil {
+		// without a valid client and redirect_uri the user cannot be sent back
+		http.Error(w, oerr.Description, http.StatusBadRequest)
+		return
+	}
+	if code, description := req.validate(q); code != "" {
+		req.redirectError(w, r, code, description)
+		return
+	}
+	uid, authTime := p.sessionUser(r)
+	if r.Method == http.MethodPost {
+		var ok bool
+		if uid, ok = p.login(w, r); !ok {
+			return
+		}
+		authTime = time.Now()
+	}
+	if uid == "" || req.prompt == "login" && r.Method != http.MethodPost {
+		if req.prompt == "none" {
+			req.redirectError(w, r, "login_required", "")
+			return
+		}
+		proxy.RenderLogin(w, http.StatusOK, proxy.LoginPage{Action: r.URL.RequestURI()})
+		return
+	}
+	code, err := auth.CreateAuthCode(r.Context(), p.st, auth.AuthCode{
+		ClientID:      req.client.ClientID,
+		UID:           uid,
+		RedirectURI:   req.redirectURI,
+		Scope:         req.scope,
+		Nonce:         req.nonce,
+		CodeChallenge: req.codeChallenge,
+		AuthTime:      authTime,
+	}, auth.DefaultAuthCodeTTL)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to create authorization code")
+		req.redirectError(w, r, "server_error", "")
+		return
+	}
+	req.redirect(w, r, url.Values{"code": {code}})
+}
+
+func (p *provider) parseAuthorize(ctx context.Context, q url.Values) (authorizeRequest, *oauthError) {
+	client, err := auth.LookupClient(ctx, p.st, q.Get("client_id"))
+	if err != nil {
+		return authorizeRequest{}, &oauthError{Code: "invalid_request", Description: "Unknown client_id"}
+	}
+	if !client.AllowsRedirect(q.Get("redirect_uri")) {
+		return authorizeRequest{}, &oauthError{Code: "invalid_request", Description: "redirect_uri is not registered for this client"}
+	}
+	req := authorizeRequest{
+		client:        client,
+		redirectURI:   q.Get("redirect_uri"),
+		state:         q.Get("state"),
+		scope:         q.Get("scope"),
+		nonce:         q.Get("nonce"),
+		codeChallenge: q.Get("code_challenge"),
+		prompt:        q.Get("prompt"),
+	}
+	return req, nil
+}
+
+// validate checks the parameters which are reported back to the client
+func (req authorizeRequest) validate(q url.Values) (string, string) {
+	switch {
+	case q.Get("response_type") != "code":
+		return "unsupported_response_type", "Only the code response type is supported"
+	case !slices.Contains(strings.Fields(req.scope), "openid"):
+		return "invalid_scope", "The openid scope is required"
+	case req.codeChallenge != "" && q.Get("code_challenge_method") != "S256":
+		return "invalid_request", "Only the S256 code_challenge_method is supported"
+	case req.codeChallenge == "" && req.client.Public:
+		return "invalid_request", "Public clients must use PKCE"
+	}
+	return "", ""
+}
+
+func (req authorizeRequest) redirect(w http.ResponseWriter, r *http.Request, params url.Values) {
+	target, _ := url.Parse(req.redirectURI)
+	q := target.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+	if req.state != "" {
+		q.Set("state", req.state)
+	}
+	target.RawQuery = q.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+func (req authorizeRequest) redirectError(w http.ResponseWriter, r *http.Request, code, description string) {
+	params := url.Values{"error": {code}}
+	if description != "" {
+		params.Set("error_description", description)
+	}
+	req.redirect(w, r, params)
+}
+
+// sessionUser returns the user logged in to the provider, if any
+func (p *provider) sessionUser(r *http.Request) (string, time.Time) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return "", time.Time{}
+	}
+	uid, tokenType, err := auth.TokenLogin(r.Context(), p.st, cookie.Value)
+	if err != nil || tokenType != auth.TokenTypeSession {
+		return "", time.Time{}
+	}
+	tokenID, _ := auth.ExtractTokenID(cookie.Value)
+	if t, err := p.st.FindToken(r.Context(), tokenID); err == nil {
+		return uid, t.CreatedAt
+	}
+	return uid, time.Time{}
+}
+
+// login authenticates the user with the credentials posted by the login page
+// and starts a session with the provider
+func (p *provider) login(w http.ResponseWriter, r *http.Request) (string, bool) {
+	username, password := r.PostFormValue("username"), r.PostFormValue("password")
+	if username == "" || password == "" {
+		proxy.RenderLogin(w, http.StatusBadRequest, proxy.LoginPage{Action: r.URL.RequestURI(), Error: "Please inform your username and password"})
+		return "", false
+	}
+	uid, err := auth.Login(r.Context(), p.st, username, []byte(password))
+	if err != nil {
+		proxy.RenderLogin(w, http.StatusUnauthorized, proxy.LoginPage{Action: r.URL.RequestURI(), Error: "Invalid username or password"})
+		return "", false
+	}
+	expires := time.Now().Add(p.sessionTTL)
+	session, err := auth.CreateToken(r.Context(), p.st, username, auth.TokenTypeSession, expires)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to create session")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return "", false
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Path:     p.prefix + "/",
+		Value:    session,
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   p.secure,
+		// clients redirect users here from other sites
+		SameSite: http.SameSiteLaxMode,
+	})
+	return uid, true
+}
+
+func (p *provider) token(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	clientID, secret, basic := r.BasicAuth()
+	if basic {
+		// RFC 6749 section 2.3.1 requires form encoding of the credentials
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	client, err := auth.AuthenticateClient(r.Context(), p.st, clientID, secret)
+	if err != nil {
+		if basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="oidc"`)
+		}
+		writeError(w, &oauthError{status: http.StatusUnauthorized, Code: "invalid_client"})
+		return
+	}
+	if r.PostFormValue("grant_type") != "authorization_code" {
+		writeError(w, &oauthError{Code: "unsupported_grant_type"})
+		return
+	}
+	code, err := auth.RedeemAuthCode(r.Context(), p.st, r.PostFormValue("code"))
+	switch {
+	case err != nil:
+		writeError(w, &oauthError{Code: "invalid_grant", Description: "Invalid or expired code"})
+		return
+	case code.ClientID != client.ClientID || code.RedirectURI != r.PostFormValue("redirect_uri"):
+		writeError(w, &oauthError{Code: "invalid_grant", Description: "Code was issued to another client or redirect_uri"})
+		return
+	case !code.VerifyCodeVerifier(r.PostFormValue("code_verifier")):
+		writeError(w, &oauthError{Code: "invalid_grant", Description: "Invalid code_verifier"})
+		return
+	}
+	user, err := auth.LookupUserByUID(r.Context(), p.st, code.UID)
+	if err != nil || !user.Active {
+		writeError(w, &oauthError{Code: "invalid_grant", Description: "User is not active"})
+		return
+	}
+	now := time.Now()
+	accessToken, err := auth.CreateClientToken(r.Context(), p.st, user.Login, auth.TokenTypeAccess, client.ClientID, code.Scope, now.Add(p.accessTokenTTL))
+	if err != nil {
+		p.serverError(w, err)
+		return
+	}
+	key, err := p.keys.Current(r.Context())
+	if err != nil {
+		p.serverError(w, err)
+		return
+	}
+	idToken, err := jwt.Sign(key, idTokenClaims{
+		Claims: jwt.Claims{
+			Issuer:    p.issuer,
+			Subject:   user.UID,
+			Audience:  jwt.Audience{client.ClientID},
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(p.idTokenTTL).Unix(),
+		},
+		Nonce:      code.Nonce,
+		AuthTime:   code.AuthTime.Unix(),
+		userClaims: claimsFor(user, strings.Fields(code.Scope)),
+	})
+	if err != nil {
+		p.serverError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+		IDToken     string `json:"id_token"`
+		Scope       string `json:"scope"`
+	}{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(p.accessTokenTTL / time.Second),
+		IDToken:     idToken,
+		Scope:       code.Scope,
+	})
+}
+
+// userinfo returns the claims of the scopes granted to the access token
+func (p *provider) userinfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="oidc"`)
+		writeError(w, &oauthError{status: http.StatusUnauthorized, Code: "invalid_token"})
+		return
+	}
+	t, err := auth.InspectToken(r.Context(), p.st, token)
+	if err != nil || t.TokenType != auth.TokenTypeAccess {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="oidc", error="invalid_token"`)
+		writeError(w, &oauthError{status: http.StatusUnauthorized, Code: "invalid_token"})
+		return
+	}
+	user, err := auth.LookupUserByUID(r.Context(), p.st, t.UID)
+	if err != nil {
+		p.serverError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Subject string `json:"sub"`
+		userClaims
+	}{
+		Subject:    user.UID,
+		userClaims: claimsFor(user, strings.Fields(t.Scope)),
+	})
+}
+
+func (p *provider) serverError(w http.ResponseWriter, err error) {
+	log.Error().Err(err).Msg("Unable to issue tokens")
+	writeError(w, &oauthError{status: http.StatusInternalServerError, Code: "server_error"})
+}
+
+func claimsFor(u auth.User, scopes []string) userClaims {
+	var c userClaims
+	if slices.Contains(scopes, "profile") {
+		c.PreferredUsername = u.Login
+		c.Name = u.Attributes[auth.AttrName]
+	}
+	if slices.Contains(scopes, "email") {
+		c.Email = u.Attributes[auth.AttrEmail]
+	}
+	if slices.Contains(scopes, "groups") {
+		c.Groups = u.Groups
+	}
+	return c
+}
+
+func writeError(w http.ResponseWriter, err *oauthError) {
+	status := err.status
+	if status == 0 {
+		status = http.StatusBadRequest
+	}
+	writeJSON(w, status, err)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(buf)
+}
@@ -0,0 +1,257 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andrebq/auth"
+	"github.com/andrebq/auth/jwt"
+	"github.com/andrebq/auth/oidc"
+)
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	ctx := context.Background()
+	st := auth.NewMemoryStore()
+	defer st.Close()
+	if _, err := auth.RegisterUser(ctx, st, "bob", []byte("bob's secret password")); err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.SetUserAttributes(ctx, st, "bob", map[string]string{auth.AttrEmail: "bob@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	app, secret, err := auth.RegisterClient(ctx, st, auth.NewClient{Name: "app", RedirectURIs: []string{"https://app.example.com/cb"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encodedKEK, err := auth.GenerateKEK()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kek, err := auth.ParseKEK(encodedKEK)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	issuer := srv.URL + "/id"
+	handler, err := oidc.Handler(st, auth.NewSigningKeys(st, auth.NewKeyring(st, kek), auth.KeyPurposeOIDC, jwt.RS256, time.Hour), issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux.Handle("/", handler)
+
+	jar, _ := cookiejar.New(nil)
+	browser := &http.Client{
+		Jar: jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	var discovery struct {
+		Issuer        string `json:"issuer"`
+		Authorization string `json:"authorization_endpoint"`
+		Token         string `json:"token_endpoint"`
+		Userinfo      string `json:"userinfo_endpoint"`
+		JWKS          string `json:"jwks_uri"`
+	}
+	getJSON(t, srv.Client(), issuer+"/.well-known/openid-configuration", &discovery)
+	if discovery.Issuer != issuer || discovery.Token != issuer+"/oidc/token" {
+		t.Fatalf("Unexpected discovery document %#v", discovery)
+	}
+
+	verifier := "a-verifier-which-is-long-enough-to-be-accepted-by-rfc-7636"
+	sum := sha256.Sum256([]byte(verifier))
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {app.ClientID},
+		"redirect_uri":          {"https://app.example.com/cb"},
+		"scope":                 {"openid email"},
+		"state":                 {"st-1"},
+		"nonce":                 {"n-1"},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(sum[:])},
+		"code_challenge_method": {"S256"},
+	}
+	authorizeURL := discovery.Authorization + "?" + params.Encode()
+
+	params.Set("redirect_uri", "https://evil.example.com/cb")
+	res, err := browser.Get(discovery.Authorization + "?" + params.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Unregistered redirect_uri should not redirect got %v", res.StatusCode)
+	}
+
+	res, err = browser.Get(authorizeURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Login page should be rendered got %v", res.StatusCode)
+	}
+
+	code := login(t, browser, authorizeURL, "bob", "bob's secret password")
+	exchange := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {"https://app.example.com/cb"},
+		"code_verifier": {"wrong"},
+	}
+	if status, _ := tokenRequest(t, discovery.Token, app.ClientID, secret, exchange); status != http.StatusBadRequest {
+		t.Fatalf("Invalid code_verifier should be rejected got %v", status)
+	}
+
+	// the session cookie skips the login page, the previous code was consumed by the failed exchange
+	res, err = browser.Get(authorizeURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	code = codeFrom(t, res)
+	exchange.Set("code", code)
+	exchange.Set("code_verifier", verifier)
+	status, tokens := tokenRequest(t, discovery.Token, app.ClientID, secret, exchange)
+	if status != http.StatusOK {
+		t.Fatalf("Unexpected status %v: %v", status, tokens)
+	}
+	if status, _ := tokenRequest(t, discovery.Token, app.ClientID, secret, exchange); status != http.StatusBadRequest {
+		t.Fatalf("Codes must be single use got %v", status)
+	}
+
+	var keys jwt.JWKS
+	getJSON(t, srv.Client(), discovery.JWKS, &keys)
+	var claims struct {
+		jwt.Claims
+		Nonce string `json:"nonce"`
+		Email string `json:"email"`
+	}
+	if err := jwt.Verify(tokens["id_token"].(string), keys, &claims); err != nil {
+		t.Fatal(err)
+	}
+	if err := claims.Validate(time.Now(), issuer, app.ClientID); err != nil {
+		t.Fatal(err)
+	} else if claims.Nonce != "n-1" || claims.Email != "bob@example.com" {
+		t.Fatalf("Unexpected id token claims %#v", claims)
+	}
+
+	req, _ := http.NewRequest("GET", discovery.Userinfo, nil)
+	req.Header.Set("Authorization", "Bearer "+tokens["access_token"].(string))
+	res, err = srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var info map[string]any
+	if err := json.NewDecoder(res.Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	} else if info["sub"] != claims.Subject || info["email"] != "bob@example.com" || info["preferred_username"] != nil {
+		t.Fatalf("Unexpected userinfo %v", info)
+	}
+}
+
+func TestAuthorizeErrors(t *testing.T) {
+	ctx := context.Background()
+	st := auth.NewMemoryStore()
+	defer st.Close()
+	spa, _, err := auth.RegisterClient(ctx, st, auth.NewClient{Name: "spa", RedirectURIs: []string{"https://spa.example.com/cb"}, Public: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := oidc.Handler(st, auth.NewSigningKeys(st, nil, auth.KeyPurposeOIDC, jwt.EdDSA, time.Hour), "https://auth.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name   string
+		params url.Values
+		err    string
+	}{
+		{"missing openid", url.Values{"response_type": {"code"}, "scope": {"email"}, "code_challenge": {"x"}, "code_challenge_method": {"S256"}}, "invalid_scope"},
+		{"implicit flow", url.Values{"response_type": {"token"}, "scope": {"openid"}}, "unsupported_response_type"},
+		{"public without pkce", url.Values{"response_type": {"code"}, "scope": {"openid"}}, "invalid_request"},
+		{"plain pkce", url.Values{"response_type": {"code"}, "scope": {"openid"}, "code_challenge": {"x"}, "code_challenge_method": {"plain"}}, "invalid_request"},
+		{"prompt none", url.Values{"response_type": {"code"}, "scope": {"openid"}, "code_challenge": {"x"}, "code_challenge_method": {"S256"}, "prompt": {"none"}}, "login_required"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.params.Set("client_id", spa.ClientID)
+			tc.params.Set("redirect_uri", "https://spa.example.com/cb")
+			tc.params.Set("state", "st-1")
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest("GET", "/oidc/authorize?"+tc.params.Encode(), nil))
+			if rec.Code != http.StatusFound {
+				t.Fatalf("Unexpected status %v", rec.Code)
+			}
+			loc, _ := url.Parse(rec.Header().Get("Location"))
+			if loc.Query().Get("error") != tc.err || loc.Query().Get("state") != "st-1" {
+				t.Fatalf("Unexpected redirect %v", loc)
+			}
+		})
+	}
+}
+
+func login(t *testing.T, browser *http.Client, authorizeURL, username, password string) string {
+	res, err := browser.PostForm(authorizeURL, url.Values{"username": {username}, "password": {password}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return codeFrom(t, res)
+}
+
+func codeFrom(t *testing.T, res *http.Response) string {
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("Expecting a redirect got %v", res.StatusCode)
+	}
+	loc, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(loc.String(), "https://app.example.com/cb?") || loc.Query().Get("state") != "st-1" {
+		t.Fatalf("Unexpected redirect %v", loc)
+	}
+	return loc.Query().Get("code")
+}
+
+func tokenRequest(t *testing.T, endpoint, clientID, secret string, form url.Values) (int, map[string]any) {
+	req, _ := http.NewRequest("POST", endpoint, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(secret))
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var out map[string]any
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, out
+}
+
+func getJSON(t *testing.T, c *http.Client, url string, out any) {
+	res, err := c.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("GET %v returned %v", url, res.StatusCode)
+	}
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,260 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+type (
+	// PasswordPolicy is checked every time a password is set,
+	// existing passwords are not affected when the policy changes
+	PasswordPolicy struct {
+		MinLength int
+		// MaxLength bounds the cost of hashing a password, zero means unlimited
+		MaxLength int
+		// MinClasses is the number of distinct character classes (lowercase,
+		// uppercase, digits and symbols) a password must use
+		MinClasses int
+		// History rejects passwords equal to any of the last N passwords of the user
+		History int
+		// Breached rejects passwords known to be leaked, it might be nil
+		Breached BreachedChecker
+	}
+
+	// BreachedChecker reports whether a password is known to be compromised
+	BreachedChecker interface {
+		Breached(passwd []byte) (bool, error)
+	}
+
+	// PolicyError lists every rule a password failed to satisfy
+	PolicyError struct {
+		Violations []string
+	}
+
+	// BreachedFormat tells how the entries of a breached password file are encoded
+	BreachedFormat string
+
+	breachedSet map[[sha1.Size]byte]struct{}
+
+	breachedRangeDir string
+)
+
+const (
+	// BreachedPlain files list one password per line
+	BreachedPlain = BreachedFormat("plain")
+	// BreachedSHA1 files list the SHA-1 of one password per line in hex,
+	// optionally followed by :<count> (the Have I Been Pwned format)
+	BreachedSHA1 = BreachedFormat("sha1")
+)
+
+var (
+	// DefaultPasswordPolicy only rejects empty and very long passwords
+	DefaultPasswordPolicy = PasswordPolicy{
+		MinLength: 1,
+		MaxLength: 1024,
+	}
+
+	passwordPolicyLock sync.RWMutex
+	passwordPolicy     = DefaultPasswordPolicy
+)
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("auth: password rejected: %v", strings.Join(e.Violations, ", "))
+}
+
+// SetPasswordPolicy changes the policy used by RegisterUser and ReplacePassword
+func SetPasswordPolicy(p PasswordPolicy) error {
+	if p.MinLength < 1 {
+		return errors.New("auth: password policy must require at least 1 character")
+	}
+	if p.MaxLength != 0 && p.MaxLength < p.MinLength {
+		return errors.New("auth: password policy max length is smaller than min length")
+	}
+	if p.MinClasses < 0 || p.MinClasses > 4 {
+		return errors.New("auth: password policy min classes must be between 0 and 4")
+	}
+	if p.History < 0 {
+		return errors.New("auth: password policy history cannot be negative")
+	}
+	passwordPolicyLock.Lock()
+	passwordPolicy = p
+	passwordPolicyLock.Unlock()
+	return nil
+}
+
+// CurrentPasswordPolicy returns the policy used to validate new passwords
+func CurrentPasswordPolicy() PasswordPolicy {
+	passwordPolicyLock.RLock()
+	defer passwordPolicyLock.RUnlock()
+	return passwordPolicy
+}
+
+// Check validates passwd against every rule except History, which depends
+// on the user, the returned error is a *PolicyError when passwd is rejected
+func (p PasswordPolicy) Check(passwd []byte) error {
+	var violations []string
+	length := utf8.RuneCount(passwd)
+	if length < p.MinLength {
+		violations = append(violations, fmt.Sprintf("must have at least %v characters", p.MinLength))
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, fmt.Sprintf("must have at most %v characters", p.MaxLength))
+	}
+	if p.MinClasses > 0 && charClasses(passwd) < p.MinClasses {
+		violations = append(violations, fmt.Sprintf("must mix at least %v of lowercase, uppercase, digits and symbols", p.MinClasses))
+	}
+	if p.Breached != nil && len(violations) == 0 {
+		breached, err := p.Breached.Breached(passwd)
+		if err != nil {
+			return err
+		}
+		if breached {
+			violations = append(violations, "appears in a list of breached passwords")
+		}
+	}
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// checkHistory rejects passwd if it matches the current password of u
+// or any of its previous passwords
+func (p PasswordPolicy) checkHistory(ctx context.Context, st Store, u UserRecord, passwd []byte) error {
+	if p.History <= 0 {
+		return nil
+	}
+	if ok, _, _ := verifyUserPassword(u, passwd); ok {
+		return &PolicyError{Violations: []string{"must be different from the current password"}}
+	}
+	if p.History == 1 {
+		return nil
+	}
+	previous, err := st.PasswordHistory(ctx, u.UID, p.History-1)
+	if err != nil {
+		return err
+	}
+	for _, hash := range previous {
+		if ok, _, _ := verifyPassword(hash, passwd); ok {
+			return &PolicyError{Violations: []string{fmt.Sprintf("must be different from the last %v passwords", p.History)}}
+		}
+	}
+	return nil
+}
+
+func charClasses(passwd []byte) int {
+	var lower, upper, digit, symbol int
+	for _, r := range string(passwd) {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	return lower + upper + digit + symbol
+}
+
+// LoadBreachedList accepts either a file or a directory.
+//
+// A file contains one entry per line encoded as format, every line of the
+// file must use the same format. Empty lines and lines starting with # are
+// ignored. The whole file is loaded in memory.
+//
+// A directory uses the k-anonymity layout, one file per 5 hex digits
+// prefix of the SHA-1 (eg.: 5BAA6 or 5BAA6.txt) containing <suffix>:<count> lines,
+// format is ignored. Files are read on demand so the full list is never loaded.
+func LoadBreachedList(path string, format BreachedFormat) (BreachedChecker, error) {
+	if format != BreachedPlain && format != BreachedSHA1 {
+		return nil, fmt.Errorf("auth: unknown breached list format %q, expecting %v or %v", format, BreachedPlain, BreachedSHA1)
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if stat.IsDir() {
+		return breachedRangeDir(path), nil
+	}
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	set := make(breachedSet)
+	sc := bufio.NewScanner(fd)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if format == BreachedPlain {
+			set[sha1.Sum([]byte(line))] = struct{}{}
+			continue
+		}
+		sum, ok := parseSHA1Line(line)
+		if !ok {
+			return nil, fmt.Errorf("auth: %v:%v is not a SHA-1 entry", path, n)
+		}
+		set[sum] = struct{}{}
+	}
+	return set, sc.Err()
+}
+
+func (b breachedSet) Breached(passwd []byte) (bool, error) {
+	_, found := b[sha1.Sum(passwd)]
+	return found, nil
+}
+
+func (b breachedRangeDir) Breached(passwd []byte) (bool, error) {
+	sum := sha1.Sum(passwd)
+	hexsum := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hexsum[:5], hexsum[5:]
+	var fd *os.File
+	var err error
+	for _, name := range []string{prefix, prefix + ".txt"} {
+		fd, err = os.Open(filepath.Join(string(b), name))
+		if err == nil || !errors.Is(err, os.ErrNotExist) {
+			break
+		}
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer fd.Close()
+	sc := bufio.NewScanner(fd)
+	for sc.Scan() {
+		candidate, _, _ := strings.Cut(strings.TrimSpace(sc.Text()), ":")
+		if strings.EqualFold(candidate, suffix) {
+			return true, nil
+		}
+	}
+	return false, sc.Err()
+}
+
+func parseSHA1Line(line string) ([sha1.Size]byte, bool) {
+	var sum [sha1.Size]byte
+	candidate, _, _ := strings.Cut(line, ":")
+	if len(candidate) != hex.EncodedLen(sha1.Size) {
+		return sum, false
+	}
+	if _, err := hex.Decode(sum[:], []byte(candidate)); err != nil {
+		return sum, false
+	}
+	return sum, true
+}
@@ -0,0 +1,120 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andrebq/auth"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	dir := t.TempDir()
+	plainFile, sha1File := filepath.Join(dir, "plain.txt"), filepath.Join(dir, "sha1.txt")
+	// a plain password which looks like a SHA-1 must not be read as one
+	if err := os.WriteFile(plainFile, []byte("# common\npassword\nDeadBeef0123456789abcdef0123456789ABCDEF\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// the SHA-1 of "Summer2024!" in the HIBP format
+	if err := os.WriteFile(sha1File, []byte("7E8B0A3433F1210A9699D85420E363A1B162ECAC:12\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.LoadBreachedList(plainFile, auth.BreachedSHA1); err == nil {
+		t.Fatal("Plain entries should be rejected in a sha1 file")
+	}
+	plain, err := auth.LoadBreachedList(plainFile, auth.BreachedPlain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashed, err := auth.LoadBreachedList(sha1File, auth.BreachedSHA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := auth.PasswordPolicy{MinLength: 8, MaxLength: 64, MinClasses: 3, Breached: breachedAny{plain, hashed}}
+	for _, tc := range []struct {
+		passwd string
+		ok     bool
+	}{
+		{"Sh0rt!", false},
+		{"alllowercase", false},
+		{"Lower-and-upper", true},
+		{"Way-Too-Long-Password-1-Way-Too-Long-Password-1-Way-Too-Long-Password-1", false},
+		{"DeadBeef0123456789abcdef0123456789ABCDEF", false},
+		{"password", false},
+		{"Summer2024!", false},
+		{"Correct-Horse-1", true},
+	} {
+		err := policy.Check([]byte(tc.passwd))
+		var perr *auth.PolicyError
+		if tc.ok && err != nil {
+			t.Errorf("%q should be accepted got %v", tc.passwd, err)
+		} else if !tc.ok && !errors.As(err, &perr) {
+			t.Errorf("%q should be rejected with a PolicyError got %v", tc.passwd, err)
+		}
+	}
+}
+
+// breachedAny reports passwords found by any of the checkers
+type breachedAny []auth.BreachedChecker
+
+func (b breachedAny) Breached(passwd []byte) (bool, error) {
+	for _, c := range b {
+		if found, err := c.Breached(passwd); err != nil || found {
+			return found, err
+		}
+	}
+	return false, nil
+}
+
+func TestBreachedRangeDir(t *testing.T) {
+	dir := t.TempDir()
+	// SHA-1 of "password" is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+	if err := os.WriteFile(filepath.Join(dir, "5BAA6.txt"), []byte("0018A45C4D1DEF81644B54AB7F969B88D65:1\n1E4C9B93F3F0682250B6CF8331B7EE68FD8:9545824\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	breached, err := auth.LoadBreachedList(dir, auth.BreachedSHA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found, err := breached.Breached([]byte("password")); err != nil || !found {
+		t.Fatalf("password should be breached got %v, %v", found, err)
+	}
+	if found, err := breached.Breached([]byte("not-in-the-list")); err != nil || found {
+		t.Fatalf("Missing range file should not be breached got %v, %v", found, err)
+	}
+}
+
+func TestPasswordHistory(t *testing.T) {
+	eachStore(t, func(t *testing.T, st auth.Store) {
+		if err := auth.SetPasswordPolicy(auth.PasswordPolicy{MinLength: 1, History: 3}); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { auth.SetPasswordPolicy(auth.DefaultPasswordPolicy) })
+		ctx := context.Background()
+		if _, err := auth.RegisterUser(ctx, st, "bob", []byte("first")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := auth.RegisterUser(ctx, st, "alice", nil); err == nil {
+			t.Fatal("Empty passwords should be rejected")
+		}
+		var perr *auth.PolicyError
+		for _, next := range []string{"second", "third"} {
+			if err := auth.ReplacePassword(ctx, st, "bob", []byte(next)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, reused := range []string{"first", "second", "third"} {
+			if err := auth.ReplacePassword(ctx, st, "bob", []byte(reused)); !errors.As(err, &perr) {
+				t.Fatalf("Reusing %v should be rejected got %v", reused, err)
+			}
+		}
+		if err := auth.ReplacePassword(ctx, st, "bob", []byte("fourth")); err != nil {
+			t.Fatal(err)
+		}
+		if err := auth.ReplacePassword(ctx, st, "bob", []byte("first")); err != nil {
+			t.Fatalf("Passwords older than the history should be accepted got %v", err)
+		}
+	})
+}
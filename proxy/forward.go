@@ -0,0 +1,162 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/andrebq/auth/client"
+)
+
+// Headers set by the forward-auth endpoint, reverse proxies copy them
+// to the upstream request (eg.: nginx auth_request_set, Traefik
+// authResponseHeaders, Caddy copy_headers)
+const (
+	HeaderUser      = "X-Auth-User"
+	HeaderUID       = "X-Auth-UID"
+	HeaderGroups    = "X-Auth-Groups"
+	HeaderTokenType = "X-Auth-Token-Type"
+)
+
+// ForwardAuthHandler serves the login pages and /forward-auth, which lets
+// reverse proxies such as nginx (auth_request), Traefik (forwardAuth) and
+// Caddy (forward_auth) delegate authentication to auth.
+//
+// /forward-auth answers 200 with the identity headers when the request has a
+// valid session cookie or bearer token. Otherwise browsers navigating to a page
+// are redirected to the login page of the original host, other requests get 401.
+// The original request is described by the X-Forwarded-Proto, X-Forwarded-Host,
+// X-Forwarded-Uri (or X-Original-URI) and X-Forwarded-Method headers, requests
+// without the original uri get 400.
+// nginx only accepts 2xx, 401 and 403 from auth_request, so ?status=401 turns
+// the redirect into a 401 which carries the login url in the Location header.
+// The identity token configured by WithIdentityJWT is also returned.
+// Rules given by WithRules are applied to the original request, bearer
+// tokens are always accepted.
+//
+// The reverse proxy must route /.auth/ of every protected host to this handler.
+func ForwardAuthHandler(apiBase string, opts ...Option) (http.Handler, error) {
+	mux := http.NewServeMux()
+	cli := client.New(apiBase)
+	cfg := newConfig(cli, opts)
+	handleUI(mux, cli, cfg)
+	mux.Handle("/forward-auth", handleForwardAuth(cfg))
+	return mux, nil
+}
+
+func handleForwardAuth(cfg config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		orig, err := forwardedRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rule := cfg.rules.match(orig)
+		if rule.Public {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		info, ok := requestToken(r, cfg.validator)
+		if !ok {
+			denyForward(w, r)
+			return
+		}
+		if !rule.allows(info.Groups) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		h := w.Header()
+		h.Set(HeaderUser, info.Login)
+		h.Set(HeaderUID, info.UID)
+		h.Set(HeaderTokenType, info.TokenType)
+		if len(info.Groups) > 0 {
+			h.Set(HeaderGroups, strings.Join(info.Groups, ","))
+		}
+		forwardAttributes(h, info.Attributes)
+		if cfg.identityJWT != nil {
+			token, err := cfg.identityJWT.sign(info)
+			if err != nil {
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			h.Set(cfg.identityJWT.Header, token)
+		}
+		h.Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// requestToken validates the session cookie, the bearer token is tried
+// when the request has no cookie or the cookie is no longer valid
+func requestToken(r *http.Request, validator TokenValidator) (client.TokenInfo, bool) {
+	if cookie, err := r.Cookie("auth.session"); err == nil && cookie.Value != "" {
+		if info, ok := validCookie(r.Context(), cookie, validator); ok {
+			return info, true
+		}
+	}
+	bearer, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || bearer == "" {
+		return client.TokenInfo{}, false
+	}
+	return validCookie(r.Context(), &http.Cookie{Value: bearer}, validator)
+}
+
+// forwardedRequest describes the original request so rules can be applied to it,
+// requests without a valid original uri are rejected instead of guessing one
+func forwardedRequest(r *http.Request) (*http.Request, error) {
+	uri := forwardedURI(r)
+	if uri == "" {
+		return nil, errors.New("missing X-Forwarded-Uri header")
+	}
+	u, err := url.ParseRequestURI(uri)
+	if err != nil {
+		return nil, errors.New("invalid X-Forwarded-Uri header")
+	}
+	return &http.Request{Method: forwardedMethod(r), URL: u}, nil
+}
+
+func forwardedMethod(r *http.Request) string {
+	if method := r.Header.Get("X-Forwarded-Method"); method != "" {
+		return method
+	}
+	return r.Method
+}
+
+func forwardedURI(r *http.Request) string {
+	if uri := r.Header.Get("X-Forwarded-Uri"); uri != "" {
+		return uri
+	}
+	return r.Header.Get("X-Original-URI")
+}
+
+func denyForward(w http.ResponseWriter, r *http.Request) {
+	method := forwardedMethod(r)
+	host := r.Header.Get("X-Forwarded-Host")
+	browser := method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html")
+	if host == "" || !browser {
+		http.Error(w, "Please login and try again", http.StatusUnauthorized)
+		return
+	}
+	proto := r.Header.Get("X-Forwarded-Proto")
+	if proto == "" {
+		proto = "https"
+	}
+	uri := forwardedURI(r)
+	loginURL := url.URL{Scheme: proto, Host: host, Path: "/.auth/login"}
+	if safeRedirect(uri) {
+		loginURL.RawQuery = url.Values{"rd": []string{uri}}.Encode()
+	}
+	if r.URL.Query().Get("status") == "401" {
+		w.Header().Set("Location", loginURL.String())
+		http.Error(w, "Please login and try again", http.StatusUnauthorized)
+		return
+	}
+	http.Redirect(w, r, loginURL.String(), http.StatusFound)
+}
+
+// safeRedirect reports whether rd is a path on the same host,
+// so the login page cannot be used as an open redirect
+func safeRedirect(rd string) bool {
+	return strings.HasPrefix(rd, "/") && !strings.HasPrefix(rd, "//") && !strings.HasPrefix(rd, "/\\")
+}
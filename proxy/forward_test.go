@@ -0,0 +1,85 @@
+package proxy_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/andrebq/auth"
+	"github.com/andrebq/auth/api"
+	"github.com/andrebq/auth/proxy"
+)
+
+func TestForwardAuth(t *testing.T) {
+	ctx := context.Background()
+	db, err := auth.OpenMemory(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	server := httptest.NewServer(api.Handler(db))
+	defer server.Close()
+	if _, err := auth.RegisterUser(ctx, db, "bob", []byte("1234")); err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.CreateGroup(ctx, db, "admins"); err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.AddToGroup(ctx, db, "admins", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	token, err := auth.CreateToken(ctx, db, "bob", auth.TokenTypeSession, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := proxy.ForwardAuthHandler(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forward := func(query string, setup func(r *http.Request)) *http.Response {
+		req := httptest.NewRequest("GET", "/forward-auth"+query, nil)
+		req.Header.Set("X-Forwarded-Host", "app.example.com")
+		req.Header.Set("X-Forwarded-Uri", "/reports?year=2024")
+		setup(req)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Result()
+	}
+
+	res := forward("", func(r *http.Request) {
+		r.AddCookie(&http.Cookie{Name: "auth.session", Value: token})
+		// identity headers sent by the client must never be trusted
+		r.Header.Set(proxy.HeaderUser, "mallory")
+	})
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected status %v", res.StatusCode)
+	} else if res.Header.Get(proxy.HeaderUser) != "bob" || res.Header.Get(proxy.HeaderGroups) != "admins" {
+		t.Fatalf("Unexpected identity headers %v", res.Header)
+	}
+	if res := forward("", func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }); res.StatusCode != http.StatusOK {
+		t.Fatalf("Bearer token should be accepted got %v", res.StatusCode)
+	}
+	res = forward("", func(r *http.Request) {
+		r.AddCookie(&http.Cookie{Name: "auth.session", Value: "stale:dG9rZW4="})
+		r.Header.Set("Authorization", "Bearer "+token)
+	})
+	if res.StatusCode != http.StatusOK || res.Header.Get(proxy.HeaderUser) != "bob" {
+		t.Fatalf("Bearer token should be used when the cookie is stale got %v", res.StatusCode)
+	}
+
+	loginURL := "https://app.example.com/.auth/login?" + url.Values{"rd": []string{"/reports?year=2024"}}.Encode()
+	res = forward("", func(r *http.Request) { r.Header.Set("Accept", "text/html") })
+	if res.StatusCode != http.StatusFound || res.Header.Get("Location") != loginURL {
+		t.Fatalf("Browsers should be redirected to the login page got %v %v", res.StatusCode, res.Header.Get("Location"))
+	}
+	res = forward("?status=401", func(r *http.Request) { r.Header.Set("Accept", "text/html") })
+	if res.StatusCode != http.StatusUnauthorized || res.Header.Get("Location") != loginURL {
+		t.Fatalf("nginx mode should return 401 with the login url got %v %v", res.StatusCode, res.Header.Get("Location"))
+	}
+	if res := forward("", func(r *http.Request) { r.Header.Set("X-Forwarded-Method", "POST") }); res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Non browser requests should get 401 got %v", res.StatusCode)
+	}
+}
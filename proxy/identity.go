@@ -0,0 +1,210 @@
+package proxy
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/andrebq/auth/client"
+	"github.com/andrebq/auth/jwt"
+)
+
+type (
+	// IdentityHeaders names the headers used to tell upstream who the user
+	// is, an empty name disables that header. Headers with these names sent
+	// by clients are always removed.
+	IdentityHeaders struct {
+		User      string
+		UID       string
+		TokenType string
+		// Groups holds the groups of the user separated by commas
+		Groups string
+	}
+
+	// IdentityJWT makes the proxy send the identity as a short-lived token
+	// signed by Key, upstream can verify it with the keys published at
+	// /.auth/jwks instead of trusting the network between them
+	IdentityJWT struct {
+		Header   string
+		Key      jwt.Key
+		TTL      time.Duration
+		Issuer   string
+		Audience string
+	}
+
+	// IdentityClaims is the payload of the identity token
+	IdentityClaims struct {
+		jwt.Claims
+		Login      string            `json:"login"`
+		TokenType  string            `json:"token_type"`
+		Groups     []string          `json:"groups,omitempty"`
+		Attributes map[string]string `json:"attrs,omitempty"`
+	}
+)
+
+var (
+	// DefaultIdentityHeaders are used unless WithIdentityHeaders is given
+	DefaultIdentityHeaders = IdentityHeaders{
+		User:      "X-Forwarded-User",
+		UID:       "X-Forwarded-UID",
+		TokenType: "X-Forwarded-Token-Type",
+		Groups:    "X-Forwarded-Groups",
+	}
+)
+
+const (
+	// DefaultIdentityHeader carries the identity token unless stated otherwise
+	DefaultIdentityHeader = "X-Forwarded-Identity"
+	// DefaultIdentityTTL is how long identity tokens are valid, they are
+	// created for every request so they only need to survive the trip upstream
+	DefaultIdentityTTL = time.Minute
+)
+
+// WithIdentityHeaders changes the names of the identity headers sent to upstream
+func WithIdentityHeaders(h IdentityHeaders) Option {
+	return func(c *config) {
+		c.identity = h
+	}
+}
+
+// WithIdentityJWT sends a signed identity token to upstream in addition
+// to the identity headers
+func WithIdentityJWT(j IdentityJWT) Option {
+	return func(c *config) {
+		if j.Header == "" {
+			j.Header = DefaultIdentityHeader
+		}
+		if j.TTL <= 0 {
+			j.TTL = DefaultIdentityTTL
+		}
+		c.identityJWT = &j
+	}
+}
+
+// forwardIdentity replaces any identity header sent by the client with
+// the identity of the authenticated user
+func forwardIdentity(h http.Header, cfg config, info client.TokenInfo) error {
+	stripIdentity(h, cfg)
+	setHeader(h, cfg.identity.User, info.Login)
+	setHeader(h, cfg.identity.UID, info.UID)
+	setHeader(h, cfg.identity.TokenType, info.TokenType)
+	setHeader(h, cfg.identity.Groups, strings.Join(info.Groups, ","))
+	forwardAttributes(h, info.Attributes)
+	if cfg.identityJWT == nil {
+		return nil
+	}
+	token, err := cfg.identityJWT.sign(info)
+	if err != nil {
+		return err
+	}
+	h.Set(cfg.identityJWT.Header, token)
+	return nil
+}
+
+// stripIdentity removes every identity header, requests to public paths
+// must not be able to claim an identity either
+func stripIdentity(h http.Header, cfg config) {
+	for _, name := range []string{cfg.identity.User, cfg.identity.UID, cfg.identity.TokenType, cfg.identity.Groups} {
+		if name != "" {
+			h.Del(name)
+		}
+	}
+	if cfg.identityJWT != nil {
+		h.Del(cfg.identityJWT.Header)
+	}
+	forwardAttributes(h, nil)
+}
+
+func setHeader(h http.Header, name, value string) {
+	if name != "" && value != "" {
+		h.Set(name, value)
+	}
+}
+
+func (j *IdentityJWT) sign(info client.TokenInfo) (string, error) {
+	now := time.Now()
+	claims := IdentityClaims{
+		Claims: jwt.Claims{
+			Issuer:    j.Issuer,
+			Subject:   info.UID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(j.TTL).Unix(),
+		},
+		Login:      info.Login,
+		TokenType:  info.TokenType,
+		Groups:     info.Groups,
+		Attributes: info.Attributes,
+	}
+	if j.Audience != "" {
+		claims.Audience = jwt.Audience{j.Audience}
+	}
+	return jwt.Sign(j.Key, claims)
+}
+
+// LoadIdentityKey reads a PEM encoded PKCS #8 private key (Ed25519 or RSA)
+// to sign identity tokens, every replica of the proxy should use the same key.
+// An empty file generates a new Ed25519 key.
+func LoadIdentityKey(file string) (jwt.Key, error) {
+	var signer crypto.Signer
+	if file == "" {
+		var err error
+		if signer, err = jwt.GenerateKey(jwt.EdDSA); err != nil {
+			return jwt.Key{}, err
+		}
+	} else {
+		buf, err := os.ReadFile(file)
+		if err != nil {
+			return jwt.Key{}, err
+		}
+		block, _ := pem.Decode(buf)
+		if block == nil || block.Type != "PRIVATE KEY" {
+			return jwt.Key{}, fmt.Errorf("proxy: %v is not a PEM encoded PKCS #8 private key", file)
+		}
+		priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return jwt.Key{}, err
+		}
+		var ok bool
+		if signer, ok = priv.(crypto.Signer); !ok {
+			return jwt.Key{}, fmt.Errorf("proxy: unsupported key type %T", priv)
+		}
+	}
+	// the id is derived from the public key so replicas sharing a key agree on it
+	der, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return jwt.Key{}, err
+	}
+	sum := sha256.Sum256(der)
+	key := jwt.Key{ID: hex.EncodeToString(sum[:8]), Signer: signer}
+	if _, err := key.Algorithm(); err != nil {
+		return jwt.Key{}, err
+	}
+	return key, nil
+}
+
+// handleJWKS publishes the key which verifies identity tokens
+func handleJWKS(j *IdentityJWT) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pub, err := j.Key.Public()
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		buf, err := json.Marshal(jwt.JWKS{Keys: []jwt.JWK{pub}})
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		w.Write(buf)
+	})
+}
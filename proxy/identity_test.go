@@ -0,0 +1,89 @@
+package proxy_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andrebq/auth"
+	"github.com/andrebq/auth/api"
+	"github.com/andrebq/auth/jwt"
+	"github.com/andrebq/auth/proxy"
+)
+
+func TestProxyIdentity(t *testing.T) {
+	ctx := context.Background()
+	db, err := auth.OpenMemory(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	server := httptest.NewServer(api.Handler(db))
+	defer server.Close()
+	uid, err := auth.RegisterUser(ctx, db, "bob", []byte("1234"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.CreateGroup(ctx, db, "admins"); err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.AddToGroup(ctx, db, "admins", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	token, err := auth.CreateToken(ctx, db, "bob", auth.TokenTypeSession, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var received http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+	}))
+	defer upstream.Close()
+	key, err := proxy.LoadIdentityKey("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(handler http.Handler, path string, header http.Header) *http.Response {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header = header
+		req.AddCookie(&http.Cookie{Name: "auth.session", Value: token})
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Result()
+	}
+
+	handler, err := proxy.Handler(upstream.URL, server.URL, proxy.WithIdentityJWT(proxy.IdentityJWT{Key: key, Audience: "app"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// identity headers sent by the client must never reach upstream
+	spoofed := http.Header{"X-Forwarded-User": {"mallory"}, "X-Forwarded-Groups": {"root"}, proxy.DefaultIdentityHeader: {"forged"}}
+	if res := get(handler, "/", spoofed); res.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected status %v", res.StatusCode)
+	}
+	if received.Get("X-Forwarded-User") != "bob" || received.Get("X-Forwarded-UID") != uid || received.Get("X-Forwarded-Groups") != "admins" {
+		t.Fatalf("Unexpected identity headers %v", received)
+	}
+	var keys jwt.JWKS
+	if err := json.NewDecoder(get(handler, "/.auth/jwks", http.Header{}).Body).Decode(&keys); err != nil {
+		t.Fatal(err)
+	}
+	var claims proxy.IdentityClaims
+	if err := jwt.Verify(received.Get(proxy.DefaultIdentityHeader), keys, &claims); err != nil {
+		t.Fatal(err)
+	} else if err := claims.Validate(time.Now(), "", "app"); err != nil || claims.Subject != uid || claims.Login != "bob" {
+		t.Fatalf("Unexpected claims %#v, %v", claims, err)
+	}
+
+	handler, err = proxy.Handler(upstream.URL, server.URL, proxy.WithIdentityHeaders(proxy.IdentityHeaders{User: "X-Remote-User"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	get(handler, "/", http.Header{"X-Remote-User": {"mallory"}})
+	if received.Get("X-Remote-User") != "bob" || received.Get("X-Forwarded-UID") != "" || received.Get(proxy.DefaultIdentityHeader) != "" {
+		t.Fatalf("Only the configured headers should be sent got %v", received)
+	}
+}
@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/andrebq/auth/client"
+	"github.com/andrebq/auth/internal/usererror"
+)
+
+type (
+	// passwordForm is used by every page where the user
+	// chooses a password using a single use token
+	passwordForm struct {
+		Title  string
+		Action string
+		Token  string
+		Error  string
+
+		// submit sends the token and the new password to the api
+		submit func(ctx context.Context, token, password string) error
+		done   message
+	}
+
+	message struct {
+		Title   string
+		Message string
+	}
+)
+
+func handleForgotUI(cli *client.C) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			renderTemplate(w, loginTmpl, http.StatusOK, "forgot", struct{ Error string }{})
+		case "POST":
+			requestReset(w, r, cli)
+		}
+	})
+}
+
+func requestReset(w http.ResponseWriter, req *http.Request, cli *client.C) {
+	username := req.FormValue("username")
+	if len(username) == 0 {
+		renderTemplate(w, loginTmpl, http.StatusBadRequest, "forgot", struct{ Error string }{Error: "Please inform your username"})
+		return
+	}
+	if err := cli.RequestPasswordReset(req.Context(), username); err != nil {
+		renderTemplate(w, loginTmpl, http.StatusInternalServerError, "unavailable", struct{ Error string }{Error: "Password reset is not available at the moment, please contact your administrator"})
+		return
+	}
+	renderTemplate(w, loginTmpl, http.StatusOK, "message", message{
+		Title:   "Check your inbox",
+		Message: "If the account exists you will receive instructions to reset your password shortly",
+	})
+}
+
+func handleResetUI(cli *client.C) http.Handler {
+	return handlePasswordForm(passwordForm{
+		Title:  "Choose a new password",
+		Action: "./reset",
+		submit: cli.ResetPassword,
+		done: message{
+			Title:   "Password updated",
+			Message: "Your password was updated, please login again",
+		},
+	})
+}
+
+func handleInviteUI(cli *client.C) http.Handler {
+	return handlePasswordForm(passwordForm{
+		Title:  "Welcome, choose your password",
+		Action: "./accept-invite",
+		submit: cli.AcceptInvite,
+		done: message{
+			Title:   "Account activated",
+			Message: "Your account is ready, please login with your new password",
+		},
+	})
+}
+
+func handlePasswordForm(page passwordForm) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		form := page
+		switch r.Method {
+		case "GET":
+			form.Token = r.URL.Query().Get("token")
+			renderTemplate(w, loginTmpl, http.StatusOK, "set-password", form)
+		case "POST":
+			form.Token = r.FormValue("token")
+			submitPassword(w, r, form)
+		}
+	})
+}
+
+func submitPassword(w http.ResponseWriter, req *http.Request, form passwordForm) {
+	password := req.FormValue("password")
+	switch {
+	case len(form.Token) == 0:
+		form.Error = "The link is incomplete, please check your message"
+	case len(password) == 0:
+		form.Error = "Please inform your new password"
+	case password != req.FormValue("confirm"):
+		form.Error = "Passwords do not match"
+	}
+	if form.Error != "" {
+		renderTemplate(w, loginTmpl, http.StatusBadRequest, "set-password", form)
+		return
+	}
+	err := form.submit(req.Context(), form.Token, password)
+	var ue usererror.E
+	switch {
+	case err == nil:
+		renderTemplate(w, loginTmpl, http.StatusOK, "message", form.done)
+	case errors.As(err, &ue) && ue.Status == http.StatusUnprocessableEntity:
+		form.Error = ue.Message
+		renderTemplate(w, loginTmpl, http.StatusBadRequest, "set-password", form)
+	case errors.As(err, &ue) && ue.Status == http.StatusUnauthorized:
+		form.Error = "The link is invalid or expired, please ask for a new one"
+		renderTemplate(w, loginTmpl, http.StatusBadRequest, "set-password", form)
+	default:
+		renderTemplate(w, loginTmpl, http.StatusInternalServerError, "unavailable", struct{ Error string }{Error: "Cannot update your password at the moment, please try again later"})
+	}
+}
@@ -8,11 +8,47 @@ import (
 	"net/http/httputil"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/andrebq/auth/client"
 )
 
+type (
+	// LoginPage is the data used to render the login form, servers other
+	// than the proxy (eg.: the oidc provider) use it to present the same page
+	LoginPage struct {
+		// Action is where the form is posted, the form has the username
+		// and password fields
+		Action string
+		// ForgotURL is the link to the password reset page, the link is
+		// hidden when empty
+		ForgotURL string
+		Error     string
+	}
+
+	// TokenValidator checks session cookies, both client.C and
+	// client.Verifier implement it
+	TokenValidator interface {
+		ValidateTokenInfo(ctx context.Context, token string) (client.TokenInfo, error)
+	}
+
+	// Option customizes the behaviour of Handler
+	Option func(*config)
+
+	config struct {
+		validator    TokenValidator
+		identity     IdentityHeaders
+		identityJWT  *IdentityJWT
+		rules        Rules
+		cookieDomain string
+	}
+)
+
+// attrHeaderPrefix is used to forward user attributes to upstream,
+// eg.: the email attribute is sent as X-Auth-Attr-Email
+const attrHeaderPrefix = "X-Auth-Attr-"
+
 var (
 	loginTmpl = template.Must(template.New("__root__").Parse(`
 {{ define "unavailable" }}
@@ -30,6 +66,18 @@ var (
 	</body>
 </html>
 {{ end }}
+{{ define "forbidden" }}
+<!DOCTYPE html>
+<html>
+	<head>
+		<title>Forbidden</title>
+	</head>
+	<body>
+		<p class="error">{{ if .Login }}{{.Login}}, you{{ else }}You{{ end }} are not allowed to access this page</p>
+		<p><a href="/.auth/login">Login as another user</a></p>
+	</body>
+</html>
+{{ end }}
 {{ define "login" }}
 <!DOCTYPE html>
 <html>
@@ -37,7 +85,7 @@ var (
 		<title>Please login</title>
 	</head>
 	<body>
-		<form method="POST" action="./login">
+		<form method="POST" action="{{.Action}}">
 			<fieldset>
 				<caption>Login credentials</caption>
 				<section>
@@ -56,37 +104,141 @@ var (
 				{{ end}}
 			</fieldset>
 		</form>
+		{{ if .ForgotURL }}
+		<p><a href="{{.ForgotURL}}">Forgot your password?</a></p>
+		{{ end }}
+	</body>
+</html>
+{{ end }}
+{{ define "forgot" }}
+<!DOCTYPE html>
+<html>
+	<head>
+		<title>Reset your password</title>
+	</head>
+	<body>
+		<form method="POST" action="./forgot">
+			<fieldset>
+				<caption>Reset your password</caption>
+				<section>
+					<label for="username">Username</label>
+					<input id="username" name="username" placeholder="username"/>
+				</section>
+				<section>
+					<button>Send reset instructions</button>
+				</section>
+				{{ if .Error }}
+				<p class="error">{{.Error}}</p>
+				{{ end}}
+			</fieldset>
+		</form>
+	</body>
+</html>
+{{ end }}
+{{ define "set-password" }}
+<!DOCTYPE html>
+<html>
+	<head>
+		<title>{{.Title}}</title>
+	</head>
+	<body>
+		<form method="POST" action="{{.Action}}">
+			<input type="hidden" name="token" value="{{.Token}}"/>
+			<fieldset>
+				<caption>{{.Title}}</caption>
+				<section>
+					<label for="password">New password</label>
+					<input id="password" name="password" type="password"/>
+				</section>
+				<section>
+					<label for="confirm">Confirm password</label>
+					<input id="confirm" name="confirm" type="password"/>
+				</section>
+				<section>
+					<button>Save password</button>
+				</section>
+				{{ if .Error }}
+				<p class="error">{{.Error}}</p>
+				{{ end}}
+			</fieldset>
+		</form>
+	</body>
+</html>
+{{ end }}
+{{ define "message" }}
+<!DOCTYPE html>
+<html>
+	<head>
+		<title>{{.Title}}</title>
+	</head>
+	<body>
+		<p>{{.Message}}</p>
+		<p><a href="./login">Back to login</a></p>
 	</body>
 </html>
 {{ end }}
 `))
 )
 
-func Handler(upstreamBase string, apiBase string) (http.Handler, error) {
-	upstreamURL, err := url.Parse(upstreamBase)
-	if err != nil {
-		return nil, err
+// WithValidator replaces the auth API as the validator of session cookies,
+// eg.: a client.Verifier validates signed tokens locally
+func WithValidator(v TokenValidator) Option {
+	return func(c *config) {
+		c.validator = v
+	}
+}
+
+func Handler(upstreamBase string, apiBase string, opts ...Option) (http.Handler, error) {
+	return RoutesHandler([]Route{{Upstream: upstreamBase}}, apiBase, opts...)
+}
+
+func newConfig(cli *client.C, opts []Option) config {
+	cfg := config{validator: cli, identity: DefaultIdentityHeaders}
+	for _, o := range opts {
+		o(&cfg)
+	}
+	return cfg
+}
+
+// handleUI registers the pages used by people to login and manage their password
+func handleUI(mux *http.ServeMux, cli *client.C, cfg config) {
+	mux.Handle("/.auth/login", handleLoginUI(cli, cfg))
+	mux.Handle("/.auth/forgot", handleForgotUI(cli))
+	mux.Handle("/.auth/reset", handleResetUI(cli))
+	mux.Handle("/.auth/accept-invite", handleInviteUI(cli))
+	if cfg.identityJWT != nil {
+		mux.Handle("/.auth/jwks", handleJWKS(cfg.identityJWT))
 	}
-	mux := http.NewServeMux()
-	cli := client.New(apiBase)
-	mux.Handle("/.auth/login", handleLoginUI(cli))
-	mux.Handle("/", handleProxy(upstreamURL, cli))
-	return mux, nil
 }
 
-func handleLoginUI(cli *client.C) http.Handler {
+func handleLoginUI(cli *client.C, cfg config) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case "GET":
 			renderLoginUI(w, r)
 		case "POST":
-			loginAndRedirect(w, r, cli)
+			loginAndRedirect(w, r, cli, cfg)
 		}
 	})
 }
 
 func renderLoginUI(w http.ResponseWriter, req *http.Request) {
-	renderTemplate(w, loginTmpl, http.StatusOK, "login", struct{ Error string }{})
+	RenderLogin(w, http.StatusOK, proxyLoginPage(req, ""))
+}
+
+// RenderLogin writes the login page with the given status
+func RenderLogin(w http.ResponseWriter, status int, page LoginPage) {
+	renderTemplate(w, loginTmpl, status, "login", page)
+}
+
+// proxyLoginPage keeps the rd parameter, set by the forward-auth endpoint,
+// so people return to the page they tried to access
+func proxyLoginPage(req *http.Request, err string) LoginPage {
+	action := "./login"
+	if rd := req.URL.Query().Get("rd"); safeRedirect(rd) {
+		action += "?" + url.Values{"rd": []string{rd}}.Encode()
+	}
+	return LoginPage{Action: action, ForgotURL: "./forgot", Error: err}
 }
 
 func renderTemplate(w http.ResponseWriter, tmpl *template.Template, status int, name string, data interface{}) {
@@ -103,16 +255,16 @@ func renderTemplate(w http.ResponseWriter, tmpl *template.Template, status int,
 	w.Write(buf.Bytes())
 }
 
-func loginAndRedirect(w http.ResponseWriter, req *http.Request, cli *client.C) {
+func loginAndRedirect(w http.ResponseWriter, req *http.Request, cli *client.C, cfg config) {
 	err := req.ParseForm()
 	if err != nil {
-		renderTemplate(w, loginTmpl, http.StatusOK, "login", struct{ Error string }{Error: err.Error()})
+		RenderLogin(w, http.StatusOK, proxyLoginPage(req, err.Error()))
 		return
 	}
 	username := req.FormValue("username")
 	password := req.FormValue("password")
 	if len(username) == 0 || len(password) == 0 {
-		renderTemplate(w, loginTmpl, http.StatusBadRequest, "login", struct{ Error string }{Error: "Please inform your username and password"})
+		RenderLogin(w, http.StatusBadRequest, proxyLoginPage(req, "Please inform your username and password"))
 		return
 	}
 	session, err := cli.StartSession(req.Context(), username, password, time.Hour*24)
@@ -131,44 +283,93 @@ func loginAndRedirect(w http.ResponseWriter, req *http.Request, cli *client.C) {
 		Domain:   req.URL.Hostname(),
 		Secure:   true,
 	}
+	if cfg.cookieDomain != "" {
+		cookie.Domain = cfg.cookieDomain
+	}
 	http.SetCookie(w, &cookie)
-	http.Redirect(w, req, "/", http.StatusSeeOther)
+	target := "/"
+	if rd := req.URL.Query().Get("rd"); safeRedirect(rd) {
+		target = rd
+	}
+	http.Redirect(w, req, target, http.StatusSeeOther)
 }
 
-func handleProxy(upstreamBase *url.URL, cli *client.C) http.Handler {
-	rev := httputil.NewSingleHostReverseProxy(upstreamBase)
+func handleProxy(rev *httputil.ReverseProxy, cfg config) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cookie, err := r.Cookie("auth.session")
-		if err != nil {
-			redirectOrFail(w, r)
+		rule := cfg.rules.match(r)
+		if rule.Public {
+			stripIdentity(r.Header, cfg)
+			stripSession(r)
+			rev.ServeHTTP(w, r)
 			return
 		}
-		if !validCookie(r.Context(), cookie, cli) {
+		var info client.TokenInfo
+		if cookie, err := r.Cookie("auth.session"); err == nil {
+			var ok bool
+			if info, ok = validCookie(r.Context(), cookie, cfg.validator); !ok {
+				redirectOrFail(w, r)
+				return
+			}
+		} else if bearer, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); found && rule.Bearer {
+			var ok bool
+			if info, ok = validCookie(r.Context(), &http.Cookie{Value: bearer}, cfg.validator); !ok {
+				http.Error(w, "Invalid bearer token", http.StatusUnauthorized)
+				return
+			}
+			// upstream gets the identity, not the credential
+			r.Header.Del("Authorization")
+		} else {
 			redirectOrFail(w, r)
 			return
 		}
-		var upstreamCookies []*http.Cookie
-		for _, v := range r.Cookies() {
-			if v.Name == "auth.session" {
-				continue
-			}
-			upstreamCookies = append(upstreamCookies, v)
+		if !rule.allows(info.Groups) {
+			renderTemplate(w, loginTmpl, http.StatusForbidden, "forbidden", struct{ Login string }{Login: info.Login})
+			return
 		}
-		r.Header.Del("Cookie")
-		for _, c := range upstreamCookies {
-			r.Header.Add("Cookie", c.String())
+		if err := forwardIdentity(r.Header, cfg, info); err != nil {
+			renderTemplate(w, loginTmpl, http.StatusInternalServerError, "unavailable", struct{ Error string }{})
+			return
 		}
+		stripSession(r)
 		rev.ServeHTTP(w, r)
 	})
 }
 
-func validCookie(ctx context.Context, c *http.Cookie, cli *client.C) bool {
+// stripSession removes the session cookie so upstream never sees it
+func stripSession(r *http.Request) {
+	var upstreamCookies []*http.Cookie
+	for _, v := range r.Cookies() {
+		if v.Name == "auth.session" {
+			continue
+		}
+		upstreamCookies = append(upstreamCookies, v)
+	}
+	r.Header.Del("Cookie")
+	for _, c := range upstreamCookies {
+		r.Header.Add("Cookie", c.String())
+	}
+}
+
+func validCookie(ctx context.Context, c *http.Cookie, validator TokenValidator) (client.TokenInfo, bool) {
 	// TODO: encrypt this cookie
-	_, _, err := cli.ValidateToken(ctx, c.Value)
+	info, err := validator.ValidateTokenInfo(ctx, c.Value)
 	if err != nil {
-		return false
+		return client.TokenInfo{}, false
+	}
+	return info, true
+}
+
+// forwardAttributes replaces any attribute header sent by the client
+// with the attributes of the authenticated user
+func forwardAttributes(h http.Header, attrs map[string]string) {
+	for name := range h {
+		if strings.HasPrefix(name, attrHeaderPrefix) {
+			h.Del(name)
+		}
+	}
+	for name, value := range attrs {
+		h.Set(attrHeaderPrefix+name, value)
 	}
-	return true
 }
 
 func redirectOrFail(w http.ResponseWriter, req *http.Request) {
@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/andrebq/auth/client"
+	"gopkg.in/yaml.v3"
+)
+
+type (
+	// Route sends requests for Host whose path starts with PathPrefix to
+	// Upstream. An empty Host matches every host and a host starting with
+	// "*." matches its subdomains.
+	Route struct {
+		Host       string `yaml:"host" json:"host"`
+		PathPrefix string `yaml:"pathPrefix" json:"pathPrefix"`
+		Upstream   string `yaml:"upstream" json:"upstream"`
+		// StripPrefix removes PathPrefix before the request is sent upstream
+		StripPrefix bool     `yaml:"stripPrefix" json:"stripPrefix"`
+		TLS         RouteTLS `yaml:"tls" json:"tls"`
+		// Rules replace the rules given by WithRules for this route
+		Rules []Rule `yaml:"rules" json:"rules"`
+	}
+
+	// RouteTLS configures how the proxy connects to https upstreams
+	RouteTLS struct {
+		// CAFile has the PEM certificates trusted to sign the upstream
+		// certificate, the system pool is used when empty
+		CAFile             string `yaml:"caFile" json:"caFile"`
+		ServerName         string `yaml:"serverName" json:"serverName"`
+		InsecureSkipVerify bool   `yaml:"insecureSkipVerify" json:"insecureSkipVerify"`
+	}
+
+	// Routes is the content of a routes file
+	Routes struct {
+		Routes []Route `yaml:"routes" json:"routes"`
+	}
+
+	route struct {
+		Route
+		handler http.Handler
+	}
+)
+
+// LoadRoutes reads routes from a YAML or JSON file, eg.:
+//
+//	routes:
+//	  - host: grafana.example.com
+//	    upstream: http://127.0.0.1:3000
+//	  - host: tools.example.com
+//	    pathPrefix: /wiki/
+//	    stripPrefix: true
+//	    upstream: https://10.0.0.5:8443
+//	    tls:
+//	      caFile: /etc/auth/internal-ca.pem
+//	    rules:
+//	      - path: /wiki/*
+//	        groups: [staff]
+func LoadRoutes(file string) (Routes, error) {
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return Routes{}, err
+	}
+	var r Routes
+	dec := yaml.NewDecoder(bytes.NewReader(buf))
+	dec.KnownFields(true)
+	if err := dec.Decode(&r); err != nil {
+		return Routes{}, fmt.Errorf("proxy: invalid routes file %v: %w", file, err)
+	}
+	if len(r.Routes) == 0 {
+		return Routes{}, fmt.Errorf("proxy: routes file %v has no routes", file)
+	}
+	for i, rt := range r.Routes {
+		if err := (Rules{Rules: rt.Rules}).Validate(); err != nil {
+			return Routes{}, fmt.Errorf("proxy: invalid routes file %v: route %d: %w", file, i, err)
+		}
+	}
+	return r, nil
+}
+
+// WithCookieDomain shares the session cookie with every subdomain of domain
+// (eg.: example.com), so people login once for every routed application
+func WithCookieDomain(domain string) Option {
+	return func(c *config) {
+		c.cookieDomain = domain
+	}
+}
+
+// RoutesHandler is like Handler but sends requests to the first route which
+// matches them, every route shares the login pages and the session cookie.
+// Requests which match no route get 404.
+func RoutesHandler(routes []Route, apiBase string, opts ...Option) (http.Handler, error) {
+	mux := http.NewServeMux()
+	cli := client.New(apiBase)
+	cfg := newConfig(cli, opts)
+	handleUI(mux, cli, cfg)
+	var compiled []route
+	for i, rt := range routes {
+		rev, err := reverseProxy(rt)
+		if err != nil {
+			return nil, fmt.Errorf("proxy: route %d: %w", i, err)
+		}
+		routeCfg := cfg
+		if len(rt.Rules) > 0 {
+			routeCfg.rules = Rules{Rules: rt.Rules}
+		}
+		compiled = append(compiled, route{Route: rt, handler: handleProxy(rev, routeCfg)})
+	}
+	mux.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, rt := range compiled {
+			if rt.matches(r) {
+				rt.handler.ServeHTTP(w, r)
+				return
+			}
+		}
+		http.NotFound(w, r)
+	}))
+	return mux, nil
+}
+
+func (r route) matches(req *http.Request) bool {
+	host := req.Host
+	if h, _, found := strings.Cut(host, ":"); found {
+		host = h
+	}
+	switch {
+	case r.Host == "":
+	case strings.HasPrefix(r.Host, "*."):
+		if !strings.HasSuffix(strings.ToLower(host), strings.ToLower(r.Host[1:])) {
+			return false
+		}
+	case !strings.EqualFold(host, r.Host):
+		return false
+	}
+	return strings.HasPrefix(cleanPath(req.URL.Path), r.PathPrefix)
+}
+
+func reverseProxy(rt Route) (*httputil.ReverseProxy, error) {
+	upstreamURL, err := url.Parse(rt.Upstream)
+	if err != nil {
+		return nil, err
+	}
+	if upstreamURL.Scheme != "http" && upstreamURL.Scheme != "https" {
+		return nil, fmt.Errorf("upstream %q must be an http or https url", rt.Upstream)
+	}
+	rev := httputil.NewSingleHostReverseProxy(upstreamURL)
+	if rt.StripPrefix && rt.PathPrefix != "" {
+		director := rev.Director
+		rev.Director = func(r *http.Request) {
+			r.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, rt.PathPrefix), "/")
+			r.URL.RawPath = ""
+			director(r)
+		}
+	}
+	if rt.TLS != (RouteTLS{}) {
+		tlsConfig := &tls.Config{ServerName: rt.TLS.ServerName, InsecureSkipVerify: rt.TLS.InsecureSkipVerify}
+		if rt.TLS.CAFile != "" {
+			buf, err := os.ReadFile(rt.TLS.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(buf) {
+				return nil, fmt.Errorf("no certificates found in %v", rt.TLS.CAFile)
+			}
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		rev.Transport = transport
+	}
+	return rev, nil
+}
@@ -0,0 +1,110 @@
+package proxy_test
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andrebq/auth"
+	"github.com/andrebq/auth/api"
+	"github.com/andrebq/auth/proxy"
+)
+
+func TestRoutesHandler(t *testing.T) {
+	ctx := context.Background()
+	db, err := auth.OpenMemory(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	server := httptest.NewServer(api.Handler(db))
+	defer server.Close()
+	if _, err := auth.RegisterUser(ctx, db, "bob", []byte("1234")); err != nil {
+		t.Fatal(err)
+	}
+	token, err := auth.CreateToken(ctx, db, "bob", auth.TokenTypeSession, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "%v %v %v", name, r.URL.Path, r.Header.Get("X-Forwarded-User"))
+		}
+	}
+	grafana := httptest.NewServer(upstream("grafana"))
+	defer grafana.Close()
+	wiki := httptest.NewTLSServer(upstream("wiki"))
+	defer wiki.Close()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: wiki.Certificate().Raw})
+	if err := os.WriteFile(caFile, ca, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	routesFile := filepath.Join(t.TempDir(), "routes.yaml")
+	if err := os.WriteFile(routesFile, []byte(fmt.Sprintf(`
+routes:
+  - host: grafana.example.com
+    upstream: %v
+  - host: "*.example.com"
+    pathPrefix: /wiki/
+    stripPrefix: true
+    upstream: %v
+    tls:
+      caFile: %v
+    rules:
+      - path: /wiki/public/*
+        public: true
+`, grafana.URL, wiki.URL, caFile)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	routes, err := proxy.LoadRoutes(routesFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := proxy.RoutesHandler(routes.Routes, server.URL, proxy.WithCookieDomain("example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(host, path string, withCookie bool) (int, string) {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Host = host
+		if withCookie {
+			req.AddCookie(&http.Cookie{Name: "auth.session", Value: token})
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code, rec.Body.String()
+	}
+
+	if status, body := get("grafana.example.com", "/d/home", true); status != http.StatusOK || body != "grafana /d/home bob" {
+		t.Fatalf("Unexpected response %v %q", status, body)
+	}
+	if status, body := get("tools.example.com:443", "/wiki/pages/1", true); status != http.StatusOK || body != "wiki /pages/1 bob" {
+		t.Fatalf("Prefix should be stripped and the upstream certificate trusted got %v %q", status, body)
+	}
+	if status, body := get("tools.example.com", "/wiki/public/logo.png", false); status != http.StatusOK || body != "wiki /public/logo.png " {
+		t.Fatalf("Route rules should apply got %v %q", status, body)
+	}
+	if status, _ := get("tools.example.com", "/other", true); status != http.StatusNotFound {
+		t.Fatalf("Requests without a route should get 404 got %v", status)
+	}
+
+	// one login is valid for every subdomain
+	req := httptest.NewRequest("POST", "/.auth/login", strings.NewReader(url.Values{"username": {"bob"}, "password": {"1234"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Domain != "example.com" {
+		t.Fatalf("Session cookie should be shared with the parent domain got %v", cookies)
+	}
+}
@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type (
+	// Rules decide how each request is authenticated before it is proxied,
+	// the first rule matching the request applies. Requests which match no
+	// rule require a session cookie.
+	Rules struct {
+		Rules []Rule `yaml:"rules" json:"rules"`
+	}
+
+	// Rule applies to requests whose path matches Path, paths ending in *
+	// match every path with that prefix, other paths must be equal.
+	// An empty Methods matches every method.
+	Rule struct {
+		Path    string   `yaml:"path" json:"path"`
+		Methods []string `yaml:"methods" json:"methods"`
+		// Public requests are proxied without authentication
+		Public bool `yaml:"public" json:"public"`
+		// Groups, when not empty, requires the user to be member of any of them
+		Groups []string `yaml:"groups" json:"groups"`
+		// Bearer accepts tokens from the Authorization header in addition to
+		// the session cookie, eg.: for APIs used by other services
+		Bearer bool `yaml:"bearer" json:"bearer"`
+	}
+)
+
+// defaultRule protects every path which is not covered by a rule
+var defaultRule = Rule{Path: "/*"}
+
+// LoadRules reads rules from a YAML or JSON file, eg.:
+//
+//	rules:
+//	  - path: /healthz
+//	    public: true
+//	  - path: /static/*
+//	    methods: [GET, HEAD]
+//	    public: true
+//	  - path: /admin/*
+//	    groups: [admins]
+//	  - path: /api/*
+//	    bearer: true
+func LoadRules(file string) (Rules, error) {
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return Rules{}, err
+	}
+	var r Rules
+	dec := yaml.NewDecoder(bytes.NewReader(buf))
+	dec.KnownFields(true)
+	if err := dec.Decode(&r); err != nil {
+		return Rules{}, fmt.Errorf("proxy: invalid rules file %v: %w", file, err)
+	}
+	if err := r.Validate(); err != nil {
+		return Rules{}, fmt.Errorf("proxy: invalid rules file %v: %w", file, err)
+	}
+	return r, nil
+}
+
+// Validate checks that every rule can be applied
+func (r Rules) Validate() error {
+	for i, rule := range r.Rules {
+		switch {
+		case !strings.HasPrefix(rule.Path, "/"):
+			return fmt.Errorf("rule %d: path %q must start with /", i, rule.Path)
+		case strings.Contains(strings.TrimSuffix(rule.Path, "*"), "*"):
+			return fmt.Errorf("rule %d: path %q can only use * at the end", i, rule.Path)
+		case rule.Public && (len(rule.Groups) > 0 || rule.Bearer):
+			return fmt.Errorf("rule %d: public paths cannot require groups or bearer tokens", i)
+		}
+		for _, m := range rule.Methods {
+			if m != strings.ToUpper(m) {
+				return fmt.Errorf("rule %d: method %q must be uppercase", i, m)
+			}
+		}
+	}
+	return nil
+}
+
+// match returns the rule which applies to req
+func (r Rules) match(req *http.Request) Rule {
+	for _, rule := range r.Rules {
+		if rule.matches(req) {
+			return rule
+		}
+	}
+	return defaultRule
+}
+
+func (r Rule) matches(req *http.Request) bool {
+	if len(r.Methods) > 0 && !slices.Contains(r.Methods, req.Method) {
+		return false
+	}
+	p := cleanPath(req.URL.Path)
+	if prefix, found := strings.CutSuffix(r.Path, "*"); found {
+		return strings.HasPrefix(p, prefix)
+	}
+	return p == r.Path
+}
+
+// cleanPath resolves . and .. in the decoded path p the way upstreams do,
+// otherwise /public/../admin would be matched by the rules of /public.
+// The trailing slash is kept.
+func cleanPath(p string) string {
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	cleaned := path.Clean(p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+// allows reports whether a member of groups can access paths covered by r
+func (r Rule) allows(groups []string) bool {
+	if len(r.Groups) == 0 {
+		return true
+	}
+	for _, g := range groups {
+		if slices.Contains(r.Groups, g) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithRules makes the proxy apply r to every request, see Rules
+func WithRules(r Rules) Option {
+	return func(c *config) {
+		c.rules = r
+	}
+}
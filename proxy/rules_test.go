@@ -0,0 +1,193 @@
+package proxy_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andrebq/auth"
+	"github.com/andrebq/auth/api"
+	"github.com/andrebq/auth/proxy"
+)
+
+func TestLoadRules(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+	rules, err := proxy.LoadRules(write("rules.yaml", `
+rules:
+  - path: /healthz
+    public: true
+  - path: /admin/*
+    methods: [GET, POST]
+    groups: [admins]
+`))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(rules.Rules) != 2 || rules.Rules[1].Groups[0] != "admins" {
+		t.Fatalf("Unexpected rules %#v", rules)
+	}
+	if rules, err := proxy.LoadRules(write("rules.json", `{"rules": [{"path": "/api/*", "bearer": true}]}`)); err != nil || !rules.Rules[0].Bearer {
+		t.Fatalf("Unexpected rules %#v, %v", rules, err)
+	}
+	for name, content := range map[string]string{
+		"relative.yaml": "rules: [{path: healthz, public: true}]",
+		"wildcard.yaml": "rules: [{path: /a/*/b}]",
+		"public.yaml":   "rules: [{path: /a, public: true, groups: [admins]}]",
+		"unknown.yaml":  "rules: [{path: /a, roles: [admins]}]",
+	} {
+		if _, err := proxy.LoadRules(write(name, content)); err == nil {
+			t.Errorf("%v should be rejected", name)
+		}
+	}
+}
+
+func TestProxyRules(t *testing.T) {
+	ctx := context.Background()
+	db, err := auth.OpenMemory(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	server := httptest.NewServer(api.Handler(db))
+	defer server.Close()
+	for _, login := range []string{"bob", "alice"} {
+		if _, err := auth.RegisterUser(ctx, db, login, []byte("1234")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := auth.CreateGroup(ctx, db, "admins"); err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.AddToGroup(ctx, db, "admins", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	tokens := map[string]string{}
+	for _, login := range []string{"bob", "alice"} {
+		if tokens[login], err = auth.CreateToken(ctx, db, login, auth.TokenTypeSession, time.Now().Add(time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var received http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+	}))
+	defer upstream.Close()
+	handler, err := proxy.Handler(upstream.URL, server.URL, proxy.WithRules(proxy.Rules{Rules: []proxy.Rule{
+		{Path: "/healthz", Public: true},
+		{Path: "/admin/*", Groups: []string{"admins"}},
+		{Path: "/api/*", Bearer: true},
+	}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	do := func(method, path string, setup func(r *http.Request)) int {
+		received = nil
+		req := httptest.NewRequest(method, path, nil)
+		setup(req)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	cookie := func(login string) func(r *http.Request) {
+		return func(r *http.Request) { r.AddCookie(&http.Cookie{Name: "auth.session", Value: tokens[login]}) }
+	}
+	bearer := func(login string) func(r *http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+tokens[login]) }
+	}
+
+	if status := do("GET", "/healthz", func(r *http.Request) { r.Header.Set("X-Forwarded-User", "mallory") }); status != http.StatusOK {
+		t.Fatalf("Public path should not require a session got %v", status)
+	} else if received.Get("X-Forwarded-User") != "" {
+		t.Fatalf("Identity headers should be stripped from public paths got %v", received)
+	}
+	if status := do("GET", "/admin/users", cookie("alice")); status != http.StatusForbidden {
+		t.Fatalf("Users outside admins should be forbidden got %v", status)
+	}
+	if status := do("GET", "/admin/users", cookie("bob")); status != http.StatusOK {
+		t.Fatalf("Admins should be allowed got %v", status)
+	}
+	if status := do("POST", "/api/reports", bearer("alice")); status != http.StatusOK {
+		t.Fatalf("Bearer tokens should be accepted by /api got %v", status)
+	} else if received.Get("Authorization") != "" || received.Get("X-Forwarded-User") != "alice" {
+		t.Fatalf("Upstream should get the identity instead of the token got %v", received)
+	}
+	if status := do("POST", "/reports", bearer("alice")); status != http.StatusUnauthorized {
+		t.Fatalf("Bearer tokens should only be accepted where a rule allows them got %v", status)
+	}
+	if status := do("GET", "/reports", func(r *http.Request) {}); status != http.StatusSeeOther {
+		t.Fatalf("Paths without rules should require a session got %v", status)
+	}
+	// the mux redirects literal dot segments to the clean path, encoded ones reach the rules
+	for _, path := range []string{"/healthz/../admin/users", "/healthz/%2e%2e/admin/users", "/x/../admin/users"} {
+		if status := do("GET", path, cookie("alice")); received != nil || (status != http.StatusForbidden && status != http.StatusTemporaryRedirect) {
+			t.Errorf("%v should be matched as /admin/users got %v", path, status)
+		}
+	}
+}
+
+func TestForwardAuthRules(t *testing.T) {
+	ctx := context.Background()
+	db, err := auth.OpenMemory(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	server := httptest.NewServer(api.Handler(db))
+	defer server.Close()
+	if _, err := auth.RegisterUser(ctx, db, "alice", []byte("1234")); err != nil {
+		t.Fatal(err)
+	}
+	token, err := auth.CreateToken(ctx, db, "alice", auth.TokenTypeSession, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := proxy.ForwardAuthHandler(server.URL, proxy.WithRules(proxy.Rules{Rules: []proxy.Rule{
+		{Path: "/public/*", Public: true},
+		{Path: "/admin/*", Groups: []string{"admins"}},
+	}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	forward := func(uri string, session bool) int {
+		req := httptest.NewRequest("GET", "/forward-auth", nil)
+		if uri != "" {
+			req.Header.Set("X-Forwarded-Uri", uri)
+		}
+		if session {
+			req.AddCookie(&http.Cookie{Name: "auth.session", Value: token})
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	for _, tc := range []struct {
+		uri     string
+		session bool
+		status  int
+	}{
+		{"/public/index.html", false, http.StatusOK},
+		{"/public/../admin/x", false, http.StatusUnauthorized},
+		{"/public/%2e%2e/admin/x", false, http.StatusUnauthorized},
+		{"/public/./../admin/x?a=b", false, http.StatusUnauthorized},
+		{"/admin/x", true, http.StatusForbidden},
+		{"/x/../admin/x", true, http.StatusForbidden},
+		{"/public/../admin/", true, http.StatusForbidden},
+		{"", false, http.StatusBadRequest},
+		{"not a uri", true, http.StatusBadRequest},
+	} {
+		if status := forward(tc.uri, tc.session); status != tc.status {
+			t.Errorf("%q (session %v) should get %v got %v", tc.uri, tc.session, tc.status, status)
+		}
+	}
+}
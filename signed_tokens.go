@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/andrebq/auth/jwt"
+)
+
+type (
+	// SignedTokenClaims is the payload of tokens created by CreateSignedToken,
+	// the subject is the uid of the owner and the id is the id of the token
+	// as reported by ListTokens
+	SignedTokenClaims struct {
+		jwt.Claims
+		TokenType  string            `json:"token_type"`
+		Login      string            `json:"login"`
+		Attributes map[string]string `json:"attrs,omitempty"`
+		Groups     []string          `json:"groups,omitempty"`
+	}
+)
+
+// MaxSignedTokenTTL is the longest lifetime of a signed token, it bounds
+// how long a revoked token must be reported by RevokedTokens
+const MaxSignedTokenTTL = 24 * time.Hour
+
+// CreateSignedToken is like CreateToken but returns a self-contained token
+// signed by keys, services holding the published keys can verify it without
+// calling the auth API. The token is also stored so it can be listed and
+// revoked, revoked tokens are reported by RevokedTokens until they expire.
+func CreateSignedToken(ctx context.Context, st Store, keys *SigningKeys, login, tokenType string, expiresAt time.Time) (string, error) {
+	if SingleUse(tokenType) {
+		return "", fmt.Errorf("%w: %v tokens cannot be signed", ErrInvalidInput, tokenType)
+	}
+	now := time.Now()
+	if limit := now.Add(MaxSignedTokenTTL); expiresAt.After(limit) {
+		expiresAt = limit
+	}
+	u, err := lookupActiveLogin(ctx, st, login)
+	if err != nil {
+		return "", err
+	}
+	details, err := withDetails(ctx, st, u)
+	if err != nil {
+		return "", err
+	}
+	key, err := keys.Current(ctx)
+	if err != nil {
+		return "", err
+	}
+	stored, err := createToken(ctx, st, u, TokenRecord{TokenType: tokenType, ExpiresAt: expiresAt})
+	if err != nil {
+		return "", err
+	}
+	tokenID, _ := ExtractTokenID(stored)
+	return jwt.SignType(key, jwt.TypeAccessToken, SignedTokenClaims{
+		Claims: jwt.Claims{
+			Subject:   u.UID,
+			ID:        tokenID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: expiresAt.Unix(),
+		},
+		TokenType:  tokenType,
+		Login:      u.Login,
+		Attributes: details.Attributes,
+		Groups:     details.Groups,
+	})
+}
+
+// IsSignedToken reports whether token looks like a token created by
+// CreateSignedToken, it does not verify the token
+func IsSignedToken(token string) bool {
+	return strings.Count(token, ".") == 2 && !strings.Contains(token, ":")
+}
+
+// RevokedTokens returns the ids of tokens which were revoked before their
+// expiration, services verifying or caching tokens locally should poll it.
+// Only revocations after since are returned, since is moved forward when it
+// is older than MaxSignedTokenTTL.
+func RevokedTokens(ctx context.Context, st Store, since time.Time) ([]string, error) {
+	if limit := time.Now().Add(-MaxSignedTokenTTL); since.Before(limit) {
+		since = limit
+	}
+	return st.ListRevokedTokens(ctx, since)
+}
+
+// verifySignedToken checks the signature of token and the stored record,
+// the auth server never relies on the signature alone
+func verifySignedToken(ctx context.Context, st Store, token string) (TokenRecord, error) {
+	keys, err := PublishedKeys(ctx, st, KeyPurposeToken)
+	if err != nil {
+		return TokenRecord{}, err
+	}
+	var claims SignedTokenClaims
+	if err := jwt.VerifyType(token, keys, jwt.TypeAccessToken, &claims); err != nil {
+		return TokenRecord{}, ErrInvalidCredentials
+	}
+	if err := claims.Validate(time.Now(), "", ""); err != nil {
+		return TokenRecord{}, errors.New("auth: token expired")
+	}
+	t, err := st.FindToken(ctx, claims.ID)
+	if err != nil {
+		return TokenRecord{}, err
+	}
+	if t.UID != claims.Subject || t.TokenType != claims.TokenType {
+		return TokenRecord{}, ErrInvalidCredentials
+	}
+	now := time.Now().Unix()
+	if t.CreatedAt.Unix() > now || t.ExpiresAt.Unix() <= now {
+		return TokenRecord{}, errors.New("auth: token expired")
+	}
+	return t, nil
+}
+
+// signedTokenID returns the jti of a signed token without verifying it
+func signedTokenID(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	buf, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", errors.New("auth: invalid token format")
+	}
+	var claims jwt.Claims
+	if err := json.Unmarshal(buf, &claims); err != nil || claims.ID == "" {
+		return "", errors.New("auth: invalid token format")
+	}
+	return claims.ID, nil
+}
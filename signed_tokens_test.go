@@ -0,0 +1,75 @@
+package auth_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/andrebq/auth"
+	"github.com/andrebq/auth/jwt"
+)
+
+func TestSignedTokens(t *testing.T) {
+	eachStore(t, func(t *testing.T, st auth.Store) {
+		ctx := context.Background()
+		uid, err := auth.RegisterUser(ctx, st, "bob", []byte("super-secure"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := auth.SetUserAttributes(ctx, st, "bob", map[string]string{auth.AttrEmail: "bob@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+		keys := auth.NewSigningKeys(st, auth.NewKeyring(st, mustKEK(t)), auth.KeyPurposeToken, jwt.EdDSA, time.Hour)
+		token, err := auth.CreateSignedToken(ctx, st, keys, "bob", auth.TokenTypeSession, time.Now().Add(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		} else if !auth.IsSignedToken(token) {
+			t.Fatalf("Token %q should be signed", token)
+		}
+		if _, err := auth.CreateSignedToken(ctx, st, keys, "bob", auth.TokenTypeReset, time.Now().Add(time.Minute)); err == nil {
+			t.Fatal("Single use tokens should not be signed")
+		}
+
+		// the published keys are enough to verify the token
+		published, err := auth.PublishedKeys(ctx, st, auth.KeyPurposeToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var claims auth.SignedTokenClaims
+		if err := jwt.VerifyType(token, published, jwt.TypeAccessToken, &claims); err != nil {
+			t.Fatal(err)
+		} else if claims.Subject != uid || claims.Login != "bob" || claims.Attributes[auth.AttrEmail] != "bob@example.com" {
+			t.Fatalf("Unexpected claims %#v", claims)
+		}
+		if oidcKeys, err := auth.PublishedKeys(ctx, st, auth.KeyPurposeOIDC); err != nil || len(oidcKeys.Keys) != 0 {
+			t.Fatalf("Token keys should not be published as oidc keys got %v, %v", oidcKeys, err)
+		}
+
+		info, err := auth.InspectToken(ctx, st, token)
+		if err != nil {
+			t.Fatal(err)
+		} else if info.UID != uid || info.TokenID != claims.ID || info.TokenType != auth.TokenTypeSession {
+			t.Fatalf("Unexpected token %#v", info)
+		}
+		if tokenID, err := auth.ExtractTokenID(token); err != nil || tokenID != claims.ID {
+			t.Fatalf("Unexpected token id %v, %v", tokenID, err)
+		}
+
+		if err := auth.RevokeToken(ctx, st, claims.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := auth.TokenLogin(ctx, st, token); err == nil {
+			t.Fatal("Revoked token should be rejected")
+		}
+		revoked, err := auth.RevokedTokens(ctx, st, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		} else if !slices.Contains(revoked, claims.ID) {
+			t.Fatalf("Revoked tokens should include %v got %v", claims.ID, revoked)
+		}
+		if revoked, err := auth.RevokedTokens(ctx, st, time.Now().Add(time.Minute)); err != nil || len(revoked) != 0 {
+			t.Fatalf("Revocations before since should be skipped got %v, %v", revoked, err)
+		}
+	})
+}
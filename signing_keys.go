@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/andrebq/auth/jwt"
+	"github.com/google/uuid"
+)
+
+type (
+	// SigningKeys manages the keys used to sign tokens (eg.: OpenID Connect
+	// id tokens). Private keys are sealed by a Keyring before they are stored,
+	// so every server sharing the database signs with the same keys.
+	//
+	// A new key is created once the newest key is older than the rotation
+	// period, previous keys are still published for another period so tokens
+	// signed by them can be verified. Tokens must not live longer than the
+	// rotation period.
+	SigningKeys struct {
+		st      Store
+		kr      *Keyring
+		purpose string
+		alg     string
+		rotate  time.Duration
+
+		lock sync.Mutex
+		keys map[string]jwt.Key
+	}
+)
+
+const (
+	// DefaultKeyRotation is how long a signing key is used before a new one is created
+	DefaultKeyRotation = 7 * 24 * time.Hour
+
+	// KeyPurposeOIDC keys sign the id tokens of the oidc provider
+	KeyPurposeOIDC = "oidc"
+	// KeyPurposeToken keys sign the tokens created by CreateSignedToken
+	KeyPurposeToken = "token"
+)
+
+// NewSigningKeys returns signing keys for purpose which use alg
+// (see package jwt) and are replaced every rotate
+func NewSigningKeys(st Store, kr *Keyring, purpose, alg string, rotate time.Duration) *SigningKeys {
+	if rotate <= 0 {
+		rotate = DefaultKeyRotation
+	}
+	return &SigningKeys{st: st, kr: kr, purpose: purpose, alg: alg, rotate: rotate, keys: make(map[string]jwt.Key)}
+}
+
+// Algorithm returns the algorithm used by new keys
+func (s *SigningKeys) Algorithm() string {
+	return s.alg
+}
+
+// Current returns the key which should be used to sign new tokens
+func (s *SigningKeys) Current(ctx context.Context) (jwt.Key, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	recs, err := s.st.ListSigningKeys(ctx, s.purpose)
+	if err != nil {
+		return jwt.Key{}, err
+	}
+	for _, rec := range recs {
+		if rec.Algorithm != s.alg {
+			continue
+		}
+		if time.Since(rec.CreatedAt) < s.rotate {
+			return s.open(ctx, rec)
+		}
+		break
+	}
+	return s.generate(ctx)
+}
+
+// PublicKeys returns every key which might have signed a token that is still valid
+func (s *SigningKeys) PublicKeys(ctx context.Context) (jwt.JWKS, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	recs, err := s.st.ListSigningKeys(ctx, s.purpose)
+	if err != nil {
+		return jwt.JWKS{}, err
+	}
+	out := jwt.JWKS{Keys: []jwt.JWK{}}
+	for _, rec := range recs {
+		if time.Since(rec.CreatedAt) > 2*s.rotate {
+			continue
+		}
+		pub, err := s.public(ctx, rec)
+		if err != nil {
+			return jwt.JWKS{}, err
+		}
+		out.Keys = append(out.Keys, pub)
+	}
+	return out, nil
+}
+
+// PublishedKeys returns the public part of every stored key with the given
+// purpose, it does not need the key-encryption-key so any server sharing the
+// database can verify tokens. Keys are removed once they are older than
+// three rotation periods.
+func PublishedKeys(ctx context.Context, st Store, purpose string) (jwt.JWKS, error) {
+	recs, err := st.ListSigningKeys(ctx, purpose)
+	if err != nil {
+		return jwt.JWKS{}, err
+	}
+	out := jwt.JWKS{Keys: []jwt.JWK{}}
+	for _, rec := range recs {
+		if rec.PublicKey == "" {
+			continue
+		}
+		var pub jwt.JWK
+		if err := json.Unmarshal([]byte(rec.PublicKey), &pub); err != nil {
+			return jwt.JWKS{}, err
+		}
+		out.Keys = append(out.Keys, pub)
+	}
+	return out, nil
+}
+
+// generate must be called with s.lock held
+func (s *SigningKeys) generate(ctx context.Context) (jwt.Key, error) {
+	signer, err := jwt.GenerateKey(s.alg)
+	if err != nil {
+		return jwt.Key{}, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return jwt.Key{}, err
+	}
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return jwt.Key{}, err
+	}
+	key := jwt.Key{ID: id.String(), Signer: signer}
+	pub, err := key.Public()
+	if err != nil {
+		return jwt.Key{}, err
+	}
+	encodedPub, err := json.Marshal(pub)
+	if err != nil {
+		return jwt.Key{}, err
+	}
+	rec := SigningKeyRecord{KeyID: key.ID, Algorithm: s.alg, Purpose: s.purpose, PublicKey: string(encodedPub), CreatedAt: time.Now()}
+	if rec.DataKeyID, rec.Sealed, err = s.kr.Seal(ctx, der, []byte(rec.KeyID)); err != nil {
+		return jwt.Key{}, err
+	}
+	if err := s.st.InsertSigningKey(ctx, rec); err != nil {
+		return jwt.Key{}, err
+	}
+	// keys which are not published anymore are useless
+	if err := s.st.DeleteSigningKeys(ctx, s.purpose, time.Now().Add(-3*s.rotate)); err != nil {
+		return jwt.Key{}, err
+	}
+	s.keys[key.ID] = key
+	return key, nil
+}
+
+// public must be called with s.lock held, keys created before the public
+// key was stored need to be opened
+func (s *SigningKeys) public(ctx context.Context, rec SigningKeyRecord) (jwt.JWK, error) {
+	if rec.PublicKey != "" {
+		var pub jwt.JWK
+		err := json.Unmarshal([]byte(rec.PublicKey), &pub)
+		return pub, err
+	}
+	key, err := s.open(ctx, rec)
+	if err != nil {
+		return jwt.JWK{}, err
+	}
+	return key.Public()
+}
+
+// open must be called with s.lock held
+func (s *SigningKeys) open(ctx context.Context, rec SigningKeyRecord) (jwt.Key, error) {
+	if key, ok := s.keys[rec.KeyID]; ok {
+		return key, nil
+	}
+	der, err := s.kr.Open(ctx, rec.DataKeyID, rec.Sealed, []byte(rec.KeyID))
+	if err != nil {
+		return jwt.Key{}, err
+	}
+	priv, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return jwt.Key{}, err
+	}
+	key := jwt.Key{ID: rec.KeyID, Signer: priv.(crypto.Signer)}
+	s.keys[key.ID] = key
+	return key, nil
+}
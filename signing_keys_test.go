@@ -0,0 +1,56 @@
+package auth_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/andrebq/auth"
+	"github.com/andrebq/auth/jwt"
+)
+
+func TestSigningKeys(t *testing.T) {
+	eachStore(t, func(t *testing.T, st auth.Store) {
+		ctx := context.Background()
+		kek := mustKEK(t)
+		keys := auth.NewSigningKeys(st, auth.NewKeyring(st, kek), auth.KeyPurposeOIDC, jwt.EdDSA, time.Hour)
+		current, err := keys.Current(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// another server sharing the database must sign with the same key
+		other := auth.NewSigningKeys(st, auth.NewKeyring(st, kek), auth.KeyPurposeOIDC, jwt.EdDSA, time.Hour)
+		if again, err := other.Current(ctx); err != nil || again.ID != current.ID {
+			t.Fatalf("Unexpected key %v, %v", again.ID, err)
+		}
+		token, err := jwt.Sign(current, jwt.Claims{Subject: "uid-1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		published, err := other.PublicKeys(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var claims jwt.Claims
+		if err := jwt.Verify(token, published, &claims); err != nil || claims.Subject != "uid-1" {
+			t.Fatalf("Unexpected claims %v, %v", claims, err)
+		}
+
+		// a short rotation forces a new key, the previous one is still published,
+		// sql stores keep timestamps in seconds
+		rotated := auth.NewSigningKeys(st, auth.NewKeyring(st, kek), auth.KeyPurposeOIDC, jwt.EdDSA, time.Second)
+		time.Sleep(1100 * time.Millisecond)
+		next, err := rotated.Current(ctx)
+		if err != nil {
+			t.Fatal(err)
+		} else if next.ID == current.ID {
+			t.Fatal("Key should be rotated")
+		}
+		if published, err = keys.PublicKeys(ctx); err != nil || len(published.Keys) != 2 {
+			t.Fatalf("Both keys should be published got %v, %v", published, err)
+		}
+		if _, ok := published.Find(current.ID); !ok {
+			t.Fatal("Previous key should be published")
+		}
+	})
+}
@@ -0,0 +1,584 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+type (
+	// SQLStore implements Store on top of a database/sql connection
+	SQLStore struct {
+		db      *sql.DB
+		dialect dialect
+	}
+)
+
+var _ Store = (*SQLStore)(nil)
+
+// NewSQLStore wraps db, which must already have all migrations applied
+func NewSQLStore(db *sql.DB) *SQLStore {
+	return &SQLStore{db: db, dialect: dialectOf(db)}
+}
+
+// DB returns the underlying connection, used for maintenance tasks like migrations
+func (s *SQLStore) DB() *sql.DB {
+	return s.db
+}
+
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLStore) InsertUser(ctx context.Context, u UserRecord) error {
+	_, err := s.db.ExecContext(ctx, s.q(`insert into db_users(uid, login, passwd_hash, salt, passwd, active) values (?, ?, ?, ?, ?, ?)`),
+		u.UID, u.Login, u.PasswdHash, nonNil(u.Salt), nonNil(u.Passwd), boolToInt(u.Active))
+	return s.dialect.conflict(err)
+}
+
+const userColumns = `uid, login, passwd_hash, salt, passwd, active`
+
+func (s *SQLStore) FindUserByLogin(ctx context.Context, login string) (UserRecord, error) {
+	return scanUser(s.db.QueryRowContext(ctx, s.q(`select `+userColumns+` from db_users where login = ?`), login))
+}
+
+func (s *SQLStore) FindUserByUID(ctx context.Context, uid string) (UserRecord, error) {
+	return scanUser(s.db.QueryRowContext(ctx, s.q(`select `+userColumns+` from db_users where uid = ?`), uid))
+}
+
+func (s *SQLStore) ListUsers(ctx context.Context) ([]UserRecord, error) {
+	rows, err := s.db.QueryContext(ctx, s.q(`select `+userColumns+` from db_users order by login`))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []UserRecord
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, u)
+	}
+	return out, rows.Err()
+}
+
+func (s *SQLStore) SetUserActive(ctx context.Context, uid string, active bool) error {
+	res, err := s.db.ExecContext(ctx, s.q(`update db_users set active = ? where uid = ?`), boolToInt(active), uid)
+	return expectOne(res, err)
+}
+
+func (s *SQLStore) RenameUser(ctx context.Context, uid string, login string) error {
+	res, err := s.db.ExecContext(ctx, s.q(`update db_users set login = ? where uid = ?`), login, uid)
+	return expectOne(res, s.dialect.conflict(err))
+}
+
+func (s *SQLStore) DeleteUser(ctx context.Context, uid string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// revoked tokens are kept so they are still reported by ListRevokedTokens,
+	// services which verify signed tokens locally would accept them otherwise
+	now := time.Now().Unix()
+	_, err = tx.ExecContext(ctx, s.q(`update db_tokens set expires_at_unix = created_at_unix, revoked_at_unix = ? where uid = ? and expires_at_unix > ?`), now, uid, now)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, s.q(`delete from db_tokens where uid = ? and revoked_at_unix < ?`), uid, now-int64(MaxSignedTokenTTL/time.Second))
+	if err != nil {
+		return err
+	}
+	for _, table := range []string{"db_user_attributes", "db_password_history", "db_group_members"} {
+		if _, err := tx.ExecContext(ctx, s.q(`delete from `+table+` where uid = ?`), uid); err != nil {
+			return err
+		}
+	}
+	res, err := tx.ExecContext(ctx, s.q(`delete from db_users where uid = ?`), uid)
+	if err := expectOne(res, err); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) UpdatePassword(ctx context.Context, uid string, hash string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, s.q(`insert into db_password_history(uid, passwd_hash, replaced_at_unix)
+		select uid, passwd_hash, ? from db_users where uid = ? and passwd_hash <> ''`), time.Now().Unix(), uid)
+	if err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, s.q(`update db_users set passwd_hash = ?, passwd = ?, salt = ? where uid = ?`), hash, []byte{}, []byte{}, uid)
+	if err := expectOne(res, err); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) PasswordHistory(ctx context.Context, uid string, limit int) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, s.q(`select passwd_hash from db_password_history where uid = ? order by seq desc limit ?`), uid, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		out = append(out, hash)
+	}
+	return out, rows.Err()
+}
+
+func (s *SQLStore) UserAttributes(ctx context.Context, uid string) (map[string]string, error) {
+	rows, err := s.db.QueryContext(ctx, s.q(`select name, value from db_user_attributes where uid = ?`), uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make(map[string]string)
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		out[name] = value
+	}
+	return out, rows.Err()
+}
+
+func (s *SQLStore) SetUserAttributes(ctx context.Context, uid string, attrs map[string]string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var found int
+	if err := tx.QueryRowContext(ctx, s.q(`select count(*) from db_users where uid = ?`), uid).Scan(&found); err != nil {
+		return err
+	} else if found == 0 {
+		return ErrNotFound
+	}
+	for name, value := range attrs {
+		if value == "" {
+			_, err = tx.ExecContext(ctx, s.q(`delete from db_user_attributes where uid = ? and name = ?`), uid, name)
+		} else {
+			_, err = tx.ExecContext(ctx, s.q(`insert into db_user_attributes(uid, name, value) values (?, ?, ?)
+				on conflict(uid, name) do update set value = excluded.value`), uid, name, value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) InsertToken(ctx context.Context, t TokenRecord) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// tokens of deleted users are kept until no signed token can outlive
+	// their revocation, they are purged here since nothing else removes them
+	_, err = tx.ExecContext(ctx, s.q(`delete from db_tokens where revoked_at_unix > 0 and revoked_at_unix < ?
+		and not exists (select 1 from db_users where db_users.uid = db_tokens.uid)`), time.Now().Add(-MaxSignedTokenTTL).Unix())
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, s.q(`insert into db_tokens(token_id,
+		token_type,
+		uid,
+		token_hash,
+		salt,
+		token,
+		client_id,
+		scope,
+		created_at_unix,
+		expires_at_unix) values (?, ?,?,?,?,?,?,?,?,?)`), t.TokenID, t.TokenType, t.UID, t.TokenHash, nonNil(t.Salt), nonNil(t.Token), t.ClientID, t.Scope, t.CreatedAt.Unix(), t.ExpiresAt.Unix())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const tokenColumns = `token_id, uid, token_type, token_hash, salt, token, client_id, scope, created_at_unix, expires_at_unix, revoked_at_unix`
+
+func (s *SQLStore) FindToken(ctx context.Context, tokenID string) (TokenRecord, error) {
+	return scanToken(s.db.QueryRowContext(ctx, s.q(`select `+tokenColumns+` from db_tokens where token_id = ?`), tokenID))
+}
+
+func (s *SQLStore) ListTokens(ctx context.Context, uid string) ([]TokenRecord, error) {
+	rows, err := s.db.QueryContext(ctx, s.q(`select `+tokenColumns+` from db_tokens where uid = ? order by created_at_unix desc, token_id`), uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []TokenRecord
+	for rows.Next() {
+		t, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+func (s *SQLStore) ExpireToken(ctx context.Context, tokenID string) error {
+	res, err := s.db.ExecContext(ctx, s.q(`update db_tokens set expires_at_unix = created_at_unix, revoked_at_unix = ? where token_id = ?`), time.Now().Unix(), tokenID)
+	return expectOne(res, err)
+}
+
+func (s *SQLStore) ConsumeToken(ctx context.Context, tokenID string) error {
+	now := time.Now().Unix()
+	res, err := s.db.ExecContext(ctx, s.q(`update db_tokens set expires_at_unix = created_at_unix, revoked_at_unix = ? where token_id = ? and expires_at_unix > ?`), now, tokenID, now)
+	return expectOne(res, err)
+}
+
+func (s *SQLStore) ExpireUserTokens(ctx context.Context, uid string, keep ...string) error {
+	now := time.Now().Unix()
+	query := `update db_tokens set expires_at_unix = created_at_unix, revoked_at_unix = ? where uid = ? and expires_at_unix > ?`
+	args := []any{now, uid, now}
+	for _, k := range keep {
+		query += ` and token_id <> ?`
+		args = append(args, k)
+	}
+	_, err := s.db.ExecContext(ctx, s.q(query), args...)
+	return err
+}
+
+func (s *SQLStore) ListRevokedTokens(ctx context.Context, since time.Time) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, s.q(`select token_id from db_tokens where revoked_at_unix > 0 and revoked_at_unix >= ? order by token_id`), since.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		out = append(out, id)
+	}
+	return out, rows.Err()
+}
+
+func (s *SQLStore) CreateGroup(ctx context.Context, name string) error {
+	res, err := s.db.ExecContext(ctx, s.q(`insert into db_groups(name) values (?) on conflict(name) do nothing`), name)
+	err = expectOne(res, err)
+	if errors.Is(err, ErrNotFound) {
+		return ErrConflict
+	}
+	return err
+}
+
+func (s *SQLStore) DeleteGroup(ctx context.Context, name string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, s.q(`delete from db_group_members where group_name = ?`), name); err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, s.q(`delete from db_groups where name = ?`), name)
+	if err := expectOne(res, err); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) ListGroups(ctx context.Context) ([]string, error) {
+	return s.queryStrings(ctx, `select name from db_groups order by name`)
+}
+
+func (s *SQLStore) AddGroupMember(ctx context.Context, group, uid string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var found int
+	err = tx.QueryRowContext(ctx, s.q(`select (select count(*) from db_groups where name = ?) + (select count(*) from db_users where uid = ?)`), group, uid).Scan(&found)
+	if err != nil {
+		return err
+	} else if found != 2 {
+		return ErrNotFound
+	}
+	_, err = tx.ExecContext(ctx, s.q(`insert into db_group_members(group_name, uid) values (?, ?) on conflict(group_name, uid) do nothing`), group, uid)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) RemoveGroupMember(ctx context.Context, group, uid string) error {
+	res, err := s.db.ExecContext(ctx, s.q(`delete from db_group_members where group_name = ? and uid = ?`), group, uid)
+	return expectOne(res, err)
+}
+
+func (s *SQLStore) GroupMembers(ctx context.Context, group string) ([]string, error) {
+	var found int
+	if err := s.db.QueryRowContext(ctx, s.q(`select count(*) from db_groups where name = ?`), group).Scan(&found); err != nil {
+		return nil, err
+	} else if found == 0 {
+		return nil, ErrNotFound
+	}
+	return s.queryStrings(ctx, `select uid from db_group_members where group_name = ? order by uid`, group)
+}
+
+func (s *SQLStore) UserGroups(ctx context.Context, uid string) ([]string, error) {
+	return s.queryStrings(ctx, `select group_name from db_group_members where uid = ? order by group_name`, uid)
+}
+
+func (s *SQLStore) InsertClient(ctx context.Context, c ClientRecord) error {
+	_, err := s.db.ExecContext(ctx, s.q(`insert into db_clients(client_id, name, secret_hash, redirect_uris, uid, created_at_unix) values (?, ?, ?, ?, ?, ?)`),
+		c.ClientID, c.Name, c.SecretHash, strings.Join(c.RedirectURIs, "\n"), c.UID, c.CreatedAt.Unix())
+	return s.dialect.conflict(err)
+}
+
+const clientColumns = `client_id, name, secret_hash, redirect_uris, uid, created_at_unix`
+
+func (s *SQLStore) FindClient(ctx context.Context, clientID string) (ClientRecord, error) {
+	return scanClient(s.db.QueryRowContext(ctx, s.q(`select `+clientColumns+` from db_clients where client_id = ?`), clientID))
+}
+
+func (s *SQLStore) ListClients(ctx context.Context) ([]ClientRecord, error) {
+	rows, err := s.db.QueryContext(ctx, s.q(`select `+clientColumns+` from db_clients order by name, client_id`))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []ClientRecord
+	for rows.Next() {
+		c, err := scanClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+func (s *SQLStore) DeleteClient(ctx context.Context, clientID string) error {
+	res, err := s.db.ExecContext(ctx, s.q(`delete from db_clients where client_id = ?`), clientID)
+	return expectOne(res, err)
+}
+
+func (s *SQLStore) InsertAuthCode(ctx context.Context, c AuthCodeRecord) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, s.q(`delete from db_auth_codes where expires_at_unix < ?`), time.Now().Unix()); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, s.q(`insert into db_auth_codes(code_hash, client_id, uid, redirect_uri, scope, nonce, code_challenge, auth_time_unix, expires_at_unix)
+		values (?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		c.CodeHash, c.ClientID, c.UID, c.RedirectURI, c.Scope, c.Nonce, c.CodeChallenge, c.AuthTime.Unix(), c.ExpiresAt.Unix())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) TakeAuthCode(ctx context.Context, codeHash string) (AuthCodeRecord, error) {
+	var c AuthCodeRecord
+	var authTime, expiresAt int64
+	err := s.db.QueryRowContext(ctx, s.q(`delete from db_auth_codes where code_hash = ?
+		returning code_hash, client_id, uid, redirect_uri, scope, nonce, code_challenge, auth_time_unix, expires_at_unix`), codeHash).
+		Scan(&c.CodeHash, &c.ClientID, &c.UID, &c.RedirectURI, &c.Scope, &c.Nonce, &c.CodeChallenge, &authTime, &expiresAt)
+	c.AuthTime, c.ExpiresAt = time.Unix(authTime, 0), time.Unix(expiresAt, 0)
+	return c, notFound(err)
+}
+
+func (s *SQLStore) InsertSigningKey(ctx context.Context, k SigningKeyRecord) error {
+	_, err := s.db.ExecContext(ctx, s.q(`insert into db_signing_keys(key_id, algorithm, purpose, data_key_id, sealed, public_key, created_at_unix) values (?, ?, ?, ?, ?, ?, ?)`),
+		k.KeyID, k.Algorithm, k.Purpose, k.DataKeyID, k.Sealed, k.PublicKey, k.CreatedAt.Unix())
+	return err
+}
+
+func (s *SQLStore) ListSigningKeys(ctx context.Context, purpose string) ([]SigningKeyRecord, error) {
+	rows, err := s.db.QueryContext(ctx, s.q(`select key_id, algorithm, purpose, data_key_id, sealed, public_key, created_at_unix from db_signing_keys
+		where purpose = ? order by created_at_unix desc, key_id`), purpose)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []SigningKeyRecord
+	for rows.Next() {
+		var k SigningKeyRecord
+		var createdAt int64
+		if err := rows.Scan(&k.KeyID, &k.Algorithm, &k.Purpose, &k.DataKeyID, &k.Sealed, &k.PublicKey, &createdAt); err != nil {
+			return nil, err
+		}
+		k.CreatedAt = time.Unix(createdAt, 0)
+		out = append(out, k)
+	}
+	return out, rows.Err()
+}
+
+func (s *SQLStore) DeleteSigningKeys(ctx context.Context, purpose string, before time.Time) error {
+	_, err := s.db.ExecContext(ctx, s.q(`delete from db_signing_keys where purpose = ? and created_at_unix < ?`), purpose, before.Unix())
+	return err
+}
+
+func (s *SQLStore) InsertDataKey(ctx context.Context, k DataKeyRecord) error {
+	_, err := s.db.ExecContext(ctx, s.q(`insert into db_data_keys(key_id, kek_id, wrapped, created_at_unix, active) values (?, ?, ?, ?, ?)`),
+		k.KeyID, k.KEKID, k.Wrapped, k.CreatedAt.Unix(), boolToInt(k.Active))
+	return err
+}
+
+func (s *SQLStore) ListDataKeys(ctx context.Context) ([]DataKeyRecord, error) {
+	rows, err := s.db.QueryContext(ctx, s.q(`select key_id, kek_id, wrapped, created_at_unix, active from db_data_keys order by created_at_unix desc, key_id`))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []DataKeyRecord
+	for rows.Next() {
+		var k DataKeyRecord
+		var createdAt int64
+		var active int
+		if err := rows.Scan(&k.KeyID, &k.KEKID, &k.Wrapped, &createdAt, &active); err != nil {
+			return nil, err
+		}
+		k.CreatedAt, k.Active = time.Unix(createdAt, 0), active == 1
+		out = append(out, k)
+	}
+	return out, rows.Err()
+}
+
+func (s *SQLStore) UpdateDataKeyWrap(ctx context.Context, keyID, kekID string, wrapped []byte) error {
+	res, err := s.db.ExecContext(ctx, s.q(`update db_data_keys set kek_id = ?, wrapped = ? where key_id = ?`), kekID, wrapped, keyID)
+	return expectOne(res, err)
+}
+
+func (s *SQLStore) RotateDataKey(ctx context.Context, k DataKeyRecord) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if s.dialect == dialectPostgres {
+		// keys inserted by a concurrent rotation would not be deactivated
+		if _, err := tx.ExecContext(ctx, `lock table db_data_keys in exclusive mode`); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, s.q(`update db_data_keys set active = 0`)); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, s.q(`insert into db_data_keys(key_id, kek_id, wrapped, created_at_unix, active) values (?, ?, ?, ?, ?)`),
+		k.KeyID, k.KEKID, k.Wrapped, k.CreatedAt.Unix(), boolToInt(k.Active))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// queryStrings returns the first column of every row returned by query
+func (s *SQLStore) queryStrings(ctx context.Context, query string, args ...any) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, s.q(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
+
+// q adapts query to the dialect of the underlying database
+func (s *SQLStore) q(query string) string {
+	return s.dialect.rebind(query)
+}
+
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func expectOne(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows != 1 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanUser(row scanner) (UserRecord, error) {
+	var u UserRecord
+	var active int
+	err := row.Scan(&u.UID, &u.Login, &u.PasswdHash, &u.Salt, &u.Passwd, &active)
+	u.Active = active == 1
+	return u, notFound(err)
+}
+
+func scanToken(row scanner) (TokenRecord, error) {
+	var t TokenRecord
+	var createdAt, expiresAt, revokedAt int64
+	err := row.Scan(&t.TokenID, &t.UID, &t.TokenType, &t.TokenHash, &t.Salt, &t.Token, &t.ClientID, &t.Scope, &createdAt, &expiresAt, &revokedAt)
+	t.CreatedAt, t.ExpiresAt = time.Unix(createdAt, 0), time.Unix(expiresAt, 0)
+	if revokedAt > 0 {
+		t.RevokedAt = time.Unix(revokedAt, 0)
+	}
+	return t, notFound(err)
+}
+
+func scanClient(row scanner) (ClientRecord, error) {
+	var c ClientRecord
+	var redirectURIs string
+	var createdAt int64
+	err := row.Scan(&c.ClientID, &c.Name, &c.SecretHash, &redirectURIs, &c.UID, &createdAt)
+	if redirectURIs != "" {
+		c.RedirectURIs = strings.Split(redirectURIs, "\n")
+	}
+	c.CreatedAt = time.Unix(createdAt, 0)
+	return c, notFound(err)
+}
+
+// nonNil avoids inserting NULL on "not null" blob columns
+func nonNil(b []byte) []byte {
+	if b == nil {
+		return []byte{}
+	}
+	return b
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
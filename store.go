@@ -0,0 +1,244 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+type (
+	// UserRecord is the persisted representation of a user
+	UserRecord struct {
+		UID   string
+		Login string
+		// PasswdHash is encoded as a PHC string, when empty Salt and Passwd
+		// hold a hash created by older versions of auth
+		PasswdHash string
+		Salt       []byte
+		Passwd     []byte
+		Active     bool
+	}
+
+	// TokenRecord is the persisted representation of a token,
+	// the plain token is never stored
+	TokenRecord struct {
+		TokenID   string
+		TokenType string
+		UID       string
+		// TokenHash is a HMAC-SHA256 keyed by the token pepper (see SetTokenPepper),
+		// tokens created by older versions use the same rules as UserRecord.PasswdHash
+		TokenHash string
+		Salt      []byte
+		Token     []byte
+		// ClientID and Scope are set for tokens issued to oauth clients
+		ClientID  string
+		Scope     string
+		CreatedAt time.Time
+		ExpiresAt time.Time
+		// RevokedAt is zero unless the token was revoked
+		RevokedAt time.Time
+	}
+
+	// DataKeyRecord is a data-encryption-key wrapped by a key-encryption-key,
+	// see Keyring
+	DataKeyRecord struct {
+		KeyID     string
+		KEKID     string
+		Wrapped   []byte
+		CreatedAt time.Time
+		Active    bool
+	}
+
+	// ClientRecord is an application which requests tokens on behalf of
+	// users (eg.: an OpenID Connect relying party)
+	ClientRecord struct {
+		ClientID string
+		Name     string
+		// SecretHash is created by hashToken since secrets are random,
+		// older clients use argon2id, it is empty for public clients
+		SecretHash   string
+		RedirectURIs []string
+		// UID owns the tokens issued by the client_credentials grant,
+		// it is empty for clients which can only act on behalf of users
+		UID       string
+		CreatedAt time.Time
+	}
+
+	// AuthCodeRecord is an authorization code waiting to be exchanged
+	// for tokens, the plain code is never stored
+	AuthCodeRecord struct {
+		CodeHash      string
+		ClientID      string
+		UID           string
+		RedirectURI   string
+		Scope         string
+		Nonce         string
+		CodeChallenge string
+		AuthTime      time.Time
+		ExpiresAt     time.Time
+	}
+
+	// SigningKeyRecord is a private key used to sign tokens,
+	// sealed by a Keyring using the data key DataKeyID
+	SigningKeyRecord struct {
+		KeyID     string
+		Algorithm string
+		// Purpose separates keys used for different kinds of tokens,
+		// see KeyPurposeOIDC and KeyPurposeToken
+		Purpose   string
+		DataKeyID string
+		Sealed    []byte
+		// PublicKey is the JWK encoded as json, so tokens can be verified
+		// without the key-encryption-key
+		PublicKey string
+		CreatedAt time.Time
+	}
+
+	// UserStore persists users
+	UserStore interface {
+		// InsertUser fails if either the UID or the Login are already taken
+		InsertUser(ctx context.Context, u UserRecord) error
+		// FindUserByLogin returns ErrNotFound if no user has the given login
+		FindUserByLogin(ctx context.Context, login string) (UserRecord, error)
+		// FindUserByUID returns ErrNotFound if uid does not exist
+		FindUserByUID(ctx context.Context, uid string) (UserRecord, error)
+		// ListUsers returns all users ordered by login
+		ListUsers(ctx context.Context) ([]UserRecord, error)
+		// UpdatePassword replaces the password hash and discards any legacy hash,
+		// the previous hash is kept in the password history.
+		// It returns ErrNotFound if uid does not exist
+		UpdatePassword(ctx context.Context, uid string, hash string) error
+		// PasswordHistory returns up to limit hashes previously used by uid, newest first
+		PasswordHistory(ctx context.Context, uid string, limit int) ([]string, error)
+		// SetUserActive returns ErrNotFound if uid does not exist
+		SetUserActive(ctx context.Context, uid string, active bool) error
+		// RenameUser returns ErrNotFound if uid does not exist and
+		// ErrConflict if login is already taken
+		RenameUser(ctx context.Context, uid string, login string) error
+		// DeleteUser removes the user, its attributes, password history, group
+		// memberships and all of its tokens. Tokens are revoked first and
+		// recently revoked tokens are kept so ListRevokedTokens reports them
+		// until InsertToken purges them, it returns ErrNotFound if uid does not exist
+		DeleteUser(ctx context.Context, uid string) error
+	}
+
+	// AttributeStore persists free-form key/value attributes of users
+	AttributeStore interface {
+		// UserAttributes returns an empty map if uid has no attributes
+		UserAttributes(ctx context.Context, uid string) (map[string]string, error)
+		// SetUserAttributes inserts or replaces the given attributes, an empty value
+		// removes the attribute. It returns ErrNotFound if uid does not exist
+		SetUserAttributes(ctx context.Context, uid string, attrs map[string]string) error
+	}
+
+	// TokenStore persists tokens
+	TokenStore interface {
+		// InsertToken also purges tokens of deleted users which were
+		// revoked more than MaxSignedTokenTTL ago
+		InsertToken(ctx context.Context, t TokenRecord) error
+		// FindToken returns ErrNotFound if tokenID does not exist,
+		// expired tokens are returned as well
+		FindToken(ctx context.Context, tokenID string) (TokenRecord, error)
+		// ExpireToken sets the expiration of the token to its creation time
+		// and records when it was revoked, it returns ErrNotFound if tokenID
+		// does not exist
+		ExpireToken(ctx context.Context, tokenID string) error
+		// ConsumeToken is like ExpireToken but only succeeds if the token was
+		// still valid, so concurrent calls redeem a single use token at most
+		// once. It returns ErrNotFound if tokenID does not exist or expired.
+		ConsumeToken(ctx context.Context, tokenID string) error
+		// ExpireUserTokens expires all valid tokens owned by uid except the
+		// ones listed in keep
+		ExpireUserTokens(ctx context.Context, uid string, keep ...string) error
+		// ListRevokedTokens returns the ids of tokens revoked after since
+		ListRevokedTokens(ctx context.Context, since time.Time) ([]string, error)
+		// ListTokens returns every token owned by uid, including expired ones,
+		// newest first
+		ListTokens(ctx context.Context, uid string) ([]TokenRecord, error)
+	}
+
+	// GroupStore persists groups and their members
+	GroupStore interface {
+		// CreateGroup returns ErrConflict if the group already exists
+		CreateGroup(ctx context.Context, name string) error
+		// DeleteGroup removes the group and its memberships,
+		// it returns ErrNotFound if the group does not exist
+		DeleteGroup(ctx context.Context, name string) error
+		// ListGroups returns all group names in order
+		ListGroups(ctx context.Context) ([]string, error)
+		// AddGroupMember returns ErrNotFound if either the group or uid do not
+		// exist, adding an existing member is not an error
+		AddGroupMember(ctx context.Context, group, uid string) error
+		// RemoveGroupMember returns ErrNotFound if uid is not a member of group
+		RemoveGroupMember(ctx context.Context, group, uid string) error
+		// GroupMembers returns the uids of all members of group,
+		// it returns ErrNotFound if the group does not exist
+		GroupMembers(ctx context.Context, group string) ([]string, error)
+		// UserGroups returns the names of the groups uid belongs to, in order
+		UserGroups(ctx context.Context, uid string) ([]string, error)
+	}
+
+	// ClientStore persists applications allowed to request tokens
+	ClientStore interface {
+		InsertClient(ctx context.Context, c ClientRecord) error
+		// FindClient returns ErrNotFound if clientID does not exist
+		FindClient(ctx context.Context, clientID string) (ClientRecord, error)
+		// ListClients returns all clients ordered by name
+		ListClients(ctx context.Context) ([]ClientRecord, error)
+		// DeleteClient returns ErrNotFound if clientID does not exist
+		DeleteClient(ctx context.Context, clientID string) error
+	}
+
+	// AuthCodeStore persists authorization codes until they are exchanged
+	AuthCodeStore interface {
+		// InsertAuthCode also removes codes which expired without being used
+		InsertAuthCode(ctx context.Context, c AuthCodeRecord) error
+		// TakeAuthCode removes the code and returns it, so a code is only
+		// returned once. It returns ErrNotFound if codeHash does not exist
+		TakeAuthCode(ctx context.Context, codeHash string) (AuthCodeRecord, error)
+	}
+
+	// SigningKeyStore persists sealed signing keys
+	SigningKeyStore interface {
+		InsertSigningKey(ctx context.Context, k SigningKeyRecord) error
+		// ListSigningKeys returns all keys with the given purpose, newest first
+		ListSigningKeys(ctx context.Context, purpose string) ([]SigningKeyRecord, error)
+		// DeleteSigningKeys removes keys with the given purpose created before t
+		DeleteSigningKeys(ctx context.Context, purpose string, before time.Time) error
+	}
+
+	// DataKeyStore persists wrapped data keys
+	DataKeyStore interface {
+		InsertDataKey(ctx context.Context, k DataKeyRecord) error
+		// ListDataKeys returns all keys, newest first
+		ListDataKeys(ctx context.Context) ([]DataKeyRecord, error)
+		// UpdateDataKeyWrap replaces the wrapped key and the kek used to wrap it,
+		// it returns ErrNotFound if keyID does not exist
+		UpdateDataKeyWrap(ctx context.Context, keyID, kekID string, wrapped []byte) error
+		// RotateDataKey inserts k as the only active key, all other keys are
+		// marked as inactive in the same transaction. Inactive keys are only
+		// used to decrypt existing data.
+		RotateDataKey(ctx context.Context, k DataKeyRecord) error
+	}
+
+	// Store aggregates all entities managed by auth
+	Store interface {
+		UserStore
+		AttributeStore
+		GroupStore
+		TokenStore
+		ClientStore
+		AuthCodeStore
+		SigningKeyStore
+		DataKeyStore
+		io.Closer
+	}
+)
+
+var (
+	// ErrNotFound is returned by Store implementations when the requested entity does not exist
+	ErrNotFound = errors.New("auth: not found")
+	// ErrConflict is returned by Store implementations when an entity violates a uniqueness constraint
+	ErrConflict = errors.New("auth: conflict")
+)
@@ -0,0 +1,328 @@
+package auth_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/andrebq/auth"
+	"github.com/google/uuid"
+)
+
+// eachStore runs fn against every available Store implementation,
+// PostgreSQL is only used when AUTH_TEST_POSTGRES_DSN is set
+// (see make test-postgres)
+func eachStore(t *testing.T, fn func(t *testing.T, st auth.Store)) {
+	t.Run("memory", func(t *testing.T) {
+		st := auth.NewMemoryStore()
+		defer st.Close()
+		fn(t, st)
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		st, err := auth.OpenDir(context.Background(), t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer st.Close()
+		fn(t, st)
+	})
+	t.Run("postgres", func(t *testing.T) {
+		dsn := os.Getenv("AUTH_TEST_POSTGRES_DSN")
+		if dsn == "" {
+			t.Skip("AUTH_TEST_POSTGRES_DSN not set")
+		}
+		st, err := auth.Open(context.Background(), auth.DBConfig{DSN: postgresSchema(t, dsn)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer st.Close()
+		fn(t, st)
+	})
+}
+
+// postgresSchema creates an isolated schema for the current test and returns
+// a dsn which uses it, the schema is dropped once the test finishes
+func postgresSchema(t *testing.T, dsn string) string {
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := fmt.Sprintf("auth_test_%v", uuid.New().ID())
+	if _, err := admin.Exec(fmt.Sprintf("create schema %v", schema)); err != nil {
+		admin.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		admin.Exec(fmt.Sprintf("drop schema %v cascade", schema))
+		admin.Close()
+	})
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func TestStoreUsers(t *testing.T) {
+	eachStore(t, func(t *testing.T, st auth.Store) {
+		ctx := context.Background()
+		u := auth.UserRecord{UID: "uid-1", Login: "bob", Salt: []byte("salt"), Passwd: []byte("passwd"), Active: true}
+		if err := st.InsertUser(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+		if err := st.InsertUser(ctx, auth.UserRecord{UID: "uid-2", Login: "bob", Salt: []byte{}, Passwd: []byte{}}); !errors.Is(err, auth.ErrConflict) {
+			t.Fatalf("Login should be unique, got %v", err)
+		}
+		if err := st.InsertUser(ctx, auth.UserRecord{UID: "uid-1", Login: "alice", Salt: []byte{}, Passwd: []byte{}}); !errors.Is(err, auth.ErrConflict) {
+			t.Fatalf("UID should be unique, got %v", err)
+		}
+		actual, err := st.FindUserByLogin(ctx, "bob")
+		if err != nil {
+			t.Fatal(err)
+		} else if actual.UID != u.UID || !actual.Active || string(actual.Passwd) != "passwd" {
+			t.Fatalf("Unexpected user %#v", actual)
+		}
+		if err := st.UpdatePassword(ctx, u.UID, "$argon2id$new-hash"); err != nil {
+			t.Fatal(err)
+		}
+		if actual, err = st.FindUserByLogin(ctx, "bob"); err != nil {
+			t.Fatal(err)
+		} else if actual.PasswdHash != "$argon2id$new-hash" || len(actual.Salt) != 0 || len(actual.Passwd) != 0 {
+			t.Fatalf("Password was not updated %#v", actual)
+		}
+		if _, err := st.FindUserByLogin(ctx, "alice"); !errors.Is(err, auth.ErrNotFound) {
+			t.Fatalf("Missing user should return ErrNotFound got %v", err)
+		}
+		if err := st.UpdatePassword(ctx, "uid-404", ""); !errors.Is(err, auth.ErrNotFound) {
+			t.Fatalf("Missing user should return ErrNotFound got %v", err)
+		}
+	})
+}
+
+func TestStoreTokens(t *testing.T) {
+	eachStore(t, func(t *testing.T, st auth.Store) {
+		ctx := context.Background()
+		now := time.Unix(time.Now().Unix(), 0)
+		tk := auth.TokenRecord{TokenID: "tid-1", TokenType: "session", UID: "uid-1",
+			Salt: []byte("salt"), Token: []byte("token"), CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+		if err := st.InsertToken(ctx, tk); err != nil {
+			t.Fatal(err)
+		}
+		actual, err := st.FindToken(ctx, tk.TokenID)
+		if err != nil {
+			t.Fatal(err)
+		} else if actual.UID != tk.UID || actual.TokenType != tk.TokenType || !actual.ExpiresAt.Equal(tk.ExpiresAt) {
+			t.Fatalf("Unexpected token %#v", actual)
+		}
+		if err := st.ExpireToken(ctx, tk.TokenID); err != nil {
+			t.Fatal(err)
+		}
+		if actual, err = st.FindToken(ctx, tk.TokenID); err != nil {
+			t.Fatal(err)
+		} else if !actual.ExpiresAt.Equal(actual.CreatedAt) {
+			t.Fatalf("Token should be expired %#v", actual)
+		}
+		if err := st.ExpireToken(ctx, "tid-404"); !errors.Is(err, auth.ErrNotFound) {
+			t.Fatalf("Missing token should return ErrNotFound got %v", err)
+		}
+	})
+}
+
+func TestStoreUserLifecycle(t *testing.T) {
+	eachStore(t, func(t *testing.T, st auth.Store) {
+		ctx := context.Background()
+		now := time.Unix(time.Now().Unix(), 0)
+		for _, u := range []auth.UserRecord{{UID: "uid-1", Login: "bob", Active: true}, {UID: "uid-2", Login: "alice", Active: true}} {
+			if err := st.InsertUser(ctx, u); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, id := range []string{"tid-1", "tid-2"} {
+			if err := st.InsertToken(ctx, auth.TokenRecord{TokenID: id, TokenType: "session", UID: "uid-1", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		users, err := st.ListUsers(ctx)
+		if err != nil {
+			t.Fatal(err)
+		} else if len(users) != 2 || users[0].Login != "alice" || users[1].Login != "bob" {
+			t.Fatalf("Users should be ordered by login %#v", users)
+		}
+		if err := st.SetUserActive(ctx, "uid-1", false); err != nil {
+			t.Fatal(err)
+		}
+		if u, err := st.FindUserByUID(ctx, "uid-1"); err != nil {
+			t.Fatal(err)
+		} else if u.Active {
+			t.Fatal("User should be inactive")
+		}
+		if err := st.RenameUser(ctx, "uid-1", "alice"); !errors.Is(err, auth.ErrConflict) {
+			t.Fatalf("Rename to a taken login should return ErrConflict got %v", err)
+		}
+		if err := st.RenameUser(ctx, "uid-1", "robert"); err != nil {
+			t.Fatal(err)
+		}
+		if u, err := st.FindUserByLogin(ctx, "robert"); err != nil || u.UID != "uid-1" {
+			t.Fatalf("Unexpected user %#v err %v", u, err)
+		}
+		if err := st.ExpireUserTokens(ctx, "uid-1", "tid-2"); err != nil {
+			t.Fatal(err)
+		}
+		if tk, _ := st.FindToken(ctx, "tid-1"); !tk.ExpiresAt.Equal(tk.CreatedAt) {
+			t.Fatalf("Token should be expired %#v", tk)
+		}
+		if tk, _ := st.FindToken(ctx, "tid-2"); tk.ExpiresAt.Equal(tk.CreatedAt) {
+			t.Fatalf("Token should be kept %#v", tk)
+		}
+		if err := st.DeleteUser(ctx, "uid-1"); err != nil {
+			t.Fatal(err)
+		}
+		if tk, err := st.FindToken(ctx, "tid-2"); err != nil || !tk.ExpiresAt.Equal(tk.CreatedAt) {
+			t.Fatalf("Tokens should be revoked with the user got %#v, %v", tk, err)
+		}
+		if revoked, err := st.ListRevokedTokens(ctx, now); err != nil || !slices.Contains(revoked, "tid-2") {
+			t.Fatalf("Tokens of deleted users should be reported as revoked got %v, %v", revoked, err)
+		}
+		if err := st.DeleteUser(ctx, "uid-1"); !errors.Is(err, auth.ErrNotFound) {
+			t.Fatalf("Missing user should return ErrNotFound got %v", err)
+		}
+	})
+}
+
+func TestPurgeRevokedTokens(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	st, err := auth.OpenDir(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	now := time.Unix(time.Now().Unix(), 0)
+	for _, uid := range []string{"uid-1", "uid-2"} {
+		if err := st.InsertUser(ctx, auth.UserRecord{UID: uid, Login: uid, Active: true}); err != nil {
+			t.Fatal(err)
+		}
+		if err := st.InsertToken(ctx, auth.TokenRecord{TokenID: "tid-" + uid, TokenType: "session", UID: uid, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := st.DeleteUser(ctx, "uid-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.ExpireToken(ctx, "tid-uid-2"); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := auth.OpenDirNoMigrate(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.ExecContext(ctx, `update db_tokens set revoked_at_unix = ?`, now.Add(-auth.MaxSignedTokenTTL-time.Minute).Unix()); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.InsertToken(ctx, auth.TokenRecord{TokenID: "tid-3", TokenType: "session", UID: "uid-2", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.FindToken(ctx, "tid-uid-1"); !errors.Is(err, auth.ErrNotFound) {
+		t.Fatalf("Old revoked tokens of deleted users should be purged got %v", err)
+	}
+	if _, err := st.FindToken(ctx, "tid-uid-2"); err != nil {
+		t.Fatalf("Revoked tokens of existing users should be kept got %v", err)
+	}
+}
+
+func TestStoreUserAttributes(t *testing.T) {
+	eachStore(t, func(t *testing.T, st auth.Store) {
+		ctx := context.Background()
+		if err := st.InsertUser(ctx, auth.UserRecord{UID: "uid-1", Login: "bob", Active: true}); err != nil {
+			t.Fatal(err)
+		}
+		if err := st.SetUserAttributes(ctx, "uid-1", map[string]string{"name": "Bob", "team": "infra"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := st.SetUserAttributes(ctx, "uid-1", map[string]string{"name": "Robert", "team": ""}); err != nil {
+			t.Fatal(err)
+		}
+		attrs, err := st.UserAttributes(ctx, "uid-1")
+		if err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(attrs, map[string]string{"name": "Robert"}) {
+			t.Fatalf("Unexpected attributes %v", attrs)
+		}
+		if err := st.SetUserAttributes(ctx, "uid-404", map[string]string{"name": "Nobody"}); !errors.Is(err, auth.ErrNotFound) {
+			t.Fatalf("Missing user should return ErrNotFound got %v", err)
+		}
+		if err := st.DeleteUser(ctx, "uid-1"); err != nil {
+			t.Fatal(err)
+		}
+		if attrs, err := st.UserAttributes(ctx, "uid-1"); err != nil || len(attrs) != 0 {
+			t.Fatalf("Attributes should be removed with the user got %v, %v", attrs, err)
+		}
+	})
+}
+
+func TestStoreGroups(t *testing.T) {
+	eachStore(t, func(t *testing.T, st auth.Store) {
+		ctx := context.Background()
+		if err := st.InsertUser(ctx, auth.UserRecord{UID: "uid-1", Login: "bob", Active: true}); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"ops", "dev"} {
+			if err := st.CreateGroup(ctx, name); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := st.CreateGroup(ctx, "ops"); !errors.Is(err, auth.ErrConflict) {
+			t.Fatalf("Duplicated group should return ErrConflict got %v", err)
+		}
+		if groups, err := st.ListGroups(ctx); err != nil || !reflect.DeepEqual(groups, []string{"dev", "ops"}) {
+			t.Fatalf("Unexpected groups %v, %v", groups, err)
+		}
+		for i := 0; i < 2; i++ {
+			if err := st.AddGroupMember(ctx, "ops", "uid-1"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := st.AddGroupMember(ctx, "missing", "uid-1"); !errors.Is(err, auth.ErrNotFound) {
+			t.Fatalf("Missing group should return ErrNotFound got %v", err)
+		}
+		if err := st.AddGroupMember(ctx, "dev", "uid-404"); !errors.Is(err, auth.ErrNotFound) {
+			t.Fatalf("Missing user should return ErrNotFound got %v", err)
+		}
+		if members, err := st.GroupMembers(ctx, "ops"); err != nil || !reflect.DeepEqual(members, []string{"uid-1"}) {
+			t.Fatalf("Unexpected members %v, %v", members, err)
+		}
+		if groups, err := st.UserGroups(ctx, "uid-1"); err != nil || !reflect.DeepEqual(groups, []string{"ops"}) {
+			t.Fatalf("Unexpected user groups %v, %v", groups, err)
+		}
+		if err := st.RemoveGroupMember(ctx, "ops", "uid-1"); err != nil {
+			t.Fatal(err)
+		}
+		if err := st.RemoveGroupMember(ctx, "ops", "uid-1"); !errors.Is(err, auth.ErrNotFound) {
+			t.Fatalf("Removing a non member should return ErrNotFound got %v", err)
+		}
+		if err := st.AddGroupMember(ctx, "dev", "uid-1"); err != nil {
+			t.Fatal(err)
+		}
+		if err := st.DeleteGroup(ctx, "dev"); err != nil {
+			t.Fatal(err)
+		}
+		if groups, err := st.UserGroups(ctx, "uid-1"); err != nil || len(groups) != 0 {
+			t.Fatalf("Deleted group should not be listed got %v, %v", groups, err)
+		}
+		if _, err := st.GroupMembers(ctx, "dev"); !errors.Is(err, auth.ErrNotFound) {
+			t.Fatalf("Deleted group should return ErrNotFound got %v", err)
+		}
+	})
+}
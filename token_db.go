@@ -2,7 +2,6 @@ package auth
 
 import (
 	"context"
-	"database/sql"
 	"encoding/base64"
 	"errors"
 	"fmt"
@@ -12,72 +11,236 @@ import (
 	"github.com/google/uuid"
 )
 
-func CreateToken(ctx context.Context, db *sql.DB, login, token_type string, expiresAt time.Time) (string, error) {
-	id, err := uuid.NewRandom()
+type (
+	// Token is the public view of a token, it never exposes the token hash
+	Token struct {
+		TokenID   string    `json:"tokenID"`
+		TokenType string    `json:"tokenType"`
+		UID       string    `json:"uid"`
+		ClientID  string    `json:"clientID,omitempty"`
+		Scope     string    `json:"scope,omitempty"`
+		CreatedAt time.Time `json:"createdAt"`
+		ExpiresAt time.Time `json:"expiresAt"`
+	}
+)
+
+const (
+	// TokenTypeSession is used by interactive logins
+	TokenTypeSession = "session"
+	// TokenTypeReset can only be used once to choose a new password
+	TokenTypeReset = "reset"
+	// TokenTypeInvite can only be used once to activate an invited user
+	TokenTypeInvite = "invite"
+	// TokenTypeAdmin grants access to the admin api
+	TokenTypeAdmin = "admin"
+	// TokenTypeAccess is issued by the oidc provider to clients
+	TokenTypeAccess = "access"
+	// TokenTypeMachine is used by services, the client_credentials grant issues them
+	TokenTypeMachine = "machine"
+
+	// DefaultResetTTL is how long a reset token is valid unless stated otherwise
+	DefaultResetTTL = 15 * time.Minute
+	// DefaultInviteTTL is how long an invitation is valid unless stated otherwise
+	DefaultInviteTTL = 7 * 24 * time.Hour
+	// DefaultClientTokenTTL is how long tokens issued to clients with the
+	// client_credentials grant are valid unless stated otherwise
+	DefaultClientTokenTTL = time.Hour
+)
+
+// SingleUse reports whether tokens of tokenType are only valid for a specific
+// flow (eg.: password reset) and must never be accepted as regular credentials
+func SingleUse(tokenType string) bool {
+	return tokenType == TokenTypeReset || tokenType == TokenTypeInvite
+}
+
+// Credential reports whether tokens of tokenType authenticate their owner at
+// the auth API and the proxies. Access tokens belong to the oidc client they
+// were issued to and are only accepted by userinfo and introspection.
+func Credential(tokenType string) bool {
+	return !SingleUse(tokenType) && tokenType != TokenTypeAccess
+}
+
+func CreateToken(ctx context.Context, st Store, login, token_type string, expiresAt time.Time) (string, error) {
+	u, err := lookupActiveLogin(ctx, st, login)
 	if err != nil {
 		return "", err
 	}
-	var uid string
-	if err := lookupActiveLogin(ctx, &uid, db, login); err != nil {
+	return createToken(ctx, st, u, TokenRecord{TokenType: token_type, ExpiresAt: expiresAt})
+}
+
+// CreateClientToken is like CreateToken for tokens issued to an oauth client,
+// the client and the granted scope are reported by InspectToken
+func CreateClientToken(ctx context.Context, st Store, login, tokenType, clientID, scope string, expiresAt time.Time) (string, error) {
+	u, err := lookupActiveLogin(ctx, st, login)
+	if err != nil {
 		return "", err
 	}
-	genpass, err := randomSalt(20)
+	return createToken(ctx, st, u, TokenRecord{TokenType: tokenType, ClientID: clientID, Scope: scope, ExpiresAt: expiresAt})
+}
+
+// createToken fills the id, owner and hash of t before storing it
+func createToken(ctx context.Context, st Store, u UserRecord, t TokenRecord) (string, error) {
+	id, err := uuid.NewRandom()
 	if err != nil {
 		return "", err
 	}
-	salt, salted, err := saltPassword(genpass)
+	genpass, err := randomSalt(20)
 	if err != nil {
 		return "", err
 	}
-	_, err = db.ExecContext(ctx, `insert into db_tokens(token_id,
-		token_type,
-		uid,
-		salt,
-		token,
-		created_at_unix,
-		expires_at_unix) values (?, ?,?,?,?,?,?)`, id.String(), token_type, uid, salt, salted, time.Now().Unix(), expiresAt.Unix())
-	if err != nil {
+	t.TokenID, t.UID, t.TokenHash, t.CreatedAt = id.String(), u.UID, hashToken(genpass), time.Now()
+	if err := st.InsertToken(ctx, t); err != nil {
 		return "", err
 	}
 	return fmt.Sprintf("%v:%v", id.String(), base64.URLEncoding.EncodeToString(genpass)), nil
 }
 
-func TokenLogin(ctx context.Context, db *sql.DB, token string) (string, string, error) {
-	tid, plain, err := splitToken(token)
+func TokenLogin(ctx context.Context, st Store, token string) (string, string, error) {
+	t, err := verifyToken(ctx, st, token)
 	if err != nil {
 		return "", "", err
 	}
-	var uid, tokenType string
-	var salt, salted []byte
-	now := time.Now().Unix()
-	err = db.QueryRowContext(ctx,
-		`select uid, token_type, salt, token from db_tokens where token_id = ? and created_at_unix <= ? and expires_at_unix > ?`, tid, now, now).Scan(
-		&uid, &tokenType, &salt, &salted)
+	return t.UID, t.TokenType, nil
+}
+
+// InspectToken is like TokenLogin but returns everything known about the token
+func InspectToken(ctx context.Context, st Store, token string) (Token, error) {
+	t, err := verifyToken(ctx, st, token)
 	if err != nil {
-		return "", "", err
+		return Token{}, err
+	}
+	return tokenFromRecord(t), nil
+}
+
+func verifyToken(ctx context.Context, st Store, token string) (TokenRecord, error) {
+	if IsSignedToken(token) {
+		return verifySignedToken(ctx, st, token)
+	}
+	tid, plain, err := splitToken(token)
+	if err != nil {
+		return TokenRecord{}, err
+	}
+	t, err := st.FindToken(ctx, tid)
+	if err != nil {
+		return TokenRecord{}, err
+	}
+	now := time.Now().Unix()
+	if t.CreatedAt.Unix() > now || t.ExpiresAt.Unix() <= now {
+		return TokenRecord{}, errors.New("auth: token expired")
+	}
+	ok := false
+	if t.TokenHash == "" {
+		ok = validateLegacyPasswd(t.Salt, t.Token, plain)
+	} else if ok, err = verifyTokenHash(t.TokenHash, plain); err != nil {
+		return TokenRecord{}, err
+	}
+	if !ok {
+		return TokenRecord{}, ErrInvalidCredentials
 	}
-	if !validatePasswd(salt, salted, plain) {
-		return "", "", errors.New("auth: credentials not found or invalid")
+	return t, nil
+}
+
+// CreateResetToken returns a single use token which allows login to choose
+// a new password via ResetPassword
+func CreateResetToken(ctx context.Context, st Store, login string, ttl time.Duration) (string, error) {
+	if ttl <= 0 {
+		ttl = DefaultResetTTL
 	}
-	return uid, tokenType, nil
+	return CreateToken(ctx, st, login, TokenTypeReset, time.Now().Add(ttl))
 }
 
-func RevokeToken(ctx context.Context, db *sql.DB, tokenID string) error {
-	changes, err := db.ExecContext(ctx, `update db_tokens set expires_at_unix = created_at_unix where token_id = ?`, tokenID)
+// ResetPassword redeems a token created by CreateResetToken, on success
+// every token of the user is revoked, including the reset token.
+// The token is consumed before the password changes, so concurrent calls
+// with the same token set the password at most once.
+func ResetPassword(ctx context.Context, st Store, token string, newpass []byte) error {
+	uid, tokenType, err := TokenLogin(ctx, st, token)
+	if err != nil {
+		return err
+	}
+	if tokenType != TokenTypeReset {
+		return ErrInvalidCredentials
+	}
+	u, err := st.FindUserByUID(ctx, uid)
 	if err != nil {
 		return err
 	}
-	rows, err := changes.RowsAffected()
+	if !u.Active {
+		return ErrNotFound
+	}
+	// a password rejected by the policy must not burn the token
+	if err := checkNewPassword(ctx, st, u, newpass); err != nil {
+		return err
+	}
+	if err := consumeToken(ctx, st, token); err != nil {
+		return err
+	}
+	if err := savePassword(ctx, st, u, newpass); err != nil {
+		return err
+	}
+	return st.ExpireUserTokens(ctx, uid)
+}
+
+// consumeToken expires a single use token, it fails with ErrInvalidCredentials
+// if the token was already consumed
+func consumeToken(ctx context.Context, st Store, token string) error {
+	tid, err := ExtractTokenID(token)
 	if err != nil {
 		return err
 	}
-	if rows != 1 {
+	err = st.ConsumeToken(ctx, tid)
+	if errors.Is(err, ErrNotFound) {
+		return ErrInvalidCredentials
+	}
+	return err
+}
+
+// ListTokens returns every token owned by login, including expired ones
+func ListTokens(ctx context.Context, st Store, login string) ([]Token, error) {
+	u, err := st.FindUserByLogin(ctx, login)
+	if err != nil {
+		return nil, err
+	}
+	recs, err := st.ListTokens(ctx, u.UID)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Token, 0, len(recs))
+	for _, t := range recs {
+		out = append(out, tokenFromRecord(t))
+	}
+	return out, nil
+}
+
+func tokenFromRecord(t TokenRecord) Token {
+	return Token{
+		TokenID:   t.TokenID,
+		TokenType: t.TokenType,
+		UID:       t.UID,
+		ClientID:  t.ClientID,
+		Scope:     t.Scope,
+		CreatedAt: t.CreatedAt,
+		ExpiresAt: t.ExpiresAt,
+	}
+}
+
+// Expired reports whether t can no longer be used
+func (t Token) Expired(now time.Time) bool {
+	return t.ExpiresAt.Unix() <= now.Unix()
+}
+
+func RevokeToken(ctx context.Context, st Store, tokenID string) error {
+	err := st.ExpireToken(ctx, tokenID)
+	if errors.Is(err, ErrNotFound) {
 		return errors.New("auth: invalid token format")
 	}
-	return nil
+	return err
 }
 
 func ExtractTokenID(t string) (string, error) {
+	if IsSignedToken(t) {
+		return signedTokenID(t)
+	}
 	i := strings.Index(t, ":")
 	switch {
 	case i < 0:
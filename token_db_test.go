@@ -2,59 +2,279 @@ package auth_test
 
 import (
 	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
 	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/andrebq/auth"
+	"golang.org/x/crypto/argon2"
 )
 
 func TestTokenAuth(t *testing.T) {
+	eachStore(t, func(t *testing.T, db auth.Store) {
+		ctx := context.Background()
+		login := "bob"
+		tokenType := "session"
+		passwd := []byte("super-secure")
+
+		uid, err := auth.RegisterUser(ctx, db, login, passwd)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		token, err := auth.CreateToken(ctx, db, login, tokenType, time.Now().Add(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		actualUID, actualTokenType, err := auth.TokenLogin(ctx, db, token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(actualUID, uid) {
+			t.Errorf("UID should be %v got %v", uid, actualUID)
+		}
+		if !reflect.DeepEqual(actualTokenType, tokenType) {
+			t.Errorf("Token Type should be %v got %v", tokenType, actualTokenType)
+		}
+
+		tid, err := auth.ExtractTokenID(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = auth.RevokeToken(ctx, db, tid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = auth.TokenLogin(ctx, db, token)
+		if err == nil {
+			t.Fatal("Token has been revoked but can still login!")
+		}
+	})
+}
+
+func TestTokenHashes(t *testing.T) {
+	eachStore(t, func(t *testing.T, db auth.Store) {
+		ctx := context.Background()
+		uid, err := auth.RegisterUser(ctx, db, "bob", []byte("super-secure"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := auth.CreateToken(ctx, db, "bob", auth.TokenTypeSession, time.Now().Add(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tid, _ := auth.ExtractTokenID(token)
+		if rec, err := db.FindToken(ctx, tid); err != nil {
+			t.Fatal(err)
+		} else if !strings.HasPrefix(rec.TokenHash, "$hmac-sha256$") {
+			t.Fatalf("New tokens should use hmac got %v", rec.TokenHash)
+		}
+
+		// tokens hashed with argon2id by older versions remain valid
+		legacy := insertArgon2Token(t, db, uid)
+		if actualUID, _, err := auth.TokenLogin(ctx, db, legacy); err != nil || actualUID != uid {
+			t.Fatalf("Argon2id token should be accepted got %v, %v", actualUID, err)
+		}
+		// password formats imported from htpasswd files are not valid for tokens
+		secret := []byte("0123456789abcdefghij")
+		sum := sha1.Sum(secret)
+		err = db.InsertToken(ctx, auth.TokenRecord{
+			TokenID:   "sha1-" + uid,
+			UID:       uid,
+			TokenType: auth.TokenTypeSession,
+			TokenHash: "{SHA}" + base64.StdEncoding.EncodeToString(sum[:]),
+			CreatedAt: time.Now(),
+			ExpiresAt: time.Now().Add(time.Hour),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := auth.TokenLogin(ctx, db, "sha1-"+uid+":"+base64.URLEncoding.EncodeToString(secret)); err == nil {
+			t.Fatal("Token hashed with sha1 should be rejected")
+		}
+
+		auth.SetTokenPepper([]byte("another-pepper"))
+		defer auth.SetTokenPepper(nil)
+		if _, _, err := auth.TokenLogin(ctx, db, token); err == nil {
+			t.Fatal("Token hashed with another pepper should be rejected")
+		}
+		auth.SetTokenPepper([]byte("another-pepper"), nil)
+		if _, _, err := auth.TokenLogin(ctx, db, token); err != nil {
+			t.Fatalf("Token hashed with a previous pepper should be accepted got %v", err)
+		}
+	})
+}
+
+func TestInitTokenPepper(t *testing.T) {
+	auth.SetTokenPepper(nil)
+	defer auth.SetTokenPepper(nil)
+	if err := auth.InitTokenPepper(auth.DBConfig{DSN: "postgres://localhost/auth"}); err == nil {
+		t.Fatal("PostgreSQL should require a pepper")
+	}
+
 	ctx := context.Background()
-	db, err := auth.OpenMemory(ctx)
+	dir := t.TempDir()
+	db, err := auth.OpenDir(ctx, dir)
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer db.Close()
-	if err = auth.InitDB(ctx, db); err != nil {
+	if err := auth.InitTokenPepper(auth.DBConfig{Dir: dir}); err != nil {
 		t.Fatal(err)
 	}
-	login := "bob"
-	tokenType := "session"
-	passwd := []byte("super-secure")
-
-	uid, err := auth.RegisterUser(ctx, db, login, passwd)
-	if err != nil {
+	if _, err := auth.RegisterUser(ctx, db, "bob", []byte("super-secure")); err != nil {
 		t.Fatal(err)
 	}
-
-	token, err := auth.CreateToken(ctx, db, login, tokenType, time.Now().Add(time.Second))
+	token, err := auth.CreateToken(ctx, db, "bob", auth.TokenTypeSession, time.Now().Add(time.Minute))
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	actualUID, actualTokenType, err := auth.TokenLogin(ctx, db, token)
-	if err != nil {
-		t.Fatal(err)
+	auth.SetTokenPepper(nil)
+	if _, _, err := auth.TokenLogin(ctx, db, token); err == nil {
+		t.Fatal("Token should be hashed with the generated pepper")
 	}
-	if !reflect.DeepEqual(actualUID, uid) {
-		t.Errorf("UID should be %v got %v", uid, actualUID)
+	// the pepper is persisted, another process gets the same value
+	if err := auth.InitTokenPepper(auth.DBConfig{Dir: dir}); err != nil {
+		t.Fatal(err)
 	}
-	if !reflect.DeepEqual(actualTokenType, tokenType) {
-		t.Errorf("Token Type should be %v got %v", tokenType, actualTokenType)
+	if _, _, err := auth.TokenLogin(ctx, db, token); err != nil {
+		t.Fatalf("Token should be accepted after loading the stored pepper got %v", err)
 	}
+}
 
-	tid, err := auth.ExtractTokenID(token)
+func BenchmarkTokenLogin(b *testing.B) {
+	ctx := context.Background()
+	db := auth.NewMemoryStore()
+	defer db.Close()
+	uid, err := auth.RegisterUser(ctx, db, "bob", []byte("super-secure"))
 	if err != nil {
-		t.Fatal(err)
+		b.Fatal(err)
 	}
-
-	err = auth.RevokeToken(ctx, db, tid)
+	token, err := auth.CreateToken(ctx, db, "bob", auth.TokenTypeSession, time.Now().Add(time.Hour))
 	if err != nil {
+		b.Fatal(err)
+	}
+	tokens := map[string]string{
+		"hmac":     token,
+		"argon2id": insertArgon2Token(b, db, uid),
+	}
+	for _, name := range []string{"hmac", "argon2id"} {
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, _, err := auth.TokenLogin(ctx, db, tokens[name]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// insertArgon2Token stores a token hashed as done before tokens used hmac
+func insertArgon2Token(t testing.TB, db auth.Store, uid string) string {
+	secret, salt := []byte("0123456789abcdefghij"), []byte("0123456789abcdef")
+	key := argon2.IDKey(secret, salt, 2, 32*1024, 4, 32)
+	rec := auth.TokenRecord{
+		TokenID:   "legacy-" + uid,
+		UID:       uid,
+		TokenType: auth.TokenTypeSession,
+		TokenHash: fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%v$%v", argon2.Version, 32*1024, 2, 4,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)),
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	if err := db.InsertToken(context.Background(), rec); err != nil {
 		t.Fatal(err)
 	}
-	_, _, err = auth.TokenLogin(ctx, db, token)
-	if err == nil {
-		t.Fatal("Token has been revoked but can still login!")
+	return rec.TokenID + ":" + base64.URLEncoding.EncodeToString(secret)
+}
+
+func TestInspectToken(t *testing.T) {
+	eachStore(t, func(t *testing.T, db auth.Store) {
+		ctx := context.Background()
+		uid, err := auth.RegisterUser(ctx, db, "bob", []byte("super-secure"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := auth.CreateClientToken(ctx, db, "bob", auth.TokenTypeAccess, "client-1", "openid email", time.Now().Add(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := auth.InspectToken(ctx, db, token)
+		if err != nil {
+			t.Fatal(err)
+		} else if info.UID != uid || info.ClientID != "client-1" || info.Scope != "openid email" || info.TokenType != auth.TokenTypeAccess {
+			t.Fatalf("Unexpected token %#v", info)
+		}
+		if _, err := auth.InspectToken(ctx, db, info.TokenID+":bogus"); err == nil {
+			t.Fatal("Token with the wrong secret should be rejected")
+		}
+	})
+}
+
+func TestResetPassword(t *testing.T) {
+	eachStore(t, func(t *testing.T, db auth.Store) {
+		ctx := context.Background()
+		if _, err := auth.RegisterUser(ctx, db, "bob", []byte("forgotten")); err != nil {
+			t.Fatal(err)
+		}
+		session, err := auth.CreateToken(ctx, db, "bob", auth.TokenTypeSession, time.Now().Add(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := auth.ResetPassword(ctx, db, session, []byte("new-password")); err == nil {
+			t.Fatal("Session tokens should not reset passwords")
+		}
+		reset, err := auth.CreateResetToken(ctx, db, "bob", time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := auth.ResetPassword(ctx, db, reset, []byte("new-password")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := auth.Login(ctx, db, "bob", []byte("new-password")); err != nil {
+			t.Fatal(err)
+		}
+		if err := auth.ResetPassword(ctx, db, reset, []byte("another-password")); err == nil {
+			t.Fatal("Reset tokens should be single use")
+		}
+		if _, _, err := auth.TokenLogin(ctx, db, session); err == nil {
+			t.Fatal("Sessions should be revoked after a reset")
+		}
+
+		reset, err = auth.CreateResetToken(ctx, db, "bob", time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		redeemed := concurrently(4, func(i int) error {
+			return auth.ResetPassword(ctx, db, reset, []byte(fmt.Sprintf("concurrent-%v", i)))
+		})
+		if redeemed != 1 {
+			t.Fatalf("Reset token should be redeemed once got %v", redeemed)
+		}
+	})
+}
+
+// concurrently calls fn n times in parallel and returns how many calls succeeded
+func concurrently(n int, fn func(i int) error) int {
+	var wg sync.WaitGroup
+	var ok atomic.Int32
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if fn(i) == nil {
+				ok.Add(1)
+			}
+		}(i)
 	}
+	wg.Wait()
+	return int(ok.Load())
 }
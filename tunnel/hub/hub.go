@@ -22,6 +22,15 @@ type (
 		ValidateToken(ctx context.Context, token string) (uid string, tokenType string, err error)
 	}
 
+	// TokenSource provides the token used to connect to a hub, it is called
+	// for every new connection so tokens can be renewed (eg.: client.TokenSource)
+	TokenSource interface {
+		Token(ctx context.Context) (string, error)
+	}
+
+	// StaticToken is a TokenSource which always returns the same token
+	StaticToken string
+
 	dialState struct {
 		client *websocket.Conn
 		done   chan struct{}
@@ -32,6 +41,10 @@ var (
 	upgrader = websocket.Upgrader{}
 )
 
+func (s StaticToken) Token(_ context.Context) (string, error) {
+	return string(s), nil
+}
+
 func NewHub(tokens ValidateToken) (http.Handler, error) {
 	hub := &H{
 		dials:  make(map[string]chan dialState),
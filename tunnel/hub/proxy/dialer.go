@@ -9,16 +9,21 @@ import (
 )
 
 // LocalToRemote takes connections from the given listener and proxies them
-// through the given tunnel at wsBase using the provided token.
+// through the given tunnel at wsBase using tokens from the provided source.
 //
 // It only returns when lst.Accept returns an error
-func LocalToRemote(ctx context.Context, lst net.Listener, wsBase, token, tunnelID string) error {
+func LocalToRemote(ctx context.Context, lst net.Listener, wsBase string, tokens hub.TokenSource, tunnelID string) error {
 	_, _ = ctxcloser.WhenDone(ctx, lst)
 	for {
 		conn, err := lst.Accept()
 		if err != nil {
 			return err
 		}
+		token, err := tokens.Token(ctx)
+		if err != nil {
+			conn.Close()
+			continue
+		}
 		remote, err := hub.Dial(ctx, wsBase, token, tunnelID)
 		if err != nil {
 			conn.Close()
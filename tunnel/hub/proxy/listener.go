@@ -10,7 +10,7 @@ import (
 )
 
 // RemoteToLocal opens a new tunnel on wsBase using the given tunnelID
-// and tokens to authenticate.
+// and tokens from the given source to authenticate.
 //
 // Then, it will accept connections on the given tunnel in a loop, for each
 // new connection from the tunnel it will use dialer to acquire a connection
@@ -20,8 +20,12 @@ import (
 // connections.
 //
 // It will only stop once hub.Accept fails
-func RemoteToLocal(ctx context.Context, wsBase, token, tunnelID string, dialer func(context.Context) (net.Conn, error)) error {
+func RemoteToLocal(ctx context.Context, wsBase string, tokens hub.TokenSource, tunnelID string, dialer func(context.Context) (net.Conn, error)) error {
 	for {
+		token, err := tokens.Token(ctx)
+		if err != nil {
+			return err
+		}
 		conn, err := hub.Accept(ctx, wsBase, token, tunnelID)
 		if err != nil {
 			return err
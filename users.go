@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"unicode"
+)
+
+type (
+	// User is the public view of a user, it never exposes password hashes
+	User struct {
+		UID    string `json:"uid"`
+		Login  string `json:"login"`
+		Active bool   `json:"active"`
+		// Invited is set for users created by InviteUser which
+		// did not accept the invitation yet
+		Invited bool `json:"invited,omitempty"`
+		// Attributes and Groups are only populated when looking up a single user
+		Attributes map[string]string `json:"attributes,omitempty"`
+		Groups     []string          `json:"groups,omitempty"`
+	}
+)
+
+const (
+	// AttrName is the well-known attribute holding the display name of a user
+	AttrName = "name"
+	// AttrEmail is the well-known attribute holding the email of a user
+	AttrEmail = "email"
+)
+
+// ErrInvalidInput is wrapped by errors caused by values which are never
+// accepted, eg.: malformed attribute or group names
+var ErrInvalidInput = errors.New("auth: invalid input")
+
+// attribute names are forwarded as http headers, so they are restricted
+// to a safe subset of ascii
+var attrNameRE = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// ValidateAttributes checks that every attribute can be stored and forwarded
+// to upstream applications
+func ValidateAttributes(attrs map[string]string) error {
+	for name, value := range attrs {
+		if !attrNameRE.MatchString(name) {
+			return fmt.Errorf("%w: attribute name %q must use lowercase letters, digits, - and _", ErrInvalidInput, name)
+		}
+		if len(value) > 1024 {
+			return fmt.Errorf("%w: attribute %v is too long", ErrInvalidInput, name)
+		}
+		for _, r := range value {
+			if unicode.IsControl(r) {
+				return fmt.Errorf("%w: attribute %v contains control characters", ErrInvalidInput, name)
+			}
+		}
+	}
+	return nil
+}
+
+func userFromRecord(u UserRecord) User {
+	invited := !u.Active && u.PasswdHash == "" && len(u.Passwd) == 0
+	return User{UID: u.UID, Login: u.Login, Active: u.Active, Invited: invited}
+}
+
+// ListUsers returns all users ordered by login, including disabled ones
+func ListUsers(ctx context.Context, st Store) ([]User, error) {
+	recs, err := st.ListUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]User, 0, len(recs))
+	for _, r := range recs {
+		out = append(out, userFromRecord(r))
+	}
+	return out, nil
+}
+
+// LookupUser returns the user with the given login and its attributes,
+// including disabled users
+func LookupUser(ctx context.Context, st Store, login string) (User, error) {
+	u, err := st.FindUserByLogin(ctx, login)
+	if err != nil {
+		return User{}, err
+	}
+	return withDetails(ctx, st, u)
+}
+
+// LookupUserByUID is like LookupUser but uses the uid to find the user
+func LookupUserByUID(ctx context.Context, st Store, uid string) (User, error) {
+	u, err := st.FindUserByUID(ctx, uid)
+	if err != nil {
+		return User{}, err
+	}
+	return withDetails(ctx, st, u)
+}
+
+// SetUserAttributes inserts or replaces attributes of login,
+// attributes with an empty value are removed
+func SetUserAttributes(ctx context.Context, st Store, login string, attrs map[string]string) error {
+	if err := ValidateAttributes(attrs); err != nil {
+		return err
+	}
+	u, err := st.FindUserByLogin(ctx, login)
+	if err != nil {
+		return err
+	}
+	return st.SetUserAttributes(ctx, u.UID, attrs)
+}
+
+func withDetails(ctx context.Context, st Store, rec UserRecord) (User, error) {
+	u := userFromRecord(rec)
+	var err error
+	if u.Attributes, err = st.UserAttributes(ctx, rec.UID); err != nil {
+		return User{}, err
+	}
+	u.Groups, err = st.UserGroups(ctx, rec.UID)
+	return u, err
+}
+
+// DisableUser prevents login from authenticating and revokes all of its tokens
+func DisableUser(ctx context.Context, st Store, login string) error {
+	u, err := st.FindUserByLogin(ctx, login)
+	if err != nil {
+		return err
+	}
+	if err := st.SetUserActive(ctx, u.UID, false); err != nil {
+		return err
+	}
+	return st.ExpireUserTokens(ctx, u.UID)
+}
+
+// EnableUser allows a disabled user to authenticate again,
+// tokens revoked by DisableUser remain revoked
+func EnableUser(ctx context.Context, st Store, login string) error {
+	u, err := st.FindUserByLogin(ctx, login)
+	if err != nil {
+		return err
+	}
+	return st.SetUserActive(ctx, u.UID, true)
+}
+
+// DeleteUser removes login and all of its tokens
+func DeleteUser(ctx context.Context, st Store, login string) error {
+	u, err := st.FindUserByLogin(ctx, login)
+	if err != nil {
+		return err
+	}
+	return st.DeleteUser(ctx, u.UID)
+}
+
+// RenameUser changes the login of a user, the uid and tokens are kept
+func RenameUser(ctx context.Context, st Store, login, newLogin string) error {
+	u, err := st.FindUserByLogin(ctx, login)
+	if err != nil {
+		return err
+	}
+	return st.RenameUser(ctx, u.UID, newLogin)
+}
@@ -0,0 +1,63 @@
+package auth_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/andrebq/auth"
+)
+
+func TestDisableUser(t *testing.T) {
+	eachStore(t, func(t *testing.T, st auth.Store) {
+		ctx := context.Background()
+		passwd := []byte("super-secure")
+		if _, err := auth.RegisterUser(ctx, st, "bob", passwd); err != nil {
+			t.Fatal(err)
+		}
+		token, err := auth.CreateToken(ctx, st, "bob", "session", time.Now().Add(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := auth.DisableUser(ctx, st, "bob"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := auth.Login(ctx, st, "bob", passwd); err == nil {
+			t.Fatal("Disabled user should not login")
+		}
+		if _, _, err := auth.TokenLogin(ctx, st, token); err == nil {
+			t.Fatal("Tokens should be revoked when the user is disabled")
+		}
+		if err := auth.EnableUser(ctx, st, "bob"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := auth.Login(ctx, st, "bob", passwd); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := auth.TokenLogin(ctx, st, token); err == nil {
+			t.Fatal("Enabling the user should not restore revoked tokens")
+		}
+	})
+}
+
+func TestSetUserAttributes(t *testing.T) {
+	ctx := context.Background()
+	st := auth.NewMemoryStore()
+	if _, err := auth.RegisterUser(ctx, st, "bob", []byte("super-secure")); err != nil {
+		t.Fatal(err)
+	}
+	for _, invalid := range []map[string]string{{"Name": "Bob"}, {"x-y z": "1"}, {"name": "Bob\r\nX-Admin: 1"}} {
+		if err := auth.SetUserAttributes(ctx, st, "bob", invalid); err == nil {
+			t.Fatalf("Attributes %q should be rejected", invalid)
+		}
+	}
+	if err := auth.SetUserAttributes(ctx, st, "bob", map[string]string{auth.AttrEmail: "bob@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	u, err := auth.LookupUser(ctx, st, "bob")
+	if err != nil {
+		t.Fatal(err)
+	} else if u.Attributes[auth.AttrEmail] != "bob@example.com" {
+		t.Fatalf("Unexpected user %#v", u)
+	}
+}